@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ChillyWR/PasswordManager/config"
+	"github.com/ChillyWR/PasswordManager/internal/controller"
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/internal/rekey"
+	"github.com/ChillyWR/PasswordManager/internal/repo"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+const (
+	defaultBatchSize      = 500
+	defaultCheckpointPath = "rekey.checkpoint.json"
+)
+
+// rekey re-encrypts passwords and re-wraps user data keys with the active master key of the configured provider,
+// then moves vaults still encrypted with the legacy Salt to data keys and upgrades vaults to the latest version.
+// Old keys must stay available to the provider until the run completes.
+func main() {
+	var logger pmlogger.Logger = pmlogger.New()
+
+	var dryRun bool
+	var batchSize int
+	var checkpointPath string
+
+	flag.BoolVar(&dryRun, "dry-run", false, "Count affected fields and check that they decrypt without writing")
+	flag.IntVar(&batchSize, "batch-size", defaultBatchSize, "Number of rows rewritten per transaction")
+	flag.StringVar(&checkpointPath, "checkpoint", defaultCheckpointPath, "Checkpoint file used to resume an interrupted run")
+	flag.Parse()
+
+	cfg, err := config.New()
+	if err != nil {
+		logger.Fatalf("Failed to initialize config: %v", err)
+	}
+
+	provider, err := cfg.Crypto.KeyProvider()
+	if err != nil {
+		logger.Fatalf("Failed to init key provider: %v", err)
+	}
+	if closer, ok := provider.(io.Closer); ok {
+		defer closer.Close()
+	}
+	keys := pmcrypto.WithLegacy(provider, controller.Salt)
+
+	db, err := repo.OpenConnection(&repo.Config{
+		Host:     cfg.DB.Host,
+		Port:     cfg.DB.Port,
+		DBName:   cfg.DB.DBName,
+		Username: cfg.DB.Username,
+		SSLMode:  cfg.DB.SSLMode,
+		Password: cfg.DB.Password,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to open DB connection: %v", err)
+	}
+	defer repo.CloseConnection(db)
+
+	var checkpoint *rekey.Checkpoint
+	if !dryRun {
+		checkpoint, err = rekey.LoadCheckpoint(checkpointPath)
+		if err != nil {
+			logger.Fatalf("Failed to load checkpoint: %v", err)
+		}
+	}
+
+	userRepo, err := repo.NewUserRepository(db)
+	if err != nil {
+		logger.Fatalf("Failed to init userRepo: %v", err)
+	}
+
+	recordRepo, err := repo.NewRecordRepository(db)
+	if err != nil {
+		logger.Fatalf("Failed to init recordRepo: %v", err)
+	}
+
+	migrator, err := controller.NewMigrator(provider, controller.CryptoMode(cfg.Crypto.Mode), userRepo, recordRepo, logger)
+	if err != nil {
+		logger.Fatalf("Failed to init vault migrator: %v", err)
+	}
+
+	rekeyer, err := rekey.New(db, rekey.ServerKeys{Keys: keys}, migrator, checkpoint, rekey.Config{
+		BatchSize: batchSize,
+		DryRun:    dryRun,
+	}, logger)
+	if err != nil {
+		logger.Fatalf("Failed to init rekeyer: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	logger.Infof("Rekeying to key %s, dry run: %t", keys.ActiveID(), dryRun)
+	stats, runErr := rekeyer.Run(ctx)
+
+	failed := 0
+	for _, column := range rekey.SortedColumns(stats) {
+		s := stats[column]
+		logger.Infof("%s: fields %d, rewritten %d, skipped %d, failed %d", column, s.Fields, s.Rewritten, s.Skipped, s.Failed)
+		failed += s.Failed
+	}
+
+	if runErr != nil {
+		logger.Errorf("Rekey stopped, rerun to resume from %s: %v", checkpointPath, runErr)
+		os.Exit(1)
+	}
+
+	if failed > 0 {
+		logger.Errorf("%d fields do not decrypt", failed)
+		os.Exit(1)
+	}
+
+	logger.Info("Done")
+}
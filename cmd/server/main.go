@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +14,7 @@ import (
 	"github.com/ChillyWR/PasswordManager/internal/controller"
 	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
 	"github.com/ChillyWR/PasswordManager/internal/repo"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
 )
 
 func main() {
@@ -45,12 +47,162 @@ func main() {
 		logger.Fatalf("failed to init recordRepo: %s", err.Error())
 	}
 
-	ctrl, err := controller.New(userRepo, recordRepo, logger)
+	keyRepo, err := repo.NewKeyRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init keyRepo: %s", err.Error())
+	}
+
+	recoveryRepo, err := repo.NewRecoveryRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init recoveryRepo: %s", err.Error())
+	}
+
+	sessionRepo, err := repo.NewSessionRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init sessionRepo: %s", err.Error())
+	}
+
+	tokenRepo, err := repo.NewAccessTokenRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init tokenRepo: %s", err.Error())
+	}
+
+	serviceRepo, err := repo.NewServiceAccountRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init serviceRepo: %s", err.Error())
+	}
+
+	twoFARepo, err := repo.NewTwoFactorRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init twoFARepo: %s", err.Error())
+	}
+
+	webauthnRepo, err := repo.NewWebAuthnRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init webauthnRepo: %s", err.Error())
+	}
+
+	oidcRepo, err := repo.NewOIDCRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init oidcRepo: %s", err.Error())
+	}
+
+	certRepo, err := repo.NewCertificateRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init certRepo: %s", err.Error())
+	}
+
+	rateRepo, err := repo.NewRateLimitRepository(db)
+	if err != nil {
+		logger.Fatalf("failed to init rateRepo: %s", err.Error())
+	}
+
+	var rateLimitConfig *controller.RateLimitConfig
+	if config.RateLimit.Enabled {
+		rateLimitConfig = &controller.RateLimitConfig{
+			IP:               config.RateLimit.IPLimit(),
+			Account:          config.RateLimit.AccountLimit(),
+			LoginIP:          config.RateLimit.LoginIPLimit(),
+			LoginAccount:     config.RateLimit.LoginAccountLimit(),
+			FreeFailures:     config.RateLimit.FreeFailures,
+			FailureDelay:     config.RateLimit.FailureDelay,
+			MaxFailureDelay:  config.RateLimit.MaxFailureDelay,
+			LockoutThreshold: config.RateLimit.LockoutThreshold,
+			LockoutDuration:  config.RateLimit.LockoutDuration,
+			FailureWindow:    config.RateLimit.FailureWindow,
+		}
+	}
+
+	var oidcConfig *controller.OIDCConfig
+	provider, err := config.OIDC.Provider()
+	if err != nil {
+		logger.Fatalf("failed to init OIDC provider: %s", err.Error())
+	}
+	if provider != nil {
+		oidcConfig = &controller.OIDCConfig{
+			Provider:      provider,
+			AutoProvision: config.OIDC.AutoProvision,
+			AdminGroups:   config.OIDC.AdminGroups,
+			Required:      config.OIDC.Required,
+		}
+	}
+
+	var ldapConfig *controller.LDAPConfig
+	directory, err := config.LDAP.Directory()
+	if err != nil {
+		logger.Fatalf("failed to init LDAP directory: %s", err.Error())
+	}
+	if directory != nil {
+		ldapConfig = &controller.LDAPConfig{Directory: directory}
+	}
+
+	keys, err := config.Crypto.KeyProvider()
+	if err != nil {
+		logger.Fatalf("failed to init key provider: %s", err.Error())
+	}
+	if closer, ok := keys.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	preloginSecret, err := config.Crypto.Prelogin()
+	if err != nil {
+		logger.Fatalf("failed to read prelogin secret: %s", err.Error())
+	}
+
+	ctrl, err := controller.New(&controller.Config{
+		Keys: keys,
+		PasswordHash: pmcrypto.KDFParams{
+			Time:    config.Crypto.PasswordHashTime,
+			Memory:  config.Crypto.PasswordHashMemory,
+			Threads: config.Crypto.PasswordHashThreads,
+		},
+		Mode: controller.CryptoMode(config.Crypto.Mode),
+		KDF: pmcrypto.KDFParams{
+			Time:    config.Crypto.KDFTime,
+			Memory:  config.Crypto.KDFMemory,
+			Threads: config.Crypto.KDFThreads,
+		},
+		PreloginSecret: preloginSecret,
+		RecoveryTTL:    config.Recovery.TTL,
+		RecoveryDelay:  config.Recovery.Delay,
+		SessionTTL:     config.Session.TTL,
+		RelyingParty:   config.WebAuthn.RelyingParty(),
+		OIDC:           oidcConfig,
+		LDAP:           ldapConfig,
+		RateLimit:      rateLimitConfig,
+	}, userRepo, recordRepo, keyRepo, recoveryRepo, sessionRepo, tokenRepo, serviceRepo, twoFARepo, webauthnRepo, oidcRepo, certRepo, rateRepo, logger)
 	if err != nil {
 		logger.Fatalf("failed to init ctrl: %s", err.Error())
 	}
 
-	apiService, err := api.New(&api.Config{Port: config.API.Port}, ctrl, logger)
+	signingKeys, err := config.JWT.KeySet()
+	if err != nil {
+		logger.Fatalf("failed to init JWT signing keys: %s", err.Error())
+	}
+
+	var tlsConfig *api.TLSConfig
+	clientCAs, err := config.API.ClientCAs()
+	if err != nil {
+		logger.Fatalf("failed to init client CAs: %s", err.Error())
+	}
+	if config.API.TLSCertFile != "" {
+		tlsConfig = &api.TLSConfig{
+			CertFile:  config.API.TLSCertFile,
+			KeyFile:   config.API.TLSKeyFile,
+			ClientCAs: clientCAs,
+		}
+	}
+
+	var proxyConfig *api.ProxyConfig
+	proxyHeader, trustedProxies, err := config.API.Proxies()
+	if err != nil {
+		logger.Fatalf("failed to init trusted proxies: %s", err.Error())
+	}
+	if proxyHeader != "" {
+		proxyConfig = &api.ProxyConfig{Header: proxyHeader, Trusted: trustedProxies}
+	}
+
+	apiService, err := api.New(&api.Config{Port: config.API.Port, SigningKeys: signingKeys, TLS: tlsConfig, Proxy: proxyConfig}, ctrl, logger)
 	if err != nil {
 		logger.Fatalf("failed to init serviceAPI: %s", err.Error())
 	}
@@ -65,12 +217,25 @@ func main() {
 		}
 	}()
 
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	if ldapConfig != nil && config.LDAP.SyncInterval > 0 {
+		go ctrl.RunLDAPSync(background, config.LDAP.SyncInterval)
+	}
+
+	if rateLimitConfig != nil && config.RateLimit.PruneInterval > 0 {
+		go ctrl.RunRateLimitPrune(background, config.RateLimit.PruneInterval)
+	}
+
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
 
 	osCall := <-osSignals
 	logger.Infof("system call: %v", osCall)
 
+	stopBackground()
+
 	if repo.CloseConnection(db) != nil {
 		logger.Errorf("Failed to close DB: %s", err.Error())
 	}
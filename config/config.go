@@ -1,20 +1,111 @@
 package config
 
 import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/netip"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
 )
 
 type Config struct {
-	API APIConfig
-	DB  DBConfig
+	API       APIConfig
+	DB        DBConfig
+	Crypto    CryptoConfig
+	Recovery  RecoveryConfig
+	Session   SessionConfig
+	JWT       JWTConfig
+	WebAuthn  WebAuthnConfig
+	OIDC      OIDCConfig
+	LDAP      LDAPConfig
+	RateLimit RateLimitConfig
 }
 
+// APIConfig serves HTTPS when TLSCertFile and TLSKeyFile are set. TLSClientCAFile is a PEM bundle of the CAs
+// whose client certificates machine clients may authenticate with instead of a token. Behind a reverse proxy
+// ClientIPHeader names the header it passes client addresses in, believed only from the comma separated
+// TrustedProxies addresses or CIDRs.
 type APIConfig struct {
-	Port            uint          `envConfig:"PM_SERVER_PORT"             default:"5000"`
-	ShutdownTimeout time.Duration `envConfig:"PM_SERVER_SHUTDOWN_TIMEOUT" default:"15s"`
+	Port            uint          `envConfig:"PM_SERVER_PORT"               default:"5000"`
+	ShutdownTimeout time.Duration `envConfig:"PM_SERVER_SHUTDOWN_TIMEOUT"   default:"15s"`
+	TLSCertFile     string        `envConfig:"PM_SERVER_TLS_CERT_FILE"      split_words:"true"`
+	TLSKeyFile      string        `envConfig:"PM_SERVER_TLS_KEY_FILE"       split_words:"true"`
+	TLSClientCAFile string        `envConfig:"PM_SERVER_TLS_CLIENT_CA_FILE" envconfig:"TLS_CLIENT_CA_FILE"`
+	ClientIPHeader  string        `envConfig:"PM_SERVER_CLIENT_IP_HEADER"   envconfig:"CLIENT_IP_HEADER"`
+	TrustedProxies  []string      `envConfig:"PM_SERVER_TRUSTED_PROXIES"    split_words:"true"`
+}
+
+// Proxies parses TrustedProxies, it returns no header when the server is not behind a proxy
+func (c APIConfig) Proxies() (string, []netip.Prefix, error) {
+	if c.ClientIPHeader == "" {
+		if len(c.TrustedProxies) > 0 {
+			return "", nil, errors.New("trusted proxies are configured but no client IP header")
+		}
+
+		return "", nil, nil
+	}
+
+	if len(c.TrustedProxies) == 0 {
+		return "", nil, errors.New("client IP header is configured but no trusted proxies")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return "", nil, fmt.Errorf("parse trusted proxy %q: %w", proxy, err)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return "", nil, fmt.Errorf("parse trusted proxy %q: %w", proxy, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return c.ClientIPHeader, prefixes, nil
+}
+
+// ClientCAs returns nil when no client CA bundle is configured
+func (c APIConfig) ClientCAs() (*x509.CertPool, error) {
+	if c.TLSClientCAFile == "" {
+		return nil, nil
+	}
+
+	if c.TLSCertFile == "" {
+		return nil, errors.New("client CAs are configured but TLS is not")
+	}
+
+	bundle, err := os.ReadFile(c.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("client CA file holds no PEM certificates")
+	}
+
+	return pool, nil
 }
 
 type DBConfig struct {
@@ -26,6 +117,281 @@ type DBConfig struct {
 	SSLMode  string `envConfig:"PM_DB_SSL_MODE" default:"disable"`
 }
 
+type RecoveryConfig struct {
+	// TTL limits how long a recovery ceremony collects shares once it is ready
+	TTL time.Duration `envConfig:"PM_RECOVERY_TTL" default:"24h"`
+	// Delay gives the owner of an account time to cancel a recovery ceremony before it can be completed
+	Delay time.Duration `envConfig:"PM_RECOVERY_DELAY" default:"72h"`
+}
+
+type SessionConfig struct {
+	// TTL limits how long a session can be refreshed after signing in
+	TTL time.Duration `envConfig:"PM_SESSION_TTL" default:"720h"`
+}
+
+// JWTConfig lists the keys access tokens are signed with as comma separated "id:path" pairs of PEM files.
+// ActiveKey signs new tokens, the others only verify them. To rotate, add the new key and let the JWKS
+// reach other services, make it active, then remove the old key once its tokens expired.
+type JWTConfig struct {
+	Keys      string `envConfig:"PM_JWT_KEYS"`
+	ActiveKey string `envConfig:"PM_JWT_ACTIVE_KEY" split_words:"true"`
+}
+
+func (c JWTConfig) KeySet() (*pmjwt.KeySet, error) {
+	if c.Keys == "" {
+		return nil, errors.New("no JWT signing keys configured")
+	}
+
+	return pmjwt.ReadKeySet(c.Keys, c.ActiveKey)
+}
+
+// WebAuthnConfig is the relying party passkeys are registered for. RPID is the domain of the web vault,
+// Origins the comma separated origins its pages are served from, passkeys only work on those.
+type WebAuthnConfig struct {
+	RPID    string   `envConfig:"PM_WEBAUTHN_RP_ID"   envconfig:"RP_ID"   default:"localhost"`
+	RPName  string   `envConfig:"PM_WEBAUTHN_RP_NAME" envconfig:"RP_NAME" default:"PasswordManager"`
+	Origins []string `envConfig:"PM_WEBAUTHN_ORIGINS" default:"http://localhost:5000"`
+}
+
+func (c WebAuthnConfig) RelyingParty() *pmwebauthn.RelyingParty {
+	return &pmwebauthn.RelyingParty{ID: c.RPID, Name: c.RPName, Origins: c.Origins}
+}
+
+// OIDCConfig enables single sign-on when Issuer is set. The endpoints of the provider are discovered unless
+// all of them are set, which lets a local provider stand in. Members of any of the comma separated
+// AdminGroups are made admins, Required disables password and passkey login for the deployment until an admin
+// sets the policy at /admin/sso.
+type OIDCConfig struct {
+	Issuer                string        `envConfig:"PM_OIDC_ISSUER"`
+	ClientID              string        `envConfig:"PM_OIDC_CLIENT_ID"              envconfig:"CLIENT_ID"`
+	ClientSecret          string        `envConfig:"PM_OIDC_CLIENT_SECRET"          split_words:"true"`
+	RedirectURL           string        `envConfig:"PM_OIDC_REDIRECT_URL"           envconfig:"REDIRECT_URL" default:"http://localhost:5000/login/oidc/callback"`
+	Scopes                []string      `envConfig:"PM_OIDC_SCOPES"                 default:"openid,profile,email"`
+	GroupsClaim           string        `envConfig:"PM_OIDC_GROUPS_CLAIM"           split_words:"true" default:"groups"`
+	DiscoveryURL          string        `envConfig:"PM_OIDC_DISCOVERY_URL"          envconfig:"DISCOVERY_URL"`
+	AuthorizationEndpoint string        `envConfig:"PM_OIDC_AUTHORIZATION_ENDPOINT" split_words:"true"`
+	TokenEndpoint         string        `envConfig:"PM_OIDC_TOKEN_ENDPOINT"         split_words:"true"`
+	JWKSURI               string        `envConfig:"PM_OIDC_JWKS_URI"               envconfig:"JWKS_URI"`
+	Timeout               time.Duration `envConfig:"PM_OIDC_TIMEOUT"                default:"10s"`
+	AutoProvision         bool          `envConfig:"PM_OIDC_AUTO_PROVISION"         split_words:"true"`
+	AdminGroups           []string      `envConfig:"PM_OIDC_ADMIN_GROUPS"           split_words:"true"`
+	Required              bool          `envConfig:"PM_OIDC_REQUIRED"`
+}
+
+// Provider returns nil when single sign-on is disabled
+func (c OIDCConfig) Provider() (*pmoidc.Provider, error) {
+	if c.Issuer == "" {
+		if c.Required {
+			return nil, errors.New("single sign-on is required but no issuer is configured")
+		}
+
+		return nil, nil
+	}
+
+	return pmoidc.NewProvider(pmoidc.Config{
+		Issuer:                c.Issuer,
+		ClientID:              c.ClientID,
+		ClientSecret:          c.ClientSecret,
+		RedirectURL:           c.RedirectURL,
+		Scopes:                c.Scopes,
+		GroupsClaim:           c.GroupsClaim,
+		DiscoveryURL:          c.DiscoveryURL,
+		AuthorizationEndpoint: c.AuthorizationEndpoint,
+		TokenEndpoint:         c.TokenEndpoint,
+		JWKSURI:               c.JWKSURI,
+		Timeout:               c.Timeout,
+	})
+}
+
+// LDAPConfig enables signing in with directory passwords when URL is set. Members of the groups GroupFilter
+// selects may sign in, every SyncInterval they are synced to users and those that left are disabled, 0 only
+// syncs when an admin asks to.
+type LDAPConfig struct {
+	URL               string        `envConfig:"PM_LDAP_URL"`
+	StartTLS          bool          `envConfig:"PM_LDAP_START_TLS"          split_words:"true"`
+	BindDN            string        `envConfig:"PM_LDAP_BIND_DN"            split_words:"true"`
+	BindPassword      string        `envConfig:"PM_LDAP_BIND_PASSWORD"      split_words:"true"`
+	UserBaseDN        string        `envConfig:"PM_LDAP_USER_BASE_DN"       split_words:"true"`
+	UserFilter        string        `envConfig:"PM_LDAP_USER_FILTER"        split_words:"true" default:"(uid=%s)"`
+	UsernameAttribute string        `envConfig:"PM_LDAP_USERNAME_ATTRIBUTE" split_words:"true" default:"uid"`
+	EmailAttribute    string        `envConfig:"PM_LDAP_EMAIL_ATTRIBUTE"    split_words:"true" default:"mail"`
+	NameAttribute     string        `envConfig:"PM_LDAP_NAME_ATTRIBUTE"     split_words:"true" default:"cn"`
+	GroupBaseDN       string        `envConfig:"PM_LDAP_GROUP_BASE_DN"      split_words:"true"`
+	GroupFilter       string        `envConfig:"PM_LDAP_GROUP_FILTER"       split_words:"true"`
+	MemberAttribute   string        `envConfig:"PM_LDAP_MEMBER_ATTRIBUTE"   split_words:"true" default:"member"`
+	Timeout           time.Duration `envConfig:"PM_LDAP_TIMEOUT"            default:"10s"`
+	SyncInterval      time.Duration `envConfig:"PM_LDAP_SYNC_INTERVAL"      split_words:"true" default:"15m"`
+}
+
+// Directory returns nil when directory authentication is disabled
+func (c LDAPConfig) Directory() (*pmldap.Directory, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+
+	return pmldap.NewDirectory(pmldap.Config{
+		URL:               c.URL,
+		StartTLS:          c.StartTLS,
+		BindDN:            c.BindDN,
+		BindPassword:      c.BindPassword,
+		UserBaseDN:        c.UserBaseDN,
+		UserFilter:        c.UserFilter,
+		UsernameAttribute: c.UsernameAttribute,
+		EmailAttribute:    c.EmailAttribute,
+		NameAttribute:     c.NameAttribute,
+		GroupBaseDN:       c.GroupBaseDN,
+		GroupFilter:       c.GroupFilter,
+		MemberAttribute:   c.MemberAttribute,
+		Timeout:           c.Timeout,
+	})
+}
+
+// RateLimitConfig throttles requests per client address and per signed in account, and sign in attempts per
+// address and per account name, with token buckets of Requests refilled over Interval. A zero Requests turns a
+// limit off. Failed logins beyond FreeFailures delay the next attempt, from FailureDelay doubling up to
+// MaxFailureDelay, and LockoutThreshold of them lock the account for LockoutDuration.
+type RateLimitConfig struct {
+	Enabled bool `envConfig:"PM_RATE_LIMIT_ENABLED" default:"true"`
+
+	IPRequests           int           `envConfig:"PM_RATE_LIMIT_IP_REQUESTS"            envconfig:"IP_REQUESTS"            default:"600"`
+	IPInterval           time.Duration `envConfig:"PM_RATE_LIMIT_IP_INTERVAL"            envconfig:"IP_INTERVAL"            default:"1m"`
+	AccountRequests      int           `envConfig:"PM_RATE_LIMIT_ACCOUNT_REQUESTS"       split_words:"true"                 default:"1200"`
+	AccountInterval      time.Duration `envConfig:"PM_RATE_LIMIT_ACCOUNT_INTERVAL"       split_words:"true"                 default:"1m"`
+	LoginIPRequests      int           `envConfig:"PM_RATE_LIMIT_LOGIN_IP_REQUESTS"      envconfig:"LOGIN_IP_REQUESTS"      default:"20"`
+	LoginIPInterval      time.Duration `envConfig:"PM_RATE_LIMIT_LOGIN_IP_INTERVAL"      envconfig:"LOGIN_IP_INTERVAL"      default:"1m"`
+	LoginAccountRequests int           `envConfig:"PM_RATE_LIMIT_LOGIN_ACCOUNT_REQUESTS" split_words:"true"                 default:"10"`
+	LoginAccountInterval time.Duration `envConfig:"PM_RATE_LIMIT_LOGIN_ACCOUNT_INTERVAL" split_words:"true"                 default:"1m"`
+
+	FreeFailures     int           `envConfig:"PM_RATE_LIMIT_FREE_FAILURES"      split_words:"true" default:"3"`
+	FailureDelay     time.Duration `envConfig:"PM_RATE_LIMIT_FAILURE_DELAY"      split_words:"true" default:"1s"`
+	MaxFailureDelay  time.Duration `envConfig:"PM_RATE_LIMIT_MAX_FAILURE_DELAY"  split_words:"true" default:"1m"`
+	LockoutThreshold int           `envConfig:"PM_RATE_LIMIT_LOCKOUT_THRESHOLD"  split_words:"true" default:"10"`
+	LockoutDuration  time.Duration `envConfig:"PM_RATE_LIMIT_LOCKOUT_DURATION"   split_words:"true" default:"15m"`
+	// FailureWindow forgets failed logins older than it
+	FailureWindow time.Duration `envConfig:"PM_RATE_LIMIT_FAILURE_WINDOW" split_words:"true" default:"24h"`
+	// PruneInterval is how often stale buckets and failures are deleted
+	PruneInterval time.Duration `envConfig:"PM_RATE_LIMIT_PRUNE_INTERVAL" split_words:"true" default:"1h"`
+}
+
+func (c RateLimitConfig) IPLimit() pmratelimit.Limit {
+	return pmratelimit.Limit{Requests: c.IPRequests, Interval: c.IPInterval}
+}
+
+func (c RateLimitConfig) AccountLimit() pmratelimit.Limit {
+	return pmratelimit.Limit{Requests: c.AccountRequests, Interval: c.AccountInterval}
+}
+
+func (c RateLimitConfig) LoginIPLimit() pmratelimit.Limit {
+	return pmratelimit.Limit{Requests: c.LoginIPRequests, Interval: c.LoginIPInterval}
+}
+
+func (c RateLimitConfig) LoginAccountLimit() pmratelimit.Limit {
+	return pmratelimit.Limit{Requests: c.LoginAccountRequests, Interval: c.LoginAccountInterval}
+}
+
+const (
+	KeyProviderLocal  = "local"
+	KeyProviderVault  = "vault"
+	KeyProviderPKCS11 = "pkcs11"
+)
+
+// CryptoConfig selects where the server master keys live, key material is never compiled in.
+// The local provider reads a comma separated list of "id:base64key" either inline in Keys or from KeysFile.
+// The vault provider uses a transit key, the pkcs11 provider uses comma separated key labels in PKCS11Keys.
+// ActiveKey is the ID or label encrypting new values for the local and pkcs11 providers.
+type CryptoConfig struct {
+	Provider  string `envConfig:"PM_CRYPTO_PROVIDER"   default:"local"`
+	Keys      string `envConfig:"PM_CRYPTO_KEYS"`
+	KeysFile  string `envConfig:"PM_CRYPTO_KEYS_FILE"  split_words:"true"`
+	ActiveKey string `envConfig:"PM_CRYPTO_ACTIVE_KEY" split_words:"true"`
+
+	VaultAddress string `envConfig:"PM_CRYPTO_VAULT_ADDRESS" split_words:"true"`
+	VaultToken   string `envConfig:"PM_CRYPTO_VAULT_TOKEN"   split_words:"true"`
+	VaultMount   string `envConfig:"PM_CRYPTO_VAULT_MOUNT"   split_words:"true" default:"transit"`
+	VaultKey     string `envConfig:"PM_CRYPTO_VAULT_KEY"     split_words:"true"`
+
+	PKCS11Module string `envConfig:"PM_CRYPTO_PKCS11_MODULE" envconfig:"PKCS11_MODULE"`
+	PKCS11Token  string `envConfig:"PM_CRYPTO_PKCS11_TOKEN"  envconfig:"PKCS11_TOKEN"`
+	PKCS11PIN    string `envConfig:"PM_CRYPTO_PKCS11_PIN"    envconfig:"PKCS11_PIN"`
+	PKCS11Keys   string `envConfig:"PM_CRYPTO_PKCS11_KEYS"   envconfig:"PKCS11_KEYS"`
+
+	// Mode is "server" to encrypt records on the server or "client" to store records encrypted by clients
+	Mode string `envConfig:"PM_CRYPTO_MODE" default:"server"`
+	// Argon2id parameters clients use to derive vault keys in client mode
+	KDFTime    uint32 `envConfig:"PM_CRYPTO_KDF_TIME"    split_words:"true" default:"3"`
+	KDFMemory  uint32 `envConfig:"PM_CRYPTO_KDF_MEMORY"  split_words:"true" default:"65536"`
+	KDFThreads uint8  `envConfig:"PM_CRYPTO_KDF_THREADS" split_words:"true" default:"4"`
+	// PreloginSecret is a base64 secret of at least 32 bytes, required in client mode
+	PreloginSecret string `envConfig:"PM_CRYPTO_PRELOGIN_SECRET" split_words:"true"`
+
+	// Argon2id parameters of new password hashes, existing hashes are upgraded on login
+	PasswordHashTime    uint32 `envConfig:"PM_CRYPTO_PASSWORD_HASH_TIME"    split_words:"true" default:"3"`
+	PasswordHashMemory  uint32 `envConfig:"PM_CRYPTO_PASSWORD_HASH_MEMORY"  split_words:"true" default:"65536"`
+	PasswordHashThreads uint8  `envConfig:"PM_CRYPTO_PASSWORD_HASH_THREADS" split_words:"true" default:"4"`
+}
+
+// KeyProvider connects to the configured provider, close it if it implements io.Closer
+func (c CryptoConfig) KeyProvider() (pmcrypto.KeyProvider, error) {
+	switch c.Provider {
+	case KeyProviderLocal:
+		return c.keyring()
+	case KeyProviderVault:
+		return pmcrypto.NewVaultTransit(pmcrypto.VaultTransitConfig{
+			Address: c.VaultAddress,
+			Token:   c.VaultToken,
+			Mount:   c.VaultMount,
+			Key:     c.VaultKey,
+		})
+	case KeyProviderPKCS11:
+		var labels []string
+		for _, label := range strings.Split(c.PKCS11Keys, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
+
+		return pmcrypto.NewPKCS11(pmcrypto.PKCS11Config{
+			Module:     c.PKCS11Module,
+			TokenLabel: c.PKCS11Token,
+			PIN:        c.PKCS11PIN,
+			Keys:       labels,
+			Active:     c.ActiveKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", c.Provider)
+	}
+}
+
+// Prelogin decodes PreloginSecret, it is nil when unset
+func (c CryptoConfig) Prelogin() ([]byte, error) {
+	if c.PreloginSecret == "" {
+		return nil, nil
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(c.PreloginSecret)
+	if err != nil {
+		return nil, fmt.Errorf("decode prelogin secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (c CryptoConfig) keyring() (*pmcrypto.Keyring, error) {
+	if c.KeysFile != "" {
+		if c.Keys != "" {
+			return nil, errors.New("both keys and keys file are set")
+		}
+
+		return pmcrypto.ReadKeyring(c.KeysFile, c.ActiveKey)
+	}
+
+	if c.Keys == "" {
+		return nil, errors.New("no server keys configured")
+	}
+
+	return pmcrypto.ParseKeyring(c.Keys, c.ActiveKey)
+}
+
 func New() (*Config, error) {
 	var c Config
 	err := envconfig.Process("PM_SERVER", &c.API)
@@ -38,5 +404,45 @@ func New() (*Config, error) {
 		return nil, fmt.Errorf("process: %w", err)
 	}
 
+	err = envconfig.Process("PM_CRYPTO", &c.Crypto)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_RECOVERY", &c.Recovery)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_SESSION", &c.Session)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_JWT", &c.JWT)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_WEBAUTHN", &c.WebAuthn)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_OIDC", &c.OIDC)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_LDAP", &c.LDAP)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
+	err = envconfig.Process("PM_RATE_LIMIT", &c.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
+	}
+
 	return &c, nil
 }
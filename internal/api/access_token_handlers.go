@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidAccessTokenIDMessage = "Invalid access token ID"
+
+func NewListAccessTokensHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListAccessTokens",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		tokens, err := apictx.ctrl.AllAccessTokens(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list access tokens: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, tokens, http.StatusOK, logger)
+	}
+}
+
+func NewCreateAccessTokenHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CreateAccessToken",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.AccessTokenForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		token, secret, err := apictx.ctrl.CreateAccessToken(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to create access token: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		response := struct {
+			Message     string             `json:"message,omitempty"`
+			AccessToken *model.AccessToken `json:"access_token"`
+			Token       string             `json:"token"`
+		}{
+			Message:     "Store the token now, it is not shown again",
+			AccessToken: token,
+			Token:       secret,
+		}
+
+		writeResponse(w, response, http.StatusCreated, logger)
+	}
+}
+
+func NewRevokeAccessTokenHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RevokeAccessToken",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid access token id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidAccessTokenIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		token, err := apictx.ctrl.RevokeAccessToken(rctx.userID, id)
+		if err != nil {
+			logger.Errorf("Failed to revoke access token: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, token, http.StatusOK, logger)
+	}
+}
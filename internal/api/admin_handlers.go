@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+func NewKeyUsageHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "KeyUsage",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		usage, err := apictx.ctrl.KeyUsage(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to get key usage: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, struct {
+			Keys []model.KeyUsage `json:"keys"`
+		}{
+			Keys: usage,
+		}, http.StatusOK, logger)
+	}
+}
+
+// NewSyncLDAPHandler syncs directory users now instead of waiting for the periodic sync
+func NewSyncLDAPHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "SyncLDAP",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		result, err := apictx.ctrl.SyncLDAP(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to sync LDAP directory: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, result, http.StatusOK, logger)
+	}
+}
+
+func NewSSOPolicyHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "SSOPolicy",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		policy, err := apictx.ctrl.SSOPolicy(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to get sso policy: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, policy, http.StatusOK, logger)
+	}
+}
+
+func NewUpdateSSOPolicyHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "UpdateSSOPolicy",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.SSOPolicyForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		policy, err := apictx.ctrl.UpdateSSOPolicy(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to update sso policy: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, policy, http.StatusOK, logger)
+	}
+}
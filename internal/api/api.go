@@ -14,6 +14,8 @@ import (
 
 	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
 	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
 )
 
 type Controller interface {
@@ -22,19 +24,85 @@ type Controller interface {
 	CreateRecord(recordType model.RecordType, record json.RawMessage, userID uuid.UUID) (interface{}, error)
 	UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID uuid.UUID) (interface{}, error)
 	DeleteRecord(id uuid.UUID, userID uuid.UUID) (*model.CredentialRecord, error)
+	SearchRecords(userID uuid.UUID, query string) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error)
 
-	Login(form *model.UserForm) (uuid.UUID, error)
-	AllUsers() ([]model.User, error)
-	GetUser(id uuid.UUID) (*model.User, error)
+	CreateSession(userID uuid.UUID, userAgent string) (*model.Session, string, error)
+	RefreshSession(refreshToken string) (*model.Session, string, error)
+	ValidateSession(userID, id uuid.UUID) error
+	AllSessions(userID, current uuid.UUID) ([]model.Session, error)
+	RevokeSession(userID, id uuid.UUID) (*model.Session, error)
+
+	CreateAccessToken(userID uuid.UUID, form *model.AccessTokenForm) (*model.AccessToken, string, error)
+	AllAccessTokens(userID uuid.UUID) ([]model.AccessToken, error)
+	RevokeAccessToken(userID, id uuid.UUID) (*model.AccessToken, error)
+	AuthenticateAccessToken(token, ip string) (*model.AccessToken, error)
+
+	AllServiceAccounts(userID uuid.UUID) ([]model.ServiceAccount, error)
+	GetServiceAccount(id uuid.UUID, userID uuid.UUID) (*model.ServiceAccount, error)
+	CreateServiceAccount(form *model.ServiceAccountForm, userID uuid.UUID) (*model.ServiceAccount, error)
+	DeleteServiceAccount(id uuid.UUID, userID uuid.UUID) (*model.ServiceAccount, error)
+	GrantRecords(id uuid.UUID, form *model.RecordGrantForm, userID uuid.UUID) (*model.ServiceAccount, error)
+	RevokeGrant(id, recordID uuid.UUID, userID uuid.UUID) (*model.RecordGrant, error)
+	CreateServiceAccountToken(id uuid.UUID, form *model.AccessTokenForm, userID uuid.UUID) (*model.AccessToken, string, error)
+	RevokeServiceAccountToken(id, tokenID uuid.UUID, userID uuid.UUID) (*model.AccessToken, error)
+
+	AllCertificateMappings(userID uuid.UUID) ([]model.CertificateMapping, error)
+	CreateCertificateMapping(form *model.CertificateMappingForm, userID uuid.UUID) (*model.CertificateMapping, error)
+	DeleteCertificateMapping(id uuid.UUID, userID uuid.UUID) (*model.CertificateMapping, error)
+	AuthenticateCertificate(subjects []string) (*model.CertificateMapping, error)
+
+	Prelogin(name string) (*model.Prelogin, error)
+	Login(form *model.UserForm) (uuid.UUID, string, error)
+	CompleteLogin(form *model.SecondFactorForm) (uuid.UUID, error)
+	AllUsers(userID uuid.UUID) ([]model.User, error)
+	GetUser(id uuid.UUID, userID uuid.UUID) (*model.User, error)
 	CreateUser(user *model.UserForm) (*model.User, error)
-	UpdateUser(id uuid.UUID, form *model.UserForm) (*model.User, error)
-	DeleteUser(id uuid.UUID) (*model.User, error)
+	UpdateUser(id uuid.UUID, form *model.UserForm, userID uuid.UUID) (*model.User, error)
+	DeleteUser(id uuid.UUID, userID uuid.UUID) (*model.User, error)
+
+	EnrollTOTP(userID uuid.UUID) (*model.TOTPEnrollment, error)
+	ConfirmTOTP(userID uuid.UUID, form *model.TOTPConfirmForm) ([]string, error)
+	DisableTOTP(userID uuid.UUID, form *model.ReauthForm) error
+	RegenerateRecoveryCodes(userID uuid.UUID, form *model.ReauthForm) ([]string, error)
+
+	BeginWebAuthnRegistration(userID uuid.UUID) (*pmwebauthn.CreationOptions, error)
+	FinishWebAuthnRegistration(userID uuid.UUID, form *model.WebAuthnRegistrationForm) (*model.WebAuthnCredential, error)
+	WebAuthnCredentials(userID uuid.UUID) ([]model.WebAuthnCredential, error)
+	DeleteWebAuthnCredential(userID, id uuid.UUID) (*model.WebAuthnCredential, error)
+	BeginWebAuthnLogin() (*pmwebauthn.RequestOptions, error)
+	WebAuthnLogin(form *model.WebAuthnLoginForm) (uuid.UUID, error)
+	BeginWebAuthnSecondFactor(form *model.WebAuthnOptionsForm) (*pmwebauthn.RequestOptions, error)
+
+	BeginOIDCLogin() (string, error)
+	BeginOIDCLink(userID uuid.UUID) (string, error)
+	BeginOIDCReauth(userID uuid.UUID) (string, error)
+	CompleteOIDCLogin(form *model.OIDCCallbackForm) (*model.OIDCResult, error)
+	OIDCIdentities(userID uuid.UUID) ([]model.Identity, error)
+
+	KeyUsage(userID uuid.UUID) ([]model.KeyUsage, error)
+	SyncLDAP(userID uuid.UUID) (*model.LDAPSyncResult, error)
+	SSOPolicy(userID uuid.UUID) (*model.SSOPolicy, error)
+	UpdateSSOPolicy(userID uuid.UUID, form *model.SSOPolicyForm) (*model.SSOPolicy, error)
+
+	LimitAddress(ip string) error
+	LimitPrincipal(id uuid.UUID) error
+	LimitLogin(ip, name string) error
+
+	SetupRecovery(userID uuid.UUID, form *model.RecoverySetupForm) (*model.RecoveryKit, error)
+	CollectRecoveryShares(trusteeID uuid.UUID) ([]model.RecoveryShare, error)
+	StartRecovery(name string) (*model.RecoveryCeremony, string, error)
+	RecoveryCeremonies(userID uuid.UUID) ([]model.RecoveryCeremony, error)
+	CancelRecovery(id uuid.UUID, userID uuid.UUID) (*model.RecoveryCeremony, error)
+	GetRecoveryCeremony(id uuid.UUID, userID uuid.UUID) (*model.RecoveryCeremony, error)
+	SubmitRecoveryShare(id uuid.UUID, userID uuid.UUID, form *model.RecoveryShareForm) (*model.RecoveryCeremony, error)
+	CompleteRecovery(id uuid.UUID, form *model.RecoveryCompleteForm) (string, error)
 }
 
 type RequestContext struct {
-	corID  uuid.UUID
-	userID uuid.UUID
-	params httprouter.Params
+	corID     uuid.UUID
+	userID    uuid.UUID
+	sessionID uuid.UUID
+	params    httprouter.Params
 }
 
 type API struct {
@@ -45,12 +113,30 @@ type API struct {
 
 type APIContext struct {
 	ctrl   Controller
+	keys   *pmjwt.KeySet
+	proxy  *ProxyConfig
 	logger pmlogger.Logger
 }
 
 type HandlerFunc func(rw http.ResponseWriter, r *http.Request, ctx *RequestContext)
 
 func New(config *Config, ctrl Controller, logger pmlogger.Logger) (*API, error) {
+	if config == nil {
+		return nil, errors.New("config is nil")
+	}
+
+	if config.SigningKeys == nil {
+		return nil, errors.New("signing keys is nil")
+	}
+
+	if config.TLS != nil && (config.TLS.CertFile == "" || config.TLS.KeyFile == "") {
+		return nil, errors.New("TLS certificate or key file is empty")
+	}
+
+	if config.Proxy != nil && (config.Proxy.Header == "" || len(config.Proxy.Trusted) == 0) {
+		return nil, errors.New("proxy header or trusted proxies are empty")
+	}
+
 	if ctrl == nil {
 		return nil, errors.New("ctrl is nil")
 	}
@@ -59,6 +145,8 @@ func New(config *Config, ctrl Controller, logger pmlogger.Logger) (*API, error)
 		config: config,
 		ctx: &APIContext{
 			ctrl:   ctrl,
+			keys:   config.SigningKeys,
+			proxy:  config.Proxy,
 			logger: logger.WithFields(pmlogger.Fields{"module": "api"}),
 		},
 	}, nil
@@ -68,52 +156,248 @@ func (api *API) Start() error {
 	router := httprouter.New()
 	api.SetFunctionalEndpoints(router)
 	api.SetUserEndpoints(router)
+	api.SetSessionEndpoints(router)
+	api.SetAccessTokenEndpoints(router)
+	api.SetTwoFactorEndpoints(router)
+	api.SetWebAuthnEndpoints(router)
+	api.SetOIDCEndpoints(router)
 	api.SetRecordEndpoints(router)
+	api.SetAdminEndpoints(router)
+	api.SetServiceAccountEndpoints(router)
+	api.SetCertificateEndpoints(router)
+	api.SetRecoveryEndpoints(router)
 
-	api.server = http.Server{Addr: api.config.Address(), Handler: router}
+	api.server = http.Server{Addr: api.config.Address(), Handler: RateLimit(api.ctx, router)}
+	if api.config.TLS == nil {
+		return api.server.ListenAndServe()
+	}
+
+	api.server.TLSConfig = api.config.TLS.serverConfig()
 
-	return api.server.ListenAndServe()
+	return api.server.ListenAndServeTLS(api.config.TLS.CertFile, api.config.TLS.KeyFile)
 }
 
 func (api *API) SetUserEndpoints(r *httprouter.Router) {
+	r.POST("/prelogin",
+		ContextSetter(api.ctx.logger,
+			Dispatch(NewPreloginHandler(api.ctx))))
 	r.POST("/login",
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewLoginHandler(api.ctx)))))
+	r.POST("/login/second-factor",
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewCompleteLoginHandler(api.ctx)))))
+	r.POST("/login/second-factor/webauthn/options",
+		ContextSetter(api.ctx.logger,
+			Dispatch(NewBeginWebAuthnSecondFactorHandler(api.ctx))))
+	r.POST("/login/webauthn/options",
+		ContextSetter(api.ctx.logger,
+			Dispatch(NewBeginWebAuthnLoginHandler(api.ctx))))
+	r.POST("/login/webauthn",
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewWebAuthnLoginHandler(api.ctx)))))
+	r.GET("/login/oidc",
+		ContextSetter(api.ctx.logger,
+			Dispatch(NewBeginOIDCLoginHandler(api.ctx))))
+	r.GET("/login/oidc/callback",
 		ContextSetter(api.ctx.logger,
-			Dispatch(NewLoginHandler(api.ctx))))
+			Dispatch(NewOIDCCallbackHandler(api.ctx))))
 	r.GET("/users",
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
 			Dispatch(NewListUsersHandler(api.ctx)))))
 	r.POST("/users",
-		ContextSetter(api.ctx.logger,
-			Dispatch(NewCreateUserHandler(api.ctx))))
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewCreateUserHandler(api.ctx)))))
 	r.GET(fmt.Sprintf("/users/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
 			Dispatch(NewGetUserHandler(api.ctx)))))
 	r.PUT(fmt.Sprintf("/users/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
 			Dispatch(NewUpdateUserHandler(api.ctx)))))
 	r.DELETE(fmt.Sprintf("/users/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
 			Dispatch(NewDeleteUserHandler(api.ctx)))))
 }
 
+func (api *API) SetSessionEndpoints(r *httprouter.Router) {
+	r.POST("/token/refresh",
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewRefreshTokenHandler(api.ctx)))))
+	r.POST("/logout",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewLogoutHandler(api.ctx)))))
+	r.GET("/sessions",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListSessionsHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/sessions/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRevokeSessionHandler(api.ctx)))))
+}
+
+// SetAccessTokenEndpoints manages personal access tokens, it requires signing in so that a token can not issue others
+func (api *API) SetAccessTokenEndpoints(r *httprouter.Router) {
+	r.GET("/tokens",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListAccessTokensHandler(api.ctx)))))
+	r.POST("/tokens",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCreateAccessTokenHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/tokens/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRevokeAccessTokenHandler(api.ctx)))))
+}
+
+// SetTwoFactorEndpoints manages two-factor authentication of the signed in user
+func (api *API) SetTwoFactorEndpoints(r *httprouter.Router) {
+	r.POST("/2fa/totp",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewEnrollTOTPHandler(api.ctx)))))
+	r.POST("/2fa/totp/confirm",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewConfirmTOTPHandler(api.ctx)))))
+	r.POST("/2fa/disable",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewDisableTOTPHandler(api.ctx)))))
+	r.POST("/2fa/recovery-codes",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRegenerateRecoveryCodesHandler(api.ctx)))))
+}
+
+// SetWebAuthnEndpoints manages the passkeys and security keys of the signed in user
+func (api *API) SetWebAuthnEndpoints(r *httprouter.Router) {
+	r.POST("/webauthn/credentials/options",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewBeginWebAuthnRegistrationHandler(api.ctx)))))
+	r.POST("/webauthn/credentials",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewFinishWebAuthnRegistrationHandler(api.ctx)))))
+	r.GET("/webauthn/credentials",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListWebAuthnCredentialsHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/webauthn/credentials/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewDeleteWebAuthnCredentialHandler(api.ctx)))))
+}
+
+// SetOIDCEndpoints links identities of the single sign-on provider to the signed in user,
+// who signs in there again to confirm sensitive changes
+func (api *API) SetOIDCEndpoints(r *httprouter.Router) {
+	r.POST("/oidc/link",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewBeginOIDCLinkHandler(api.ctx)))))
+	r.POST("/oidc/reauth",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewBeginOIDCReauthHandler(api.ctx)))))
+	r.GET("/oidc/identities",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListOIDCIdentitiesHandler(api.ctx)))))
+}
+
 func (api *API) SetRecordEndpoints(r *httprouter.Router) {
 	r.GET("/records",
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeRecordsRead,
 			Dispatch(NewListRecordsHandler(api.ctx)))))
 	r.POST("/records",
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeRecordsWrite,
 			Dispatch(NewCreateRecordHandler(api.ctx)))))
 	r.GET(fmt.Sprintf("/records/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
-			Dispatch(NewGetRecordHandler(api.ctx)))))
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeRecordsRead,
+			Dispatch(StaticSegment(IDPPN, "search", NewSearchRecordsHandler(api.ctx), NewGetRecordHandler(api.ctx))))))
 	r.PATCH(fmt.Sprintf("/records/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeRecordsWrite,
 			Dispatch(NewUpdateRecordHandler(api.ctx)))))
 	r.DELETE(fmt.Sprintf("/records/:%s", IDPPN),
-		ContextSetter(api.ctx.logger, Authentication(api.ctx.logger,
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeRecordsWrite,
 			Dispatch(NewDeleteRecordHandler(api.ctx)))))
 }
 
+func (api *API) SetAdminEndpoints(r *httprouter.Router) {
+	r.GET("/admin/keys",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
+			Dispatch(NewKeyUsageHandler(api.ctx)))))
+	r.POST("/admin/ldap/sync",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
+			Dispatch(NewSyncLDAPHandler(api.ctx)))))
+	r.GET("/admin/sso",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, model.ScopeUsersAdmin,
+			Dispatch(NewSSOPolicyHandler(api.ctx)))))
+	// requiring single sign-on locks out whoever has no identity, a token must not do that on its own
+	r.PUT("/admin/sso",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewUpdateSSOPolicyHandler(api.ctx)))))
+}
+
+// SetCertificateEndpoints lets admins map client certificates to users and service accounts, like service accounts
+// it requires signing in so that neither a token nor a certificate can map others
+func (api *API) SetCertificateEndpoints(r *httprouter.Router) {
+	r.GET("/admin/certificates",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListCertificateMappingsHandler(api.ctx)))))
+	r.POST("/admin/certificates",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCreateCertificateMappingHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/admin/certificates/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewDeleteCertificateMappingHandler(api.ctx)))))
+}
+
+// SetServiceAccountEndpoints lets admins manage service accounts, it requires signing in so that a token can not issue others
+func (api *API) SetServiceAccountEndpoints(r *httprouter.Router) {
+	r.GET("/admin/service-accounts",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewListServiceAccountsHandler(api.ctx)))))
+	r.POST("/admin/service-accounts",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCreateServiceAccountHandler(api.ctx)))))
+	r.GET(fmt.Sprintf("/admin/service-accounts/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewGetServiceAccountHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/admin/service-accounts/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewDeleteServiceAccountHandler(api.ctx)))))
+	r.POST(fmt.Sprintf("/admin/service-accounts/:%s/grants", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewGrantRecordsHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/admin/service-accounts/:%s/grants/:%s", IDPPN, RecordIDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRevokeGrantHandler(api.ctx)))))
+	r.POST(fmt.Sprintf("/admin/service-accounts/:%s/tokens", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCreateServiceAccountTokenHandler(api.ctx)))))
+	r.DELETE(fmt.Sprintf("/admin/service-accounts/:%s/tokens/:%s", IDPPN, TokenIDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRevokeServiceAccountTokenHandler(api.ctx)))))
+}
+
+// SetRecoveryEndpoints serves recovery ceremonies, starting and completing one does not require signing in.
+// Users list the ceremonies recovering them to cancel those they did not start.
+func (api *API) SetRecoveryEndpoints(r *httprouter.Router) {
+	r.PUT("/recovery",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewSetupRecoveryHandler(api.ctx)))))
+	r.POST("/recovery/shares",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCollectRecoverySharesHandler(api.ctx)))))
+	r.POST("/recovery/ceremonies",
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewStartRecoveryHandler(api.ctx)))))
+	r.GET("/recovery/ceremonies",
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewRecoveryCeremoniesHandler(api.ctx)))))
+	r.POST(fmt.Sprintf("/recovery/ceremonies/:%s/cancel", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewCancelRecoveryHandler(api.ctx)))))
+	r.GET(fmt.Sprintf("/recovery/ceremonies/:%s", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewGetRecoveryCeremonyHandler(api.ctx)))))
+	r.POST(fmt.Sprintf("/recovery/ceremonies/:%s/shares", IDPPN),
+		ContextSetter(api.ctx.logger, Authentication(api.ctx, SessionOnly,
+			Dispatch(NewSubmitRecoveryShareHandler(api.ctx)))))
+	r.POST(fmt.Sprintf("/recovery/ceremonies/:%s/complete", IDPPN),
+		ContextSetter(api.ctx.logger, LoginRateLimit(api.ctx,
+			Dispatch(NewCompleteRecoveryHandler(api.ctx)))))
+}
+
 func (api *API) SetFunctionalEndpoints(r *httprouter.Router) {
 	spec := NewOpenAPIv3(api.config, api.ctx.logger)
 	r.GET("/openapi3.json",
@@ -122,6 +406,9 @@ func (api *API) SetFunctionalEndpoints(r *httprouter.Router) {
 	r.GET("/openapi3.yaml",
 		ContextSetter(api.ctx.logger,
 			Dispatch(NewYAMLSpecHandler(api.ctx.logger, spec))))
+	r.GET("/.well-known/jwks.json",
+		ContextSetter(api.ctx.logger,
+			Dispatch(NewJWKSHandler(api.ctx))))
 }
 
 func (api *API) Stop(ctx context.Context) error {
@@ -136,6 +423,17 @@ func NewJSONSpecHandler(parentLogger pmlogger.Logger, spec *openapi3.T) http.Han
 	}
 }
 
+// NewJWKSHandler publishes the keys access tokens are verified with, so that other services can verify them offline.
+// Offline verification does not notice revoked sessions before the tokens expire.
+func NewJWKSHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{"handler": "JWKS"})
+	jwks := apictx.keys.JWKS()
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeResponse(w, jwks, http.StatusOK, logger)
+	}
+}
+
 func NewYAMLSpecHandler(parentLogger pmlogger.Logger, spec *openapi3.T) http.HandlerFunc {
 	logger := parentLogger.WithFields(pmlogger.Fields{"handler": "SpecHandler"})
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -3,9 +3,11 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"path"
 	"sort"
 	"testing"
@@ -14,13 +16,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 
 	"github.com/ChillyWR/PasswordManager/internal/controller"
 	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
 	"github.com/ChillyWR/PasswordManager/internal/repo"
 	"github.com/ChillyWR/PasswordManager/model"
 	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
 	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
 )
 
 type TableTest struct {
@@ -51,12 +56,76 @@ func setup() *APIContext {
 		logger.Fatalf("Failed to initialize DB: %s", err.Error())
 	}
 
-	ctrl, err := controller.New(userRepo, recordRepo, logger)
+	keyRepo, err := repo.NewKeyRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	recoveryRepo, err := repo.NewRecoveryRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	sessionRepo, err := repo.NewSessionRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	tokenRepo, err := repo.NewAccessTokenRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	serviceRepo, err := repo.NewServiceAccountRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	twoFARepo, err := repo.NewTwoFactorRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	webauthnRepo, err := repo.NewWebAuthnRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	oidcRepo, err := repo.NewOIDCRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	certRepo, err := repo.NewCertificateRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	rateRepo, err := repo.NewRateLimitRepository(testDB)
+	if err != nil {
+		logger.Fatalf("Failed to initialize DB: %s", err.Error())
+	}
+
+	key, err := pmcrypto.NewSalt()
+	if err != nil {
+		logger.Fatalf("Failed to generate key: %s", err.Error())
+	}
+
+	keyring := pmcrypto.NewKeyring()
+	if err := keyring.Add("test", append(key, key...)); err != nil {
+		logger.Fatalf("Failed to init keyring: %s", err.Error())
+	}
+
+	if err := keyring.SetActive("test"); err != nil {
+		logger.Fatalf("Failed to init keyring: %s", err.Error())
+	}
+
+	ctrl, err := controller.New(&controller.Config{Keys: keyring, PasswordHash: pmcrypto.DefaultKDFParams, Mode: controller.CryptoModeServer, RecoveryTTL: time.Hour, SessionTTL: time.Hour, RelyingParty: &pmwebauthn.RelyingParty{ID: "localhost", Name: "Test", Origins: []string{"http://localhost:5000"}}}, userRepo, recordRepo, keyRepo, recoveryRepo, sessionRepo, tokenRepo, serviceRepo, twoFARepo, webauthnRepo, oidcRepo, certRepo, rateRepo, logger)
 	if err != nil {
 		logger.Fatalf("Failed to init ctrl: %s", err.Error())
 	}
 
-	return &APIContext{ctrl, logger}
+	return &APIContext{ctrl: ctrl, logger: logger}
 }
 
 func TestGet(t *testing.T) {
@@ -88,11 +157,14 @@ func TestGet(t *testing.T) {
 
 	defer cleanup(t, apictx.ctrl, []*model.CredentialRecord{testRecord1, testRecord2}, testUser1)
 
-	val := *testRecord1
-	decryptedTestRecord1 := val // copy
-	v, err := pmcrypto.Decrypt(*decryptedTestRecord1.Notes, controller.Salt)
-	require.NoError(t, err)
-	decryptedTestRecord1.Notes = &v
+	// listings decrypt names only
+	listedTestRecord1 := *testRecord1
+	listedTestRecord1.Name = "Test Record Name 1"
+	listedTestRecord2 := *testRecord2
+	listedTestRecord2.Name = "Test Record Name 2"
+
+	decryptedTestRecord1 := listedTestRecord1
+	decryptedTestRecord1.Notes = pmpointer.String("Test Record Notes 1")
 
 	tts := TableTests{
 		tt: []*TableTest{
@@ -106,7 +178,7 @@ func TestGet(t *testing.T) {
 				expectedHTTPStatus: http.StatusOK,
 				expectedBody: fmt.Sprintf(
 					`{"secure_notes":[%s, %s],"logins":[],"cards":[],"identities":[]}`,
-					toJSONString(t, testRecord1), toJSONString(t, testRecord2),
+					toJSONString(t, listedTestRecord1), toJSONString(t, listedTestRecord2),
 				),
 			},
 			{
@@ -141,6 +213,84 @@ func TestGet(t *testing.T) {
 	TableTestRunner(t, tts)
 }
 
+func TestRowLevelSecurity(t *testing.T) {
+	apictx := setup()
+
+	owner, err := apictx.ctrl.CreateUser(&model.UserForm{
+		Name:     pmpointer.String("Test Owner Name"),
+		Password: pmpointer.String("Test Owner Password"),
+	})
+	require.NoError(t, err)
+
+	other, err := apictx.ctrl.CreateUser(&model.UserForm{
+		Name:     pmpointer.String("Test Other Name"),
+		Password: pmpointer.String("Test Other Password"),
+	})
+	require.NoError(t, err)
+
+	rawRecord, err := apictx.ctrl.CreateRecord(model.LoginRecordType, json.RawMessage(`{
+		"name": "Test Login Name",
+		"username": "Test Login Username"
+	}`), owner.ID)
+	require.NoError(t, err)
+
+	record, ok := rawRecord.(*model.LoginRecord)
+	require.True(t, ok)
+
+	defer func() {
+		_, err := apictx.ctrl.DeleteUser(other.ID, other.ID)
+		require.NoError(t, err)
+	}()
+	defer cleanup(t, apictx.ctrl, []*model.CredentialRecord{&record.CredentialRecord}, owner)
+
+	recordRepo, err := repo.NewRecordRepository(testDB)
+	require.NoError(t, err)
+
+	_, err = recordRepo.GetRecord(owner.ID, record.ID)
+	require.NoError(t, err)
+
+	_, err = recordRepo.GetRecord(owner.ID, uuid.New())
+	require.True(t, errors.Is(err, pmerror.ErrNotFound))
+
+	_, err = recordRepo.GetRecord(other.ID, record.ID)
+	require.True(t, errors.Is(err, pmerror.ErrForbidden))
+
+	_, logins, _, _, err := recordRepo.GetAll(other.ID)
+	require.NoError(t, err)
+	require.Empty(t, logins)
+
+	_, err = recordRepo.Delete(other.ID, record.ID)
+	require.True(t, errors.Is(err, pmerror.ErrForbidden))
+
+	// queries without any filter of their own only see rows of the user set for the transaction
+	count := func(userID uuid.UUID, table, column string, id uuid.UUID) int64 {
+		var n int64
+		err := testDB.Transaction(func(tx *gorm.DB) error {
+			if err := repo.SetRecordOwner(tx, userID); err != nil {
+				return err
+			}
+
+			return tx.Table(table).Where(column+" = ?", id).Count(&n).Error
+		})
+		require.NoError(t, err)
+
+		return n
+	}
+
+	for _, table := range []struct{ name, column string }{
+		{"credential_record", "id"},
+		{"login", "id"},
+		{"record_index", "record_id"},
+	} {
+		require.NotZero(t, count(owner.ID, table.name, table.column, record.ID), table.name)
+		require.Zero(t, count(other.ID, table.name, table.column, record.ID), table.name)
+	}
+
+	// the owner role reaches no user but the one set for the transaction
+	require.NotZero(t, count(owner.ID, "reg_user", "id", owner.ID))
+	require.Zero(t, count(other.ID, "reg_user", "id", owner.ID))
+}
+
 func TableTestRunner(t *testing.T, tts TableTests) {
 	t.Helper()
 	for _, test := range tts.tt {
@@ -171,6 +321,42 @@ func OrderedTableTestRunner(t *testing.T, tts TableTests) {
 	}
 }
 
+func TestClientIP(t *testing.T) {
+	proxy := &ProxyConfig{
+		Header:  "X-Forwarded-For",
+		Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32")},
+	}
+
+	tests := []struct {
+		name     string
+		proxy    *ProxyConfig
+		remote   string
+		header   []string
+		expected string
+	}{
+		{name: "no proxy", remote: "198.51.100.1:4000", header: []string{"203.0.113.9"}, expected: "198.51.100.1"},
+		{name: "untrusted peer", proxy: proxy, remote: "198.51.100.1:4000", header: []string{"203.0.113.9"}, expected: "198.51.100.1"},
+		{name: "trusted peer", proxy: proxy, remote: "10.1.2.3:4000", header: []string{"203.0.113.9"}, expected: "203.0.113.9"},
+		{name: "forged entries", proxy: proxy, remote: "10.1.2.3:4000", header: []string{"1.1.1.1, 203.0.113.9"}, expected: "203.0.113.9"},
+		{name: "proxy chain", proxy: proxy, remote: "10.1.2.3:4000", header: []string{"1.1.1.1", "203.0.113.9, 192.0.2.7"}, expected: "203.0.113.9"},
+		{name: "garbage", proxy: proxy, remote: "10.1.2.3:4000", header: []string{"unknown"}, expected: "10.1.2.3"},
+		{name: "no header", proxy: proxy, remote: "10.1.2.3:4000", expected: "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apictx := &APIContext{proxy: tt.proxy}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.header {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			require.Equal(t, tt.expected, apictx.clientIP(r))
+		})
+	}
+}
+
 func cleanup(t *testing.T, ctrl Controller, records []*model.CredentialRecord, user *model.User) {
 	t.Helper()
 	var err error
@@ -178,7 +364,7 @@ func cleanup(t *testing.T, ctrl Controller, records []*model.CredentialRecord, u
 		_, err = ctrl.DeleteRecord(record.ID, user.ID)
 		require.NoError(t, err)
 	}
-	_, err = ctrl.DeleteUser(user.ID)
+	_, err = ctrl.DeleteUser(user.ID, user.ID)
 	require.NoError(t, err)
 }
 
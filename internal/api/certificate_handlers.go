@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidCertificateMappingIDMessage = "Invalid certificate mapping ID"
+
+func NewListCertificateMappingsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListCertificateMappings",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		mappings, err := apictx.ctrl.AllCertificateMappings(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list certificate mappings: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, mappings, http.StatusOK, logger)
+	}
+}
+
+func NewCreateCertificateMappingHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CreateCertificateMapping",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.CertificateMappingForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		mapping, err := apictx.ctrl.CreateCertificateMapping(&form, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to create certificate mapping: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, mapping, http.StatusCreated, logger)
+	}
+}
+
+func NewDeleteCertificateMappingHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "DeleteCertificateMapping",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid certificate mapping id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidCertificateMappingIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		mapping, err := apictx.ctrl.DeleteCertificateMapping(id, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to delete certificate mapping: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, mapping, http.StatusOK, logger)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -18,8 +20,11 @@ const (
 	// PPN: Path Parameter Name
 	// HPN: Header Parameter Name
 	IDPPN                 = "id"
+	RecordIDPPN           = "record_id"
+	TokenIDPPN            = "token_id"
 	CorrelationIDHPN      = "X-Request-ID"
 	AuthorizationTokenHPN = "Authorization"
+	RetryAfterHPN         = "Retry-After"
 
 	RequestContextName = "rctx"
 )
@@ -30,6 +35,9 @@ const (
 	InvalidUserIDMessage   = "Invalid user ID"
 	InternalErrorMessage   = "Oops, something went wrong"
 	UnAuthorizedMessage    = "Sign in to use service"
+	SessionEndedMessage    = "Session ended, sign in again"
+	IntegrityErrorMessage  = "Stored data failed integrity check"
+	TooManyRequestsMessage = "Too many requests, try again later"
 )
 
 type Error struct {
@@ -48,7 +56,12 @@ func unpackRequestContext(ctx context.Context, logger pmlogger.Logger) *RequestC
 
 // getIDFrom checks if id is set and returns the result of uuid parsing
 func getIDFrom(ps httprouter.Params, logger pmlogger.Logger) (uuid.UUID, error) {
-	idStr := ps.ByName(IDPPN)
+	return getNamedIDFrom(ps, IDPPN, logger)
+}
+
+// getNamedIDFrom parses path parameter name of routes with more than one ID
+func getNamedIDFrom(ps httprouter.Params, name string, logger pmlogger.Logger) (uuid.UUID, error) {
+	idStr := ps.ByName(name)
 	if idStr == "" {
 		logger.Fatal("Failed to get path parameter")
 	}
@@ -83,6 +96,19 @@ func writeResponse(w http.ResponseWriter, body any, statusCode int, logger pmlog
 }
 
 func writeError(w http.ResponseWriter, err error, logger pmlogger.Logger) {
+	var retry *pmerror.RetryError
+	if errors.As(err, &retry) {
+		// whole seconds, rounded up so that clients do not come back early
+		w.Header().Set(RetryAfterHPN, strconv.Itoa(int(math.Ceil(retry.After.Seconds()))))
+		writeResponse(w, Error{Message: TooManyRequestsMessage}, http.StatusTooManyRequests, logger)
+		return
+	}
+
+	if errors.Is(err, pmerror.ErrIntegrity) {
+		writeResponse(w, Error{Message: IntegrityErrorMessage}, errorStatus(err), logger)
+		return
+	}
+
 	writeResponse(w, nil, errorStatus(err), logger)
 }
 
@@ -94,6 +120,12 @@ func errorStatus(err error) int {
 		return http.StatusNotFound
 	case errors.Is(err, pmerror.ErrForbidden):
 		return http.StatusForbidden
+	case errors.Is(err, pmerror.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, pmerror.ErrIntegrity):
+		return http.StatusConflict
+	case errors.Is(err, pmerror.ErrTooManyRequests):
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
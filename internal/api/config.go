@@ -1,11 +1,58 @@
 package api
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/netip"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
 )
 
 type Config struct {
 	Port uint
+	// SigningKeys sign and verify access tokens, their public parts are published as a JWKS
+	SigningKeys *pmjwt.KeySet
+	// TLS serves HTTPS instead of HTTP when set
+	TLS *TLSConfig
+	// Proxy is the reverse proxy in front of the server, nil takes the peer of the connection for the client
+	Proxy *ProxyConfig
+}
+
+// ProxyConfig names the header a reverse proxy passes the client address in, such as X-Forwarded-For or
+// X-Real-IP. The header is only believed on connections from one of the Trusted prefixes, anyone else can set it.
+type ProxyConfig struct {
+	Header  string
+	Trusted []netip.Prefix
+}
+
+// trusts reports whether addr is one of the trusted proxies
+func (c *ProxyConfig) trusts(addr netip.Addr) bool {
+	for _, prefix := range c.Trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TLSConfig is the server certificate. ClientCAs verify the certificates machine clients may present instead
+// of a token, nil requests none. Clients without a certificate still connect and authenticate with tokens.
+type TLSConfig struct {
+	CertFile  string
+	KeyFile   string
+	ClientCAs *x509.CertPool
+}
+
+func (c *TLSConfig) serverConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.ClientCAs != nil {
+		config.ClientCAs = c.ClientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config
 }
 
 func (c Config) Address() string {
@@ -15,3 +62,11 @@ func (c Config) Address() string {
 func (c Config) LocalAddress() string {
 	return fmt.Sprintf("127.0.0.1:%d", c.Port)
 }
+
+func (c Config) Scheme() string {
+	if c.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
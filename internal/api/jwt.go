@@ -4,23 +4,25 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
 )
 
 const (
 	ExpirationTime = time.Minute * 30
 )
 
-var SigningKey = []byte("DApAJQgpjRDHa9Ad")
-
-func GenerateJWT(userID string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func GenerateJWT(keys *pmjwt.KeySet, userID, sessionID string) (string, error) {
+	now := time.Now()
+	tokenStr, err := keys.Sign(jwt.MapClaims{
 		"authorized": true,
 		// User ID
 		"user_id": userID,
-		"exp":  time.Now().Add(ExpirationTime).Unix(),
+		// Session the token was issued to, revoking it invalidates the token
+		"session_id": sessionID,
+		"iat":        now.Unix(),
+		"exp":        now.Add(ExpirationTime).Unix(),
 	})
-
-	tokenStr, err := token.SignedString(SigningKey)
 	if err != nil {
 		return "", err
 	}
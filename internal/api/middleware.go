@@ -1,11 +1,21 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 
 	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -32,21 +42,109 @@ func ContextSetter(logger pmlogger.Logger, next httprouter.Handle) httprouter.Ha
 	}
 }
 
-func Authentication(logger pmlogger.Logger, next httprouter.Handle) httprouter.Handle {
+// RateLimit throttles all requests per client address before they are routed
+func RateLimit(apictx *APIContext, next http.Handler) http.Handler {
+	logger := apictx.logger
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := apictx.ctrl.LimitAddress(apictx.clientIP(r)); err != nil {
+			logRateLimitError(logger, err)
+			writeError(w, err, logger)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxLoginBodySize fits every sign in form including WebAuthn assertions, the body is read before anyone is authenticated
+const maxLoginBodySize = 16 << 10
+
+// LoginRateLimit throttles sign in attempts per client address and, when the JSON body names one, per account name
+func LoginRateLimit(apictx *APIContext, next httprouter.Handle) httprouter.Handle {
+	logger := apictx.logger
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLoginBodySize))
+		r.Body.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			logger.Warnf("Rejected login body over %d bytes", tooLarge.Limit)
+			writeResponse(w, Error{Message: http.StatusText(http.StatusRequestEntityTooLarge)}, http.StatusRequestEntityTooLarge, logger)
+			return
+		} else if err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+		// the handler reads the body again and reports invalid JSON itself
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+
+		var form struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(raw, &form)
+
+		if err := apictx.ctrl.LimitLogin(apictx.clientIP(r), form.Name); err != nil {
+			logRateLimitError(logger, err)
+			writeError(w, err, logger)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+// accountRateLimit throttles the requests of the user or service account Authentication resolved
+func accountRateLimit(apictx *APIContext, next httprouter.Handle) httprouter.Handle {
+	logger := apictx.logger
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		if err := apictx.ctrl.LimitPrincipal(rctx.userID); err != nil {
+			logRateLimitError(logger, err)
+			writeError(w, err, logger)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+func logRateLimitError(logger pmlogger.Logger, err error) {
+	if errors.Is(err, pmerror.ErrTooManyRequests) {
+		logger.Warnf("Throttled request: %s", err.Error())
+		return
+	}
+
+	logger.Errorf("Failed to apply rate limit: %s", err.Error())
+}
+
+// SessionOnly marks routes personal access tokens can not reach, whatever their scopes
+const SessionOnly model.Scope = ""
+
+// Authentication verifies the access token and that the session it was issued to is still active.
+// Personal access tokens are accepted instead when they carry scope, as are mapped client certificates
+// presented without a token. Requests are then throttled per account.
+func Authentication(apictx *APIContext, scope model.Scope, next httprouter.Handle) httprouter.Handle {
+	logger := apictx.logger
+	next = accountRateLimit(apictx, next)
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		tokenStr := r.Header.Get(AuthorizationTokenHPN)
 		if tokenStr == "" {
+			if cert := clientCertificate(r); cert != nil {
+				certificateAuthentication(apictx, scope, cert, next)(w, r, ps)
+				return
+			}
+
 			logger.Errorf("Failed to authorize: no token provided")
 			writeResponse(w, Error{Message: UnAuthorizedMessage}, http.StatusUnauthorized, logger)
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("wrong signing method")
-			}
-			return SigningKey, nil
-		})
+		if strings.HasPrefix(tokenStr, model.AccessTokenPrefix) {
+			accessTokenAuthentication(apictx, scope, tokenStr, next)(w, r, ps)
+			return
+		}
+
+		token, err := apictx.keys.Parse(tokenStr)
 		if err != nil {
 			logger.Errorf("Failed to parse JWT token: %s", err.Error())
 			writeResponse(w, Error{Message: "Invalid token"}, http.StatusUnauthorized, logger)
@@ -80,16 +178,185 @@ func Authentication(logger pmlogger.Logger, next httprouter.Handle) httprouter.H
 			return
 		}
 
+		sessionIDStr, ok := claims["session_id"].(string)
+		if !ok {
+			logger.Warn("Received JWT token without a session")
+			writeResponse(w, Error{Message: "Invalid token"}, http.StatusUnauthorized, logger)
+			return
+		}
+
+		sessionID, err := uuid.Parse(sessionIDStr)
+		if err != nil {
+			logger.Errorf("Failed to parse session ID <%s>: %s", sessionIDStr, err)
+			writeResponse(w, Error{Message: "Invalid token"}, http.StatusUnauthorized, logger)
+			return
+		}
+
+		if err := apictx.ctrl.ValidateSession(userID, sessionID); errors.Is(err, pmerror.ErrUnauthorized) {
+			logger.Warnf("Rejected token: %s", err.Error())
+			writeResponse(w, Error{Message: SessionEndedMessage}, http.StatusUnauthorized, logger)
+			return
+		} else if err != nil {
+			logger.Errorf("Failed to validate session: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
 		rctx := unpackRequestContext(r.Context(), logger)
 		rctx.userID = userID
+		rctx.sessionID = sessionID
+		ctx := context.WithValue(r.Context(), RequestContextName, rctx)
+
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+func accessTokenAuthentication(apictx *APIContext, scope model.Scope, tokenStr string, next httprouter.Handle) httprouter.Handle {
+	logger := apictx.logger
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if scope == SessionOnly {
+			logger.Warn("Rejected access token on a route that requires signing in")
+			writeResponse(w, Error{Message: "Personal access tokens can not be used here"}, http.StatusForbidden, logger)
+			return
+		}
+
+		token, err := apictx.ctrl.AuthenticateAccessToken(tokenStr, apictx.clientIP(r))
+		if errors.Is(err, pmerror.ErrUnauthorized) {
+			logger.Warnf("Rejected access token: %s", err.Error())
+			writeResponse(w, Error{Message: "Invalid token"}, http.StatusUnauthorized, logger)
+			return
+		} else if err != nil {
+			logger.Errorf("Failed to authenticate access token: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		if !token.HasScope(scope) {
+			logger.Warnf("Access token %s lacks scope %s", token.ID, scope)
+			writeResponse(w, Error{Message: fmt.Sprintf("Token lacks scope %s", scope)}, http.StatusForbidden, logger)
+			return
+		}
+
+		rctx := unpackRequestContext(r.Context(), logger)
+		// service accounts act as themselves, the controller resolves which records they were granted
+		rctx.userID = token.Principal()
 		ctx := context.WithValue(r.Context(), RequestContextName, rctx)
 
 		next(w, r.WithContext(ctx), ps)
 	}
 }
 
+func certificateAuthentication(apictx *APIContext, scope model.Scope, cert *x509.Certificate, next httprouter.Handle) httprouter.Handle {
+	logger := apictx.logger
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if scope == SessionOnly {
+			logger.Warn("Rejected client certificate on a route that requires signing in")
+			writeResponse(w, Error{Message: "Client certificates can not be used here"}, http.StatusForbidden, logger)
+			return
+		}
+
+		mapping, err := apictx.ctrl.AuthenticateCertificate(certificateSubjects(cert))
+		if errors.Is(err, pmerror.ErrUnauthorized) {
+			logger.Warnf("Rejected client certificate: %s", err.Error())
+			writeResponse(w, Error{Message: "Certificate is not mapped to an account"}, http.StatusUnauthorized, logger)
+			return
+		} else if err != nil {
+			logger.Errorf("Failed to authenticate client certificate: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		if !mapping.HasScope(scope) {
+			logger.Warnf("Certificate mapping %s lacks scope %s", mapping.ID, scope)
+			writeResponse(w, Error{Message: fmt.Sprintf("Certificate lacks scope %s", scope)}, http.StatusForbidden, logger)
+			return
+		}
+
+		rctx := unpackRequestContext(r.Context(), logger)
+		rctx.userID = mapping.Principal()
+		ctx := context.WithValue(r.Context(), RequestContextName, rctx)
+
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+// clientCertificate returns the certificate the client presented if the TLS handshake verified it against the client CAs
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateSubjects lists the URI SANs of cert before its common name, URIs such as SPIFFE IDs are the more specific
+func certificateSubjects(cert *x509.Certificate) []string {
+	subjects := make([]string, 0, len(cert.URIs)+1)
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, model.CommonNamePrefix+cert.Subject.CommonName)
+	}
+
+	return subjects
+}
+
+// clientIP is the address the request came from. Behind a trusted proxy it is the last address of the proxy
+// header that is not a trusted proxy itself, entries left of it were set by the client and may be forged.
+func (apictx *APIContext) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	proxy := apictx.proxy
+	if proxy == nil {
+		return host
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !proxy.trusts(peer) {
+		return host
+	}
+
+	values := r.Header.Values(proxy.Header)
+	for i := len(values) - 1; i >= 0; i-- {
+		entries := strings.Split(values[i], ",")
+		for j := len(entries) - 1; j >= 0; j-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(entries[j]))
+			if err != nil {
+				// an entry we can not parse ends the chain of proxies we know about
+				return host
+			}
+
+			if !proxy.trusts(addr) {
+				return addr.Unmap().String()
+			}
+
+			host = addr.Unmap().String()
+		}
+	}
+
+	return host
+}
+
 func Dispatch(next http.HandlerFunc) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		next(w, r)
 	}
 }
+
+// StaticSegment serves static instead of next when path parameter name equals segment,
+// httprouter does not allow a static segment next to a parameter at the same level
+func StaticSegment(name, segment string, static, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rctx, ok := r.Context().Value(RequestContextName).(*RequestContext); ok && rctx.params.ByName(name) == segment {
+			static(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
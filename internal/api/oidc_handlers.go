@@ -0,0 +1,164 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type authorizationURL struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// NewBeginOIDCLoginHandler redirects browsers to the identity provider, API clients read the URL from the body
+func NewBeginOIDCLoginHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginOIDCLogin",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		authURL, err := apictx.ctrl.BeginOIDCLogin()
+		if err != nil {
+			logger.Errorf("Failed to begin oidc login: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		w.Header().Set("Location", authURL)
+		writeResponse(w, authorizationURL{AuthorizationURL: authURL}, http.StatusFound, logger)
+	}
+}
+
+func NewOIDCCallbackHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "OIDCCallback",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		query := r.URL.Query()
+		if reason := query.Get("error"); reason != "" {
+			logger.Errorf("Identity provider declined sign in: %s %s", reason, query.Get("error_description"))
+			writeResponse(w, Error{Message: "Identity provider declined sign in: " + reason}, http.StatusBadRequest, logger)
+			return
+		}
+
+		state, code := query.Get("state"), query.Get("code")
+		result, err := apictx.ctrl.CompleteOIDCLogin(&model.OIDCCallbackForm{State: &state, Code: &code})
+		if err != nil {
+			logger.Errorf("Failed to complete oidc login: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		// a reauthentication signs nobody in, the state is sent along with the change it confirms
+		if result.Reauth {
+			t := struct {
+				Message   string `json:"message,omitempty"`
+				OIDCState string `json:"oidc_state"`
+			}{
+				Message:   "Reauthenticated, send this as oidc_state with the change",
+				OIDCState: state,
+			}
+
+			writeResponse(w, t, http.StatusOK, logger)
+			return
+		}
+
+		if result.Challenge != "" {
+			writeLoginChallenge(w, result.Challenge, logger)
+			return
+		}
+
+		token, refreshToken, err := startSession(apictx, result.UserID, r)
+		if err != nil {
+			logger.Errorf("Failed to start session: %s", err.Error())
+			writeResponse(w, Error{Message: "Oops, failed to generate your token"}, http.StatusInternalServerError, logger)
+			return
+		}
+
+		t := struct {
+			Message      string `json:"message,omitempty"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{
+			Message:      "Welcome, welcome, use this as Authorization header",
+			Token:        token,
+			RefreshToken: refreshToken,
+		}
+
+		writeResponse(w, t, http.StatusOK, logger)
+	}
+}
+
+func NewBeginOIDCLinkHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginOIDCLink",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		authURL, err := apictx.ctrl.BeginOIDCLink(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to begin oidc link: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, authorizationURL{AuthorizationURL: authURL}, http.StatusOK, logger)
+	}
+}
+
+// NewBeginOIDCReauthHandler returns the URL of the identity provider to sign in at again before a sensitive change
+func NewBeginOIDCReauthHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginOIDCReauth",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		authURL, err := apictx.ctrl.BeginOIDCReauth(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to begin oidc reauth: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, authorizationURL{AuthorizationURL: authURL}, http.StatusOK, logger)
+	}
+}
+
+func NewListOIDCIdentitiesHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListOIDCIdentities",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		identities, err := apictx.ctrl.OIDCIdentities(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list oidc identities: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, identities, http.StatusOK, logger)
+	}
+}
@@ -95,7 +95,7 @@ func NewOpenAPIv3(cfg *Config, logger pmlogger.Logger) *openapi3.T {
 		Servers: openapi3.Servers{
 			&openapi3.Server{
 				Description: "Local development",
-				URL:         "http://" + cfg.LocalAddress(),
+				URL:         cfg.Scheme() + "://" + cfg.LocalAddress(),
 			},
 		},
 		Components: &openapi3.Components{
@@ -232,6 +232,28 @@ func NewOpenAPIv3(cfg *Config, logger pmlogger.Logger) *openapi3.T {
 				),
 			},
 		}),
+		openapi3.WithPath("/records/search", &openapi3.PathItem{
+			Get: &openapi3.Operation{
+				OperationID: "SearchRecords",
+				Parameters: []*openapi3.ParameterRef{{
+					Value: openapi3.NewQueryParameter("q").
+						WithDescription("Matches usernames, emails and URL hosts exactly or words of names and URL hosts").
+						WithRequired(true).
+						WithSchema(openapi3.NewStringSchema()),
+				}},
+				Responses: openapi3.NewResponses(
+					openapi3.WithStatus(200, &openapi3.ResponseRef{
+						Ref: "#/components/responses/ListRecordsResponse",
+					}),
+					openapi3.WithStatus(400, &openapi3.ResponseRef{
+						Ref: "#/components/responses/ErrorResponse",
+					}),
+					openapi3.WithStatus(500, &openapi3.ResponseRef{
+						Ref: "#/components/responses/ErrorResponse",
+					}),
+				),
+			},
+		}),
 		openapi3.WithPath(fmt.Sprintf("/records/{%s}", IDPPN), &openapi3.PathItem{
 			Get: &openapi3.Operation{
 				OperationID: "GetRecord",
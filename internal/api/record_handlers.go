@@ -40,6 +40,37 @@ func NewListRecordsHandler(apictx *APIContext) http.HandlerFunc {
 	}
 }
 
+func NewSearchRecordsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "SearchRecords",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		secureNotes, logins, cards, identities, err := apictx.ctrl.SearchRecords(rctx.userID, r.URL.Query().Get("q"))
+		if err != nil {
+			logger.Errorf("Failed to search records: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, struct {
+			SecureNotes []model.CredentialRecord `json:"secure_notes"`
+			Logins      []model.LoginRecord      `json:"logins"`
+			Cards       []model.CardRecord       `json:"cards"`
+			Identities  []model.IdentityRecord   `json:"identities"`
+		}{
+			SecureNotes: secureNotes,
+			Logins:      logins,
+			Cards:       cards,
+			Identities:  identities,
+		}, http.StatusOK, logger)
+	}
+}
+
 func NewGetRecordHandler(apictx *APIContext) http.HandlerFunc {
 	logger := apictx.logger.WithFields(pmlogger.Fields{
 		"handler": "GetRecord",
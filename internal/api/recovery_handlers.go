@@ -0,0 +1,251 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidCeremonyIDMessage = "Invalid ceremony ID"
+
+func NewSetupRecoveryHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "SetupRecovery",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.RecoverySetupForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		kit, err := apictx.ctrl.SetupRecovery(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to set up recovery: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, kit, http.StatusOK, logger)
+	}
+}
+
+// NewCollectRecoverySharesHandler hands the caller the shares it holds as a trustee, the server forgets them afterwards
+func NewCollectRecoverySharesHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CollectRecoveryShares",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		shares, err := apictx.ctrl.CollectRecoveryShares(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to collect recovery shares: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, shares, http.StatusOK, logger)
+	}
+}
+
+func NewStartRecoveryHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "StartRecovery",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var user model.UserForm
+		if err := readBody(r.Body, &user); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var name string
+		if user.Name != nil {
+			name = *user.Name
+		}
+
+		ceremony, secret, err := apictx.ctrl.StartRecovery(name)
+		if err != nil {
+			logger.Errorf("Failed to start recovery: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, struct {
+			*model.RecoveryCeremony
+			Secret string `json:"secret"`
+		}{
+			RecoveryCeremony: ceremony,
+			Secret:           secret,
+		}, http.StatusCreated, logger)
+	}
+}
+
+func NewRecoveryCeremoniesHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RecoveryCeremonies",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		ceremonies, err := apictx.ctrl.RecoveryCeremonies(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list recovery ceremonies: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, ceremonies, http.StatusOK, logger)
+	}
+}
+
+func NewCancelRecoveryHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CancelRecovery",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		ceremonyID, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("%s: %s", InvalidCeremonyIDMessage, err.Error())
+			writeResponse(w, Error{Message: InvalidCeremonyIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		ceremony, err := apictx.ctrl.CancelRecovery(ceremonyID, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to cancel recovery: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, ceremony, http.StatusOK, logger)
+	}
+}
+
+func NewGetRecoveryCeremonyHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "GetRecoveryCeremony",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		ceremonyID, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("%s: %s", InvalidCeremonyIDMessage, err.Error())
+			writeResponse(w, Error{Message: InvalidCeremonyIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		ceremony, err := apictx.ctrl.GetRecoveryCeremony(ceremonyID, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to get recovery ceremony: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, ceremony, http.StatusOK, logger)
+	}
+}
+
+func NewSubmitRecoveryShareHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "SubmitRecoveryShare",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		ceremonyID, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("%s: %s", InvalidCeremonyIDMessage, err.Error())
+			writeResponse(w, Error{Message: InvalidCeremonyIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var form model.RecoveryShareForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		ceremony, err := apictx.ctrl.SubmitRecoveryShare(ceremonyID, rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to submit recovery share: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, ceremony, http.StatusOK, logger)
+	}
+}
+
+func NewCompleteRecoveryHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CompleteRecovery",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		ceremonyID, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("%s: %s", InvalidCeremonyIDMessage, err.Error())
+			writeResponse(w, Error{Message: InvalidCeremonyIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var form model.RecoveryCompleteForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		vaultKey, err := apictx.ctrl.CompleteRecovery(ceremonyID, &form)
+		if err != nil {
+			logger.Errorf("Failed to complete recovery: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		// only set in client mode
+		writeResponse(w, struct {
+			VaultKey string `json:"vault_key,omitempty"`
+		}{
+			VaultKey: vaultKey,
+		}, http.StatusOK, logger)
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidServiceAccountIDMessage = "Invalid service account ID"
+
+func NewListServiceAccountsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListServiceAccounts",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		accounts, err := apictx.ctrl.AllServiceAccounts(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list service accounts: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, accounts, http.StatusOK, logger)
+	}
+}
+
+func NewGetServiceAccountHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "GetServiceAccount",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		account, err := apictx.ctrl.GetServiceAccount(id, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to get service account: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, account, http.StatusOK, logger)
+	}
+}
+
+func NewCreateServiceAccountHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CreateServiceAccount",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.ServiceAccountForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		account, err := apictx.ctrl.CreateServiceAccount(&form, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to create service account: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, account, http.StatusCreated, logger)
+	}
+}
+
+func NewDeleteServiceAccountHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "DeleteServiceAccount",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		account, err := apictx.ctrl.DeleteServiceAccount(id, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to delete service account: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, account, http.StatusOK, logger)
+	}
+}
+
+func NewGrantRecordsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "GrantRecords",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var form model.RecordGrantForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		account, err := apictx.ctrl.GrantRecords(id, &form, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to grant records: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, account, http.StatusOK, logger)
+	}
+}
+
+func NewRevokeGrantHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RevokeGrant",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		recordID, err := getNamedIDFrom(rctx.params, RecordIDPPN, logger)
+		if err != nil {
+			logger.Errorf("Invalid record id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidRecordIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		grant, err := apictx.ctrl.RevokeGrant(id, recordID, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to revoke grant: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, grant, http.StatusOK, logger)
+	}
+}
+
+func NewCreateServiceAccountTokenHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CreateServiceAccountToken",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var form model.AccessTokenForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		token, secret, err := apictx.ctrl.CreateServiceAccountToken(id, &form, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to create service account token: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		response := struct {
+			Message     string             `json:"message,omitempty"`
+			AccessToken *model.AccessToken `json:"access_token"`
+			Token       string             `json:"token"`
+		}{
+			Message:     "Store the token now, it is not shown again",
+			AccessToken: token,
+			Token:       secret,
+		}
+
+		writeResponse(w, response, http.StatusCreated, logger)
+	}
+}
+
+func NewRevokeServiceAccountTokenHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RevokeServiceAccountToken",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid service account id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidServiceAccountIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		tokenID, err := getNamedIDFrom(rctx.params, TokenIDPPN, logger)
+		if err != nil {
+			logger.Errorf("Invalid access token id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidAccessTokenIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		token, err := apictx.ctrl.RevokeServiceAccountToken(id, tokenID, rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to revoke service account token: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, token, http.StatusOK, logger)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidSessionIDMessage = "Invalid session ID"
+
+// startSession signs userID in on the device of r, it returns an access token and the refresh token of the new session
+func startSession(apictx *APIContext, userID uuid.UUID, r *http.Request) (string, string, error) {
+	session, refreshToken, err := apictx.ctrl.CreateSession(userID, r.UserAgent())
+	if err != nil {
+		return "", "", fmt.Errorf("create session: %w", err)
+	}
+
+	token, err := GenerateJWT(apictx.keys, userID.String(), session.ID.String())
+	if err != nil {
+		return "", "", fmt.Errorf("generate jwt: %w", err)
+	}
+
+	return token, refreshToken, nil
+}
+
+func NewRefreshTokenHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RefreshToken",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.RefreshForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var presented string
+		if form.RefreshToken != nil {
+			presented = *form.RefreshToken
+		}
+
+		session, refreshToken, err := apictx.ctrl.RefreshSession(presented)
+		if err != nil {
+			logger.Errorf("Failed to refresh session: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		token, err := GenerateJWT(apictx.keys, session.UserID.String(), session.ID.String())
+		if err != nil {
+			logger.Errorf("Failed to generate jwt: %s", err.Error())
+			writeResponse(w, Error{Message: "Oops, failed to generate your token"}, http.StatusInternalServerError, logger)
+			return
+		}
+
+		t := struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{
+			Token:        token,
+			RefreshToken: refreshToken,
+		}
+
+		writeResponse(w, t, http.StatusOK, logger)
+	}
+}
+
+func NewLogoutHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "Logout",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		if _, err := apictx.ctrl.RevokeSession(rctx.userID, rctx.sessionID); err != nil {
+			logger.Errorf("Failed to log out: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, nil, http.StatusNoContent, logger)
+	}
+}
+
+func NewListSessionsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListSessions",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		sessions, err := apictx.ctrl.AllSessions(rctx.userID, rctx.sessionID)
+		if err != nil {
+			logger.Errorf("Failed to list sessions: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, sessions, http.StatusOK, logger)
+	}
+}
+
+func NewRevokeSessionHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RevokeSession",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid session id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidSessionIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		session, err := apictx.ctrl.RevokeSession(rctx.userID, id)
+		if err != nil {
+			logger.Errorf("Failed to revoke session: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, session, http.StatusOK, logger)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+func NewCompleteLoginHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "CompleteLogin",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.SecondFactorForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		id, err := apictx.ctrl.CompleteLogin(&form)
+		if err != nil {
+			logger.Errorf("Failed to complete login: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		token, refreshToken, err := startSession(apictx, id, r)
+		if err != nil {
+			logger.Errorf("Failed to start session: %s", err.Error())
+			writeResponse(w, Error{Message: "Oops, failed to generate your token"}, http.StatusInternalServerError, logger)
+			return
+		}
+
+		t := struct {
+			Message      string `json:"message,omitempty"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{
+			Message:      "Welcome, welcome, use this as Authorization header",
+			Token:        token,
+			RefreshToken: refreshToken,
+		}
+
+		writeResponse(w, t, http.StatusOK, logger)
+	}
+}
+
+func NewEnrollTOTPHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "EnrollTOTP",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		enrollment, err := apictx.ctrl.EnrollTOTP(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to enroll totp: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, enrollment, http.StatusOK, logger)
+	}
+}
+
+func NewConfirmTOTPHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ConfirmTOTP",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.TOTPConfirmForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		codes, err := apictx.ctrl.ConfirmTOTP(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to confirm totp: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeRecoveryCodes(w, codes, logger)
+	}
+}
+
+func NewDisableTOTPHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "DisableTOTP",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.ReauthForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		if err := apictx.ctrl.DisableTOTP(rctx.userID, &form); err != nil {
+			logger.Errorf("Failed to disable totp: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, nil, http.StatusNoContent, logger)
+	}
+}
+
+func NewRegenerateRecoveryCodesHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "RegenerateRecoveryCodes",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.ReauthForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		codes, err := apictx.ctrl.RegenerateRecoveryCodes(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to regenerate recovery codes: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeRecoveryCodes(w, codes, logger)
+	}
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string, logger pmlogger.Logger) {
+	response := struct {
+		Message       string   `json:"message,omitempty"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		Message:       "Store the recovery codes now, they are not shown again and each works once",
+		RecoveryCodes: codes,
+	}
+
+	writeResponse(w, response, http.StatusOK, logger)
+}
@@ -7,6 +7,39 @@ import (
 	"github.com/ChillyWR/PasswordManager/model"
 )
 
+func NewPreloginHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "Prelogin",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var user model.UserForm
+		if err := readBody(r.Body, &user); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		var name string
+		if user.Name != nil {
+			name = *user.Name
+		}
+
+		prelogin, err := apictx.ctrl.Prelogin(name)
+		if err != nil {
+			logger.Errorf("Failed to prelogin: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, prelogin, http.StatusOK, logger)
+	}
+}
+
 func NewLoginHandler(apictx *APIContext) http.HandlerFunc {
 	logger := apictx.logger.WithFields(pmlogger.Fields{
 		"handler": "ListUsers",
@@ -24,26 +57,33 @@ func NewLoginHandler(apictx *APIContext) http.HandlerFunc {
 			return
 		}
 
-		id, err := apictx.ctrl.Login(&user)
+		id, challenge, err := apictx.ctrl.Login(&user)
 		if err != nil {
 			logger.Errorf("Failed to login: %s", err.Error())
 			writeError(w, err, logger)
 			return
 		}
 
-		token, err := GenerateJWT(id.String())
+		if challenge != "" {
+			writeLoginChallenge(w, challenge, logger)
+			return
+		}
+
+		token, refreshToken, err := startSession(apictx, id, r)
 		if err != nil {
-			logger.Errorf("Failed to generate jwt: %s", err.Error())
+			logger.Errorf("Failed to start session: %s", err.Error())
 			writeResponse(w, Error{Message: "Oops, failed to generate your token"}, http.StatusInternalServerError, logger)
 			return
 		}
 
 		t := struct {
-			Message string `json:"message,omitempty"`
-			Token   string `json:"token"`
+			Message      string `json:"message,omitempty"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
 		}{
-			Message: "Welcome, welcome, use this as Authorization header",
-			Token:   token,
+			Message:      "Welcome, welcome, use this as Authorization header",
+			Token:        token,
+			RefreshToken: refreshToken,
 		}
 
 		writeResponse(w, t, http.StatusOK, logger)
@@ -60,7 +100,7 @@ func NewListUsersHandler(apictx *APIContext) http.HandlerFunc {
 			"cor_id": rctx.corID.String(),
 		})
 
-		users, err := apictx.ctrl.AllUsers()
+		users, err := apictx.ctrl.AllUsers(rctx.userID)
 		if err != nil {
 			logger.Errorf("Failed to list users: %s", err.Error())
 			writeError(w, err, logger)
@@ -88,7 +128,7 @@ func NewGetUserHandler(apictx *APIContext) http.HandlerFunc {
 			return
 		}
 
-		user, err := apictx.ctrl.GetUser(userID)
+		user, err := apictx.ctrl.GetUser(userID, rctx.userID)
 		if err != nil {
 			logger.Errorf("Failed to get user: %s", err.Error())
 			writeError(w, err, logger)
@@ -123,19 +163,21 @@ func NewCreateUserHandler(apictx *APIContext) http.HandlerFunc {
 			return
 		}
 
-		token, err := GenerateJWT(result.ID.String())
+		token, refreshToken, err := startSession(apictx, result.ID, r)
 		if err != nil {
-			logger.Errorf("Failed to generate jwt: %s", err.Error())
+			logger.Errorf("Failed to start session: %s", err.Error())
 			writeResponse(w, nil, http.StatusInternalServerError, logger)
 			return
 		}
 
 		response := struct {
-			User  *model.User
-			Token string
+			User         *model.User
+			Token        string
+			RefreshToken string
 		}{
-			User:  result,
-			Token: token,
+			User:         result,
+			Token:        token,
+			RefreshToken: refreshToken,
 		}
 
 		writeResponse(w, response, http.StatusCreated, logger)
@@ -166,7 +208,7 @@ func NewUpdateUserHandler(apictx *APIContext) http.HandlerFunc {
 			return
 		}
 
-		result, err := apictx.ctrl.UpdateUser(userID, &form)
+		result, err := apictx.ctrl.UpdateUser(userID, &form, rctx.userID)
 		if err != nil {
 			logger.Errorf("Failed to update user: %s", err.Error())
 			writeError(w, err, logger)
@@ -194,7 +236,7 @@ func NewDeleteUserHandler(apictx *APIContext) http.HandlerFunc {
 			return
 		}
 
-		result, err := apictx.ctrl.DeleteUser(userID)
+		result, err := apictx.ctrl.DeleteUser(userID, rctx.userID)
 		if err != nil {
 			logger.Errorf("Failed to delete user: %s", err.Error())
 			writeError(w, err, logger)
@@ -204,3 +246,18 @@ func NewDeleteUserHandler(apictx *APIContext) http.HandlerFunc {
 		writeResponse(w, result, http.StatusOK, logger)
 	}
 }
+
+// writeLoginChallenge asks for the second factor of a login, it is completed at /login/second-factor
+func writeLoginChallenge(w http.ResponseWriter, challenge string, logger pmlogger.Logger) {
+	response := struct {
+		Message        string `json:"message,omitempty"`
+		ChallengeToken string `json:"challenge_token"`
+		ExpiresIn      int    `json:"expires_in"`
+	}{
+		Message:        "Enter a code of your authenticator app, a recovery code or use a security key to complete the login",
+		ChallengeToken: challenge,
+		ExpiresIn:      int(model.LoginChallengeTTL.Seconds()),
+	}
+
+	writeResponse(w, response, http.StatusAccepted, logger)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+const InvalidWebAuthnCredentialIDMessage = "Invalid webauthn credential ID"
+
+func NewBeginWebAuthnLoginHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginWebAuthnLogin",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		options, err := apictx.ctrl.BeginWebAuthnLogin()
+		if err != nil {
+			logger.Errorf("Failed to begin webauthn login: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, options, http.StatusOK, logger)
+	}
+}
+
+func NewWebAuthnLoginHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "WebAuthnLogin",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.WebAuthnLoginForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		id, err := apictx.ctrl.WebAuthnLogin(&form)
+		if err != nil {
+			logger.Errorf("Failed to login with webauthn: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		token, refreshToken, err := startSession(apictx, id, r)
+		if err != nil {
+			logger.Errorf("Failed to start session: %s", err.Error())
+			writeResponse(w, Error{Message: "Oops, failed to generate your token"}, http.StatusInternalServerError, logger)
+			return
+		}
+
+		t := struct {
+			Message      string `json:"message,omitempty"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{
+			Message:      "Welcome, welcome, use this as Authorization header",
+			Token:        token,
+			RefreshToken: refreshToken,
+		}
+
+		writeResponse(w, t, http.StatusOK, logger)
+	}
+}
+
+func NewBeginWebAuthnSecondFactorHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginWebAuthnSecondFactor",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.WebAuthnOptionsForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		options, err := apictx.ctrl.BeginWebAuthnSecondFactor(&form)
+		if err != nil {
+			logger.Errorf("Failed to begin webauthn second factor: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, options, http.StatusOK, logger)
+	}
+}
+
+func NewBeginWebAuthnRegistrationHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "BeginWebAuthnRegistration",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		options, err := apictx.ctrl.BeginWebAuthnRegistration(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to begin webauthn registration: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, options, http.StatusOK, logger)
+	}
+}
+
+func NewFinishWebAuthnRegistrationHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "FinishWebAuthnRegistration",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		var form model.WebAuthnRegistrationForm
+		if err := readBody(r.Body, &form); err != nil {
+			logger.Errorf("Failed to read body: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidJSONMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		credential, err := apictx.ctrl.FinishWebAuthnRegistration(rctx.userID, &form)
+		if err != nil {
+			logger.Errorf("Failed to finish webauthn registration: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, credential, http.StatusCreated, logger)
+	}
+}
+
+func NewListWebAuthnCredentialsHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "ListWebAuthnCredentials",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		credentials, err := apictx.ctrl.WebAuthnCredentials(rctx.userID)
+		if err != nil {
+			logger.Errorf("Failed to list webauthn credentials: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, credentials, http.StatusOK, logger)
+	}
+}
+
+func NewDeleteWebAuthnCredentialHandler(apictx *APIContext) http.HandlerFunc {
+	logger := apictx.logger.WithFields(pmlogger.Fields{
+		"handler": "DeleteWebAuthnCredential",
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := unpackRequestContext(r.Context(), logger)
+		logger = logger.WithFields(pmlogger.Fields{
+			"cor_id": rctx.corID.String(),
+		})
+
+		id, err := getIDFrom(rctx.params, logger)
+		if err != nil {
+			logger.Errorf("Invalid webauthn credential id: %s", err.Error())
+			writeResponse(w, Error{Message: InvalidWebAuthnCredentialIDMessage}, http.StatusBadRequest, logger)
+			return
+		}
+
+		credential, err := apictx.ctrl.DeleteWebAuthnCredential(rctx.userID, id)
+		if err != nil {
+			logger.Errorf("Failed to delete webauthn credential: %s", err.Error())
+			writeError(w, err, logger)
+			return
+		}
+
+		writeResponse(w, credential, http.StatusOK, logger)
+	}
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+// CreateAccessToken issues a personal access token of userID, the token itself is returned once
+func (c *Controller) CreateAccessToken(userID uuid.UUID, form *model.AccessTokenForm) (*model.AccessToken, string, error) {
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if err := form.Validate(now); err != nil {
+		return nil, "", fmt.Errorf("validate: %w", err)
+	}
+
+	for _, scope := range form.Scopes {
+		if scope != model.ScopeUsersAdmin {
+			continue
+		}
+
+		if err := c.authorizeAdmin(userID); err != nil {
+			return nil, "", fmt.Errorf("authorize %s: %w", scope, err)
+		}
+	}
+
+	token, secret, err := newAccessToken(form, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token.UserID = userID
+	if err := c.tokenRepo.Create(token); err != nil {
+		return nil, "", fmt.Errorf("create access token: %w", err)
+	}
+
+	c.log.Infof("Created access token %s of user %s with scopes %v", token.ID, userID, token.Scopes)
+
+	return token, secret, nil
+}
+
+// newAccessToken builds a token from a validated form, the caller sets whom it belongs to
+func newAccessToken(form *model.AccessTokenForm, now time.Time) (*model.AccessToken, string, error) {
+	secret, err := randomToken(model.AccessTokenPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &model.AccessToken{
+		ID:        uuid.New(),
+		Name:      *form.Name,
+		Hash:      hashToken(secret),
+		Scopes:    form.Scopes,
+		CreatedOn: now,
+		ExpiresOn: pmtime.TruncateToMillisecond(form.ExpiresOn.UTC()),
+	}, secret, nil
+}
+
+func (c *Controller) AllAccessTokens(userID uuid.UUID) ([]model.AccessToken, error) {
+	tokens, err := c.tokenRepo.GetAll(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get access tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (c *Controller) RevokeAccessToken(userID, id uuid.UUID) (*model.AccessToken, error) {
+	token, err := c.tokenRepo.Revoke(userID, id, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("revoke access token: %w", err)
+	}
+
+	c.log.Infof("Revoked access token %s of user %s", id, userID)
+
+	return token, nil
+}
+
+// AuthenticateAccessToken resolves a personal access token presented from ip and records its use
+func (c *Controller) AuthenticateAccessToken(secret, ip string) (*model.AccessToken, error) {
+	if !strings.HasPrefix(secret, model.AccessTokenPrefix) {
+		return nil, fmt.Errorf("%w: not an access token", pmerror.ErrUnauthorized)
+	}
+
+	token, err := c.tokenRepo.GetByHash(hashToken(secret))
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown access token", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return nil, fmt.Errorf("get access token: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if !token.Active(now) {
+		return nil, fmt.Errorf("%w: access token %s is revoked or expired", pmerror.ErrUnauthorized, token.ID)
+	}
+
+	if err := c.tokenRepo.Touch(token.ID, now, ip); err != nil {
+		// not fatal, only the last use shown to the owner is stale
+		c.log.Errorf("Failed to record use of access token %s: %s", token.ID, err.Error())
+	}
+
+	token.LastUsedOn, token.LastUsedIP = &now, ip
+
+	return token, nil
+}
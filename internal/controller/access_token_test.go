@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+// expectAccessTokenStore backs the access token repository mock with memory
+func expectAccessTokenStore(mocks *controllerMocks) map[string]*model.AccessToken {
+	tokens := make(map[string]*model.AccessToken)
+
+	r := mocks.TokenRepository.EXPECT()
+	r.Create(gomock.Any()).AnyTimes().DoAndReturn(func(token *model.AccessToken) error {
+		stored := *token
+		tokens[token.Hash] = &stored
+		return nil
+	})
+	r.GetByHash(gomock.Any()).AnyTimes().DoAndReturn(func(hash string) (*model.AccessToken, error) {
+		if token, ok := tokens[hash]; ok {
+			result := *token
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.GetAll(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) ([]model.AccessToken, error) {
+		var result []model.AccessToken
+		for _, token := range tokens {
+			if token.UserID == userID {
+				result = append(result, *token)
+			}
+		}
+		return result, nil
+	})
+	r.Touch(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID, now time.Time, ip string) error {
+		for _, token := range tokens {
+			if token.ID == id {
+				token.LastUsedOn, token.LastUsedIP = &now, ip
+			}
+		}
+		return nil
+	})
+	r.Revoke(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID, id uuid.UUID, now time.Time) (*model.AccessToken, error) {
+		for _, token := range tokens {
+			if token.ID == id && token.UserID == userID && token.RevokedOn == nil {
+				token.RevokedOn = &now
+				result := *token
+				return &result, nil
+			}
+		}
+		return nil, pmerror.ErrNotFound
+	})
+
+	return tokens
+}
+
+func TestController_AccessToken(t *testing.T) {
+	userID := uuid.New()
+	expiresOn := time.Now().Add(time.Hour)
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				tokens := expectAccessTokenStore(mocks)
+
+				token, secret, err := c.CreateAccessToken(userID, &model.AccessTokenForm{
+					Name:      pmpointer.String("Test CI"),
+					Scopes:    []model.Scope{model.ScopeRecordsRead},
+					ExpiresOn: &expiresOn,
+				})
+				require.NoError(t, err)
+				require.Contains(t, tokens, hashToken(secret))
+
+				authenticated, err := c.AuthenticateAccessToken(secret, "192.0.2.1")
+				require.NoError(t, err)
+				require.Equal(t, token.ID, authenticated.ID)
+				require.Equal(t, userID, authenticated.UserID)
+				require.True(t, authenticated.HasScope(model.ScopeRecordsRead))
+				require.False(t, authenticated.HasScope(model.ScopeRecordsWrite))
+
+				stored := tokens[hashToken(secret)]
+				require.NotNil(t, stored.LastUsedOn)
+				require.Equal(t, "192.0.2.1", stored.LastUsedIP)
+			},
+		},
+		{
+			Name: "success_admin_scope",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectAccessTokenStore(mocks)
+
+				mocks.UserRepository.EXPECT().
+					Get(userID).
+					Return(&model.User{ID: userID, IsAdmin: true}, nil)
+
+				_, _, err := c.CreateAccessToken(userID, &model.AccessTokenForm{
+					Name:      pmpointer.String("Test Admin"),
+					Scopes:    []model.Scope{model.ScopeUsersAdmin},
+					ExpiresOn: &expiresOn,
+				})
+				require.NoError(t, err)
+			},
+		},
+		{
+			Name: "error_admin_scope_not_admin",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				mocks.UserRepository.EXPECT().
+					Get(userID).
+					Return(&model.User{ID: userID}, nil)
+
+				_, _, err := c.CreateAccessToken(userID, &model.AccessTokenForm{
+					Name:      pmpointer.String("Test Admin"),
+					Scopes:    []model.Scope{model.ScopeRecordsRead, model.ScopeUsersAdmin},
+					ExpiresOn: &expiresOn,
+				})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+		{
+			Name: "error_invalid_form",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				tooLate := time.Now().Add(model.MaxAccessTokenTTL + time.Hour)
+				for _, form := range []model.AccessTokenForm{
+					{Name: pmpointer.String("Test"), Scopes: []model.Scope{"records:delete"}, ExpiresOn: &expiresOn},
+					{Name: pmpointer.String("Test"), Scopes: []model.Scope{model.ScopeRecordsRead}},
+					{Name: pmpointer.String("Test"), Scopes: []model.Scope{model.ScopeRecordsRead}, ExpiresOn: &tooLate},
+				} {
+					_, _, err := c.CreateAccessToken(userID, &form)
+					require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+				}
+			},
+		},
+		{
+			Name: "error_revoked_and_expired",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				tokens := expectAccessTokenStore(mocks)
+
+				token, secret, err := c.CreateAccessToken(userID, &model.AccessTokenForm{
+					Name:      pmpointer.String("Test CI"),
+					Scopes:    []model.Scope{model.ScopeRecordsWrite},
+					ExpiresOn: &expiresOn,
+				})
+				require.NoError(t, err)
+
+				tokens[hashToken(secret)].ExpiresOn = time.Now().Add(-time.Minute)
+				_, err = c.AuthenticateAccessToken(secret, "192.0.2.1")
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+
+				tokens[hashToken(secret)].ExpiresOn = expiresOn
+				_, err = c.RevokeAccessToken(userID, token.ID)
+				require.NoError(t, err)
+				_, err = c.AuthenticateAccessToken(secret, "192.0.2.1")
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+
+				_, err = c.AuthenticateAccessToken(model.AccessTokenPrefix+"unknown", "192.0.2.1")
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
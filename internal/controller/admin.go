@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// KeyUsage reports how many encrypted fields use each server key.
+// Fields encrypted with data keys of individual users are grouped under model.PerUserKeyID.
+func (c *Controller) KeyUsage(userID uuid.UUID) ([]model.KeyUsage, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, id := range c.keys.IDs() {
+		counts[id] = 0
+	}
+
+	err := c.keyRepo.ForEachEncrypted(func(value string, serverKey bool) error {
+		id, err := pmcrypto.KeyIDOf(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+		}
+
+		if !serverKey && id != pmcrypto.LegacyKeyID {
+			id = model.PerUserKeyID
+		}
+
+		counts[id]++
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("count: %w", err)
+	}
+
+	active := c.keys.ActiveID()
+	usage := make([]model.KeyUsage, 0, len(counts))
+	for id, fields := range counts {
+		usage = append(usage, model.KeyUsage{KeyID: id, Active: id == active, Fields: fields})
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].KeyID < usage[j].KeyID })
+
+	return usage, nil
+}
+
+func (c *Controller) authorizeAdmin(userID uuid.UUID) error {
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	if !user.IsAdmin {
+		return fmt.Errorf("%w: user %s is not an admin", pmerror.ErrForbidden, userID.String())
+	}
+
+	return nil
+}
+
+// authorizeUser lets userID manage the account id when it is its own or userID is an admin
+func (c *Controller) authorizeUser(id, userID uuid.UUID) error {
+	if id == userID {
+		return nil
+	}
+
+	return c.authorizeAdmin(userID)
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+func TestController_KeyUsage(t *testing.T) {
+	adminID := uuid.New()
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				legacy, err := pmcrypto.Encrypt("value", Salt)
+				require.NoError(t, err)
+
+				server, err := c.keys.Encrypt("value")
+				require.NoError(t, err)
+
+				dataKey := "abcdefghijklmnopqrstuvwxyz123456"
+				vault, err := pmcrypto.EncryptAEAD("value", dataKey, pmcrypto.KeyID(dataKey))
+				require.NoError(t, err)
+
+				mocks.UserRepository.EXPECT().
+					Get(adminID).
+					Return(&model.User{ID: adminID, IsAdmin: true}, nil)
+
+				mocks.KeyRepository.EXPECT().
+					ForEachEncrypted(gomock.Any()).
+					DoAndReturn(func(fn func(string, bool) error) error {
+						require.NoError(t, fn(legacy, true))
+						require.NoError(t, fn(server, true))
+						require.NoError(t, fn(legacy, false))
+						require.NoError(t, fn(vault, false))
+						require.NoError(t, fn(vault, false))
+						return nil
+					})
+
+				usage, err := c.KeyUsage(adminID)
+				require.NoError(t, err)
+				require.Equal(t, []model.KeyUsage{
+					{KeyID: pmcrypto.LegacyKeyID, Fields: 2},
+					{KeyID: model.PerUserKeyID, Fields: 2},
+					{KeyID: testServerKeyID, Active: true, Fields: 1},
+				}, usage)
+			},
+		},
+		{
+			Name: "error_not_admin",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				mocks.UserRepository.EXPECT().
+					Get(adminID).
+					Return(&model.User{ID: adminID}, nil)
+
+				_, err := c.KeyUsage(adminID)
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
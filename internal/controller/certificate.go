@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+func (c *Controller) AllCertificateMappings(userID uuid.UUID) ([]model.CertificateMapping, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	mappings, err := c.certRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("get certificate mappings: %w", err)
+	}
+
+	return mappings, nil
+}
+
+// CreateCertificateMapping lets clients presenting a certificate with the subject of form act as its user or service account.
+// Like their access tokens, service accounts are limited to model.ScopeRecordsRead.
+func (c *Controller) CreateCertificateMapping(form *model.CertificateMappingForm, userID uuid.UUID) (*model.CertificateMapping, error) {
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	mapping := &model.CertificateMapping{
+		ID:               uuid.New(),
+		Subject:          *form.Subject,
+		ServiceAccountID: form.ServiceAccountID,
+		Scopes:           form.Scopes,
+		CreatedBy:        &userID,
+		CreatedOn:        pmtime.TruncateToMillisecond(time.Now().UTC()),
+	}
+
+	if form.ServiceAccountID != nil {
+		for _, scope := range form.Scopes {
+			if scope != model.ScopeRecordsRead {
+				return nil, fmt.Errorf("%w: service accounts can not have scope %s", pmerror.ErrInvalidInput, scope)
+			}
+		}
+
+		if _, err := c.serviceRepo.Get(*form.ServiceAccountID); err != nil {
+			return nil, fmt.Errorf("get service account: %w", err)
+		}
+	} else {
+		if _, err := c.userRepo.Get(*form.UserID); err != nil {
+			return nil, fmt.Errorf("get user: %w", err)
+		}
+
+		mapping.UserID = *form.UserID
+	}
+
+	if err := c.certRepo.Create(mapping); err != nil {
+		return nil, fmt.Errorf("create certificate mapping: %w", err)
+	}
+
+	c.log.Infof("User %s mapped certificate subject %s to %s with scopes %v", userID, mapping.Subject, mapping.Principal(), mapping.Scopes)
+
+	return mapping, nil
+}
+
+func (c *Controller) DeleteCertificateMapping(id uuid.UUID, userID uuid.UUID) (*model.CertificateMapping, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	mapping, err := c.certRepo.Delete(id)
+	if err != nil {
+		return nil, fmt.Errorf("delete certificate mapping: %w", err)
+	}
+
+	c.log.Infof("User %s deleted certificate mapping %s of subject %s", userID, id, mapping.Subject)
+
+	return mapping, nil
+}
+
+// AuthenticateCertificate resolves the mapping of the first of subjects that has one, subjects are those
+// of a client certificate the TLS handshake already verified against the trusted CAs
+func (c *Controller) AuthenticateCertificate(subjects []string) (*model.CertificateMapping, error) {
+	var mapping *model.CertificateMapping
+	for _, subject := range subjects {
+		m, err := c.certRepo.GetBySubject(subject)
+		if errors.Is(err, pmerror.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("get certificate mapping: %w", err)
+		}
+
+		mapping = m
+		break
+	}
+
+	if mapping == nil {
+		return nil, fmt.Errorf("%w: no mapping for certificate subjects %v", pmerror.ErrUnauthorized, subjects)
+	}
+
+	if mapping.ServiceAccountID == nil {
+		user, err := c.userRepo.Get(mapping.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("get user: %w", err)
+		}
+
+		if user.DisabledOn != nil {
+			return nil, fmt.Errorf("%w: user %s is disabled", pmerror.ErrUnauthorized, user.ID)
+		}
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if err := c.certRepo.Touch(mapping.ID, now); err != nil {
+		// not fatal, only the last use shown to admins is stale
+		c.log.Errorf("Failed to record use of certificate mapping %s: %s", mapping.ID, err.Error())
+	}
+
+	mapping.LastUsedOn = &now
+
+	return mapping, nil
+}
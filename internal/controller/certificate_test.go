@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+// expectCertificateStore backs the certificate repository mock with memory, keyed by subject
+func expectCertificateStore(mocks *controllerMocks) map[string]*model.CertificateMapping {
+	mappings := make(map[string]*model.CertificateMapping)
+
+	r := mocks.CertRepository.EXPECT()
+	r.Create(gomock.Any()).AnyTimes().DoAndReturn(func(mapping *model.CertificateMapping) error {
+		stored := *mapping
+		mappings[mapping.Subject] = &stored
+		return nil
+	})
+	r.GetBySubject(gomock.Any()).AnyTimes().DoAndReturn(func(subject string) (*model.CertificateMapping, error) {
+		if mapping, ok := mappings[subject]; ok {
+			result := *mapping
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.Touch(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID, now time.Time) error {
+		for _, mapping := range mappings {
+			if mapping.ID == id {
+				mapping.LastUsedOn = &now
+			}
+		}
+		return nil
+	})
+
+	return mappings
+}
+
+func TestController_Certificate(t *testing.T) {
+	adminID := uuid.New()
+	const spiffeID = "spiffe://example.org/billing"
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_service_account",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectAdmin(t, c, mocks, adminID)
+				accounts := expectServiceAccountStore(mocks)
+				mappings := expectCertificateStore(mocks)
+
+				accountID := uuid.New()
+				accounts[accountID] = &model.ServiceAccount{ID: accountID, Name: "billing"}
+
+				mapping, err := c.CreateCertificateMapping(&model.CertificateMappingForm{
+					Subject:          pmpointer.String(spiffeID),
+					ServiceAccountID: &accountID,
+					Scopes:           []model.Scope{model.ScopeRecordsRead},
+				}, adminID)
+				require.NoError(t, err)
+				require.Equal(t, accountID, mapping.Principal())
+
+				// the URI SAN is preferred over a common name that is mapped to someone else
+				mappings["CN=billing"] = &model.CertificateMapping{ID: uuid.New(), Subject: "CN=billing", UserID: adminID}
+
+				authenticated, err := c.AuthenticateCertificate([]string{spiffeID, "CN=billing"})
+				require.NoError(t, err)
+				require.Equal(t, mapping.ID, authenticated.ID)
+				require.True(t, authenticated.HasScope(model.ScopeRecordsRead))
+				require.NotNil(t, mappings[spiffeID].LastUsedOn)
+			},
+		},
+		{
+			Name: "error_create",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectAdmin(t, c, mocks, adminID)
+				accounts := expectServiceAccountStore(mocks)
+				expectCertificateStore(mocks)
+
+				accountID := uuid.New()
+				accounts[accountID] = &model.ServiceAccount{ID: accountID, Name: "billing"}
+
+				for _, form := range []model.CertificateMappingForm{
+					{Subject: pmpointer.String("billing"), UserID: &adminID, Scopes: []model.Scope{model.ScopeRecordsRead}},
+					{Subject: pmpointer.String("CN="), UserID: &adminID, Scopes: []model.Scope{model.ScopeRecordsRead}},
+					{Subject: pmpointer.String(spiffeID), UserID: &adminID, ServiceAccountID: &accountID, Scopes: []model.Scope{model.ScopeRecordsRead}},
+					{Subject: pmpointer.String(spiffeID), UserID: &adminID},
+					{Subject: pmpointer.String(spiffeID), ServiceAccountID: &accountID, Scopes: []model.Scope{model.ScopeRecordsWrite}},
+				} {
+					_, err := c.CreateCertificateMapping(&form, adminID)
+					require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+				}
+
+				userID := uuid.New()
+				mocks.UserRepository.EXPECT().Get(userID).Return(&model.User{ID: userID}, nil)
+
+				_, err := c.CreateCertificateMapping(&model.CertificateMappingForm{
+					Subject: pmpointer.String("CN=billing"),
+					UserID:  &adminID,
+					Scopes:  []model.Scope{model.ScopeRecordsRead},
+				}, userID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_authenticate",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				mappings := expectCertificateStore(mocks)
+
+				_, err := c.AuthenticateCertificate([]string{spiffeID, "CN=billing"})
+				require.ErrorIs(t, err, pmerror.ErrUnauthorized)
+
+				// users disabled by the directory sync lose certificate access along with their tokens
+				disabledOn := time.Now()
+				userID := uuid.New()
+				mappings["CN=billing"] = &model.CertificateMapping{ID: uuid.New(), Subject: "CN=billing", UserID: userID}
+				mocks.UserRepository.EXPECT().Get(userID).Return(&model.User{ID: userID, DisabledOn: &disabledOn}, nil)
+
+				_, err = c.AuthenticateCertificate([]string{"CN=billing"})
+				require.ErrorIs(t, err, pmerror.ErrUnauthorized)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
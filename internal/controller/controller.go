@@ -2,50 +2,358 @@ package controller
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 
 	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
 	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
 )
 
 const (
+	// Salt is the legacy vault key shared by all users. It is only used to
+	// read data of users that have not logged in since per-user keys were introduced.
 	Salt = "abc&1*~#^2^#s0^=)^^7%b34"
 )
 
+// CryptoMode is chosen per deployment, switching an existing deployment does not convert stored records
+type CryptoMode string
+
+const (
+	// CryptoModeServer encrypts record fields on the server with per-user data keys
+	CryptoModeServer CryptoMode = "server"
+	// CryptoModeClient stores fields encrypted by clients with keys they derive from the master password,
+	// the server never sees plaintext. Clients should log in with a hash derived from that key rather than the password.
+	CryptoModeClient CryptoMode = "client"
+)
+
+// RecordRepository enforces ownership itself, every method only reaches records of the acting user.
+// Records of other users are reported as pmerror.ErrForbidden, records that do not exist as pmerror.ErrNotFound.
 type RecordRepository interface {
 	GetAll(userID uuid.UUID) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error)
-	GetCredentialRecord(id uuid.UUID) (*model.CredentialRecord, error)
-	GetLogin(id uuid.UUID) (*model.LoginRecord, error)
-	GetCard(id uuid.UUID) (*model.CardRecord, error)
-	GetIdentity(id uuid.UUID) (*model.IdentityRecord, error)
-	CreateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error)
-	CreateLogin(record *model.LoginRecord) (*model.LoginRecord, error)
-	CreateCard(record *model.CardRecord) (*model.CardRecord, error)
-	CreateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error)
-	UpdateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error)
-	UpdateLogin(record *model.LoginRecord) (*model.LoginRecord, error)
-	UpdateCard(record *model.CardRecord) (*model.CardRecord, error)
-	UpdateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error)
-	Delete(id uuid.UUID) (*model.CredentialRecord, error)
+	// GetRecord returns a record along with its type specific fields in a single query
+	GetRecord(userID, id uuid.UUID) (interface{}, error)
+	// GetVaultRecord returns a record like GetRecord along with the owner's wrapped data key, read by the same query
+	GetVaultRecord(userID, id uuid.UUID) (*model.User, interface{}, error)
+	// GetVault returns the owner with its wrapped data key and the records with ids, all of them when ids is nil,
+	// read in one transaction
+	GetVault(userID uuid.UUID, ids []uuid.UUID) (*model.User, *model.Vault, error)
+	// Create and Update methods replace the blind index entries of the record in the same transaction
+	CreateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error)
+	CreateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error)
+	CreateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error)
+	CreateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error)
+	UpdateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error)
+	UpdateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error)
+	UpdateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error)
+	UpdateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error)
+	Delete(userID, id uuid.UUID) (*model.CredentialRecord, error)
+	// Rekey atomically rewrites the vault records and the owner's key parameters
+	Rekey(user *model.User, vault *model.Vault) error
+	// Search returns the IDs of records by blind index token in a single query
+	Search(userID uuid.UUID, tokens []string) (map[string][]uuid.UUID, error)
 }
 
 type UserRepository interface {
 	GetAll() ([]model.User, error)
 	Get(id uuid.UUID) (*model.User, error)
 	GetByName(name string) (*model.User, error)
+	GetByDirectoryDN(dn string) (*model.User, error)
+	GetDirectoryUsers() ([]model.User, error)
 	Create(user *model.User) (*model.User, error)
 	Update(user *model.User) (*model.User, error)
 	Delete(id uuid.UUID) (*model.User, error)
+	SetDisabled(id uuid.UUID, disabledOn *time.Time) error
+}
+
+type KeyRepository interface {
+	ForEachEncrypted(fn func(value string, serverKey bool) error) error
+}
+
+type RecoveryRepository interface {
+	GetSetup(userID uuid.UUID) (*model.RecoverySetup, error)
+	// SaveSetup replaces the recovery setup of a user along with the shares held for its trustees
+	SaveSetup(setup *model.RecoverySetup, shares []model.RecoveryShare) error
+	GetPendingShares(trusteeID uuid.UUID) ([]model.RecoveryShare, error)
+	DeletePendingShares(trusteeID uuid.UUID, userIDs []uuid.UUID) error
+	CreateCeremony(ceremony *model.RecoveryCeremony) error
+	// GetCeremonies returns the open ceremonies recovering a user
+	GetCeremonies(userID uuid.UUID) ([]model.RecoveryCeremony, error)
+	GetCeremony(id uuid.UUID) (*model.RecoveryCeremony, error)
+	// CloseCeremony sets the final status of an open ceremony and deletes its shares
+	CloseCeremony(id uuid.UUID, status model.RecoveryCeremonyStatus) error
+	AddShare(ceremonyID, trusteeID uuid.UUID, share string) error
+	GetShares(ceremonyID uuid.UUID) (map[uuid.UUID]string, error)
+	AddEvent(event *model.RecoveryEvent) error
+	GetEvents(ceremonyID uuid.UUID) ([]model.RecoveryEvent, error)
+}
+
+type SessionRepository interface {
+	// Create stores a new session along with its first refresh token
+	Create(session *model.Session, token *model.RefreshToken) error
+	Get(id uuid.UUID) (*model.Session, error)
+	// GetActive returns the sessions of a user that are neither revoked nor expired at now
+	GetActive(userID uuid.UUID, now time.Time) ([]model.Session, error)
+	GetRefreshToken(hash string) (*model.RefreshToken, error)
+	// Rotate marks a refresh token used and stores next in its place, pmerror.ErrNotFound means it was used meanwhile
+	Rotate(hash string, next *model.RefreshToken, now time.Time) error
+	// Revoke revokes a session of a user, sessions already revoked are reported as pmerror.ErrNotFound
+	Revoke(userID, id uuid.UUID, now time.Time) (*model.Session, error)
+}
+
+type AccessTokenRepository interface {
+	Create(token *model.AccessToken) error
+	// GetAll returns the access tokens of a user or service account that are not revoked
+	GetAll(principalID uuid.UUID) ([]model.AccessToken, error)
+	GetByHash(hash string) (*model.AccessToken, error)
+	// Touch records when and from where a token was last used
+	Touch(id uuid.UUID, now time.Time, ip string) error
+	// Revoke revokes an access token of a user or service account, tokens already revoked are reported as pmerror.ErrNotFound
+	Revoke(principalID, id uuid.UUID, now time.Time) (*model.AccessToken, error)
+}
+
+type ServiceAccountRepository interface {
+	Create(account *model.ServiceAccount) error
+	GetAll() ([]model.ServiceAccount, error)
+	// Get returns a service account along with its grants
+	Get(id uuid.UUID) (*model.ServiceAccount, error)
+	Delete(id uuid.UUID) (*model.ServiceAccount, error)
+	// AddGrants stores grants, records already granted to the account are left as they are
+	AddGrants(grants []model.RecordGrant) error
+	RemoveGrant(accountID, recordID uuid.UUID) (*model.RecordGrant, error)
+}
+
+type TwoFactorRepository interface {
+	GetTOTP(userID uuid.UUID) (*model.TOTP, error)
+	// SaveTOTP stores a pending enrollment in place of the former pending one
+	SaveTOTP(totp *model.TOTP) error
+	// ConfirmTOTP enables a pending enrollment and replaces the recovery codes of its user
+	ConfirmTOTP(userID uuid.UUID, step int64, now time.Time, codes []model.RecoveryCode) error
+	// UseTOTPStep records an accepted code, steps not after the last used one are reported as pmerror.ErrNotFound
+	UseTOTPStep(userID uuid.UUID, step int64) error
+	// DeleteTOTP disables two-factor authentication of a user along with the recovery codes
+	DeleteTOTP(userID uuid.UUID) error
+	ReplaceRecoveryCodes(userID uuid.UUID, codes []model.RecoveryCode) error
+	// UseRecoveryCode marks a recovery code used, codes already used are reported as pmerror.ErrNotFound
+	UseRecoveryCode(userID uuid.UUID, hash string, now time.Time) error
+	CreateChallenge(challenge *model.LoginChallenge) error
+	GetChallenge(hash string) (*model.LoginChallenge, error)
+	// FailChallenge counts a wrong code entered for a challenge
+	FailChallenge(hash string) error
+	// UseChallenge marks a challenge used, challenges used meanwhile or out of attempts are reported as pmerror.ErrNotFound
+	UseChallenge(hash string, now time.Time) error
+}
+
+type WebAuthnRepository interface {
+	CreateCredential(credential *model.WebAuthnCredential) error
+	GetCredentials(userID uuid.UUID) ([]model.WebAuthnCredential, error)
+	// GetCredential returns the credential an authenticator identifies by credentialID
+	GetCredential(credentialID []byte) (*model.WebAuthnCredential, error)
+	// UseCredential stores the signature counter of an assertion, counters that do not increase are reported as pmerror.ErrNotFound
+	UseCredential(id uuid.UUID, signCount uint32, now time.Time) error
+	// DeleteCredential deletes a credential of a user, credentials of other users are reported as pmerror.ErrNotFound
+	DeleteCredential(userID, id uuid.UUID) (*model.WebAuthnCredential, error)
+	CreateCeremony(ceremony *model.WebAuthnCeremony) error
+	GetCeremony(challenge string) (*model.WebAuthnCeremony, error)
+	// UseCeremony marks a ceremony used, ceremonies used meanwhile are reported as pmerror.ErrNotFound
+	UseCeremony(challenge string, now time.Time) error
+}
+
+type OIDCRepository interface {
+	GetIdentity(issuer, subject string) (*model.Identity, error)
+	GetIdentities(userID uuid.UUID) ([]model.Identity, error)
+	CreateIdentity(identity *model.Identity) error
+	// Provision creates a user along with its identity
+	Provision(user *model.User, identity *model.Identity) error
+	// TouchIdentity stores the groups the provider reported on a sign in
+	TouchIdentity(issuer, subject string, groups []string, now time.Time) error
+	SetAdmin(userID uuid.UUID, isAdmin bool) error
+	CreateLogin(login *model.OIDCLogin) error
+	GetLogin(hash string) (*model.OIDCLogin, error)
+	// UseLogin marks a login used, logins used meanwhile are reported as pmerror.ErrNotFound
+	UseLogin(hash string, now time.Time) error
+	// AuthenticateLogin records that the provider signed the user of a reauth login in again
+	AuthenticateLogin(hash string, now time.Time) error
+	// SpendReauth deletes an authenticated reauth login of userID, once, unknown ones are pmerror.ErrNotFound
+	SpendReauth(hash string, userID uuid.UUID, since time.Time) error
+	// GetPolicy returns the single sign-on policy admins set, pmerror.ErrNotFound while none did
+	GetPolicy() (*model.SSOPolicy, error)
+	SetPolicy(policy *model.SSOPolicy) error
+}
+
+type CertificateRepository interface {
+	Create(mapping *model.CertificateMapping) error
+	GetAll() ([]model.CertificateMapping, error)
+	GetBySubject(subject string) (*model.CertificateMapping, error)
+	// Touch records when a certificate mapping was last used
+	Touch(id uuid.UUID, now time.Time) error
+	Delete(id uuid.UUID) (*model.CertificateMapping, error)
+}
+
+type RateLimitRepository interface {
+	// Take takes a token from a bucket shared by all instances, it returns how long to wait when none is left
+	Take(key string, limit pmratelimit.Limit, now time.Time) (time.Duration, error)
+	GetLoginFailure(account string) (*model.LoginFailure, error)
+	// AddLoginFailure counts a failed sign in with an account name, failures before since are forgotten
+	AddLoginFailure(account string, since, now time.Time) (*model.LoginFailure, error)
+	LockAccount(account string, until time.Time) error
+	// ResetLoginFailures forgets the failed sign ins with an account name, along with a lockout
+	ResetLoginFailures(account string) error
+	// Prune deletes buckets and login failures last updated before before, accounts still locked at now are kept
+	Prune(before, now time.Time) error
+}
+
+// OIDCConfig enables single sign-on with an OpenID Connect provider
+type OIDCConfig struct {
+	Provider *pmoidc.Provider
+	// AutoProvision creates users for identities that are not linked yet, otherwise users link them after signing in
+	AutoProvision bool
+	// AdminGroups make members of any of them admins on every sign in and revoke it from others, empty leaves admins as they are
+	AdminGroups []string
+	// Required disables signing in and registering with a password or passkey until an admin sets the policy
+	Required bool
+}
+
+// LDAPConfig enables signing in with directory passwords, members of the allowed groups are synced to users
+type LDAPConfig struct {
+	Directory *pmldap.Directory
+}
+
+// RateLimitConfig throttles requests with token buckets shared by all instances,
+// and slows down and locks out password guessing against an account
+type RateLimitConfig struct {
+	// IP limits all requests from an address, Account the requests of a signed in user or service account
+	IP      pmratelimit.Limit
+	Account pmratelimit.Limit
+	// LoginIP and LoginAccount limit sign in attempts from an address and with an account name
+	LoginIP      pmratelimit.Limit
+	LoginAccount pmratelimit.Limit
+	// FreeFailures failed logins of an account are not delayed, each further one doubles the delay
+	// from FailureDelay up to MaxFailureDelay
+	FreeFailures    int
+	FailureDelay    time.Duration
+	MaxFailureDelay time.Duration
+	// LockoutThreshold failed logins lock the account for LockoutDuration, zero never locks
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// FailureWindow forgets failed logins older than it
+	FailureWindow time.Duration
+}
+
+type Config struct {
+	// Keys are the server master keys that wrap user data keys
+	Keys pmcrypto.KeyProvider
+	// PasswordHash are Argon2id parameters of new account password hashes
+	PasswordHash pmcrypto.KDFParams
+	Mode         CryptoMode
+	// KDF parameters clients use to derive vault keys of new users in client mode
+	KDF pmcrypto.KDFParams
+	// PreloginSecret derives KDF salts reported for unknown user names in client mode.
+	// It must be the same on every instance and across restarts, otherwise the salts tell unknown names apart.
+	PreloginSecret []byte
+	// RecoveryTTL limits how long a recovery ceremony collects shares once it is ready
+	RecoveryTTL time.Duration
+	// RecoveryDelay is how long the owner of an account has to cancel a recovery ceremony before it can be completed
+	RecoveryDelay time.Duration
+	// SessionTTL limits how long a session can be refreshed after signing in
+	SessionTTL time.Duration
+	// RelyingParty is what passkeys are registered for, the domain and origins browsers run ceremonies on
+	RelyingParty *pmwebauthn.RelyingParty
+	// OIDC is nil when single sign-on is disabled
+	OIDC *OIDCConfig
+	// LDAP is nil when directory authentication is disabled
+	LDAP *LDAPConfig
+	// RateLimit is nil when requests are not throttled
+	RateLimit *RateLimitConfig
 }
 
 type Controller struct {
-	userRepo   UserRepository
-	recordRepo RecordRepository
-	log        pmlogger.Logger
+	config       *Config
+	userRepo     UserRepository
+	recordRepo   RecordRepository
+	keyRepo      KeyRepository
+	recoveryRepo RecoveryRepository
+	sessionRepo  SessionRepository
+	tokenRepo    AccessTokenRepository
+	serviceRepo  ServiceAccountRepository
+	twoFARepo    TwoFactorRepository
+	webauthnRepo WebAuthnRepository
+	oidcRepo     OIDCRepository
+	certRepo     CertificateRepository
+	rateRepo     RateLimitRepository
+	keys         pmcrypto.KeyProvider
+	log          pmlogger.Logger
 }
 
-func New(userRepo UserRepository, recordRepo RecordRepository, logger pmlogger.Logger) (*Controller, error) {
+func New(config *Config, userRepo UserRepository, recordRepo RecordRepository, keyRepo KeyRepository, recoveryRepo RecoveryRepository, sessionRepo SessionRepository, tokenRepo AccessTokenRepository, serviceRepo ServiceAccountRepository, twoFARepo TwoFactorRepository, webauthnRepo WebAuthnRepository, oidcRepo OIDCRepository, certRepo CertificateRepository, rateRepo RateLimitRepository, logger pmlogger.Logger) (*Controller, error) {
+	if config == nil {
+		return nil, errors.New("config is nil")
+	}
+
+	if config.Keys == nil {
+		return nil, errors.New("keys is nil")
+	}
+
+	if err := config.PasswordHash.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid password hash params: %w", err)
+	}
+
+	switch config.Mode {
+	case CryptoModeServer:
+	case CryptoModeClient:
+		if err := config.KDF.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid KDF params: %w", err)
+		}
+
+		if len(config.PreloginSecret) < pmcrypto.KeySize {
+			return nil, fmt.Errorf("prelogin secret must be at least %d bytes", pmcrypto.KeySize)
+		}
+	default:
+		return nil, fmt.Errorf("unknown crypto mode %q", config.Mode)
+	}
+
+	if config.RecoveryTTL <= 0 {
+		return nil, errors.New("recovery TTL must be positive")
+	}
+
+	if config.RecoveryDelay < 0 {
+		return nil, errors.New("recovery delay must not be negative")
+	}
+
+	if config.SessionTTL <= 0 {
+		return nil, errors.New("session TTL must be positive")
+	}
+
+	if config.RelyingParty == nil {
+		return nil, errors.New("relying party is nil")
+	}
+
+	if err := config.RelyingParty.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid relying party: %w", err)
+	}
+
+	if config.OIDC != nil && config.OIDC.Provider == nil {
+		return nil, errors.New("OIDC provider is nil")
+	}
+
+	if config.LDAP != nil && config.LDAP.Directory == nil {
+		return nil, errors.New("LDAP directory is nil")
+	}
+
+	if config.RateLimit != nil && config.RateLimit.FailureWindow <= 0 {
+		return nil, errors.New("rate limit failure window must be positive")
+	}
+
+	if config.RateLimit != nil && config.RateLimit.LockoutThreshold > 0 && config.RateLimit.LockoutDuration <= 0 {
+		return nil, errors.New("rate limit lockout duration must be positive")
+	}
+
 	if userRepo == nil {
 		return nil, errors.New("userRepo is nil")
 	}
@@ -54,9 +362,61 @@ func New(userRepo UserRepository, recordRepo RecordRepository, logger pmlogger.L
 		return nil, errors.New("recordRepo is nil")
 	}
 
+	if keyRepo == nil {
+		return nil, errors.New("keyRepo is nil")
+	}
+
+	if recoveryRepo == nil {
+		return nil, errors.New("recoveryRepo is nil")
+	}
+
+	if sessionRepo == nil {
+		return nil, errors.New("sessionRepo is nil")
+	}
+
+	if tokenRepo == nil {
+		return nil, errors.New("tokenRepo is nil")
+	}
+
+	if serviceRepo == nil {
+		return nil, errors.New("serviceRepo is nil")
+	}
+
+	if twoFARepo == nil {
+		return nil, errors.New("twoFARepo is nil")
+	}
+
+	if webauthnRepo == nil {
+		return nil, errors.New("webauthnRepo is nil")
+	}
+
+	if oidcRepo == nil {
+		return nil, errors.New("oidcRepo is nil")
+	}
+
+	if certRepo == nil {
+		return nil, errors.New("certRepo is nil")
+	}
+
+	if rateRepo == nil {
+		return nil, errors.New("rateRepo is nil")
+	}
+
 	return &Controller{
-		userRepo:   userRepo,
-		recordRepo: recordRepo,
-		log:        logger.WithFields(pmlogger.Fields{"module": "Controller"}),
+		config:       config,
+		userRepo:     userRepo,
+		recordRepo:   recordRepo,
+		keyRepo:      keyRepo,
+		recoveryRepo: recoveryRepo,
+		sessionRepo:  sessionRepo,
+		tokenRepo:    tokenRepo,
+		serviceRepo:  serviceRepo,
+		twoFARepo:    twoFARepo,
+		webauthnRepo: webauthnRepo,
+		oidcRepo:     oidcRepo,
+		certRepo:     certRepo,
+		rateRepo:     rateRepo,
+		keys:         pmcrypto.WithLegacy(config.Keys, Salt),
+		log:          logger.WithFields(pmlogger.Fields{"module": "Controller"}),
 	}, nil
 }
@@ -1,315 +1,320 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/ChillyWR/PasswordManager/model"
 	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
 )
 
-func (c *Controller) encryptCredentialRecord(record *model.CredentialRecord) error {
-	if record.Notes != nil {
-		v, err := pmcrypto.Encrypt(*record.Notes, Salt)
-		if err != nil {
-			return err
-		}
+// fieldAAD binds the ciphertext of field to its record, owner and revision,
+// so it does not open once copied to another row or column or replayed after an update.
+// A whole row rolled back to an earlier revision would still open, its revision mark catches that.
+// In client mode the server never holds the key: fields are neither bound nor marked by the server,
+// clients that want the same guarantees have to encrypt with this AAD and keep their own marks.
+func fieldAAD(record *model.CredentialRecord, field string) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|%d", record.ID, record.CreatedBy, field, record.Revision))
+}
 
-		record.Notes = &v
+// markSubject names record in its revision mark, a mark does not verify for another record or owner
+func markSubject(record *model.CredentialRecord) string {
+	return record.ID.String() + "|" + record.CreatedBy.String()
+}
+
+// markRecord sets the revision mark of record to its current revision
+func (c *Controller) markRecord(record *model.CredentialRecord, key string) {
+	record.Mark = &model.RevisionMark{
+		Revision: record.Revision,
+		MAC:      pmcrypto.NewRevisionMAC(key).Sum(markSubject(record), record.Revision),
 	}
+}
 
-	return nil
+// checkMark rejects a record that is not at the revision of its mark, or whose mark was not written with key
+func (c *Controller) checkMark(record *model.CredentialRecord, key string) error {
+	var reason string
+	switch {
+	case record.Revision == 0:
+		reason = "it was written before fields were bound to records"
+	case record.Mark == nil:
+		reason = "it has no revision mark"
+	case !pmcrypto.NewRevisionMAC(key).Verify(markSubject(record), record.Mark.Revision, record.Mark.MAC):
+		reason = "its revision mark does not verify"
+	case record.Mark.Revision != record.Revision:
+		reason = fmt.Sprintf("it is at revision %d, its mark at %d", record.Revision, record.Mark.Revision)
+	default:
+		return nil
+	}
+
+	c.log.Errorf("Integrity check failed for record %s: %s", record.ID, reason)
+
+	return fmt.Errorf("%w: record %s: %s", pmerror.ErrIntegrity, record.ID, reason)
 }
 
-func (c *Controller) encryptLogin(record *model.LoginRecord) error {
-	if err := c.encryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("encrypt core: %w", err)
+// encryptField encrypts *v in place with key, bound to field of record.
+// In client mode values arrive encrypted by the client and are stored as-is, they only have to be envelopes.
+func (c *Controller) encryptField(record *model.CredentialRecord, field string, v *string, key string) error {
+	if v == nil {
+		return nil
 	}
 
-	if record.Username != nil {
-		v, err := pmcrypto.Encrypt(*record.Username, Salt)
-		if err != nil {
-			return err
+	if c.config.Mode == CryptoModeClient {
+		if _, err := pmcrypto.ParseEnvelope(*v); err != nil {
+			return fmt.Errorf("%w: fields must be encrypted by the client: %s", pmerror.ErrInvalidInput, err.Error())
 		}
 
-		record.Username = &v
+		return nil
 	}
 
-	if record.Password != nil {
-		v, err := pmcrypto.Encrypt(*record.Password, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.Password = &v
+	encrypted, err := pmcrypto.EncryptWithAAD(*v, key, pmcrypto.KeyID(key), fieldAAD(record, field))
+	if err != nil {
+		return err
 	}
 
+	*v = encrypted
+
 	return nil
 }
 
-func (c *Controller) encryptCard(record *model.CardRecord) error {
-	if err := c.encryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("encrypt core: %w", err)
+// decryptField decrypts *v in place with key, in client mode values are returned as-is
+func (c *Controller) decryptField(record *model.CredentialRecord, field string, v *string, key string) error {
+	if v == nil || c.config.Mode == CryptoModeClient {
+		return nil
 	}
 
-	if record.Number != nil {
-		v, err := pmcrypto.Encrypt(*record.Number, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.Number = &v
+	decrypted, err := pmcrypto.DecryptWithAAD(*v, key, fieldAAD(record, field))
+	if errors.Is(err, pmcrypto.ErrIntegrity) || errors.Is(err, pmcrypto.ErrMalformedEnvelope) {
+		c.log.Errorf("Integrity check failed for %s of record %s revision %d: %s", field, record.ID, record.Revision, err.Error())
+		return fmt.Errorf("%w: %s of record %s", pmerror.ErrIntegrity, field, record.ID)
 	}
-
-	if record.ExpirationMonth != nil {
-		v, err := pmcrypto.Encrypt(*record.ExpirationMonth, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.ExpirationMonth = &v
-	}
-
-	if record.ExpirationYear != nil {
-		v, err := pmcrypto.Encrypt(*record.ExpirationYear, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.ExpirationYear = &v
+	if err != nil {
+		return err
 	}
 
-	if record.CVV != nil {
-		v, err := pmcrypto.Encrypt(*record.CVV, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.CVV = &v
-	}
+	*v = decrypted
 
 	return nil
 }
 
-func (c *Controller) encryptIdentity(record *model.IdentityRecord) error {
-	if err := c.encryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("encrypt core: %w", err)
-	}
+// fieldTag holds the options of a pm struct tag:
+//
+//	pm:"encrypt"         the field is encrypted at rest
+//	pm:"encrypt,label"   it also tells which service the record is for, listings return it decrypted
+//	pm:"encrypt,seal=N"  it is still plaintext on rows with a seal below N, see model.LatestSeal
+//	pm:"plain"           the field is deliberately stored in plaintext
+type fieldTag struct {
+	encrypt bool
+	label   bool
+	seal    int
+}
 
-	if record.FirstName != nil {
-		v, err := pmcrypto.Encrypt(*record.FirstName, Salt)
-		if err != nil {
-			return err
+func parseFieldTag(tag string) (fieldTag, error) {
+	var t fieldTag
+	if tag == "plain" {
+		return t, nil
+	}
+
+	options := strings.Split(tag, ",")
+	if options[0] != "encrypt" {
+		return t, fmt.Errorf("unknown tag %q", tag)
+	}
+	t.encrypt = true
+
+	for _, option := range options[1:] {
+		name, value, _ := strings.Cut(option, "=")
+		switch name {
+		case "label":
+			t.label = true
+		case "seal":
+			seal, err := strconv.Atoi(value)
+			if err != nil || seal < 1 || seal > model.LatestSeal {
+				return t, fmt.Errorf("invalid seal %q", value)
+			}
+			t.seal = seal
+		default:
+			return t, fmt.Errorf("unknown option %q", option)
 		}
-
-		record.FirstName = &v
 	}
 
-	if record.MiddleName != nil {
-		v, err := pmcrypto.Encrypt(*record.MiddleName, Salt)
-		if err != nil {
-			return err
-		}
+	return t, nil
+}
 
-		record.MiddleName = &v
-	}
+// recordField is a field of a record tagged for encryption
+type recordField struct {
+	fieldTag
+	// key is "<scope>.<json name>", values are bound to it
+	key   string
+	value *string
+}
 
-	if record.LastName != nil {
-		v, err := pmcrypto.Encrypt(*record.LastName, Salt)
-		if err != nil {
-			return err
-		}
+type encryptionScoper interface {
+	EncryptionScope() string
+}
 
-		record.LastName = &v
+// recordFields returns the core of record and its fields tagged pm:"encrypt",
+// record is a pointer to one of the record types of model
+func recordFields(record interface{}) (*model.CredentialRecord, []recordField, error) {
+	v := reflect.ValueOf(record)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("%w: %T is not a record", pmerror.ErrInternal, record)
 	}
+	v = v.Elem()
 
-	if record.Address != nil {
-		v, err := pmcrypto.Encrypt(*record.Address, Salt)
-		if err != nil {
-			return err
+	core, ok := record.(*model.CredentialRecord)
+	if !ok {
+		embedded := v.FieldByName("CredentialRecord")
+		if !embedded.IsValid() {
+			return nil, nil, fmt.Errorf("%w: %T is not a record", pmerror.ErrInternal, record)
 		}
+		core = embedded.Addr().Interface().(*model.CredentialRecord)
+	}
 
-		record.Address = &v
+	var fields []recordField
+	if err := appendRecordFields(v, &fields); err != nil {
+		return nil, nil, fmt.Errorf("%w: fields of %T: %s", pmerror.ErrInternal, record, err.Error())
 	}
 
-	if record.Email != nil {
-		v, err := pmcrypto.Encrypt(*record.Email, Salt)
-		if err != nil {
-			return err
-		}
+	return core, fields, nil
+}
 
-		record.Email = &v
+func appendRecordFields(v reflect.Value, fields *[]recordField) error {
+	scoper, ok := v.Interface().(encryptionScoper)
+	if !ok {
+		return fmt.Errorf("%s has no encryption scope", v.Type())
 	}
 
-	if record.PhoneNumber != nil {
-		v, err := pmcrypto.Encrypt(*record.PhoneNumber, Salt)
-		if err != nil {
-			return err
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			if err := appendRecordFields(v.Field(i), fields); err != nil {
+				return err
+			}
+			continue
 		}
 
-		record.PhoneNumber = &v
-	}
+		raw, ok := field.Tag.Lookup("pm")
+		if !ok {
+			continue
+		}
 
-	if record.PassportNumber != nil {
-		v, err := pmcrypto.Encrypt(*record.PassportNumber, Salt)
+		tag, err := parseFieldTag(raw)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", field.Name, err)
 		}
 
-		record.PassportNumber = &v
-	}
-
-	return nil
-}
+		if !tag.encrypt {
+			continue
+		}
 
-func (c *Controller) decryptCredentialRecord(record *model.CredentialRecord) error {
-	if record.Notes != nil {
-		v, err := pmcrypto.Decrypt(*record.Notes, Salt)
-		if err != nil {
-			return err
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		f := recordField{fieldTag: tag, key: scoper.EncryptionScope() + "." + name}
+		switch value := v.Field(i).Addr().Interface().(type) {
+		case *string:
+			f.value = value
+		case **string:
+			f.value = *value
+		default:
+			return fmt.Errorf("%s: only strings can be encrypted", field.Name)
 		}
 
-		record.Notes = &v
+		*fields = append(*fields, f)
 	}
 
 	return nil
 }
 
-func (c *Controller) decryptLogin(record *model.LoginRecord) error {
-	if err := c.decryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("decrypt core: %w", err)
+// encryptRecord seals and marks record and encrypts all of its fields tagged pm:"encrypt"
+func (c *Controller) encryptRecord(record interface{}, key string) error {
+	core, fields, err := recordFields(record)
+	if err != nil {
+		return err
 	}
 
-	if record.Username != nil {
-		v, err := pmcrypto.Decrypt(*record.Username, Salt)
-		if err != nil {
+	core.Seal = model.LatestSeal
+	for _, field := range fields {
+		if err := c.encryptField(core, field.key, field.value, key); err != nil {
 			return err
 		}
-
-		record.Username = &v
 	}
 
-	if record.Password != nil {
-		v, err := pmcrypto.Decrypt(*record.Password, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.Password = &v
+	if c.config.Mode == CryptoModeServer {
+		c.markRecord(core, key)
 	}
 
 	return nil
 }
 
-func (c *Controller) decryptCard(record *model.CardRecord) error {
-	if err := c.decryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("decrypt core: %w", err)
-	}
+// decryptRecord decrypts the fields of record that are encrypted at its seal
+func (c *Controller) decryptRecord(record interface{}, key string) error {
+	return c.decryptRecordFields(record, key, false)
+}
 
-	if record.Number != nil {
-		v, err := pmcrypto.Decrypt(*record.Number, Salt)
-		if err != nil {
-			return err
-		}
+// decryptLabels decrypts only the labels of record, the rest of its fields stay encrypted
+func (c *Controller) decryptLabels(record interface{}, key string) error {
+	return c.decryptRecordFields(record, key, true)
+}
 
-		record.Number = &v
+func (c *Controller) decryptRecordFields(record interface{}, key string, labels bool) error {
+	core, fields, err := recordFields(record)
+	if err != nil {
+		return err
 	}
 
-	if record.ExpirationMonth != nil {
-		v, err := pmcrypto.Decrypt(*record.ExpirationMonth, Salt)
-		if err != nil {
+	if c.config.Mode == CryptoModeServer {
+		if err := c.checkMark(core, key); err != nil {
 			return err
 		}
-
-		record.ExpirationMonth = &v
 	}
 
-	if record.ExpirationYear != nil {
-		v, err := pmcrypto.Decrypt(*record.ExpirationYear, Salt)
-		if err != nil {
-			return err
+	for _, field := range fields {
+		if field.seal > core.Seal || labels && !field.label {
+			continue
 		}
 
-		record.ExpirationYear = &v
-	}
-
-	if record.CVV != nil {
-		v, err := pmcrypto.Decrypt(*record.CVV, Salt)
-		if err != nil {
+		if err := c.decryptField(core, field.key, field.value, key); err != nil {
 			return err
 		}
-
-		record.CVV = &v
 	}
 
 	return nil
 }
 
-func (c *Controller) decryptIdentity(record *model.IdentityRecord) error {
-	if err := c.decryptCredentialRecord(&record.CredentialRecord); err != nil {
-		return fmt.Errorf("decrypt core: %w", err)
-	}
-
-	if record.FirstName != nil {
-		v, err := pmcrypto.Decrypt(*record.FirstName, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.FirstName = &v
-	}
-
-	if record.MiddleName != nil {
-		v, err := pmcrypto.Decrypt(*record.MiddleName, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.MiddleName = &v
-	}
-
-	if record.LastName != nil {
-		v, err := pmcrypto.Decrypt(*record.LastName, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.LastName = &v
+// openRecord decrypts a record of a vault below model.LatestVaultVersion, which may have been written before
+// fields were bound to records or before revision marks. Only vault upgrades call it, they rewrite the record bound and marked.
+// Records that were ever marked are checked like any other, so a row replayed without its mark is not marked afresh.
+func (c *Controller) openRecord(record interface{}, key string) error {
+	core, fields, err := recordFields(record)
+	if err != nil {
+		return err
 	}
 
-	if record.Address != nil {
-		v, err := pmcrypto.Decrypt(*record.Address, Salt)
-		if err != nil {
-			return err
-		}
-
-		record.Address = &v
+	if core.Mark != nil || core.Seal >= model.MarkedSeal {
+		return c.decryptRecord(record, key)
 	}
 
-	if record.Email != nil {
-		v, err := pmcrypto.Decrypt(*record.Email, Salt)
-		if err != nil {
-			return err
+	for _, field := range fields {
+		if field.seal > core.Seal || field.value == nil {
+			continue
 		}
 
-		record.Email = &v
-	}
-
-	if record.PhoneNumber != nil {
-		v, err := pmcrypto.Decrypt(*record.PhoneNumber, Salt)
-		if err != nil {
-			return err
+		if core.Revision > 0 {
+			if err := c.decryptField(core, field.key, field.value, key); err != nil {
+				return err
+			}
+			continue
 		}
 
-		record.PhoneNumber = &v
-	}
-
-	if record.PassportNumber != nil {
-		v, err := pmcrypto.Decrypt(*record.PassportNumber, Salt)
+		decrypted, err := pmcrypto.Decrypt(*field.value, key)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s of record %s: %w", field.key, core.ID, err)
 		}
 
-		record.PassportNumber = &v
+		*field.value = decrypted
 	}
 
 	return nil
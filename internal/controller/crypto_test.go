@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ChillyWR/PasswordManager/internal/repo"
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+var testRecordTypes = []interface{}{
+	&model.CredentialRecord{},
+	&model.LoginRecord{},
+	&model.CardRecord{},
+	&model.IdentityRecord{},
+}
+
+// TestRecordFieldsTagged fails once a string field is added to a record type without deciding
+// whether it is encrypted, tag it pm:"encrypt" or pm:"plain"
+func TestRecordFieldsTagged(t *testing.T) {
+	var check func(t *testing.T, typ reflect.Type)
+	check = func(t *testing.T, typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Anonymous {
+				check(t, field.Type)
+				continue
+			}
+
+			if field.Type != reflect.TypeOf("") && field.Type != reflect.TypeOf((*string)(nil)) {
+				continue
+			}
+
+			tag, ok := field.Tag.Lookup("pm")
+			require.True(t, ok, "%s.%s has no pm tag", typ.Name(), field.Name)
+
+			_, err := parseFieldTag(tag)
+			require.NoError(t, err, "%s.%s", typ.Name(), field.Name)
+		}
+	}
+
+	for _, record := range testRecordTypes {
+		check(t, reflect.TypeOf(record).Elem())
+	}
+}
+
+// TestRecordFieldKeys pins the keys values are bound to, renaming one makes stored values unreadable
+func TestRecordFieldKeys(t *testing.T) {
+	expected := [][]string{
+		{"credential_record.name", "credential_record.notes"},
+		{"credential_record.name", "credential_record.notes", "login.username", "login.password", "login.url"},
+		{"credential_record.name", "credential_record.notes", "card.brand", "card.number", "card.expiration_month", "card.expiration_year", "card.cvv"},
+		{"credential_record.name", "credential_record.notes", "identity.first_name", "identity.middle_name", "identity.last_name",
+			"identity.address", "identity.email", "identity.phone_number", "identity.passport_number", "identity.country"},
+	}
+
+	for i, record := range testRecordTypes {
+		_, fields, err := recordFields(record)
+		require.NoError(t, err)
+
+		keys := make([]string, len(fields))
+		for j, field := range fields {
+			keys[j] = field.key
+		}
+
+		require.Equal(t, expected[i], keys)
+	}
+}
+
+// TestEncryptedColumns fails once an encrypted record field is missing from repo.EncryptedColumns,
+// the key usage report would not count it
+func TestEncryptedColumns(t *testing.T) {
+	columns := make(map[string]repo.EncryptedColumn)
+	for _, column := range repo.EncryptedColumns {
+		if !column.ServerKey {
+			columns[column.Table+"."+column.Column] = column
+		}
+	}
+
+	encrypted := make(map[string]bool)
+	for _, record := range testRecordTypes {
+		_, fields, err := recordFields(record)
+		require.NoError(t, err)
+
+		for _, field := range fields {
+			if !field.encrypt {
+				continue
+			}
+
+			column, ok := columns[field.key]
+			require.True(t, ok, "%s is not listed in repo.EncryptedColumns", field.key)
+			require.Equal(t, field.seal, column.Seal, "seal of %s", field.key)
+			encrypted[field.key] = true
+		}
+	}
+
+	for key := range columns {
+		require.True(t, encrypted[key], "repo.EncryptedColumns lists %s, which is not encrypted", key)
+	}
+}
+
+func TestController_EncryptRecord(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_round_trip",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				key, err := c.newDataKey(&model.User{ID: userID})
+				require.NoError(t, err)
+
+				card := model.CardRecord{
+					CredentialRecord: *model.NewCredentialRecord("Test Card", pmpointer.String("Test Notes"), userID),
+					Brand:            pmpointer.String("Test Brand"),
+					Number:           pmpointer.String("4111111111111111"),
+					CVV:              pmpointer.String("123"),
+				}
+				// fields are encrypted in place, expected must not share them
+				expected := card
+				expected.Seal = model.LatestSeal
+				expected.Notes = pmpointer.String("Test Notes")
+				expected.Brand = pmpointer.String("Test Brand")
+				expected.Number = pmpointer.String("4111111111111111")
+				expected.CVV = pmpointer.String("123")
+
+				require.NoError(t, c.encryptRecord(&card, key))
+				require.Equal(t, model.LatestSeal, card.Seal)
+				require.NotNil(t, card.Mark)
+				expected.Mark = card.Mark
+				require.NotEqual(t, "Test Card", card.Name)
+				require.NotEqual(t, "Test Brand", *card.Brand)
+				require.Nil(t, card.ExpirationMonth)
+
+				labels := card
+				labels.Notes = pmpointer.String(*card.Notes)
+				labels.Number = pmpointer.String(*card.Number)
+				labels.Brand = pmpointer.String(*card.Brand)
+				require.NoError(t, c.decryptLabels(&labels, key))
+				require.Equal(t, "Test Card", labels.Name)
+				require.Equal(t, "Test Brand", *labels.Brand)
+				require.Equal(t, *card.Number, *labels.Number)
+
+				require.NoError(t, c.decryptRecord(&card, key))
+				require.Equal(t, expected, card)
+			},
+		},
+		{
+			Name: "success_plaintext_below_seal",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				key, err := c.newDataKey(&model.User{ID: userID})
+				require.NoError(t, err)
+
+				card := model.CardRecord{
+					CredentialRecord: *model.NewCredentialRecord("Test Card", nil, userID),
+					Number:           pmpointer.String("4111111111111111"),
+				}
+				require.NoError(t, c.encryptRecord(&card, key))
+
+				// rows sealed before brands were encrypted store them in plaintext
+				card.Seal = 1
+				card.Brand = pmpointer.String("Test Brand")
+
+				require.NoError(t, c.decryptRecord(&card, key))
+				require.Equal(t, "Test Brand", *card.Brand)
+				require.Equal(t, "4111111111111111", *card.Number)
+			},
+		},
+		{
+			Name: "success_open_legacy",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				key, err := c.newDataKey(&model.User{ID: userID})
+				require.NoError(t, err)
+
+				// written before fields were bound and revisions marked
+				notes, err := pmcrypto.Encrypt("Test Notes", key)
+				require.NoError(t, err)
+				record := model.NewCredentialRecord("Test Record", &notes, userID)
+				record.Revision = 0
+
+				require.NoError(t, c.openRecord(record, key))
+				require.Equal(t, "Test Notes", *record.Notes)
+			},
+		},
+		{
+			Name: "error_open_lost_mark",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				key, err := c.newDataKey(&model.User{ID: userID})
+				require.NoError(t, err)
+
+				record := model.NewCredentialRecord("Test Record", pmpointer.String("Test Notes"), userID)
+				require.NoError(t, c.encryptRecord(record, key))
+
+				// the row is restored without its revision entry, upgrades must not mark it afresh
+				lost := *record
+				lost.Mark = nil
+				require.ErrorIs(t, c.openRecord(&lost, key), pmerror.ErrIntegrity)
+
+				unbound := *record
+				unbound.Revision = 0
+				require.ErrorIs(t, c.openRecord(&unbound, key), pmerror.ErrIntegrity)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// indexRecord computes blind index entries of a record before its fields are encrypted.
+// Values arrive encrypted in client mode, there is nothing to index.
+func (c *Controller) indexRecord(record interface{}, key string) []model.IndexEntry {
+	if c.config.Mode == CryptoModeClient {
+		return nil
+	}
+
+	index := pmcrypto.NewBlindIndex(key)
+	var entries []model.IndexEntry
+	add := func(kind model.IndexKind, terms ...string) {
+		for _, term := range terms {
+			entries = append(entries, model.IndexEntry{Kind: kind, Token: index.Token(string(kind), term)})
+		}
+	}
+
+	var core *model.CredentialRecord
+	switch record := record.(type) {
+	case *model.CredentialRecord:
+		core = record
+	case *model.LoginRecord:
+		core = &record.CredentialRecord
+		if record.Username != nil && normalizeTerm(*record.Username) != "" {
+			add(model.UsernameIndex, normalizeTerm(*record.Username))
+		}
+		if record.URL != nil {
+			add(model.URLHostIndex, hostTerms(*record.URL)...)
+		}
+	case *model.CardRecord:
+		core = &record.CredentialRecord
+	case *model.IdentityRecord:
+		core = &record.CredentialRecord
+		if record.Email != nil && normalizeTerm(*record.Email) != "" {
+			add(model.EmailIndex, normalizeTerm(*record.Email))
+		}
+	}
+
+	if core != nil {
+		add(model.NameIndex, words(core.Name)...)
+	}
+
+	return entries
+}
+
+// SearchRecords finds records of userID by blind index and decrypts only those, the tokens of the query
+// are looked up in one query and the hits are read in one more.
+// The whole query matches usernames, emails and URL hosts exactly, otherwise every word
+// of it has to match a word of the record name or a label of its URL host.
+// Records written before indexes were introduced are indexed when their vault is upgraded, see model.LatestVaultVersion.
+func (c *Controller) SearchRecords(userID uuid.UUID, query string) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error) {
+	if c.config.Mode == CryptoModeClient {
+		return nil, nil, nil, nil, fmt.Errorf("%w: search is not available when records are encrypted by clients", pmerror.ErrInvalidInput)
+	}
+
+	terms := words(query)
+	if len(terms) == 0 {
+		return nil, nil, nil, nil, fmt.Errorf("%w: empty query", pmerror.ErrInvalidInput)
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("get user: %w", err)
+	}
+
+	key, err := c.unlockVault(user)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	index := pmcrypto.NewBlindIndex(key)
+	token := func(kind model.IndexKind, term string) string {
+		return index.Token(string(kind), term)
+	}
+
+	exact := []string{
+		token(model.UsernameIndex, normalizeTerm(query)),
+		token(model.EmailIndex, normalizeTerm(query)),
+	}
+	if host := hostOf(query); host != "" {
+		exact = append(exact, token(model.URLHostIndex, host))
+	}
+
+	// every word matches a name word or a host label, tokens of all words are looked up at once
+	tokens := slices.Clone(exact)
+	termTokens := make([][]string, len(terms))
+	for i, term := range terms {
+		termTokens[i] = []string{token(model.NameIndex, term), token(model.URLHostIndex, term)}
+		tokens = append(tokens, termTokens[i]...)
+	}
+
+	found, err := c.recordRepo.Search(userID, tokens)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("search: %w", err)
+	}
+
+	var matched []uuid.UUID
+	for i, tokens := range termTokens {
+		if i == 0 {
+			matched = union(found, tokens)
+		} else {
+			matched = intersect(matched, union(found, tokens))
+		}
+	}
+
+	ids := union(found, exact)
+	for _, id := range matched {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, nil, nil, nil
+	}
+
+	owner, vault, err := c.recordRepo.GetVault(userID, ids)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("get vault: %w", err)
+	}
+
+	if owner.DataKey != user.DataKey {
+		// rekeyed since the lookup, the index changed as well but the records still decrypt
+		if key, err = c.unlockVault(owner); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	for _, record := range vault.Records() {
+		if err := c.decryptRecord(record, key); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("decrypt %T: %w", record, err)
+		}
+	}
+
+	sortRecords(vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities)
+
+	return vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities, nil
+}
+
+func normalizeTerm(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// words splits s into lower case words of letters and digits
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// hostOf returns the lower case host of a URL, the scheme may be left out
+func hostOf(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// hostTerms tokenizes the host of a URL: accounts.example.com gives accounts.example.com,
+// example.com and the labels accounts and example. The top level domain alone is too common to index.
+func hostTerms(raw string) []string {
+	host := hostOf(raw)
+	if host == "" {
+		return nil
+	}
+
+	labels := strings.Split(host, ".")
+	terms := []string{host}
+	for i := 1; i < len(labels)-1; i++ {
+		terms = append(terms, strings.Join(labels[i:], "."))
+	}
+
+	if len(labels) == 1 {
+		return terms
+	}
+
+	return append(terms, labels[:len(labels)-1]...)
+}
+
+// union returns the IDs found for any of tokens, each once in the order found
+func union(found map[string][]uuid.UUID, tokens []string) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool)
+	var result []uuid.UUID
+	for _, token := range tokens {
+		for _, id := range found[token] {
+			if !seen[id] {
+				seen[id] = true
+				result = append(result, id)
+			}
+		}
+	}
+
+	return result
+}
+
+func intersect(a, b []uuid.UUID) []uuid.UUID {
+	inB := make(map[uuid.UUID]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+	}
+
+	var result []uuid.UUID
+	for _, id := range a {
+		if inB[id] {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+func TestController_SearchRecords(t *testing.T) {
+	userID := uuid.New()
+
+	// setUp creates a login through the controller and serves index lookups from what it stored
+	setUp := func(t *testing.T, c *Controller, mocks *controllerMocks) {
+		user := &model.User{ID: userID, VaultVersion: model.LatestVaultVersion}
+		_, err := c.newDataKey(user)
+		require.NoError(t, err)
+
+		mocks.UserRepository.EXPECT().
+			Get(userID).
+			Return(user, nil).
+			AnyTimes()
+
+		var stored *model.LoginRecord
+		var index []model.IndexEntry
+		mocks.RecordRepository.EXPECT().
+			CreateLogin(userID, gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ uuid.UUID, record *model.LoginRecord, entries []model.IndexEntry) (*model.LoginRecord, error) {
+				stored, index = record, entries
+				return record, nil
+			})
+
+		mocks.RecordRepository.EXPECT().
+			Search(userID, gomock.Any()).
+			AnyTimes().
+			DoAndReturn(func(_ uuid.UUID, tokens []string) (map[string][]uuid.UUID, error) {
+				found := make(map[string][]uuid.UUID)
+				for _, token := range tokens {
+					for _, entry := range index {
+						if entry.Token == token {
+							found[token] = []uuid.UUID{stored.ID}
+						}
+					}
+				}
+				return found, nil
+			})
+
+		_, err = c.CreateRecord(model.LoginRecordType, []byte(`{"name": "Work Mail", "username": "Alice", "url": "https://accounts.example.com/login"}`), userID)
+		require.NoError(t, err)
+
+		// records are decrypted in place, every read gets its own copy
+		raw, err := json.Marshal(stored)
+		require.NoError(t, err)
+
+		mocks.RecordRepository.EXPECT().
+			GetVault(userID, []uuid.UUID{stored.ID}).
+			AnyTimes().
+			DoAndReturn(func(_ uuid.UUID, _ []uuid.UUID) (*model.User, *model.Vault, error) {
+				var record model.LoginRecord
+				err := json.Unmarshal(raw, &record)
+				record.Mark = stored.Mark
+				return user, &model.Vault{Logins: []model.LoginRecord{record}}, err
+			})
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				setUp(t, c, mocks)
+
+				for _, query := range []string{"alice", "example.com", "mail work", "accounts"} {
+					_, logins, _, _, err := c.SearchRecords(userID, query)
+					require.NoError(t, err)
+					require.Len(t, logins, 1, query)
+					require.Equal(t, "Alice", *logins[0].Username)
+				}
+			},
+		},
+		{
+			Name: "success_no_match",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				setUp(t, c, mocks)
+
+				for _, query := range []string{"ali", "com", "work home"} {
+					_, logins, _, _, err := c.SearchRecords(userID, query)
+					require.NoError(t, err)
+					require.Empty(t, logins, query)
+				}
+			},
+		},
+		{
+			Name: "error_empty_query",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				_, _, _, _, err := c.SearchRecords(userID, " ")
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "error_client_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+
+				_, _, _, _, err := c.SearchRecords(userID, "alice")
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// vaultKey unwraps the data key of user with the server keyring to encrypt new records.
+// Records are read with readVault or readRecord, along with the key they are encrypted with. In client mode the server holds no key.
+func (c *Controller) vaultKey(userID uuid.UUID) (string, error) {
+	if c.config.Mode == CryptoModeClient {
+		return "", nil
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return "", fmt.Errorf("get user: %w", err)
+	}
+
+	return c.unlockVault(user)
+}
+
+// readVault returns the records of userID with ids, all of them when ids is nil, and the data key they are encrypted with.
+// The owner and its records are read in one transaction, so a concurrent rekey cannot come in between.
+func (c *Controller) readVault(userID uuid.UUID, ids []uuid.UUID) (string, *model.Vault, error) {
+	owner, vault, err := c.recordRepo.GetVault(userID, ids)
+	if err != nil {
+		return "", nil, fmt.Errorf("get vault: %w", err)
+	}
+
+	if c.config.Mode == CryptoModeClient {
+		return "", vault, nil
+	}
+
+	key, err := c.unlockVault(owner)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if owner.VaultVersion < model.LatestVaultVersion {
+		// the records were just rewritten
+		if _, vault, err = c.recordRepo.GetVault(userID, ids); err != nil {
+			return "", nil, fmt.Errorf("get vault: %w", err)
+		}
+	}
+
+	return key, vault, nil
+}
+
+// readRecord returns record id of userID with its fields decrypted and the data key it is encrypted with.
+// The record and the key are read by a single query, records of other users are pmerror.ErrForbidden.
+func (c *Controller) readRecord(userID, id uuid.UUID) (string, interface{}, error) {
+	owner, record, err := c.recordRepo.GetVaultRecord(userID, id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var key string
+	if c.config.Mode == CryptoModeServer {
+		if key, err = c.unlockVault(owner); err != nil {
+			return "", nil, err
+		}
+
+		if owner.VaultVersion < model.LatestVaultVersion {
+			// the record was just rewritten
+			if _, record, err = c.recordRepo.GetVaultRecord(userID, id); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	if err := c.decryptRecord(record, key); err != nil {
+		return "", nil, fmt.Errorf("decrypt: %w", err)
+	}
+
+	return key, record, nil
+}
+
+// unlockVault unwraps the data key of user and upgrades its vault when it is behind, records are only read from current vaults.
+// Plain data keys are never stored.
+func (c *Controller) unlockVault(user *model.User) (string, error) {
+	key, err := c.unwrapDataKey(user)
+	if err != nil {
+		return "", err
+	}
+
+	if user.VaultVersion < model.LatestVaultVersion {
+		if err := c.upgradeVault(&model.User{ID: user.ID}, key); err != nil {
+			return "", err
+		}
+	}
+
+	return key, nil
+}
+
+func (c *Controller) unwrapDataKey(user *model.User) (string, error) {
+	if user.DataKey == "" {
+		return "", fmt.Errorf("%w: vault of user %s is not migrated yet, log in again", pmerror.ErrUnauthorized, user.ID.String())
+	}
+
+	key, err := c.keys.Decrypt(user.DataKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: unwrap data key of user %s: %s", pmerror.ErrInternal, user.ID.String(), err.Error())
+	}
+
+	return key, nil
+}
+
+// newDataKey generates a random data key, stores it wrapped in user and returns the plain key
+func (c *Controller) newDataKey(user *model.User) (string, error) {
+	key := make([]byte, pmcrypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+
+	if err := c.wrapDataKey(user, string(key)); err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+func (c *Controller) wrapDataKey(user *model.User, key string) error {
+	wrapped, err := c.keys.Encrypt(key)
+	if err != nil {
+		return fmt.Errorf("wrap data key: %w", err)
+	}
+
+	user.DataKey = wrapped
+
+	return nil
+}
+
+// migrateVault gives user a data key on login and upgrades the vault to model.LatestVaultVersion.
+// Vaults encrypted with a key derived from the password keep it as their data key.
+// Vaults still encrypted with the legacy Salt are re-encrypted with a random data key.
+// Data keys wrapped with a retired server key are re-wrapped with the active one.
+func (c *Controller) migrateVault(user *model.User, password string) error {
+	if user.DataKey == "" && user.KDFSalt == nil {
+		return c.migrateLegacyVault(user.ID)
+	}
+
+	current := user.VaultVersion >= model.LatestVaultVersion
+	if user.DataKey != "" && current && !pmcrypto.NeedsRotation(c.keys, user.DataKey) {
+		return nil
+	}
+
+	var key string
+	if user.DataKey != "" {
+		unwrapped, err := c.unwrapDataKey(user)
+		if err != nil {
+			return err
+		}
+
+		key = unwrapped
+	} else {
+		key = string(pmcrypto.DeriveKey(password, user.KDFSalt, user.KDFParams()))
+		c.log.Infof("Wrapping password derived vault key of user %s as data key", user.ID.String())
+	}
+
+	update := model.User{ID: user.ID}
+	if err := c.wrapDataKey(&update, key); err != nil {
+		return err
+	}
+
+	if !current {
+		return c.upgradeVault(&update, key)
+	}
+
+	if _, err := c.userRepo.Update(&update); err != nil {
+		return fmt.Errorf("store data key: %w", err)
+	}
+
+	return nil
+}
+
+// upgradeVault rewrites the vault of user with its own key at model.LatestVaultVersion and stores it with the user changes
+func (c *Controller) upgradeVault(user *model.User, key string) error {
+	if err := c.rekeyVault(user, key, key); err != nil {
+		return fmt.Errorf("upgrade vault: %w", err)
+	}
+
+	c.log.Infof("Upgraded vault of user %s to version %d", user.ID.String(), model.LatestVaultVersion)
+
+	return nil
+}
+
+// migrateLegacyVault re-encrypts a vault still encrypted with the legacy Salt with a random data key.
+// Unlike vaults encrypted with a password derived key it can be migrated offline, see Migrator.
+func (c *Controller) migrateLegacyVault(userID uuid.UUID) error {
+	update := model.User{ID: userID}
+	key, err := c.newDataKey(&update)
+	if err != nil {
+		return err
+	}
+
+	if err := c.rekeyVault(&update, Salt, key); err != nil {
+		return fmt.Errorf("migrate legacy vault: %w", err)
+	}
+
+	c.log.Infof("Migrated vault of user %s to a data key", userID.String())
+
+	return nil
+}
+
+// rekeyVault re-encrypts all records owned by user from oldKey to newKey and stores them with the user changes
+func (c *Controller) rekeyVault(user *model.User, oldKey, newKey string) error {
+	vault, err := c.openVault(user.ID, oldKey)
+	if err != nil {
+		return err
+	}
+
+	return c.sealVault(user, vault, newKey)
+}
+
+// openVault returns all records owned by userID with their fields decrypted with key, for vault upgrades and migrations
+func (c *Controller) openVault(userID uuid.UUID, key string) (*model.Vault, error) {
+	secureNotes, logins, cards, identities, err := c.recordRepo.GetAll(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get records: %w", err)
+	}
+
+	vault := &model.Vault{SecureNotes: secureNotes, Logins: logins, Cards: cards, Identities: identities}
+	for _, record := range vault.Records() {
+		if err := c.openRecord(record, key); err != nil {
+			return nil, fmt.Errorf("decrypt %T: %w", record, err)
+		}
+	}
+
+	return vault, nil
+}
+
+// sealVault encrypts the decrypted records of vault with key and stores them with the user changes.
+// Like any other write, fields are bound to the next revision and indexed with key, the vault is then current.
+func (c *Controller) sealVault(user *model.User, vault *model.Vault, key string) error {
+	user.VaultVersion = model.LatestVaultVersion
+	vault.Index = make(map[uuid.UUID][]model.IndexEntry)
+	for _, record := range vault.Records() {
+		core, _, err := recordFields(record)
+		if err != nil {
+			return err
+		}
+
+		core.Revision++
+		vault.Index[core.ID] = c.indexRecord(record, key)
+
+		if err := c.encryptRecord(record, key); err != nil {
+			return fmt.Errorf("encrypt %T: %w", record, err)
+		}
+	}
+
+	return c.recordRepo.Rekey(user, vault)
+}
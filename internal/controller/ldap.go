@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+type directoryChange int
+
+const (
+	directoryUnchanged directoryChange = iota
+	directoryCreated
+	directoryUpdated
+)
+
+// SyncLDAP creates users for members of the allowed directory groups, updates them and disables those that left
+func (c *Controller) SyncLDAP(userID uuid.UUID) (*model.LDAPSyncResult, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	return c.syncLDAP()
+}
+
+// RunLDAPSync syncs the directory every interval until ctx is done, failures are logged and retried on the next tick
+func (c *Controller) RunLDAPSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := c.syncLDAP()
+		if err != nil {
+			c.log.Errorf("Failed to sync LDAP directory: %s", err.Error())
+			continue
+		}
+
+		c.log.Infof("Synced LDAP directory: %d created, %d updated, %d disabled, %d failed", result.Created, result.Updated, result.Disabled, result.Failed)
+	}
+}
+
+func (c *Controller) syncLDAP() (*model.LDAPSyncResult, error) {
+	if c.config.LDAP == nil {
+		return nil, fmt.Errorf("%w: LDAP is disabled", pmerror.ErrForbidden)
+	}
+
+	members, err := c.config.LDAP.Directory.Members()
+	if err != nil {
+		return nil, fmt.Errorf("%w: directory: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	users, err := c.userRepo.GetDirectoryUsers()
+	if err != nil {
+		return nil, fmt.Errorf("get directory users: %w", err)
+	}
+
+	// a wrong group filter or an emptied group would otherwise lock everybody out at once
+	if len(members) == 0 && len(users) > 0 {
+		return nil, fmt.Errorf("%w: directory returned no members, refusing to disable %d users", pmerror.ErrInternal, len(users))
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	result := &model.LDAPSyncResult{}
+	current := make(map[string]bool, len(members))
+	for i := range members {
+		current[pmldap.NormalizeDN(members[i].DN)] = true
+
+		_, change, err := c.syncDirectoryUser(&members[i], now)
+		if err != nil {
+			c.log.Errorf("Failed to sync directory user %s: %s", members[i].DN, err.Error())
+			result.Failed++
+			continue
+		}
+
+		switch change {
+		case directoryCreated:
+			result.Created++
+		case directoryUpdated:
+			result.Updated++
+		}
+	}
+
+	for _, user := range users {
+		if current[*user.DirectoryDN] || user.DisabledOn != nil {
+			continue
+		}
+
+		if err := c.disableUser(user.ID, now); err != nil {
+			return nil, fmt.Errorf("disable user %s: %w", user.ID, err)
+		}
+
+		c.log.Infof("Disabled user %s that left the directory groups", user.ID)
+		result.Disabled++
+	}
+
+	return result, nil
+}
+
+// ldapLogin binds to the directory as name and returns the user of the directory entry, creating it on first sign in
+func (c *Controller) ldapLogin(name, password string) (*model.User, error) {
+	entry, err := c.config.LDAP.Directory.Authenticate(name, password)
+	if errors.Is(err, pmldap.ErrInvalidCredentials) {
+		return nil, fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	} else if errors.Is(err, pmldap.ErrNotMember) {
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrForbidden, err.Error())
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: directory: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	user, _, err := c.syncDirectoryUser(entry, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ldapReauth binds to the directory as user with password, the entry has to be the one user was synced from
+func (c *Controller) ldapReauth(user *model.User, password string) error {
+	if c.config.LDAP == nil {
+		return fmt.Errorf("%w: LDAP is disabled", pmerror.ErrForbidden)
+	}
+
+	entry, err := c.config.LDAP.Directory.Authenticate(user.Name, password)
+	if errors.Is(err, pmldap.ErrInvalidCredentials) {
+		return fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	} else if errors.Is(err, pmldap.ErrNotMember) {
+		return fmt.Errorf("%w: %s", pmerror.ErrForbidden, err.Error())
+	} else if err != nil {
+		return fmt.Errorf("%w: directory: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	if pmldap.NormalizeDN(entry.DN) != *user.DirectoryDN {
+		return fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// syncDirectoryUser returns the enabled user of a directory entry, renamed to its current name.
+// Users are matched by DN, a local user of the same name is never taken over.
+func (c *Controller) syncDirectoryUser(entry *pmldap.User, now time.Time) (*model.User, directoryChange, error) {
+	dn := pmldap.NormalizeDN(entry.DN)
+
+	user, err := c.userRepo.GetByDirectoryDN(dn)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return c.provisionDirectoryUser(entry.Username, dn)
+	} else if err != nil {
+		return nil, directoryUnchanged, fmt.Errorf("get user: %w", err)
+	}
+
+	change := directoryUnchanged
+	if user.Name != entry.Username {
+		if _, err := c.userRepo.Update(&model.User{ID: user.ID, Name: entry.Username, UpdatedOn: now}); err != nil {
+			return nil, directoryUnchanged, fmt.Errorf("rename user: %w", err)
+		}
+
+		user.Name = entry.Username
+		change = directoryUpdated
+	}
+
+	if user.DisabledOn != nil {
+		if err := c.userRepo.SetDisabled(user.ID, nil); err != nil {
+			return nil, directoryUnchanged, fmt.Errorf("enable user: %w", err)
+		}
+
+		user.DisabledOn = nil
+		change = directoryUpdated
+		c.log.Infof("Enabled user %s that rejoined the directory groups", user.ID)
+	}
+
+	return user, change, nil
+}
+
+func (c *Controller) provisionDirectoryUser(name, dn string) (*model.User, directoryChange, error) {
+	if _, err := c.userRepo.GetByName(name); err == nil {
+		return nil, directoryUnchanged, fmt.Errorf("%w: user %s already exists and is not managed by the directory", pmerror.ErrInvalidInput, name)
+	} else if !errors.Is(err, pmerror.ErrNotFound) {
+		return nil, directoryUnchanged, fmt.Errorf("get user: %w", err)
+	}
+
+	// nobody knows the password, directory users only sign in with their directory password
+	password, err := pmcrypto.NewRandom()
+	if err != nil {
+		return nil, directoryUnchanged, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	user, err := c.newUser(name, password)
+	if err != nil {
+		return nil, directoryUnchanged, err
+	}
+
+	user.DirectoryDN = &dn
+	user, err = c.userRepo.Create(user)
+	if err != nil {
+		return nil, directoryUnchanged, fmt.Errorf("create user: %w", err)
+	}
+
+	c.log.Infof("Provisioned user %s for directory entry %s", user.ID, dn)
+
+	return user, directoryCreated, nil
+}
+
+// disableUser disables userID and signs it out everywhere
+func (c *Controller) disableUser(userID uuid.UUID, now time.Time) error {
+	if err := c.userRepo.SetDisabled(userID, &now); err != nil {
+		return fmt.Errorf("set disabled: %w", err)
+	}
+
+	return c.signOutEverywhere(userID, now)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/ldaptest"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+const (
+	testPeopleDN = "ou=people,dc=example,dc=org"
+	testGroupsDN = "ou=groups,dc=example,dc=org"
+)
+
+// expectDirectoryUsers backs the user repository mock with memory, and reports no second factor
+func expectDirectoryUsers(mocks *controllerMocks) map[uuid.UUID]*model.User {
+	users := make(map[uuid.UUID]*model.User)
+	find := func(match func(user *model.User) bool) (*model.User, error) {
+		for _, user := range users {
+			if match(user) {
+				result := *user
+				return &result, nil
+			}
+		}
+		return nil, pmerror.ErrNotFound
+	}
+
+	u := mocks.UserRepository.EXPECT()
+	u.Get(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.User, error) {
+		return find(func(user *model.User) bool { return user.ID == id })
+	})
+	u.GetByName(gomock.Any()).AnyTimes().DoAndReturn(func(name string) (*model.User, error) {
+		return find(func(user *model.User) bool { return user.Name == name })
+	})
+	u.GetByDirectoryDN(gomock.Any()).AnyTimes().DoAndReturn(func(dn string) (*model.User, error) {
+		return find(func(user *model.User) bool { return user.DirectoryDN != nil && *user.DirectoryDN == dn })
+	})
+	u.GetDirectoryUsers().AnyTimes().DoAndReturn(func() ([]model.User, error) {
+		var result []model.User
+		for _, user := range users {
+			if user.DirectoryDN != nil {
+				result = append(result, *user)
+			}
+		}
+		return result, nil
+	})
+	u.Create(gomock.Any()).AnyTimes().DoAndReturn(func(user *model.User) (*model.User, error) {
+		stored := *user
+		users[user.ID] = &stored
+		return user, nil
+	})
+	u.Update(gomock.Any()).AnyTimes().DoAndReturn(func(user *model.User) (*model.User, error) {
+		users[user.ID].Name = user.Name
+		return user, nil
+	})
+	u.SetDisabled(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID, disabledOn *time.Time) error {
+		users[id].DisabledOn = disabledOn
+		return nil
+	})
+
+	mocks.TwoFactorRepository.EXPECT().GetTOTP(gomock.Any()).AnyTimes().Return(nil, pmerror.ErrNotFound)
+
+	return users
+}
+
+func TestController_LDAP(t *testing.T) {
+	server, err := ldaptest.New()
+	require.NoError(t, err)
+	// parallel subtests run after this function returns
+	t.Cleanup(server.Close)
+
+	for _, name := range []string{"alice", "bob"} {
+		server.Add("uid="+name+","+testPeopleDN, map[string][]string{
+			"uid":          {name},
+			"userPassword": {name + " secret"},
+		})
+	}
+	server.Add("cn=vault-users,"+testGroupsDN, map[string][]string{
+		"cn":     {"vault-users"},
+		"member": {"uid=alice," + testPeopleDN},
+	})
+
+	directory, err := pmldap.NewDirectory(pmldap.Config{
+		URL:         server.URL(),
+		UserBaseDN:  testPeopleDN,
+		GroupBaseDN: testGroupsDN,
+		GroupFilter: "(cn=vault-users)",
+	})
+	require.NoError(t, err)
+
+	login := func(c *Controller, name, password string) (uuid.UUID, error) {
+		userID, _, err := c.Login(&model.UserForm{Name: pmpointer.String(name), Password: pmpointer.String(password)})
+		return userID, err
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_login",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.LDAP = &LDAPConfig{Directory: directory}
+				users := expectDirectoryUsers(mocks)
+
+				userID, err := login(c, "alice", "alice secret")
+				require.NoError(t, err)
+
+				user := users[userID]
+				require.Equal(t, "alice", user.Name)
+				require.Equal(t, "uid=alice,"+testPeopleDN, *user.DirectoryDN)
+				require.NotEmpty(t, user.DataKey)
+
+				// the same directory user signs in again
+				again, err := login(c, "alice", "alice secret")
+				require.NoError(t, err)
+				require.Equal(t, userID, again)
+				require.Len(t, users, 1)
+
+				_, err = login(c, "alice", "wrong")
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+
+				_, err = login(c, "bob", "bob secret")
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "success_reauth",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.LDAP = &LDAPConfig{Directory: directory}
+				users := expectDirectoryUsers(mocks)
+
+				userID, err := login(c, "alice", "alice secret")
+				require.NoError(t, err)
+				user := users[userID]
+
+				// directory users confirm changes with their directory password, the stored one is random
+				now := time.Now()
+				require.NoError(t, c.confirmPresence(user, pmpointer.String("alice secret"), nil, now))
+				require.ErrorIs(t, c.confirmPresence(user, pmpointer.String("wrong"), nil, now), pmerror.ErrInvalidInput)
+
+				// the entry of the name has to be the one the user was synced from
+				moved := *user
+				moved.DirectoryDN = pmpointer.String("uid=alice,ou=former," + testPeopleDN)
+				require.ErrorIs(t, c.confirmPresence(&moved, pmpointer.String("alice secret"), nil, now), pmerror.ErrInvalidInput)
+			},
+		},
+		{
+			Name: "error_local_name_taken",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.LDAP = &LDAPConfig{Directory: directory}
+				users := expectDirectoryUsers(mocks)
+				hash, err := pmcrypto.HashPassword("local secret", testKDFParams)
+				require.NoError(t, err)
+
+				local := &model.User{ID: uuid.New(), Name: "alice", Password: hash}
+				users[local.ID] = local
+
+				// the local user is checked against its own password, not the directory
+				_, err = login(c, "alice", "alice secret")
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+				require.Nil(t, users[local.ID].DirectoryDN)
+
+				result, err := c.syncLDAP()
+				require.NoError(t, err)
+				require.Equal(t, &model.LDAPSyncResult{Failed: 1}, result)
+			},
+		},
+		{
+			Name: "success_sync",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.LDAP = &LDAPConfig{Directory: directory}
+				users := expectDirectoryUsers(mocks)
+
+				result, err := c.syncLDAP()
+				require.NoError(t, err)
+				require.Equal(t, &model.LDAPSyncResult{Created: 1}, result)
+				require.Len(t, users, 1)
+
+				var alice *model.User
+				for _, user := range users {
+					alice = user
+				}
+
+				// a renamed entry keeps its user, one that left the groups is signed out everywhere
+				bob := &model.User{ID: uuid.New(), Name: "bob", DirectoryDN: pmpointer.String("uid=bob," + testPeopleDN)}
+				users[bob.ID] = bob
+				alice.Name = "alice.liddell"
+
+				sessions := expectSessionStore(mocks)
+				session, _, err := c.CreateSession(bob.ID, "Test Agent")
+				require.NoError(t, err)
+
+				token := model.AccessToken{ID: uuid.New(), UserID: bob.ID}
+				mocks.TokenRepository.EXPECT().GetAll(bob.ID).Return([]model.AccessToken{token}, nil)
+				mocks.TokenRepository.EXPECT().Revoke(bob.ID, token.ID, gomock.Any()).Return(&token, nil)
+
+				result, err = c.syncLDAP()
+				require.NoError(t, err)
+				require.Equal(t, &model.LDAPSyncResult{Updated: 1, Disabled: 1}, result)
+				require.Equal(t, "alice", alice.Name)
+				require.NotNil(t, bob.DisabledOn)
+				require.NotNil(t, sessions.sessions[session.ID].RevokedOn)
+
+				_, _, err = c.CreateSession(bob.ID, "Test Agent")
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_sync_no_members",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				empty, err := pmldap.NewDirectory(pmldap.Config{URL: server.URL(), UserBaseDN: testPeopleDN, GroupBaseDN: testGroupsDN, GroupFilter: "(cn=nobody)"})
+				require.NoError(t, err)
+
+				c.config.LDAP = &LDAPConfig{Directory: empty}
+				users := expectDirectoryUsers(mocks)
+				alice := &model.User{ID: uuid.New(), Name: "alice", DirectoryDN: pmpointer.String("uid=alice," + testPeopleDN)}
+				users[alice.ID] = alice
+
+				_, err = c.syncLDAP()
+				require.ErrorIs(t, err, pmerror.ErrInternal)
+				require.Nil(t, alice.DisabledOn)
+			},
+		},
+		{
+			Name: "error_disabled",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				_, err := c.syncLDAP()
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+// VaultMigration tells what MigrateVault did, or in a dry run would do, to a vault
+type VaultMigration int
+
+const (
+	VaultCurrent VaultMigration = iota
+	VaultMigrated
+	// VaultLocked vaults are encrypted with a key derived from the password of their owner, they are migrated on the next login
+	VaultLocked
+)
+
+// Migrator migrates vaults without their owners signing in, for offline tools such as cmd/rekey.
+// It only reaches users and their records.
+type Migrator struct {
+	c *Controller
+}
+
+func NewMigrator(keys pmcrypto.KeyProvider, mode CryptoMode, userRepo UserRepository, recordRepo RecordRepository, logger pmlogger.Logger) (*Migrator, error) {
+	if keys == nil {
+		return nil, errors.New("keys is nil")
+	}
+
+	if mode != CryptoModeServer && mode != CryptoModeClient {
+		return nil, fmt.Errorf("unknown crypto mode %q", mode)
+	}
+
+	if userRepo == nil {
+		return nil, errors.New("userRepo is nil")
+	}
+
+	if recordRepo == nil {
+		return nil, errors.New("recordRepo is nil")
+	}
+
+	return &Migrator{c: &Controller{
+		config:     &Config{Keys: keys, Mode: mode},
+		userRepo:   userRepo,
+		recordRepo: recordRepo,
+		keys:       pmcrypto.WithLegacy(keys, Salt),
+		log:        logger.WithFields(pmlogger.Fields{"module": "Migrator"}),
+	}}, nil
+}
+
+// MigrateVault moves the vault of userID off the legacy Salt to a data key wrapped with the server keys
+// and upgrades it to model.LatestVaultVersion. A dry run only checks that every record decrypts.
+// In client mode the server holds no vault keys.
+func (m *Migrator) MigrateVault(userID uuid.UUID, dryRun bool) (VaultMigration, error) {
+	c := m.c
+	if c.config.Mode == CryptoModeClient {
+		return VaultCurrent, nil
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return VaultCurrent, fmt.Errorf("get user: %w", err)
+	}
+
+	switch {
+	case user.DataKey != "":
+		current := user.VaultVersion >= model.LatestVaultVersion
+		if current && !dryRun {
+			return VaultCurrent, nil
+		}
+
+		key, err := c.unwrapDataKey(user)
+		if err != nil {
+			return VaultCurrent, err
+		}
+
+		migration := VaultCurrent
+		if !current {
+			migration = VaultMigrated
+		}
+
+		if dryRun {
+			_, err = c.openVault(user.ID, key)
+			return migration, err
+		}
+
+		return migration, c.upgradeVault(&model.User{ID: user.ID}, key)
+	case user.KDFSalt != nil:
+		return VaultLocked, nil
+	case dryRun:
+		if _, err := c.openVault(user.ID, Salt); err != nil {
+			return VaultCurrent, err
+		}
+
+		return VaultMigrated, nil
+	default:
+		if err := c.migrateLegacyVault(user.ID); err != nil {
+			return VaultCurrent, err
+		}
+
+		return VaultMigrated, nil
+	}
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+func TestMigrator_MigrateVault(t *testing.T) {
+	newMigrator := func(t *testing.T, c *Controller, mocks *controllerMocks) *Migrator {
+		m, err := NewMigrator(c.config.Keys, c.config.Mode, mocks.UserRepository, mocks.RecordRepository, pmlogger.New())
+		require.NoError(t, err)
+		return m
+	}
+
+	notes := "Test Record Notes"
+	legacyNotes, err := pmcrypto.Encrypt(notes, Salt)
+	require.NoError(t, err)
+
+	// sealing rewrites fields in place, every case gets its own record
+	legacyRecords := func(userID uuid.UUID) []model.CredentialRecord {
+		notes := legacyNotes
+		return []model.CredentialRecord{{ID: uuid.New(), Notes: &notes, CreatedBy: userID}}
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_legacy",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name"}
+
+				mocks.UserRepository.EXPECT().
+					Get(user.ID).
+					Return(user, nil)
+
+				mocks.RecordRepository.EXPECT().
+					GetAll(user.ID).
+					Return(legacyRecords(user.ID), nil, nil, nil, nil)
+
+				var rekeyed *model.User
+				var vault *model.Vault
+				mocks.RecordRepository.EXPECT().
+					Rekey(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(u *model.User, v *model.Vault) error {
+						rekeyed, vault = u, v
+						return nil
+					})
+
+				migration, err := newMigrator(t, c, mocks).MigrateVault(user.ID, false)
+				require.NoError(t, err)
+				require.Equal(t, VaultMigrated, migration)
+
+				key, err := c.unwrapDataKey(rekeyed)
+				require.NoError(t, err)
+
+				actual, err := pmcrypto.DecryptWithAAD(*vault.SecureNotes[0].Notes, key, fieldAAD(&vault.SecureNotes[0], "credential_record.notes"))
+				require.NoError(t, err)
+				require.Equal(t, notes, actual)
+			},
+		},
+		{
+			Name: "success_legacy_dry_run",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name"}
+
+				mocks.UserRepository.EXPECT().
+					Get(user.ID).
+					Return(user, nil)
+
+				mocks.RecordRepository.EXPECT().
+					GetAll(user.ID).
+					Return(legacyRecords(user.ID), nil, nil, nil, nil)
+
+				migration, err := newMigrator(t, c, mocks).MigrateVault(user.ID, true)
+				require.NoError(t, err)
+				require.Equal(t, VaultMigrated, migration)
+			},
+		},
+		{
+			Name: "success_upgrade",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name"}
+				key, err := c.newDataKey(user)
+				require.NoError(t, err)
+
+				record := model.NewCredentialRecord("Work Mail", nil, user.ID)
+				require.NoError(t, c.encryptRecord(record, key))
+
+				mocks.UserRepository.EXPECT().
+					Get(user.ID).
+					Return(user, nil)
+
+				mocks.RecordRepository.EXPECT().
+					GetAll(user.ID).
+					Return([]model.CredentialRecord{*record}, nil, nil, nil, nil)
+
+				var vault *model.Vault
+				mocks.RecordRepository.EXPECT().
+					Rekey(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(u *model.User, v *model.Vault) error {
+						require.Equal(t, model.LatestVaultVersion, u.VaultVersion)
+						vault = v
+						return nil
+					})
+
+				migration, err := newMigrator(t, c, mocks).MigrateVault(user.ID, false)
+				require.NoError(t, err)
+				require.Equal(t, VaultMigrated, migration)
+				require.NotEmpty(t, vault.Index[record.ID])
+
+				// once upgraded there is nothing left to do
+				user.VaultVersion = model.LatestVaultVersion
+				mocks.UserRepository.EXPECT().
+					Get(user.ID).
+					Return(user, nil)
+
+				migration, err = newMigrator(t, c, mocks).MigrateVault(user.ID, false)
+				require.NoError(t, err)
+				require.Equal(t, VaultCurrent, migration)
+			},
+		},
+		{
+			Name: "success_locked",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", KDFSalt: []byte("1234567890123456")}
+
+				mocks.UserRepository.EXPECT().
+					Get(user.ID).
+					Return(user, nil)
+
+				migration, err := newMigrator(t, c, mocks).MigrateVault(user.ID, false)
+				require.NoError(t, err)
+				require.Equal(t, VaultLocked, migration)
+			},
+		},
+		{
+			Name: "success_client_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+
+				migration, err := newMigrator(t, c, mocks).MigrateVault(uuid.New(), false)
+				require.NoError(t, err)
+				require.Equal(t, VaultCurrent, migration)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+// oidcClockSkew is how far the clock of the provider may be behind when it reports when a user authenticated
+const oidcClockSkew = time.Minute
+
+// BeginOIDCLogin returns the URL of the provider to sign in at, it sends the browser back to CompleteOIDCLogin
+func (c *Controller) BeginOIDCLogin() (string, error) {
+	return c.newOIDCLogin(nil, false)
+}
+
+// BeginOIDCLink returns the URL of the provider to sign in at, the identity signing in is then linked to userID
+func (c *Controller) BeginOIDCLink(userID uuid.UUID) (string, error) {
+	if _, err := c.userRepo.Get(userID); err != nil {
+		return "", fmt.Errorf("get user: %w", err)
+	}
+
+	return c.newOIDCLogin(&userID, false)
+}
+
+// BeginOIDCReauth returns the URL of the provider to sign in at again before a sensitive change. The state the
+// provider sends back to CompleteOIDCLogin then stands in for the password of userID, which provisioned users
+// do not know.
+func (c *Controller) BeginOIDCReauth(userID uuid.UUID) (string, error) {
+	identities, err := c.oidcRepo.GetIdentities(userID)
+	if err != nil {
+		return "", fmt.Errorf("get identities: %w", err)
+	}
+
+	if len(identities) == 0 {
+		return "", fmt.Errorf("%w: no identity is linked to the user", pmerror.ErrInvalidInput)
+	}
+
+	return c.newOIDCLogin(&userID, true)
+}
+
+// CompleteOIDCLogin redeems the code the provider sent back and returns the user signing in, or the user that
+// reauthenticated. Identities that are not linked yet are provisioned a user when enabled. Users that enabled
+// two-factor authentication get a challenge to complete with CompleteLogin, as when signing in with a password.
+func (c *Controller) CompleteOIDCLogin(form *model.OIDCCallbackForm) (*model.OIDCResult, error) {
+	if c.config.OIDC == nil {
+		return nil, fmt.Errorf("%w: single sign-on is disabled", pmerror.ErrForbidden)
+	}
+
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	hash := hashToken(*form.State)
+	login, err := c.oidcRepo.GetLogin(hash)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown sign in", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return nil, fmt.Errorf("get oidc login: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if !login.Active(now) {
+		return nil, fmt.Errorf("%w: sign in is used or expired, start again", pmerror.ErrUnauthorized)
+	}
+
+	// marked used before redeeming the code, so that replaying a callback fails here rather than at the provider
+	if err := c.oidcRepo.UseLogin(hash, now); errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: sign in is used or expired, start again", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return nil, fmt.Errorf("use oidc login: %w", err)
+	}
+
+	claims, err := c.config.OIDC.Provider.Exchange(*form.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		c.log.Warnf("Failed to redeem OIDC code: %s", err.Error())
+		return nil, fmt.Errorf("%w: identity provider rejected the sign in", pmerror.ErrUnauthorized)
+	}
+
+	if login.Reauth {
+		return c.completeOIDCReauth(login, claims, now)
+	}
+
+	userID, err := c.identityUser(claims, login.LinkUserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.oidcRepo.TouchIdentity(claims.Issuer, claims.Subject, claims.Groups, now); err != nil {
+		return nil, fmt.Errorf("touch identity: %w", err)
+	}
+
+	if err := c.syncAdmin(userID, claims.Groups); err != nil {
+		return nil, err
+	}
+
+	enabled, err := c.twoFactorEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		challenge, err := c.newLoginChallenge(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		c.log.Infof("User %s passed identity %s of %s, second factor pending", userID, claims.Subject, claims.Issuer)
+
+		return &model.OIDCResult{Challenge: challenge}, nil
+	}
+
+	c.log.Infof("User %s signed in with identity %s of %s", userID, claims.Subject, claims.Issuer)
+
+	return &model.OIDCResult{UserID: userID}, nil
+}
+
+// completeOIDCReauth accepts a reauthentication when an identity of its user signed in again after it began
+func (c *Controller) completeOIDCReauth(login *model.OIDCLogin, claims *pmoidc.Claims, now time.Time) (*model.OIDCResult, error) {
+	identity, err := c.oidcRepo.GetIdentity(claims.Issuer, claims.Subject)
+	if errors.Is(err, pmerror.ErrNotFound) || err == nil && identity.UserID != *login.LinkUserID {
+		return nil, fmt.Errorf("%w: identity is not linked to the user", pmerror.ErrForbidden)
+	} else if err != nil {
+		return nil, fmt.Errorf("get identity: %w", err)
+	}
+
+	// a session at the provider would otherwise let whoever holds the browser through without a password
+	if claims.AuthTime.IsZero() || claims.AuthTime.Before(login.CreatedOn.Add(-oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: identity provider did not sign the user in again", pmerror.ErrUnauthorized)
+	}
+
+	if err := c.oidcRepo.AuthenticateLogin(login.Hash, now); err != nil {
+		return nil, fmt.Errorf("authenticate oidc login: %w", err)
+	}
+
+	c.log.Infof("User %s reauthenticated with identity %s of %s", identity.UserID, claims.Subject, claims.Issuer)
+
+	return &model.OIDCResult{UserID: identity.UserID, Reauth: true}, nil
+}
+
+// spendOIDCReauth accepts the state of a reauthentication of userID that completed at most model.OIDCReauthTTL ago, once
+func (c *Controller) spendOIDCReauth(userID uuid.UUID, state string, now time.Time) error {
+	err := c.oidcRepo.SpendReauth(hashToken(state), userID, now.Add(-model.OIDCReauthTTL))
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return fmt.Errorf("%w: reauthentication is unknown, used or expired, sign in at the identity provider again", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return fmt.Errorf("spend oidc reauth: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) OIDCIdentities(userID uuid.UUID) ([]model.Identity, error) {
+	return c.oidcRepo.GetIdentities(userID)
+}
+
+// identityUser returns the user the identity of claims is linked to, linking or provisioning one as needed
+func (c *Controller) identityUser(claims *pmoidc.Claims, linkUserID *uuid.UUID, now time.Time) (uuid.UUID, error) {
+	identity, err := c.oidcRepo.GetIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		if linkUserID != nil && *linkUserID != identity.UserID {
+			return uuid.UUID{}, fmt.Errorf("%w: identity is linked to another user", pmerror.ErrInvalidInput)
+		}
+
+		return identity.UserID, nil
+	} else if !errors.Is(err, pmerror.ErrNotFound) {
+		return uuid.UUID{}, fmt.Errorf("get identity: %w", err)
+	}
+
+	identity = &model.Identity{
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		Groups:    claims.Groups,
+		CreatedOn: now,
+	}
+
+	if linkUserID != nil {
+		identity.UserID = *linkUserID
+		if err := c.oidcRepo.CreateIdentity(identity); err != nil {
+			return uuid.UUID{}, fmt.Errorf("create identity: %w", err)
+		}
+
+		c.log.Infof("Linked identity %s of %s to user %s", claims.Subject, claims.Issuer, *linkUserID)
+
+		return *linkUserID, nil
+	}
+
+	if !c.config.OIDC.AutoProvision {
+		return uuid.UUID{}, fmt.Errorf("%w: identity is not linked to a user, sign in and link it first", pmerror.ErrForbidden)
+	}
+
+	name := claims.Subject
+	if claims.PreferredUsername != "" {
+		name = claims.PreferredUsername
+	} else if claims.Email != "" {
+		name = claims.Email
+	}
+
+	// an existing user of the same name is not taken over, its owner has to link the identity
+	if _, err := c.userRepo.GetByName(name); err == nil {
+		return uuid.UUID{}, fmt.Errorf("%w: user %s already exists, sign in and link the identity", pmerror.ErrInvalidInput, name)
+	} else if !errors.Is(err, pmerror.ErrNotFound) {
+		return uuid.UUID{}, fmt.Errorf("get user: %w", err)
+	}
+
+	// nobody knows the password, provisioned users only sign in with the provider
+	password, err := pmcrypto.NewRandom()
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	user, err := c.newUser(name, password)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	identity.UserID = user.ID
+	if err := c.oidcRepo.Provision(user, identity); err != nil {
+		return uuid.UUID{}, fmt.Errorf("provision: %w", err)
+	}
+
+	c.log.Infof("Provisioned user %s for identity %s of %s", user.ID, claims.Subject, claims.Issuer)
+
+	return user.ID, nil
+}
+
+// syncAdmin grants admin rights to members of the admin groups and revokes them from others
+func (c *Controller) syncAdmin(userID uuid.UUID, groups []string) error {
+	if len(c.config.OIDC.AdminGroups) == 0 {
+		return nil
+	}
+
+	isAdmin := false
+	for _, group := range groups {
+		for _, admin := range c.config.OIDC.AdminGroups {
+			if group == admin {
+				isAdmin = true
+			}
+		}
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	if user.IsAdmin == isAdmin {
+		return nil
+	}
+
+	if err := c.oidcRepo.SetAdmin(userID, isAdmin); err != nil {
+		return fmt.Errorf("set admin: %w", err)
+	}
+
+	c.log.Infof("Set admin of user %s to %t from groups of the identity provider", userID, isAdmin)
+
+	return nil
+}
+
+func (c *Controller) newOIDCLogin(linkUserID *uuid.UUID, reauth bool) (string, error) {
+	if c.config.OIDC == nil {
+		return "", fmt.Errorf("%w: single sign-on is disabled", pmerror.ErrForbidden)
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := pmcrypto.NewRandom()
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+		}
+
+		values[i] = value
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+	authURL, err := c.config.OIDC.Provider.AuthCodeURL(state, nonce, verifier, reauth)
+	if err != nil {
+		return "", fmt.Errorf("%w: identity provider: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	login := &model.OIDCLogin{
+		Hash:       hashToken(state),
+		Nonce:      nonce,
+		Verifier:   verifier,
+		LinkUserID: linkUserID,
+		Reauth:     reauth,
+		CreatedOn:  now,
+		ExpiresOn:  now.Add(model.OIDCLoginTTL),
+	}
+
+	if err := c.oidcRepo.CreateLogin(login); err != nil {
+		return "", fmt.Errorf("create oidc login: %w", err)
+	}
+
+	return authURL, nil
+}
+
+// SSOPolicy returns whether single sign-on is the only way to sign in, as set by an admin or the server config
+func (c *Controller) SSOPolicy(userID uuid.UUID) (*model.SSOPolicy, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	return c.ssoPolicy()
+}
+
+// UpdateSSOPolicy makes single sign-on the only way to sign in, or allows passwords and passkeys again.
+// The admin requiring it has to have an identity linked, so that they do not lock themselves out.
+func (c *Controller) UpdateSSOPolicy(userID uuid.UUID, form *model.SSOPolicyForm) (*model.SSOPolicy, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	if c.config.OIDC == nil {
+		return nil, fmt.Errorf("%w: single sign-on is disabled", pmerror.ErrForbidden)
+	}
+
+	if *form.Required {
+		identities, err := c.oidcRepo.GetIdentities(userID)
+		if err != nil {
+			return nil, fmt.Errorf("get identities: %w", err)
+		}
+
+		if len(identities) == 0 {
+			return nil, fmt.Errorf("%w: link an identity before requiring single sign-on", pmerror.ErrInvalidInput)
+		}
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	policy := &model.SSOPolicy{Required: *form.Required, UpdatedBy: &userID, UpdatedOn: &now}
+	if err := c.oidcRepo.SetPolicy(policy); err != nil {
+		return nil, fmt.Errorf("set sso policy: %w", err)
+	}
+
+	c.log.Warnf("User %s set single sign-on required to %t", userID, policy.Required)
+
+	return policy, nil
+}
+
+// ssoPolicy returns the policy an admin set, the server config stands in until one did
+func (c *Controller) ssoPolicy() (*model.SSOPolicy, error) {
+	if c.config.OIDC == nil {
+		return &model.SSOPolicy{}, nil
+	}
+
+	policy, err := c.oidcRepo.GetPolicy()
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return &model.SSOPolicy{Required: c.config.OIDC.Required}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get sso policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// passwordLoginAllowed reports pmerror.ErrForbidden when single sign-on is the only way to sign in
+func (c *Controller) passwordLoginAllowed() error {
+	policy, err := c.ssoPolicy()
+	if err != nil {
+		return err
+	}
+
+	if policy.Required {
+		return fmt.Errorf("%w: password login is disabled, sign in with single sign-on", pmerror.ErrForbidden)
+	}
+
+	return nil
+}
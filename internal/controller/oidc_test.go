@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc/oidctest"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtotp"
+)
+
+type oidcStore struct {
+	users      map[uuid.UUID]*model.User
+	identities map[[2]string]*model.Identity
+	logins     map[string]*model.OIDCLogin
+	policy     *model.SSOPolicy
+}
+
+// expectOIDCStore backs the OIDC repository mock, and the user lookups provisioning relies on, with memory
+func expectOIDCStore(mocks *controllerMocks) *oidcStore {
+	store := &oidcStore{
+		users:      make(map[uuid.UUID]*model.User),
+		identities: make(map[[2]string]*model.Identity),
+		logins:     make(map[string]*model.OIDCLogin),
+	}
+
+	u := mocks.UserRepository.EXPECT()
+	u.Get(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.User, error) {
+		if user, ok := store.users[id]; ok {
+			result := *user
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	u.GetByName(gomock.Any()).AnyTimes().DoAndReturn(func(name string) (*model.User, error) {
+		for _, user := range store.users {
+			if user.Name == name {
+				result := *user
+				return &result, nil
+			}
+		}
+		return nil, pmerror.ErrNotFound
+	})
+
+	r := mocks.OIDCRepository.EXPECT()
+	r.GetIdentity(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(issuer, subject string) (*model.Identity, error) {
+		if identity, ok := store.identities[[2]string{issuer, subject}]; ok {
+			result := *identity
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.GetIdentities(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) ([]model.Identity, error) {
+		var result []model.Identity
+		for _, identity := range store.identities {
+			if identity.UserID == userID {
+				result = append(result, *identity)
+			}
+		}
+		return result, nil
+	})
+	r.CreateIdentity(gomock.Any()).AnyTimes().DoAndReturn(func(identity *model.Identity) error {
+		stored := *identity
+		store.identities[[2]string{identity.Issuer, identity.Subject}] = &stored
+		return nil
+	})
+	r.Provision(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(user *model.User, identity *model.Identity) error {
+		storedUser, storedIdentity := *user, *identity
+		store.users[user.ID] = &storedUser
+		store.identities[[2]string{identity.Issuer, identity.Subject}] = &storedIdentity
+		return nil
+	})
+	r.TouchIdentity(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(issuer, subject string, groups []string, now time.Time) error {
+		identity := store.identities[[2]string{issuer, subject}]
+		identity.Groups, identity.LastLoginOn = groups, &now
+		return nil
+	})
+	r.SetAdmin(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, isAdmin bool) error {
+		store.users[userID].IsAdmin = isAdmin
+		return nil
+	})
+	r.CreateLogin(gomock.Any()).AnyTimes().DoAndReturn(func(login *model.OIDCLogin) error {
+		stored := *login
+		store.logins[login.Hash] = &stored
+		return nil
+	})
+	r.GetLogin(gomock.Any()).AnyTimes().DoAndReturn(func(hash string) (*model.OIDCLogin, error) {
+		if login, ok := store.logins[hash]; ok {
+			result := *login
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.UseLogin(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, now time.Time) error {
+		login := store.logins[hash]
+		if login.UsedOn != nil {
+			return pmerror.ErrNotFound
+		}
+		login.UsedOn = &now
+		return nil
+	})
+	r.AuthenticateLogin(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, now time.Time) error {
+		store.logins[hash].AuthenticatedOn = &now
+		return nil
+	})
+	r.SpendReauth(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, userID uuid.UUID, since time.Time) error {
+		login, ok := store.logins[hash]
+		if !ok || !login.Reauth || *login.LinkUserID != userID || login.AuthenticatedOn == nil || login.AuthenticatedOn.Before(since) {
+			return pmerror.ErrNotFound
+		}
+		delete(store.logins, hash)
+		return nil
+	})
+	r.GetPolicy().AnyTimes().DoAndReturn(func() (*model.SSOPolicy, error) {
+		if store.policy == nil {
+			return nil, pmerror.ErrNotFound
+		}
+		result := *store.policy
+		return &result, nil
+	})
+	r.SetPolicy(gomock.Any()).AnyTimes().DoAndReturn(func(policy *model.SSOPolicy) error {
+		stored := *policy
+		store.policy = &stored
+		return nil
+	})
+
+	return store
+}
+
+func TestController_OIDC(t *testing.T) {
+	idp, err := oidctest.New("client", "secret")
+	require.NoError(t, err)
+	// parallel subtests run after this function returns
+	t.Cleanup(idp.Close)
+
+	provider, err := pmoidc.NewProvider(idp.Config("http://localhost:5000/login/oidc/callback"))
+	require.NoError(t, err)
+
+	alice := map[string]interface{}{
+		"sub":                "alice-id",
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "vault-admins"},
+	}
+
+	signIn := func(t *testing.T, authURL string, claims map[string]interface{}) *model.OIDCCallbackForm {
+		code, state, err := idp.Authorize(authURL, claims)
+		require.NoError(t, err)
+
+		return &model.OIDCCallbackForm{State: &state, Code: &code}
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_provision",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, AutoProvision: true, AdminGroups: []string{"vault-admins"}}
+				store := expectOIDCStore(mocks)
+				expectTwoFactorStore(mocks)
+
+				authURL, err := c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				form := signIn(t, authURL, alice)
+				result, err := c.CompleteOIDCLogin(form)
+				require.NoError(t, err)
+				require.False(t, result.Reauth)
+
+				userID := result.UserID
+				user := store.users[userID]
+				require.Equal(t, "alice", user.Name)
+				require.True(t, user.IsAdmin)
+				require.NotEmpty(t, user.DataKey)
+				require.Equal(t, []string{"staff", "vault-admins"}, store.identities[[2]string{idp.Issuer(), "alice-id"}].Groups)
+
+				// a replayed callback does not sign in again
+				_, err = c.CompleteOIDCLogin(form)
+				require.ErrorIs(t, err, pmerror.ErrUnauthorized)
+
+				// leaving the admin group revokes admin rights on the next sign in
+				authURL, err = c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				again, err := c.CompleteOIDCLogin(signIn(t, authURL, map[string]interface{}{"sub": "alice-id", "groups": "staff"}))
+				require.NoError(t, err)
+				require.Equal(t, userID, again.UserID)
+				require.False(t, store.users[userID].IsAdmin)
+			},
+		},
+		{
+			Name: "success_link",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider}
+				store := expectOIDCStore(mocks)
+				expectTwoFactorStore(mocks)
+				user := &model.User{ID: uuid.New(), Name: "alice"}
+				store.users[user.ID] = user
+
+				authURL, err := c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				_, err = c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				authURL, err = c.BeginOIDCLink(user.ID)
+				require.NoError(t, err)
+
+				result, err := c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.NoError(t, err)
+				require.Equal(t, user.ID, result.UserID)
+
+				identities := store.identities
+				require.Len(t, identities, 1)
+				require.Equal(t, user.ID, identities[[2]string{idp.Issuer(), "alice-id"}].UserID)
+			},
+		},
+		{
+			Name: "success_reauth",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, AutoProvision: true}
+				store := expectOIDCStore(mocks)
+				expectTwoFactorStore(mocks)
+
+				authURL, err := c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				result, err := c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.NoError(t, err)
+				user := store.users[result.UserID]
+				_, _, recoveryCodes := enableTestTOTP(t, c, user)
+
+				// provisioned users do not know their password
+				_, err = c.RegenerateRecoveryCodes(user.ID, &model.ReauthForm{Password: pmpointer.String("guess"), RecoveryCode: &recoveryCodes[0]})
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+
+				authURL, err = c.BeginOIDCReauth(user.ID)
+				require.NoError(t, err)
+
+				reauth := signIn(t, authURL, map[string]interface{}{"sub": "alice-id", "auth_time": time.Now().Unix()})
+				result, err = c.CompleteOIDCLogin(reauth)
+				require.NoError(t, err)
+				require.Equal(t, &model.OIDCResult{UserID: user.ID, Reauth: true}, result)
+
+				codes, err := c.RegenerateRecoveryCodes(user.ID, &model.ReauthForm{OIDCState: reauth.State, RecoveryCode: &recoveryCodes[1]})
+				require.NoError(t, err)
+				require.Len(t, codes, model.RecoveryCodeCount)
+
+				// a reauthentication confirms one change
+				_, err = c.RegenerateRecoveryCodes(user.ID, &model.ReauthForm{OIDCState: reauth.State, RecoveryCode: &codes[0]})
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+			},
+		},
+		{
+			Name: "error_reauth_not_fresh",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider}
+				store := expectOIDCStore(mocks)
+				user := &model.User{ID: uuid.New(), Name: "alice"}
+				store.users[user.ID] = user
+				store.identities[[2]string{idp.Issuer(), "alice-id"}] = &model.Identity{Issuer: idp.Issuer(), Subject: "alice-id", UserID: user.ID}
+				other := &model.User{ID: uuid.New(), Name: "bob"}
+				store.users[other.ID] = other
+
+				_, err := c.BeginOIDCReauth(other.ID)
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+
+				// a session at the provider from before does not count
+				authURL, err := c.BeginOIDCReauth(user.ID)
+				require.NoError(t, err)
+				require.Contains(t, authURL, "prompt=login")
+
+				reauth := signIn(t, authURL, map[string]interface{}{"sub": "alice-id", "auth_time": time.Now().Add(-time.Hour).Unix()})
+				_, err = c.CompleteOIDCLogin(reauth)
+				require.ErrorIs(t, err, pmerror.ErrUnauthorized)
+				require.ErrorIs(t, c.spendOIDCReauth(user.ID, *reauth.State, time.Now()), pmerror.ErrInvalidInput)
+
+				// nor does an identity of another user
+				store.identities[[2]string{idp.Issuer(), "bob-id"}] = &model.Identity{Issuer: idp.Issuer(), Subject: "bob-id", UserID: other.ID}
+				authURL, err = c.BeginOIDCReauth(user.ID)
+				require.NoError(t, err)
+
+				_, err = c.CompleteOIDCLogin(signIn(t, authURL, map[string]interface{}{"sub": "bob-id", "auth_time": time.Now().Unix()}))
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_provision_name_taken",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, AutoProvision: true}
+				store := expectOIDCStore(mocks)
+				store.users[uuid.New()] = &model.User{Name: "alice"}
+
+				authURL, err := c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				_, err = c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+				require.Empty(t, store.identities)
+			},
+		},
+		{
+			Name: "error_unknown_state",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, AutoProvision: true}
+				expectOIDCStore(mocks)
+
+				_, err := c.CompleteOIDCLogin(&model.OIDCCallbackForm{State: pmpointer.String("forged"), Code: pmpointer.String("code")})
+				require.ErrorIs(t, err, pmerror.ErrUnauthorized)
+			},
+		},
+		{
+			Name: "success_two_factor",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, AutoProvision: true}
+				store := expectOIDCStore(mocks)
+				expectTwoFactorStore(mocks)
+
+				authURL, err := c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				result, err := c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.NoError(t, err)
+				user := store.users[result.UserID]
+				secret, step, _ := enableTestTOTP(t, c, user)
+
+				// the provider signing the user in is the first factor only
+				authURL, err = c.BeginOIDCLogin()
+				require.NoError(t, err)
+
+				result, err = c.CompleteOIDCLogin(signIn(t, authURL, alice))
+				require.NoError(t, err)
+				require.Equal(t, uuid.Nil, result.UserID)
+				require.NotEmpty(t, result.Challenge)
+
+				next, err := pmtotp.Code(secret, step+1)
+				require.NoError(t, err)
+				userID, err := c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &result.Challenge, Code: &next})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, userID)
+			},
+		},
+		{
+			Name: "success_sso_policy",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider}
+				store := expectOIDCStore(mocks)
+				admin := &model.User{ID: uuid.New(), Name: "admin", IsAdmin: true}
+				store.users[admin.ID] = admin
+				member := &model.User{ID: uuid.New(), Name: "member"}
+				store.users[member.ID] = member
+				required, optional := true, false
+
+				policy, err := c.SSOPolicy(admin.ID)
+				require.NoError(t, err)
+				require.False(t, policy.Required)
+
+				_, err = c.UpdateSSOPolicy(member.ID, &model.SSOPolicyForm{Required: &required})
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				// admins without an identity would lock themselves out
+				_, err = c.UpdateSSOPolicy(admin.ID, &model.SSOPolicyForm{Required: &required})
+				require.ErrorIs(t, err, pmerror.ErrInvalidInput)
+
+				store.identities[[2]string{idp.Issuer(), "admin-id"}] = &model.Identity{Issuer: idp.Issuer(), Subject: "admin-id", UserID: admin.ID}
+				policy, err = c.UpdateSSOPolicy(admin.ID, &model.SSOPolicyForm{Required: &required})
+				require.NoError(t, err)
+				require.True(t, policy.Required)
+				require.Equal(t, admin.ID, *policy.UpdatedBy)
+
+				_, _, err = c.Login(&model.UserForm{Name: pmpointer.String("member"), Password: pmpointer.String("Test User Password")})
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				// the stored policy outranks the server config
+				c.config.OIDC.Required = true
+				_, err = c.UpdateSSOPolicy(admin.ID, &model.SSOPolicyForm{Required: &optional})
+				require.NoError(t, err)
+				require.NoError(t, c.passwordLoginAllowed())
+			},
+		},
+		{
+			Name: "error_required",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.OIDC = &OIDCConfig{Provider: provider, Required: true}
+				expectOIDCStore(mocks)
+
+				_, _, err := c.Login(&model.UserForm{Name: pmpointer.String("alice"), Password: pmpointer.String("Test User Password")})
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.CreateUser(&model.UserForm{Name: pmpointer.String("alice"), Password: pmpointer.String("Test User Password")})
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.BeginWebAuthnLogin()
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_disabled",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				_, err := c.BeginOIDCLogin()
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
+)
+
+// bucket key prefixes, addresses and account names are kept apart from each other and from the login buckets
+const (
+	ipBucket           = "ip:"
+	accountBucket      = "account:"
+	loginIPBucket      = "login-ip:"
+	loginAccountBucket = "login-account:"
+)
+
+// LimitAddress throttles all requests from ip
+func (c *Controller) LimitAddress(ip string) error {
+	if c.config.RateLimit == nil {
+		return nil
+	}
+
+	return c.take(ipBucket+ip, c.config.RateLimit.IP)
+}
+
+// LimitPrincipal throttles the requests of a signed in user or service account
+func (c *Controller) LimitPrincipal(id uuid.UUID) error {
+	if c.config.RateLimit == nil {
+		return nil
+	}
+
+	return c.take(accountBucket+id.String(), c.config.RateLimit.Account)
+}
+
+// LimitLogin throttles sign in attempts from ip and, when the attempt names an account, with name
+func (c *Controller) LimitLogin(ip, name string) error {
+	if c.config.RateLimit == nil {
+		return nil
+	}
+
+	if err := c.take(loginIPBucket+ip, c.config.RateLimit.LoginIP); err != nil {
+		return err
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	return c.take(loginAccountBucket+name, c.config.RateLimit.LoginAccount)
+}
+
+// RunRateLimitPrune deletes buckets and login failures that no longer limit anything every interval until ctx is done
+func (c *Controller) RunRateLimitPrune(ctx context.Context, interval time.Duration) {
+	if c.config.RateLimit == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		if err := c.rateRepo.Prune(now.Add(-c.config.RateLimit.retention()), now); err != nil {
+			c.log.Errorf("Failed to prune rate limits: %s", err.Error())
+		}
+	}
+}
+
+// retention is how long buckets and failures matter, a bucket idle for its interval is full again
+func (l *RateLimitConfig) retention() time.Duration {
+	return max(l.FailureWindow, l.IP.Interval, l.Account.Interval, l.LoginIP.Interval, l.LoginAccount.Interval)
+}
+
+func (c *Controller) take(key string, limit pmratelimit.Limit) error {
+	if !limit.Enabled() {
+		return nil
+	}
+
+	wait, err := c.rateRepo.Take(key, limit, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("take token: %w", err)
+	}
+
+	if wait > 0 {
+		return fmt.Errorf("bucket %s is empty: %w", key, &pmerror.RetryError{After: wait})
+	}
+
+	return nil
+}
+
+// checkLoginFailures rejects signing in with name while the account is locked out or former failures delay it
+func (c *Controller) checkLoginFailures(name string) error {
+	limits := c.config.RateLimit
+	if limits == nil {
+		return nil
+	}
+
+	failure, err := c.rateRepo.GetLoginFailure(name)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get login failure: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		return fmt.Errorf("account %s is locked: %w", name, &pmerror.RetryError{After: failure.LockedUntil.Sub(now)})
+	}
+
+	if failure.LastFailureOn.Before(now.Add(-limits.FailureWindow)) {
+		return nil
+	}
+
+	delay := pmratelimit.Backoff(failure.Failures, limits.FreeFailures, limits.FailureDelay, limits.MaxFailureDelay)
+	if retryOn := failure.LastFailureOn.Add(delay); now.Before(retryOn) {
+		return fmt.Errorf("account %s failed %d logins: %w", name, failure.Failures, &pmerror.RetryError{After: retryOn.Sub(now)})
+	}
+
+	return nil
+}
+
+// loginFailed counts a failed sign in with name and locks the account once failures reach the threshold.
+// Errors are only logged, the login fails either way.
+func (c *Controller) loginFailed(name string) {
+	limits := c.config.RateLimit
+	if limits == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	failure, err := c.rateRepo.AddLoginFailure(name, now.Add(-limits.FailureWindow), now)
+	if err != nil {
+		c.log.Errorf("Failed to count failed login of %s: %s", name, err.Error())
+		return
+	}
+
+	if limits.LockoutThreshold <= 0 || failure.Failures < limits.LockoutThreshold {
+		return
+	}
+
+	until := now.Add(limits.LockoutDuration)
+	if err := c.rateRepo.LockAccount(name, until); err != nil {
+		c.log.Errorf("Failed to lock account %s: %s", name, err.Error())
+		return
+	}
+
+	c.log.Warnf("Locked account %s until %s after %d failed logins", name, until, failure.Failures)
+}
+
+// loginSucceeded forgets the failed sign ins with name
+func (c *Controller) loginSucceeded(name string) {
+	if c.config.RateLimit == nil {
+		return
+	}
+
+	if err := c.rateRepo.ResetLoginFailures(name); err != nil {
+		c.log.Errorf("Failed to reset failed logins of %s: %s", name, err.Error())
+	}
+}
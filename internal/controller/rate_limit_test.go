@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtotp"
+)
+
+// expectRateLimitStore backs the rate limit repository mock with memory, keyed by account name
+func expectRateLimitStore(mocks *controllerMocks) map[string]*model.LoginFailure {
+	buckets := make(map[string]*pmratelimit.Bucket)
+	failures := make(map[string]*model.LoginFailure)
+
+	r := mocks.RateRepository.EXPECT()
+	r.Take(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(key string, limit pmratelimit.Limit, now time.Time) (time.Duration, error) {
+		if _, ok := buckets[key]; !ok {
+			bucket := limit.New(now)
+			buckets[key] = &bucket
+		}
+		return limit.Take(buckets[key], now), nil
+	})
+	r.GetLoginFailure(gomock.Any()).AnyTimes().DoAndReturn(func(account string) (*model.LoginFailure, error) {
+		if failure, ok := failures[account]; ok {
+			result := *failure
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.AddLoginFailure(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(account string, since, now time.Time) (*model.LoginFailure, error) {
+		failure, ok := failures[account]
+		if !ok || failure.LastFailureOn.Before(since) {
+			failure = &model.LoginFailure{Account: account}
+			failures[account] = failure
+		}
+		failure.Failures++
+		failure.LastFailureOn = now
+		result := *failure
+		return &result, nil
+	})
+	r.LockAccount(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(account string, until time.Time) error {
+		failures[account].LockedUntil = &until
+		return nil
+	})
+	r.ResetLoginFailures(gomock.Any()).AnyTimes().DoAndReturn(func(account string) error {
+		delete(failures, account)
+		return nil
+	})
+
+	return failures
+}
+
+func TestController_RateLimit(t *testing.T) {
+	// a local user in client mode, so that signing in neither rehashes nor migrates anything
+	expectLocalUser := func(t *testing.T, c *Controller, mocks *controllerMocks) {
+		c.config.Mode = CryptoModeClient
+		users := expectDirectoryUsers(mocks)
+		hash, err := pmcrypto.HashPassword("secret", testKDFParams)
+		require.NoError(t, err)
+
+		user := &model.User{ID: uuid.New(), Name: "alice", Password: hash}
+		users[user.ID] = user
+	}
+
+	login := func(c *Controller, password string) error {
+		_, _, err := c.Login(&model.UserForm{Name: pmpointer.String("alice"), Password: pmpointer.String(password)})
+		return err
+	}
+
+	requireRetry := func(t *testing.T, err error, after time.Duration) {
+		require.ErrorIs(t, err, pmerror.ErrTooManyRequests)
+
+		var retry *pmerror.RetryError
+		require.True(t, errors.As(err, &retry))
+		require.InDelta(t, after, retry.After, float64(time.Minute))
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_reset",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RateLimit = &RateLimitConfig{FreeFailures: 3, FailureDelay: time.Hour, MaxFailureDelay: time.Hour, FailureWindow: time.Hour}
+				expectLocalUser(t, c, mocks)
+				failures := expectRateLimitStore(mocks)
+
+				require.ErrorIs(t, login(c, "wrong"), pmerror.ErrInvalidInput)
+				require.Equal(t, 1, failures["alice"].Failures)
+
+				require.NoError(t, login(c, "secret"))
+				require.Empty(t, failures)
+			},
+		},
+		{
+			Name: "error_progressive_delay",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RateLimit = &RateLimitConfig{FreeFailures: 1, FailureDelay: time.Hour, MaxFailureDelay: 4 * time.Hour, FailureWindow: 24 * time.Hour}
+				expectLocalUser(t, c, mocks)
+				failures := expectRateLimitStore(mocks)
+
+				require.ErrorIs(t, login(c, "wrong"), pmerror.ErrInvalidInput)
+				require.ErrorIs(t, login(c, "wrong"), pmerror.ErrInvalidInput)
+				requireRetry(t, login(c, "secret"), time.Hour)
+
+				// the delay doubles with every failure past the free ones
+				failures["alice"].Failures = 3
+				requireRetry(t, login(c, "secret"), 2*time.Hour)
+
+				// failures older than the window are forgotten
+				failures["alice"].LastFailureOn = time.Now().UTC().Add(-25 * time.Hour)
+				require.NoError(t, login(c, "secret"))
+			},
+		},
+		{
+			Name: "error_lockout",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RateLimit = &RateLimitConfig{FreeFailures: 5, LockoutThreshold: 2, LockoutDuration: time.Hour, FailureWindow: time.Hour}
+				expectLocalUser(t, c, mocks)
+				failures := expectRateLimitStore(mocks)
+
+				require.ErrorIs(t, login(c, "wrong"), pmerror.ErrInvalidInput)
+				require.Nil(t, failures["alice"].LockedUntil)
+				require.ErrorIs(t, login(c, "wrong"), pmerror.ErrInvalidInput)
+				require.NotNil(t, failures["alice"].LockedUntil)
+
+				// the right password does not get through a lockout either
+				requireRetry(t, login(c, "secret"), time.Hour)
+
+				expired := time.Now().UTC().Add(-time.Second)
+				failures["alice"].LockedUntil = &expired
+				require.NoError(t, login(c, "secret"))
+			},
+		},
+		{
+			Name: "error_lockout_second_factor",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RateLimit = &RateLimitConfig{FreeFailures: 5, LockoutThreshold: 2, LockoutDuration: time.Hour, FailureWindow: time.Hour}
+				c.config.Mode = CryptoModeClient
+				hash, err := pmcrypto.HashPassword("secret", testKDFParams)
+				require.NoError(t, err)
+
+				user := &model.User{ID: uuid.New(), Name: "alice", Password: hash}
+				mocks.UserRepository.EXPECT().Get(user.ID).AnyTimes().Return(user, nil)
+				mocks.UserRepository.EXPECT().GetByName(user.Name).AnyTimes().Return(user, nil)
+				failures := expectRateLimitStore(mocks)
+				expectTwoFactorStore(mocks)
+				secret, step, _ := enableTestTOTP(t, c, user)
+
+				completeLogin := func(code string) error {
+					_, challenge, err := c.Login(&model.UserForm{Name: pmpointer.String("alice"), Password: pmpointer.String("secret")})
+					if err != nil {
+						return err
+					}
+
+					_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: &code})
+					return err
+				}
+
+				// the right password alone does not forget wrong codes, a fresh challenge does not either
+				require.ErrorIs(t, completeLogin("000000"), pmerror.ErrInvalidInput)
+				require.Equal(t, 1, failures["alice"].Failures)
+				require.ErrorIs(t, completeLogin("000000"), pmerror.ErrInvalidInput)
+				require.NotNil(t, failures["alice"].LockedUntil)
+
+				next, err := pmtotp.Code(secret, step+1)
+				require.NoError(t, err)
+				requireRetry(t, completeLogin(next), time.Hour)
+
+				expired := time.Now().UTC().Add(-time.Second)
+				failures["alice"].LockedUntil = &expired
+				require.NoError(t, completeLogin(next))
+				require.Empty(t, failures)
+			},
+		},
+		{
+			Name: "error_buckets",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RateLimit = &RateLimitConfig{
+					IP:            pmratelimit.Limit{Requests: 1, Interval: time.Hour},
+					LoginIP:       pmratelimit.Limit{Requests: 3, Interval: time.Hour},
+					LoginAccount:  pmratelimit.Limit{Requests: 2, Interval: time.Hour},
+					FailureWindow: time.Hour,
+				}
+				expectRateLimitStore(mocks)
+
+				require.NoError(t, c.LimitAddress("192.0.2.1"))
+				requireRetry(t, c.LimitAddress("192.0.2.1"), time.Hour)
+				require.NoError(t, c.LimitAddress("192.0.2.2"))
+
+				// the account limit is off, and login buckets are apart from the address ones
+				require.NoError(t, c.LimitPrincipal(uuid.New()))
+				require.NoError(t, c.LimitLogin("192.0.2.1", "alice"))
+				require.NoError(t, c.LimitLogin("192.0.2.1", "alice"))
+				requireRetry(t, c.LimitLogin("192.0.2.2", "alice"), 30*time.Minute)
+				require.NoError(t, c.LimitLogin("192.0.2.1", "bob"))
+				requireRetry(t, c.LimitLogin("192.0.2.1", ""), 20*time.Minute)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
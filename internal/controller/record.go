@@ -2,72 +2,94 @@ package controller
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"time"
+	"slices"
+	"strings"
 
 	"github.com/google/uuid"
 
 	"github.com/ChillyWR/PasswordManager/model"
 	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
-	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
 )
 
+// AllRecords lists the records of userID with their labels decrypted, names are only sorted once decrypted.
+// In client mode records are listed as stored in order of creation. Service accounts get the records granted to them.
 func (c *Controller) AllRecords(userID uuid.UUID) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error) {
-	return c.recordRepo.GetAll(userID)
-}
+	key, vault, err := c.readVault(userID, nil)
+	if err != nil {
+		account, err := c.serviceAccount(userID, err)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
 
-func (c *Controller) GetRecord(id uuid.UUID, userID uuid.UUID) (interface{}, error) {
-	if err := c.authorizeRecord(id, userID); err != nil {
-		return nil, fmt.Errorf("authorize: %w", err)
+		return c.grantedRecords(account)
 	}
 
-	credentialRecord, err := c.recordRepo.GetCredentialRecord(id)
-	if err != nil {
-		return nil, fmt.Errorf("get: %w", err)
+	if c.config.Mode == CryptoModeClient {
+		return vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities, nil
 	}
 
-	login, err := c.recordRepo.GetLogin(id)
-	if err == nil {
-		if err := c.decryptLogin(login); err != nil {
-			return nil, fmt.Errorf("decrypt login: %w", err)
+	for _, record := range vault.Records() {
+		if err := c.decryptLabels(record, key); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("decrypt %T: %w", record, err)
 		}
-
-		return login, nil
-	} else if !errors.Is(err, pmerror.ErrNotFound) {
-		return nil, fmt.Errorf("get login: %w", err)
 	}
 
-	card, err := c.recordRepo.GetCard(id)
-	if err == nil {
-		if err := c.decryptCard(card); err != nil {
-			return nil, fmt.Errorf("decrypt card: %w", err)
-		}
+	sortRecords(vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities)
 
-		return card, nil
-	} else if !errors.Is(err, pmerror.ErrNotFound) {
-		return nil, fmt.Errorf("get card: %w", err)
-	}
+	return vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities, nil
+}
 
-	identity, err := c.recordRepo.GetIdentity(id)
-	if err == nil {
-		if err := c.decryptIdentity(identity); err != nil {
-			return nil, fmt.Errorf("decrypt identity: %w", err)
-		}
+// sortRecords orders decrypted records by creation time, name and ID
+func sortRecords(secureNotes []model.CredentialRecord, logins []model.LoginRecord, cards []model.CardRecord, identities []model.IdentityRecord) {
+	slices.SortStableFunc(secureNotes, func(a, b model.CredentialRecord) int {
+		return compareRecords(&a, &b)
+	})
+	slices.SortStableFunc(logins, func(a, b model.LoginRecord) int {
+		return compareRecords(&a.CredentialRecord, &b.CredentialRecord)
+	})
+	slices.SortStableFunc(cards, func(a, b model.CardRecord) int {
+		return compareRecords(&a.CredentialRecord, &b.CredentialRecord)
+	})
+	slices.SortStableFunc(identities, func(a, b model.IdentityRecord) int {
+		return compareRecords(&a.CredentialRecord, &b.CredentialRecord)
+	})
+}
+
+func compareRecords(a, b *model.CredentialRecord) int {
+	if c := a.CreatedOn.Compare(b.CreatedOn); c != 0 {
+		return c
+	}
 
-		return identity, nil
-	} else if !errors.Is(err, pmerror.ErrNotFound) {
-		return nil, fmt.Errorf("get identity: %w", err)
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
 	}
 
-	if err := c.decryptCredentialRecord(credentialRecord); err != nil {
-		return nil, fmt.Errorf("decrypt identity: %w", err)
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+// GetRecord returns record id of userID, or one granted to userID when it is a service account
+func (c *Controller) GetRecord(id uuid.UUID, userID uuid.UUID) (interface{}, error) {
+	_, record, err := c.readRecord(userID, id)
+	if err != nil {
+		// service accounts own no records, whichever they ask for is missing or someone else's
+		account, err := c.serviceAccount(userID, err)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.grantedRecord(account, id)
 	}
 
-	return credentialRecord, nil
+	return record, nil
 }
 
 func (c *Controller) CreateRecord(recordType model.RecordType, rawForm json.RawMessage, userID uuid.UUID) (interface{}, error) {
+	key, err := c.vaultKey(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	switch recordType {
 	case model.SecureNoteRecordType:
 		var form model.CredentialRecordForm
@@ -81,11 +103,12 @@ func (c *Controller) CreateRecord(recordType model.RecordType, rawForm json.RawM
 
 		record := model.NewCredentialRecord(*form.Name, form.Notes, userID)
 
-		if err := c.encryptCredentialRecord(record); err != nil {
+		entries := c.indexRecord(record, key)
+		if err := c.encryptRecord(record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.CreateCredentialRecord(record)
+		return c.recordRepo.CreateCredentialRecord(userID, record, entries)
 	case model.LoginRecordType:
 		var form model.LoginRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -103,11 +126,12 @@ func (c *Controller) CreateRecord(recordType model.RecordType, rawForm json.RawM
 			URL:              form.URL,
 		}
 
-		if err := c.encryptLogin(&record); err != nil {
+		entries := c.indexRecord(&record, key)
+		if err := c.encryptRecord(&record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.CreateLogin(&record)
+		return c.recordRepo.CreateLogin(userID, &record, entries)
 	case model.CardRecordType:
 		var form model.CardRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -127,11 +151,12 @@ func (c *Controller) CreateRecord(recordType model.RecordType, rawForm json.RawM
 			CVV:              form.CVV,
 		}
 
-		if err := c.encryptCard(&record); err != nil {
+		entries := c.indexRecord(&record, key)
+		if err := c.encryptRecord(&record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.CreateCard(&record)
+		return c.recordRepo.CreateCard(userID, &record, entries)
 	case model.IdentityRecordType:
 		var form model.IdentityRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -154,27 +179,25 @@ func (c *Controller) CreateRecord(recordType model.RecordType, rawForm json.RawM
 			Country:          form.Country,
 		}
 
-		if err := c.encryptIdentity(&record); err != nil {
+		entries := c.indexRecord(&record, key)
+		if err := c.encryptRecord(&record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.CreateIdentity(&record)
+		return c.recordRepo.CreateIdentity(userID, &record, entries)
 	default:
 		return nil, fmt.Errorf("%w: unsupported record type", pmerror.ErrInvalidInput)
 	}
 }
 
 func (c *Controller) UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID uuid.UUID) (interface{}, error) {
-	if err := c.authorizeRecord(id, userID); err != nil {
-		return nil, fmt.Errorf("authorize: %w", err)
-	}
-
-	record, err := c.GetRecord(id, userID)
+	key, record, err := c.readRecord(userID, id)
 	if err != nil {
 		return nil, fmt.Errorf("get: %w", err)
 	}
 
-	switch record.(type) {
+	// the whole record is re-encrypted, its fields are bound to the new revision
+	switch record := record.(type) {
 	case *model.CredentialRecord:
 		var form model.CredentialRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -185,18 +208,15 @@ func (c *Controller) UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID
 			return nil, fmt.Errorf("%w: empty form", pmerror.ErrInvalidInput)
 		}
 
-		record := model.CredentialRecord{
-			ID:        id,
-			UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-			UpdatedBy: userID,
-		}
 		record.ApplyForm(&form)
+		record.Touch(userID)
 
-		if err := c.encryptCredentialRecord(&record); err != nil {
+		entries := c.indexRecord(record, key)
+		if err := c.encryptRecord(record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.UpdateCredentialRecord(&record)
+		return c.recordRepo.UpdateCredentialRecord(userID, record, entries)
 	case *model.LoginRecord:
 		var form model.LoginRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -207,20 +227,15 @@ func (c *Controller) UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID
 			return nil, fmt.Errorf("%w: empty form", pmerror.ErrInvalidInput)
 		}
 
-		record := model.LoginRecord{
-			CredentialRecord: model.CredentialRecord{
-				ID:        id,
-				UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-				UpdatedBy: userID,
-			},
-		}
 		record.ApplyForm(&form)
+		record.Touch(userID)
 
-		if err := c.encryptLogin(&record); err != nil {
+		entries := c.indexRecord(record, key)
+		if err := c.encryptRecord(record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.UpdateLogin(&record)
+		return c.recordRepo.UpdateLogin(userID, record, entries)
 	case *model.CardRecord:
 		var form model.CardRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -231,20 +246,15 @@ func (c *Controller) UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID
 			return nil, fmt.Errorf("%w: empty form", pmerror.ErrInvalidInput)
 		}
 
-		record := model.CardRecord{
-			CredentialRecord: model.CredentialRecord{
-				ID:        id,
-				UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-				UpdatedBy: userID,
-			},
-		}
 		record.ApplyForm(&form)
+		record.Touch(userID)
 
-		if err := c.encryptCard(&record); err != nil {
+		entries := c.indexRecord(record, key)
+		if err := c.encryptRecord(record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.UpdateCard(&record)
+		return c.recordRepo.UpdateCard(userID, record, entries)
 	case *model.IdentityRecord:
 		var form model.IdentityRecordForm
 		if err := json.Unmarshal(rawForm, &form); err != nil {
@@ -255,43 +265,20 @@ func (c *Controller) UpdateRecord(id uuid.UUID, rawForm json.RawMessage, userID
 			return nil, fmt.Errorf("%w: empty form", pmerror.ErrInvalidInput)
 		}
 
-		record := model.IdentityRecord{
-			CredentialRecord: model.CredentialRecord{
-				ID:        id,
-				UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-				UpdatedBy: userID,
-			},
-		}
 		record.ApplyForm(&form)
+		record.Touch(userID)
 
-		if err := c.encryptIdentity(&record); err != nil {
+		entries := c.indexRecord(record, key)
+		if err := c.encryptRecord(record, key); err != nil {
 			return nil, fmt.Errorf("validate: %w", err)
 		}
 
-		return c.recordRepo.UpdateIdentity(&record)
+		return c.recordRepo.UpdateIdentity(userID, record, entries)
 	default:
 		return nil, fmt.Errorf("%w: type assertion %T", pmerror.ErrInternal, record)
 	}
 }
 
 func (c *Controller) DeleteRecord(id uuid.UUID, userID uuid.UUID) (*model.CredentialRecord, error) {
-	if err := c.authorizeRecord(id, userID); err != nil {
-		return nil, fmt.Errorf("authorize: %w", err)
-	}
-
-	return c.recordRepo.Delete(id)
-}
-
-func (c *Controller) authorizeRecord(id uuid.UUID, userID uuid.UUID) error {
-	// TODO: authorize in db with "isOwner" check
-	record, err := c.recordRepo.GetCredentialRecord(id)
-	if err != nil {
-		return fmt.Errorf("get: %w", err)
-	}
-
-	if record.CreatedBy.String() != userID.String() {
-		return fmt.Errorf("%w: user %s does not own record %s", pmerror.ErrForbidden, userID.String(), id.String())
-	}
-
-	return nil
+	return c.recordRepo.Delete(userID, id)
 }
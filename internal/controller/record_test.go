@@ -2,6 +2,7 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -14,11 +15,23 @@ import (
 	"github.com/ChillyWR/PasswordManager/model"
 	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
 	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
 )
 
 type controllerMocks struct {
-	RecordRepository *mock.MockRecordRepository
-	UserRepository   *mock.MockUserRepository
+	RecordRepository    *mock.MockRecordRepository
+	UserRepository      *mock.MockUserRepository
+	KeyRepository       *mock.MockKeyRepository
+	RecoveryRepository  *mock.MockRecoveryRepository
+	SessionRepository   *mock.MockSessionRepository
+	TokenRepository     *mock.MockAccessTokenRepository
+	ServiceRepository   *mock.MockServiceAccountRepository
+	TwoFactorRepository *mock.MockTwoFactorRepository
+	WebAuthnRepository  *mock.MockWebAuthnRepository
+	OIDCRepository      *mock.MockOIDCRepository
+	CertRepository      *mock.MockCertificateRepository
+	RateRepository      *mock.MockRateLimitRepository
 }
 
 type controllerTestCase struct {
@@ -31,18 +44,142 @@ func (tc controllerTestCase) runTests(t *testing.T) {
 	defer ctrl.Finish()
 
 	mocks := &controllerMocks{
-		RecordRepository: mock.NewMockRecordRepository(ctrl),
-		UserRepository:   mock.NewMockUserRepository(ctrl),
+		RecordRepository:    mock.NewMockRecordRepository(ctrl),
+		UserRepository:      mock.NewMockUserRepository(ctrl),
+		KeyRepository:       mock.NewMockKeyRepository(ctrl),
+		RecoveryRepository:  mock.NewMockRecoveryRepository(ctrl),
+		SessionRepository:   mock.NewMockSessionRepository(ctrl),
+		TokenRepository:     mock.NewMockAccessTokenRepository(ctrl),
+		ServiceRepository:   mock.NewMockServiceAccountRepository(ctrl),
+		TwoFactorRepository: mock.NewMockTwoFactorRepository(ctrl),
+		WebAuthnRepository:  mock.NewMockWebAuthnRepository(ctrl),
+		OIDCRepository:      mock.NewMockOIDCRepository(ctrl),
+		CertRepository:      mock.NewMockCertificateRepository(ctrl),
+		RateRepository:      mock.NewMockRateLimitRepository(ctrl),
 	}
 
 	logger := pmlogger.New()
 
-	c, err := New(mocks.UserRepository, mocks.RecordRepository, logger)
+	keyring := pmcrypto.NewKeyring()
+	require.NoError(t, keyring.Add(testServerKeyID, []byte(testServerKey)))
+	require.NoError(t, keyring.SetActive(testServerKeyID))
+
+	config := &Config{Keys: keyring, PasswordHash: testKDFParams, Mode: CryptoModeServer, KDF: testKDFParams, PreloginSecret: []byte(testPreloginSecret), RecoveryTTL: time.Hour, SessionTTL: time.Hour, RelyingParty: testRelyingParty}
+	c, err := New(config, mocks.UserRepository, mocks.RecordRepository, mocks.KeyRepository, mocks.RecoveryRepository, mocks.SessionRepository, mocks.TokenRepository, mocks.ServiceRepository, mocks.TwoFactorRepository, mocks.WebAuthnRepository, mocks.OIDCRepository, mocks.CertRepository, mocks.RateRepository, logger)
 	require.NoError(t, err)
 
 	tc.Run(t, c, mocks)
 }
 
+var testKDFParams = pmcrypto.KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+var testRelyingParty = &pmwebauthn.RelyingParty{ID: "localhost", Name: "Test", Origins: []string{"http://localhost:5000"}}
+
+const (
+	testServerKeyID    = "test"
+	testServerKey      = "12345678901234567890123456789012"
+	testPreloginSecret = "abcdefghijklmnopqrstuvwxyz123456"
+)
+
+// testOwner gives userID a current vault with a fresh wrapped data key and returns it along with the plain key
+func testOwner(t *testing.T, c *Controller, userID uuid.UUID) (*model.User, string) {
+	t.Helper()
+
+	user := &model.User{ID: userID, VaultVersion: model.LatestVaultVersion}
+	key, err := c.newDataKey(user)
+	require.NoError(t, err)
+
+	return user, key
+}
+
+// expectTestDataKey gives userID a fresh wrapped data key, expects a single lookup of the user and returns the plain key
+func expectTestDataKey(t *testing.T, c *Controller, mocks *controllerMocks, userID uuid.UUID) string {
+	t.Helper()
+
+	user, key := testOwner(t, c, userID)
+
+	mocks.UserRepository.EXPECT().
+		Get(userID).
+		Return(user, nil)
+
+	return key
+}
+
+// expectGetVault expects records with ids to be read along with owner, only the given records are found
+func expectGetVault(mocks *controllerMocks, owner *model.User, ids []uuid.UUID, records ...interface{}) {
+	vault := &model.Vault{}
+	for _, record := range records {
+		switch record := record.(type) {
+		case *model.CredentialRecord:
+			vault.SecureNotes = append(vault.SecureNotes, *record)
+		case *model.LoginRecord:
+			vault.Logins = append(vault.Logins, *record)
+		case *model.CardRecord:
+			vault.Cards = append(vault.Cards, *record)
+		case *model.IdentityRecord:
+			vault.Identities = append(vault.Identities, *record)
+		}
+	}
+
+	mocks.RecordRepository.EXPECT().
+		GetVault(owner.ID, ids).
+		Return(owner, vault, nil)
+}
+
+// expectGetVaultRecord expects record to be read along with owner by a single query
+func expectGetVaultRecord(mocks *controllerMocks, owner *model.User, id uuid.UUID, record interface{}) {
+	mocks.RecordRepository.EXPECT().
+		GetVaultRecord(owner.ID, id).
+		Return(owner, record, nil)
+}
+
+func TestController_AllRecords(t *testing.T) {
+	userID := uuid.New()
+	createdOn := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_decrypts_labels_and_sorts",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				owner, key := testOwner(t, c, userID)
+
+				sealed := model.LoginRecord{
+					CredentialRecord: model.CredentialRecord{ID: uuid.New(), Name: "Bank", CreatedOn: createdOn, CreatedBy: userID, Revision: 1},
+					Password:         pmpointer.String("Test Password"),
+					URL:              pmpointer.String("https://bank.example.com"),
+				}
+				require.NoError(t, c.encryptRecord(&sealed, key))
+				encryptedPassword := *sealed.Password
+
+				legacy := model.LoginRecord{
+					CredentialRecord: model.CredentialRecord{ID: uuid.New(), Name: "Abacus", CreatedOn: createdOn, CreatedBy: userID, Revision: 1},
+					URL:              pmpointer.String("https://abacus.example.com"),
+				}
+				c.markRecord(&legacy.CredentialRecord, key)
+
+				expectGetVault(mocks, owner, nil, &sealed, &legacy)
+
+				_, logins, _, _, err := c.AllRecords(userID)
+				require.NoError(t, err)
+				require.Len(t, logins, 2)
+
+				require.Equal(t, "Abacus", logins[0].Name)
+				require.Equal(t, "https://abacus.example.com", *logins[0].URL)
+				require.Equal(t, "Bank", logins[1].Name)
+				require.Equal(t, "https://bank.example.com", *logins[1].URL)
+				require.Equal(t, encryptedPassword, *logins[1].Password)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
+
 func TestController_GetRecord(t *testing.T) {
 	userID, err := uuid.NewUUID()
 	require.NoError(t, err)
@@ -54,61 +191,232 @@ func TestController_GetRecord(t *testing.T) {
 				id, err := uuid.NewUUID()
 				require.NoError(t, err)
 
-				notes := "Test Record Notes"
-				encryptedNotes, err := pmcrypto.Encrypt(notes, Salt)
-				require.NoError(t, err)
+				owner, key := testOwner(t, c, userID)
 
+				notes := "Test Record Notes"
 				record := &model.CredentialRecord{
 					ID:        id,
 					Name:      "Test Record Name",
-					Notes:     &encryptedNotes,
+					Notes:     pmpointer.String(notes),
 					CreatedOn: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 					UpdatedOn: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 					CreatedBy: userID,
 					UpdatedBy: userID,
+					Revision:  1,
 				}
+				require.NoError(t, c.encryptRecord(record, key))
+
+				expectGetVaultRecord(mocks, owner, id, record)
+
+				actual, err := c.GetRecord(id, userID)
+				require.NoError(t, err)
+				require.Equal(t, "Test Record Name", actual.(*model.CredentialRecord).Name)
+				require.Equal(t, notes, *actual.(*model.CredentialRecord).Notes)
+			},
+		},
+		{
+			Name: "error_integrity_unbound",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				id := uuid.New()
+				owner, key := testOwner(t, c, userID)
 
+				// fields written before binding decrypt without AAD, they must not be served anymore
+				encryptedNotes, err := pmcrypto.Encrypt("Test Record Notes", key)
+				require.NoError(t, err)
+
+				expectGetVaultRecord(mocks, owner, id, &model.CredentialRecord{ID: id, Name: "Test Record Name", Notes: &encryptedNotes, CreatedBy: userID})
+
+				_, err = c.GetRecord(id, userID)
+				require.ErrorIs(t, err, pmerror.ErrIntegrity)
+			},
+		},
+		{
+			Name: "error_integrity_rolled_back",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				owner, key := testOwner(t, c, userID)
+
+				record := model.NewCredentialRecord("Test Record Name", pmpointer.String("Old Notes"), userID)
+				require.NoError(t, c.encryptRecord(record, key))
+				old := *record
+
+				// the record is updated, then its row alone is restored from an older copy
+				updated := model.NewCredentialRecord("Test Record Name", pmpointer.String("New Notes"), userID)
+				updated.ID = record.ID
+				updated.Revision = record.Revision + 1
+				require.NoError(t, c.encryptRecord(updated, key))
+				old.Mark = updated.Mark
+
+				expectGetVaultRecord(mocks, owner, record.ID, &old)
+
+				_, err := c.GetRecord(record.ID, userID)
+				require.ErrorIs(t, err, pmerror.ErrIntegrity)
+			},
+		},
+		{
+			Name: "error_not_found",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				id := uuid.New()
+
+				// the repository found no record id at all
 				mocks.RecordRepository.EXPECT().
-					GetCredentialRecord(id).
-					Return(record, nil)
+					GetVaultRecord(userID, id).
+					Return(nil, nil, fmt.Errorf("get record: %w", pmerror.ErrNotFound))
+				mocks.ServiceRepository.EXPECT().
+					Get(userID).
+					Return(nil, pmerror.ErrNotFound)
+
+				_, err := c.GetRecord(id, userID)
+				require.ErrorIs(t, err, pmerror.ErrNotFound)
 
 				mocks.RecordRepository.EXPECT().
-					GetCredentialRecord(id).
-					Return(record, nil)
+					GetVaultRecord(userID, id).
+					Return(nil, nil, fmt.Errorf("get record: %w", pmerror.ErrNotFound))
 
+				_, err = c.UpdateRecord(id, []byte(`{"name": "Test Record Name"}`), userID)
+				require.ErrorIs(t, err, pmerror.ErrNotFound)
+			},
+		},
+		{
+			Name: "error_not_owner",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				id := uuid.New()
+
+				// record id exists, created by another user
 				mocks.RecordRepository.EXPECT().
-					GetLogin(id).
+					GetVaultRecord(userID, id).
+					Return(nil, nil, fmt.Errorf("get record: %w: user %s does not own record %s", pmerror.ErrForbidden, userID, id))
+				mocks.ServiceRepository.EXPECT().
+					Get(userID).
 					Return(nil, pmerror.ErrNotFound)
 
+				_, err := c.GetRecord(id, userID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
 				mocks.RecordRepository.EXPECT().
-					GetCard(id).
-					Return(nil, pmerror.ErrNotFound)
+					GetVaultRecord(userID, id).
+					Return(nil, nil, fmt.Errorf("get record: %w: user %s does not own record %s", pmerror.ErrForbidden, userID, id))
+
+				_, err = c.UpdateRecord(id, []byte(`{"name": "Test Record Name"}`), userID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_integrity_swapped",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				owner, key := testOwner(t, c, userID)
+
+				other := model.LoginRecord{
+					CredentialRecord: *model.NewCredentialRecord("Other Login", nil, userID),
+					Password:         pmpointer.String("Other Password"),
+				}
+				require.NoError(t, c.encryptRecord(&other, key))
+
+				// the password of another login copied into this one
+				login := model.LoginRecord{
+					CredentialRecord: *model.NewCredentialRecord("Test Login", nil, userID),
+					Username:         other.Password,
+				}
+
+				expectGetVaultRecord(mocks, owner, login.ID, &login)
+
+				_, err := c.GetRecord(login.ID, userID)
+				require.True(t, errors.Is(err, pmerror.ErrIntegrity))
+			},
+		},
+		{
+			Name: "error_integrity_replayed",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				owner, key := testOwner(t, c, userID)
+
+				login := model.LoginRecord{
+					CredentialRecord: *model.NewCredentialRecord("Test Login", nil, userID),
+					Password:         pmpointer.String("Old Password"),
+				}
+				require.NoError(t, c.encryptRecord(&login, key))
+
+				// the password of the first revision written back after an update
+				login.Touch(userID)
+
+				expectGetVaultRecord(mocks, owner, login.ID, &login)
+
+				_, err := c.GetRecord(login.ID, userID)
+				require.True(t, errors.Is(err, pmerror.ErrIntegrity))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
+
+func TestController_CreateRecord(t *testing.T) {
+	userID := uuid.New()
+
+	clientKey := "abcdefghijklmnopqrstuvwxyz123456"
+	clientNotes, err := pmcrypto.EncryptAEAD("Test Record Notes", clientKey, "client")
+	require.NoError(t, err)
+	clientName, err := pmcrypto.EncryptAEAD("Test Record Name", clientKey, "client")
+	require.NoError(t, err)
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_server_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				key := expectTestDataKey(t, c, mocks, userID)
 
 				mocks.RecordRepository.EXPECT().
-					GetIdentity(id).
-					Return(nil, pmerror.ErrNotFound)
+					CreateCredentialRecord(userID, gomock.Any(), gomock.Len(3)).
+					DoAndReturn(func(_ uuid.UUID, record *model.CredentialRecord, _ []model.IndexEntry) (*model.CredentialRecord, error) {
+						return record, nil
+					})
 
-				actual, err := c.GetRecord(id, userID)
+				actual, err := c.CreateRecord(model.SecureNoteRecordType, []byte(`{"name": "Test Record Name", "notes": "Test Record Notes"}`), userID)
 				require.NoError(t, err)
 
-				expected := record
-				expected.Notes = &notes
+				record, ok := actual.(*model.CredentialRecord)
+				require.True(t, ok)
 
-				require.Equal(t, record, actual)
+				require.Equal(t, 1, record.Revision)
+				require.Equal(t, model.LatestSeal, record.Seal)
+				notes, err := pmcrypto.DecryptWithAAD(*record.Notes, key, fieldAAD(record, "credential_record.notes"))
+				require.NoError(t, err)
+				require.Equal(t, "Test Record Notes", notes)
+				name, err := pmcrypto.DecryptWithAAD(record.Name, key, fieldAAD(record, "credential_record.name"))
+				require.NoError(t, err)
+				require.Equal(t, "Test Record Name", name)
 			},
 		},
 		{
-			Name: "error_not_found",
+			Name: "success_client_mode_stores_as_is",
 			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
-				id, err := uuid.NewUUID()
-				require.NoError(t, err)
+				c.config.Mode = CryptoModeClient
 
 				mocks.RecordRepository.EXPECT().
-					GetCredentialRecord(id).
-					Return(nil, pmerror.ErrInternal)
+					CreateCredentialRecord(userID, gomock.Any(), gomock.Nil()).
+					DoAndReturn(func(_ uuid.UUID, record *model.CredentialRecord, _ []model.IndexEntry) (*model.CredentialRecord, error) {
+						return record, nil
+					})
 
-				_, err = c.GetRecord(id, userID)
-				require.True(t, errors.Is(err, pmerror.ErrInternal))
+				actual, err := c.CreateRecord(model.SecureNoteRecordType, []byte(fmt.Sprintf(`{"name": %q, "notes": %q}`, clientName, clientNotes)), userID)
+				require.NoError(t, err)
+
+				record, ok := actual.(*model.CredentialRecord)
+				require.True(t, ok)
+				require.Equal(t, clientName, record.Name)
+				require.Equal(t, clientNotes, *record.Notes)
+			},
+		},
+		{
+			Name: "error_client_mode_plaintext",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+
+				_, err := c.CreateRecord(model.SecureNoteRecordType, []byte(`{"name": "Test Record Name", "notes": "Test Record Notes"}`), userID)
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
 			},
 		},
 	}
@@ -0,0 +1,503 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmshamir"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+// SetupRecovery splits a new recovery key of userID among trustees, any threshold of them can later let the user
+// reset a lost master password. The vault key is wrapped with the recovery key, each share is held for its trustee
+// until collected with CollectRecoveryShares. A new setup replaces the previous one along with its uncollected shares.
+func (c *Controller) SetupRecovery(userID uuid.UUID, form *model.RecoverySetupForm) (*model.RecoveryKit, error) {
+	if err := form.Validate(userID); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	for _, trustee := range form.Trustees {
+		if _, err := c.userRepo.Get(trustee); errors.Is(err, pmerror.ErrNotFound) {
+			return nil, fmt.Errorf("%w: trustee %s does not exist", pmerror.ErrInvalidInput, trustee)
+		} else if err != nil {
+			return nil, fmt.Errorf("get trustee: %w", err)
+		}
+	}
+
+	vaultKey, err := c.recoverableKey(userID, form.VaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, pmcrypto.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("read random: %w", err)
+	}
+
+	split, err := pmshamir.Split(key, len(form.Trustees), form.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("%w: split: %s", pmerror.ErrInvalidInput, err.Error())
+	}
+
+	wrapped, err := pmcrypto.EncryptWithAAD(vaultKey, string(key), recoveryKeyID(key), recoveryAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("wrap vault key: %w", err)
+	}
+
+	setup := &model.RecoverySetup{
+		UserID:     userID,
+		Threshold:  form.Threshold,
+		Trustees:   form.Trustees,
+		KeyID:      recoveryKeyID(key),
+		WrappedKey: wrapped,
+		CreatedOn:  pmtime.TruncateToMillisecond(time.Now().UTC()),
+	}
+
+	shares := make([]model.RecoveryShare, len(split))
+	for i, share := range split {
+		encrypted, err := c.keys.Encrypt(base64.StdEncoding.EncodeToString(share))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt share: %w", err)
+		}
+
+		shares[i] = model.RecoveryShare{UserID: userID, TrusteeID: form.Trustees[i], Share: encrypted}
+	}
+
+	if err := c.recoveryRepo.SaveSetup(setup, shares); err != nil {
+		return nil, fmt.Errorf("save setup: %w", err)
+	}
+
+	c.log.Infof("Set up recovery of user %s with %d of %d trustees", userID, setup.Threshold, len(setup.Trustees))
+
+	return &model.RecoveryKit{KeyID: setup.KeyID, Threshold: setup.Threshold, Trustees: setup.Trustees}, nil
+}
+
+// recoverableKey returns the vault key of userID that recovery restores. In server mode it is the data key,
+// in client mode the key derived by the client, which passes through the server without being stored.
+func (c *Controller) recoverableKey(userID uuid.UUID, clientKey *string) (string, error) {
+	if c.config.Mode == CryptoModeServer {
+		if clientKey != nil {
+			return "", fmt.Errorf("%w: vault keys are held by the server", pmerror.ErrInvalidInput)
+		}
+
+		return c.vaultKey(userID)
+	}
+
+	if clientKey == nil {
+		return "", fmt.Errorf("%w: VaultKey is empty", pmerror.ErrInvalidInput)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(*clientKey)
+	if err != nil || len(key) != pmcrypto.KeySize {
+		return "", fmt.Errorf("%w: VaultKey must be %d base64 encoded bytes", pmerror.ErrInvalidInput, pmcrypto.KeySize)
+	}
+
+	return string(key), nil
+}
+
+// CollectRecoveryShares hands trusteeID the shares it holds for other users, each share is handed out once
+func (c *Controller) CollectRecoveryShares(trusteeID uuid.UUID) ([]model.RecoveryShare, error) {
+	shares, err := c.recoveryRepo.GetPendingShares(trusteeID)
+	if err != nil {
+		return nil, fmt.Errorf("get pending shares: %w", err)
+	}
+
+	userIDs := make([]uuid.UUID, len(shares))
+	for i := range shares {
+		if shares[i].Share, err = c.keys.Decrypt(shares[i].Share); err != nil {
+			return nil, fmt.Errorf("decrypt share: %w", err)
+		}
+
+		userIDs[i] = shares[i].UserID
+	}
+
+	if err := c.recoveryRepo.DeletePendingShares(trusteeID, userIDs); err != nil {
+		return nil, fmt.Errorf("delete pending shares: %w", err)
+	}
+
+	if len(shares) > 0 {
+		c.log.Infof("Handed out %d recovery shares to trustee %s", len(shares), trusteeID)
+	}
+
+	return shares, nil
+}
+
+// StartRecovery opens a ceremony for the user called name and returns it with the secret required to complete it.
+// Nobody has to sign in to start one, so the ceremony cannot be completed before the recovery delay is over,
+// meanwhile its owner finds it with RecoveryCeremonies and can cancel it.
+func (c *Controller) StartRecovery(name string) (*model.RecoveryCeremony, string, error) {
+	user, err := c.userRepo.GetByName(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("get user: %w", err)
+	}
+
+	setup, err := c.recoveryRepo.GetSetup(user.ID)
+	if err != nil {
+		return nil, "", fmt.Errorf("get setup: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("read random: %w", err)
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	ceremony := &model.RecoveryCeremony{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		Status:     model.RecoveryCeremonyOpen,
+		SecretHash: hashRecoverySecret(encodedSecret),
+		Threshold:  setup.Threshold,
+		CreatedOn:  now,
+		ReadyOn:    now.Add(c.config.RecoveryDelay),
+		ExpiresOn:  now.Add(c.config.RecoveryDelay + c.config.RecoveryTTL),
+	}
+
+	if err := c.recoveryRepo.CreateCeremony(ceremony); err != nil {
+		return nil, "", fmt.Errorf("create ceremony: %w", err)
+	}
+
+	if err := c.recordRecoveryEvent(ceremony.ID, model.RecoveryEventStarted, nil, ""); err != nil {
+		return nil, "", err
+	}
+
+	c.log.Warnf("Started recovery ceremony %s of user %s, it can be cancelled by the user until %s", ceremony.ID, user.ID, ceremony.ReadyOn.Format(time.RFC3339))
+
+	return ceremony, encodedSecret, nil
+}
+
+// RecoveryCeremonies lists the open ceremonies recovering userID, so that the user notices them
+func (c *Controller) RecoveryCeremonies(userID uuid.UUID) ([]model.RecoveryCeremony, error) {
+	ceremonies, err := c.recoveryRepo.GetCeremonies(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get ceremonies: %w", err)
+	}
+
+	open := make([]model.RecoveryCeremony, 0, len(ceremonies))
+	for _, ceremony := range ceremonies {
+		if err := c.expireRecoveryCeremony(&ceremony); err != nil {
+			return nil, err
+		}
+
+		if ceremony.Status == model.RecoveryCeremonyOpen {
+			open = append(open, ceremony)
+		}
+	}
+
+	return open, nil
+}
+
+// CancelRecovery closes an open ceremony recovering userID, a user who still has access stops a recovery started by someone else
+func (c *Controller) CancelRecovery(id uuid.UUID, userID uuid.UUID) (*model.RecoveryCeremony, error) {
+	ceremony, err := c.recoveryRepo.GetCeremony(id)
+	if err != nil {
+		return nil, fmt.Errorf("get ceremony: %w", err)
+	}
+
+	if ceremony.UserID != userID {
+		return nil, fmt.Errorf("%w: ceremony %s does not recover user %s", pmerror.ErrForbidden, id, userID)
+	}
+
+	if _, _, err := c.openRecoveryCeremony(id); err != nil {
+		return nil, err
+	}
+
+	if err := c.recoveryRepo.CloseCeremony(id, model.RecoveryCeremonyCancelled); err != nil {
+		return nil, fmt.Errorf("close ceremony: %w", err)
+	}
+
+	if err := c.recordRecoveryEvent(id, model.RecoveryEventCancelled, &userID, ""); err != nil {
+		return nil, err
+	}
+
+	c.log.Infof("User %s cancelled recovery ceremony %s", userID, id)
+
+	ceremony.Status = model.RecoveryCeremonyCancelled
+
+	return ceremony, nil
+}
+
+// GetRecoveryCeremony returns a ceremony with its events to one of the trustees
+func (c *Controller) GetRecoveryCeremony(id uuid.UUID, userID uuid.UUID) (*model.RecoveryCeremony, error) {
+	ceremony, err := c.recoveryRepo.GetCeremony(id)
+	if err != nil {
+		return nil, fmt.Errorf("get ceremony: %w", err)
+	}
+
+	setup, err := c.recoveryRepo.GetSetup(ceremony.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("get setup: %w", err)
+	}
+
+	if !setup.IsTrustee(userID) {
+		return nil, fmt.Errorf("%w: user %s is not a trustee of ceremony %s", pmerror.ErrForbidden, userID, id)
+	}
+
+	if err := c.expireRecoveryCeremony(ceremony); err != nil {
+		return nil, err
+	}
+
+	shares, err := c.recoveryRepo.GetShares(id)
+	if err != nil {
+		return nil, fmt.Errorf("get shares: %w", err)
+	}
+
+	ceremony.Events, err = c.recoveryRepo.GetEvents(id)
+	if err != nil {
+		return nil, fmt.Errorf("get events: %w", err)
+	}
+
+	ceremony.Submitted, ceremony.Threshold = len(shares), setup.Threshold
+
+	return ceremony, nil
+}
+
+// SubmitRecoveryShare adds the share of trustee userID to an open ceremony
+func (c *Controller) SubmitRecoveryShare(id uuid.UUID, userID uuid.UUID, form *model.RecoveryShareForm) (*model.RecoveryCeremony, error) {
+	_, setup, err := c.openRecoveryCeremony(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !setup.IsTrustee(userID) {
+		if err := c.recordRecoveryEvent(id, model.RecoveryEventRejected, &userID, "not a trustee"); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: user %s is not a trustee of ceremony %s", pmerror.ErrForbidden, userID, id)
+	}
+
+	if form.Share == nil {
+		return nil, fmt.Errorf("%w: Share is empty", pmerror.ErrInvalidInput)
+	}
+
+	share, err := base64.StdEncoding.DecodeString(*form.Share)
+	if err != nil || len(share) != pmcrypto.KeySize+1 {
+		return nil, fmt.Errorf("%w: malformed share", pmerror.ErrInvalidInput)
+	}
+
+	encrypted, err := c.keys.Encrypt(*form.Share)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt share: %w", err)
+	}
+
+	if err := c.recoveryRepo.AddShare(id, userID, encrypted); err != nil {
+		return nil, fmt.Errorf("add share: %w", err)
+	}
+
+	if err := c.recordRecoveryEvent(id, model.RecoveryEventShareSubmitted, &userID, ""); err != nil {
+		return nil, err
+	}
+
+	return c.GetRecoveryCeremony(id, userID)
+}
+
+// CompleteRecovery rebuilds the recovery key once enough trustees submitted their shares and the recovery delay is over,
+// unwraps the vault key with it, replaces the master password of the recovered user and signs the user out everywhere.
+// In client mode the vault key is returned, the client re-encrypts the vault with a key derived from the new password.
+func (c *Controller) CompleteRecovery(id uuid.UUID, form *model.RecoveryCompleteForm) (string, error) {
+	if err := form.Validate(); err != nil {
+		return "", fmt.Errorf("validate: %w", err)
+	}
+
+	ceremony, setup, err := c.openRecoveryCeremony(id)
+	if err != nil {
+		return "", err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashRecoverySecret(*form.Secret)), []byte(ceremony.SecretHash)) != 1 {
+		if err := c.recordRecoveryEvent(id, model.RecoveryEventRejected, nil, "wrong secret"); err != nil {
+			return "", err
+		}
+
+		return "", fmt.Errorf("%w: wrong ceremony secret", pmerror.ErrUnauthorized)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if now.Before(ceremony.ReadyOn) {
+		return "", fmt.Errorf("%w: ceremony %s can be completed from %s", pmerror.ErrForbidden, id, ceremony.ReadyOn.Format(time.RFC3339))
+	}
+
+	encryptedShares, err := c.recoveryRepo.GetShares(id)
+	if err != nil {
+		return "", fmt.Errorf("get shares: %w", err)
+	}
+
+	var shares [][]byte
+	for trustee, encrypted := range encryptedShares {
+		// trustees removed by a newer setup do not count
+		if !setup.IsTrustee(trustee) {
+			continue
+		}
+
+		decrypted, err := c.keys.Decrypt(encrypted)
+		if err != nil {
+			return "", fmt.Errorf("decrypt share: %w", err)
+		}
+
+		share, err := base64.StdEncoding.DecodeString(decrypted)
+		if err != nil {
+			return "", fmt.Errorf("%w: decode share: %s", pmerror.ErrInternal, err.Error())
+		}
+
+		shares = append(shares, share)
+	}
+
+	if len(shares) < setup.Threshold {
+		return "", fmt.Errorf("%w: %d of %d shares submitted", pmerror.ErrForbidden, len(shares), setup.Threshold)
+	}
+
+	key, err := pmshamir.Combine(shares)
+	if err != nil || recoveryKeyID(key) != setup.KeyID {
+		if err := c.closeRecoveryCeremony(id, model.RecoveryCeremonyFailed, model.RecoveryEventFailed, "shares do not rebuild the recovery key"); err != nil {
+			return "", err
+		}
+
+		return "", fmt.Errorf("%w: shares do not rebuild the recovery key", pmerror.ErrForbidden)
+	}
+
+	hash, err := pmcrypto.HashPassword(*form.Password, c.config.PasswordHash)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+
+	update := &model.User{ID: ceremony.UserID, Password: hash, UpdatedOn: now}
+	vaultKey, err := c.recoverVaultKey(setup, key, update)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := c.userRepo.Update(update); err != nil {
+		return "", fmt.Errorf("update password: %w", err)
+	}
+
+	if err := c.closeRecoveryCeremony(id, model.RecoveryCeremonyCompleted, model.RecoveryEventCompleted, ""); err != nil {
+		return "", err
+	}
+
+	// whoever lost the password may have lost a device too
+	if err := c.signOutEverywhere(ceremony.UserID, now); err != nil {
+		return "", fmt.Errorf("sign out: %w", err)
+	}
+
+	c.log.Infof("Completed recovery ceremony %s of user %s", id, ceremony.UserID)
+
+	if c.config.Mode == CryptoModeClient && vaultKey != "" {
+		return base64.StdEncoding.EncodeToString([]byte(vaultKey)), nil
+	}
+
+	return "", nil
+}
+
+// recoverVaultKey unwraps the vault key of setup with the rebuilt recovery key. In server mode it becomes the data key
+// of update again, wrapped with the active server key.
+func (c *Controller) recoverVaultKey(setup *model.RecoverySetup, recoveryKey []byte, update *model.User) (string, error) {
+	if setup.WrappedKey == "" {
+		c.log.Warnf("Recovery setup of user %s predates wrapped vault keys, only the password is reset", setup.UserID)
+		return "", nil
+	}
+
+	vaultKey, err := pmcrypto.DecryptWithAAD(setup.WrappedKey, string(recoveryKey), recoveryAAD(setup.UserID))
+	if err != nil {
+		return "", fmt.Errorf("%w: unwrap vault key: %s", pmerror.ErrIntegrity, err.Error())
+	}
+
+	if c.config.Mode == CryptoModeServer {
+		if err := c.wrapDataKey(update, vaultKey); err != nil {
+			return "", err
+		}
+	}
+
+	return vaultKey, nil
+}
+
+// openRecoveryCeremony returns a ceremony that still accepts shares along with the setup it recovers
+func (c *Controller) openRecoveryCeremony(id uuid.UUID) (*model.RecoveryCeremony, *model.RecoverySetup, error) {
+	ceremony, err := c.recoveryRepo.GetCeremony(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get ceremony: %w", err)
+	}
+
+	if err := c.expireRecoveryCeremony(ceremony); err != nil {
+		return nil, nil, err
+	}
+
+	if ceremony.Status != model.RecoveryCeremonyOpen {
+		return nil, nil, fmt.Errorf("%w: ceremony %s is %s", pmerror.ErrForbidden, id, ceremony.Status)
+	}
+
+	setup, err := c.recoveryRepo.GetSetup(ceremony.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get setup: %w", err)
+	}
+
+	return ceremony, setup, nil
+}
+
+// expireRecoveryCeremony closes an open ceremony past its deadline
+func (c *Controller) expireRecoveryCeremony(ceremony *model.RecoveryCeremony) error {
+	if ceremony.Status != model.RecoveryCeremonyOpen || time.Now().Before(ceremony.ExpiresOn) {
+		return nil
+	}
+
+	if err := c.closeRecoveryCeremony(ceremony.ID, model.RecoveryCeremonyExpired, model.RecoveryEventExpired, ""); err != nil {
+		return err
+	}
+
+	ceremony.Status = model.RecoveryCeremonyExpired
+
+	return nil
+}
+
+func (c *Controller) closeRecoveryCeremony(id uuid.UUID, status model.RecoveryCeremonyStatus, kind model.RecoveryEventKind, detail string) error {
+	if err := c.recoveryRepo.CloseCeremony(id, status); err != nil {
+		return fmt.Errorf("close ceremony: %w", err)
+	}
+
+	return c.recordRecoveryEvent(id, kind, nil, detail)
+}
+
+func (c *Controller) recordRecoveryEvent(ceremonyID uuid.UUID, kind model.RecoveryEventKind, actorID *uuid.UUID, detail string) error {
+	event := &model.RecoveryEvent{
+		ID:         uuid.New(),
+		CeremonyID: ceremonyID,
+		Kind:       kind,
+		ActorID:    actorID,
+		Detail:     detail,
+		CreatedOn:  pmtime.TruncateToMillisecond(time.Now().UTC()),
+	}
+
+	if err := c.recoveryRepo.AddEvent(event); err != nil {
+		return fmt.Errorf("record %s event: %w", kind, err)
+	}
+
+	return nil
+}
+
+// recoveryKeyID fingerprints a recovery key, the key is random so its hash reveals nothing
+func recoveryKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// recoveryAAD binds a wrapped vault key to its user
+func recoveryAAD(userID uuid.UUID) []byte {
+	return []byte("recovery|" + userID.String())
+}
+
+func hashRecoverySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
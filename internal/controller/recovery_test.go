@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// recoveryStore backs the recovery repository mock with memory
+type recoveryStore struct {
+	setups     map[uuid.UUID]*model.RecoverySetup
+	pending    map[uuid.UUID][]model.RecoveryShare
+	ceremonies map[uuid.UUID]*model.RecoveryCeremony
+	shares     map[uuid.UUID]map[uuid.UUID]string
+	events     []model.RecoveryEvent
+}
+
+func expectRecoveryStore(mocks *controllerMocks) *recoveryStore {
+	s := &recoveryStore{
+		setups:     make(map[uuid.UUID]*model.RecoverySetup),
+		pending:    make(map[uuid.UUID][]model.RecoveryShare),
+		ceremonies: make(map[uuid.UUID]*model.RecoveryCeremony),
+		shares:     make(map[uuid.UUID]map[uuid.UUID]string),
+	}
+
+	r := mocks.RecoveryRepository.EXPECT()
+	r.SaveSetup(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(setup *model.RecoverySetup, shares []model.RecoveryShare) error {
+		s.setups[setup.UserID] = setup
+		for _, share := range shares {
+			s.pending[share.TrusteeID] = append(s.pending[share.TrusteeID], share)
+		}
+		return nil
+	})
+	r.GetPendingShares(gomock.Any()).AnyTimes().DoAndReturn(func(trusteeID uuid.UUID) ([]model.RecoveryShare, error) {
+		return append([]model.RecoveryShare(nil), s.pending[trusteeID]...), nil
+	})
+	r.DeletePendingShares(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(trusteeID uuid.UUID, userIDs []uuid.UUID) error {
+		delete(s.pending, trusteeID)
+		return nil
+	})
+	r.GetCeremonies(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) ([]model.RecoveryCeremony, error) {
+		var result []model.RecoveryCeremony
+		for _, ceremony := range s.ceremonies {
+			if ceremony.UserID == userID && ceremony.Status == model.RecoveryCeremonyOpen {
+				result = append(result, *ceremony)
+			}
+		}
+		return result, nil
+	})
+	r.GetSetup(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) (*model.RecoverySetup, error) {
+		if setup, ok := s.setups[userID]; ok {
+			return setup, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.CreateCeremony(gomock.Any()).AnyTimes().DoAndReturn(func(ceremony *model.RecoveryCeremony) error {
+		stored := *ceremony
+		s.ceremonies[ceremony.ID] = &stored
+		s.shares[ceremony.ID] = make(map[uuid.UUID]string)
+		return nil
+	})
+	r.GetCeremony(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.RecoveryCeremony, error) {
+		if ceremony, ok := s.ceremonies[id]; ok {
+			result := *ceremony
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.CloseCeremony(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID, status model.RecoveryCeremonyStatus) error {
+		s.ceremonies[id].Status = status
+		delete(s.shares, id)
+		return nil
+	})
+	r.AddShare(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id, trustee uuid.UUID, share string) error {
+		s.shares[id][trustee] = share
+		return nil
+	})
+	r.GetShares(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (map[uuid.UUID]string, error) {
+		return s.shares[id], nil
+	})
+	r.AddEvent(gomock.Any()).AnyTimes().DoAndReturn(func(event *model.RecoveryEvent) error {
+		s.events = append(s.events, *event)
+		return nil
+	})
+	r.GetEvents(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) ([]model.RecoveryEvent, error) {
+		return s.events, nil
+	})
+
+	return s
+}
+
+func (s *recoveryStore) eventKinds() []model.RecoveryEventKind {
+	kinds := make([]model.RecoveryEventKind, len(s.events))
+	for i, event := range s.events {
+		kinds[i] = event.Kind
+	}
+
+	return kinds
+}
+
+// recoveryFixture is a 2 of 3 recovery setup whose shares were collected by the trustees, with a ceremony started
+type recoveryFixture struct {
+	store    *recoveryStore
+	sessions *sessionStore
+	owner    *model.User
+	key      string
+	shares   []model.RecoveryShare
+	ceremony *model.RecoveryCeremony
+	secret   string
+}
+
+func TestController_Recovery(t *testing.T) {
+	trustees := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	password := "New Password"
+
+	setUp := func(t *testing.T, c *Controller, mocks *controllerMocks, form *model.RecoverySetupForm) *recoveryFixture {
+		f := &recoveryFixture{store: expectRecoveryStore(mocks)}
+
+		f.owner = &model.User{ID: uuid.New(), Name: "Test Owner", VaultVersion: model.LatestVaultVersion}
+		if c.config.Mode == CryptoModeServer {
+			var err error
+			f.key, err = c.newDataKey(f.owner)
+			require.NoError(t, err)
+		}
+
+		mocks.UserRepository.EXPECT().
+			Get(f.owner.ID).
+			AnyTimes().
+			Return(f.owner, nil)
+		f.sessions = expectSessionStore(mocks)
+
+		mocks.UserRepository.EXPECT().
+			GetByName(f.owner.Name).
+			Return(f.owner, nil)
+
+		form.Trustees, form.Threshold = trustees, 2
+		kit, err := c.SetupRecovery(f.owner.ID, form)
+		require.NoError(t, err)
+		require.Equal(t, trustees, kit.Trustees)
+
+		for _, trustee := range trustees {
+			shares, err := c.CollectRecoveryShares(trustee)
+			require.NoError(t, err)
+			require.Len(t, shares, 1)
+			require.Equal(t, f.owner.ID, shares[0].UserID)
+			f.shares = append(f.shares, shares[0])
+
+			// shares are handed out once
+			shares, err = c.CollectRecoveryShares(trustee)
+			require.NoError(t, err)
+			require.Empty(t, shares)
+		}
+
+		f.ceremony, f.secret, err = c.StartRecovery(f.owner.Name)
+		require.NoError(t, err)
+		require.Equal(t, model.RecoveryCeremonyOpen, f.ceremony.Status)
+
+		return f
+	}
+
+	submit := func(t *testing.T, c *Controller, f *recoveryFixture, shares []model.RecoveryShare) {
+		for _, share := range shares {
+			_, err := c.SubmitRecoveryShare(f.ceremony.ID, share.TrusteeID, &model.RecoveryShareForm{Share: &share.Share})
+			require.NoError(t, err)
+		}
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+				expectAccessTokenStore(mocks)
+
+				session, _, err := c.CreateSession(f.owner.ID, "Test Agent")
+				require.NoError(t, err)
+
+				var updated *model.User
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						updated = u
+						return u, nil
+					})
+
+				submit(t, c, f, f.shares[1:])
+
+				vaultKey, err := c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &f.secret, Password: &password})
+				require.NoError(t, err)
+				require.Empty(t, vaultKey)
+
+				require.Equal(t, f.owner.ID, updated.ID)
+				ok, err := pmcrypto.VerifyPassword(password, updated.Password)
+				require.NoError(t, err)
+				require.True(t, ok)
+
+				key, err := c.unwrapDataKey(updated)
+				require.NoError(t, err)
+				require.Equal(t, f.key, key)
+
+				require.NotNil(t, f.sessions.sessions[session.ID].RevokedOn)
+
+				require.Equal(t, model.RecoveryCeremonyCompleted, f.store.ceremonies[f.ceremony.ID].Status)
+				require.Equal(t, []model.RecoveryEventKind{
+					model.RecoveryEventStarted,
+					model.RecoveryEventShareSubmitted,
+					model.RecoveryEventShareSubmitted,
+					model.RecoveryEventCompleted,
+				}, f.store.eventKinds())
+			},
+		},
+		{
+			Name: "success_client_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+				clientKey := base64.StdEncoding.EncodeToString([]byte("abcdefghijklmnopqrstuvwxyz123456"))
+
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{VaultKey: &clientKey})
+				expectAccessTokenStore(mocks)
+
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						require.Empty(t, u.DataKey)
+						return u, nil
+					})
+
+				submit(t, c, f, f.shares[:2])
+
+				vaultKey, err := c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &f.secret, Password: &password})
+				require.NoError(t, err)
+				require.Equal(t, clientKey, vaultKey)
+			},
+		},
+		{
+			Name: "success_cancel",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+
+				ceremonies, err := c.RecoveryCeremonies(f.owner.ID)
+				require.NoError(t, err)
+				require.Len(t, ceremonies, 1)
+				require.Equal(t, f.ceremony.ID, ceremonies[0].ID)
+
+				_, err = c.CancelRecovery(f.ceremony.ID, trustees[0])
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+
+				ceremony, err := c.CancelRecovery(f.ceremony.ID, f.owner.ID)
+				require.NoError(t, err)
+				require.Equal(t, model.RecoveryCeremonyCancelled, ceremony.Status)
+				require.Equal(t, model.RecoveryEventCancelled, f.store.events[len(f.store.events)-1].Kind)
+
+				_, err = c.SubmitRecoveryShare(f.ceremony.ID, f.shares[0].TrusteeID, &model.RecoveryShareForm{Share: &f.shares[0].Share})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+
+				ceremonies, err = c.RecoveryCeremonies(f.owner.ID)
+				require.NoError(t, err)
+				require.Empty(t, ceremonies)
+			},
+		},
+		{
+			Name: "error_not_ready",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.RecoveryDelay = time.Hour
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+				require.True(t, f.ceremony.ReadyOn.After(time.Now()))
+
+				submit(t, c, f, f.shares[:2])
+
+				_, err := c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &f.secret, Password: &password})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+				require.Equal(t, model.RecoveryCeremonyOpen, f.store.ceremonies[f.ceremony.ID].Status)
+			},
+		},
+		{
+			Name: "error_client_mode_without_key",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+				expectSessionStore(mocks)
+
+				_, err := c.SetupRecovery(uuid.New(), &model.RecoverySetupForm{Trustees: trustees, Threshold: 2})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "error_below_threshold",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+
+				submit(t, c, f, f.shares[:1])
+
+				_, err := c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &f.secret, Password: &password})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+		{
+			Name: "error_wrong_share",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+
+				raw, err := base64.StdEncoding.DecodeString(f.shares[1].Share)
+				require.NoError(t, err)
+				raw[0] ^= 1
+				wrong := f.shares[1]
+				wrong.Share = base64.StdEncoding.EncodeToString(raw)
+
+				submit(t, c, f, []model.RecoveryShare{f.shares[0], wrong})
+
+				_, err = c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &f.secret, Password: &password})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+				require.Equal(t, model.RecoveryCeremonyFailed, f.store.ceremonies[f.ceremony.ID].Status)
+			},
+		},
+		{
+			Name: "error_wrong_secret",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+
+				wrong := "wrong"
+				_, err := c.CompleteRecovery(f.ceremony.ID, &model.RecoveryCompleteForm{Secret: &wrong, Password: &password})
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+				require.Equal(t, model.RecoveryEventRejected, f.store.events[len(f.store.events)-1].Kind)
+			},
+		},
+		{
+			Name: "error_not_trustee",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+
+				_, err := c.SubmitRecoveryShare(f.ceremony.ID, f.owner.ID, &model.RecoveryShareForm{Share: &f.shares[0].Share})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+
+				_, err = c.GetRecoveryCeremony(f.ceremony.ID, f.owner.ID)
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+		{
+			Name: "error_expired",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				f := setUp(t, c, mocks, &model.RecoverySetupForm{})
+				f.store.ceremonies[f.ceremony.ID].ExpiresOn = time.Now().Add(-time.Minute)
+
+				_, err := c.SubmitRecoveryShare(f.ceremony.ID, f.shares[0].TrusteeID, &model.RecoveryShareForm{Share: &f.shares[0].Share})
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+				require.Equal(t, model.RecoveryCeremonyExpired, f.store.ceremonies[f.ceremony.ID].Status)
+				require.Equal(t, model.RecoveryEventExpired, f.store.events[len(f.store.events)-1].Kind)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+func (c *Controller) AllServiceAccounts(userID uuid.UUID) ([]model.ServiceAccount, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	accounts, err := c.serviceRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("get service accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// GetServiceAccount returns service account id with its grants and the tokens that are not revoked
+func (c *Controller) GetServiceAccount(id uuid.UUID, userID uuid.UUID) (*model.ServiceAccount, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	account, err := c.serviceRepo.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get service account: %w", err)
+	}
+
+	account.Tokens, err = c.tokenRepo.GetAll(id)
+	if err != nil {
+		return nil, fmt.Errorf("get access tokens: %w", err)
+	}
+
+	return account, nil
+}
+
+func (c *Controller) CreateServiceAccount(form *model.ServiceAccountForm, userID uuid.UUID) (*model.ServiceAccount, error) {
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	account := &model.ServiceAccount{
+		ID:        uuid.New(),
+		Name:      *form.Name,
+		CreatedBy: &userID,
+		CreatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
+	}
+
+	if err := c.serviceRepo.Create(account); err != nil {
+		return nil, fmt.Errorf("create service account: %w", err)
+	}
+
+	c.log.Infof("User %s created service account %s", userID, account.ID)
+
+	return account, nil
+}
+
+// DeleteServiceAccount deletes service account id along with its grants and tokens
+func (c *Controller) DeleteServiceAccount(id uuid.UUID, userID uuid.UUID) (*model.ServiceAccount, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	account, err := c.serviceRepo.Delete(id)
+	if err != nil {
+		return nil, fmt.Errorf("delete service account: %w", err)
+	}
+
+	c.log.Infof("User %s deleted service account %s", userID, id)
+
+	return account, nil
+}
+
+// GrantRecords lets service account id read records of the admin userID.
+// Admins can only grant their own records, a grant must not become a way into vaults of other users.
+func (c *Controller) GrantRecords(id uuid.UUID, form *model.RecordGrantForm, userID uuid.UUID) (*model.ServiceAccount, error) {
+	if c.config.Mode == CryptoModeClient {
+		return nil, fmt.Errorf("%w: records encrypted by clients can not be granted", pmerror.ErrInvalidInput)
+	}
+
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	if _, err := c.serviceRepo.Get(id); err != nil {
+		return nil, fmt.Errorf("get service account: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	grants := make([]model.RecordGrant, 0, len(form.RecordIDs))
+	seen := make(map[uuid.UUID]bool, len(form.RecordIDs))
+	for _, recordID := range form.RecordIDs {
+		if seen[recordID] {
+			continue
+		}
+		seen[recordID] = true
+
+		// the repository reports records of other users as pmerror.ErrForbidden
+		if _, err := c.recordRepo.GetRecord(userID, recordID); err != nil {
+			return nil, fmt.Errorf("get record %s: %w", recordID, err)
+		}
+
+		grants = append(grants, model.RecordGrant{
+			ServiceAccountID: id,
+			RecordID:         recordID,
+			OwnerID:          userID,
+			GrantedBy:        &userID,
+			CreatedOn:        now,
+		})
+	}
+
+	if err := c.serviceRepo.AddGrants(grants); err != nil {
+		return nil, fmt.Errorf("add grants: %w", err)
+	}
+
+	c.log.Infof("User %s granted %d records to service account %s", userID, len(grants), id)
+
+	return c.GetServiceAccount(id, userID)
+}
+
+func (c *Controller) RevokeGrant(id, recordID uuid.UUID, userID uuid.UUID) (*model.RecordGrant, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	grant, err := c.serviceRepo.RemoveGrant(id, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("remove grant: %w", err)
+	}
+
+	c.log.Infof("User %s revoked record %s from service account %s", userID, recordID, id)
+
+	return grant, nil
+}
+
+// CreateServiceAccountToken issues a token of service account id, the token itself is returned once.
+// Service accounts only read records, so model.ScopeRecordsRead is the only scope they get.
+func (c *Controller) CreateServiceAccountToken(id uuid.UUID, form *model.AccessTokenForm, userID uuid.UUID) (*model.AccessToken, string, error) {
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if err := form.Validate(now); err != nil {
+		return nil, "", fmt.Errorf("validate: %w", err)
+	}
+
+	for _, scope := range form.Scopes {
+		if scope != model.ScopeRecordsRead {
+			return nil, "", fmt.Errorf("%w: service accounts can not have scope %s", pmerror.ErrInvalidInput, scope)
+		}
+	}
+
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, "", fmt.Errorf("authorize: %w", err)
+	}
+
+	if _, err := c.serviceRepo.Get(id); err != nil {
+		return nil, "", fmt.Errorf("get service account: %w", err)
+	}
+
+	token, secret, err := newAccessToken(form, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token.ServiceAccountID = &id
+	if err := c.tokenRepo.Create(token); err != nil {
+		return nil, "", fmt.Errorf("create access token: %w", err)
+	}
+
+	c.log.Infof("User %s created access token %s of service account %s", userID, token.ID, id)
+
+	return token, secret, nil
+}
+
+func (c *Controller) RevokeServiceAccountToken(id, tokenID uuid.UUID, userID uuid.UUID) (*model.AccessToken, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	token, err := c.tokenRepo.Revoke(id, tokenID, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("revoke access token: %w", err)
+	}
+
+	c.log.Infof("User %s revoked access token %s of service account %s", userID, tokenID, id)
+
+	return token, nil
+}
+
+// serviceAccount resolves principalID that vaultKey did not find among users, or that a record read did not find
+// among the records it owns. userErr is returned as is when it is no service account either.
+func (c *Controller) serviceAccount(principalID uuid.UUID, userErr error) (*model.ServiceAccount, error) {
+	if !errors.Is(userErr, pmerror.ErrNotFound) && !errors.Is(userErr, pmerror.ErrForbidden) {
+		return nil, userErr
+	}
+
+	account, err := c.serviceRepo.Get(principalID)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, userErr
+	} else if err != nil {
+		return nil, fmt.Errorf("get service account: %w", err)
+	}
+
+	return account, nil
+}
+
+// grantedRecord returns record id granted to account with its fields decrypted with the key of its owner
+func (c *Controller) grantedRecord(account *model.ServiceAccount, id uuid.UUID) (interface{}, error) {
+	for _, grant := range account.Grants {
+		if grant.RecordID != id {
+			continue
+		}
+
+		_, record, err := c.readRecord(grant.OwnerID, id)
+		if err != nil {
+			return nil, fmt.Errorf("owner record: %w", err)
+		}
+
+		return record, nil
+	}
+
+	return nil, fmt.Errorf("%w: record %s is not granted to service account %s", pmerror.ErrForbidden, id, account.ID)
+}
+
+// grantedRecords lists the records granted to account with their labels decrypted, the records of each owner are read at once
+func (c *Controller) grantedRecords(account *model.ServiceAccount) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error) {
+	secureNotes := make([]model.CredentialRecord, 0)
+	logins := make([]model.LoginRecord, 0)
+	cards := make([]model.CardRecord, 0)
+	identities := make([]model.IdentityRecord, 0)
+
+	var owners []uuid.UUID
+	granted := make(map[uuid.UUID][]uuid.UUID)
+	for _, grant := range account.Grants {
+		if _, ok := granted[grant.OwnerID]; !ok {
+			owners = append(owners, grant.OwnerID)
+		}
+		granted[grant.OwnerID] = append(granted[grant.OwnerID], grant.RecordID)
+	}
+
+	for _, ownerID := range owners {
+		key, vault, err := c.readVault(ownerID, granted[ownerID])
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("owner vault: %w", err)
+		}
+
+		for _, id := range granted[ownerID] {
+			if vault.Record(id) == nil {
+				return nil, nil, nil, nil, fmt.Errorf("get record %s: %w", id, pmerror.ErrNotFound)
+			}
+		}
+
+		for _, record := range vault.Records() {
+			if err := c.decryptLabels(record, key); err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("decrypt %T: %w", record, err)
+			}
+		}
+
+		secureNotes = append(secureNotes, vault.SecureNotes...)
+		logins = append(logins, vault.Logins...)
+		cards = append(cards, vault.Cards...)
+		identities = append(identities, vault.Identities...)
+	}
+
+	sortRecords(secureNotes, logins, cards, identities)
+
+	return secureNotes, logins, cards, identities, nil
+}
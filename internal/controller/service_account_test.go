@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+// expectServiceAccountStore backs the service account repository mock with memory
+func expectServiceAccountStore(mocks *controllerMocks) map[uuid.UUID]*model.ServiceAccount {
+	accounts := make(map[uuid.UUID]*model.ServiceAccount)
+
+	r := mocks.ServiceRepository.EXPECT()
+	r.Create(gomock.Any()).AnyTimes().DoAndReturn(func(account *model.ServiceAccount) error {
+		stored := *account
+		accounts[account.ID] = &stored
+		return nil
+	})
+	r.Get(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.ServiceAccount, error) {
+		if account, ok := accounts[id]; ok {
+			result := *account
+			result.Grants = append([]model.RecordGrant(nil), account.Grants...)
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.AddGrants(gomock.Any()).AnyTimes().DoAndReturn(func(grants []model.RecordGrant) error {
+		for _, grant := range grants {
+			account := accounts[grant.ServiceAccountID]
+			account.Grants = append(account.Grants, grant)
+		}
+		return nil
+	})
+
+	return accounts
+}
+
+// expectAdmin gives adminID a fresh wrapped data key and admin rights, and returns the plain key
+func expectAdmin(t *testing.T, c *Controller, mocks *controllerMocks, adminID uuid.UUID) (*model.User, string) {
+	t.Helper()
+
+	admin := &model.User{ID: adminID, IsAdmin: true, VaultVersion: model.LatestVaultVersion}
+	key, err := c.newDataKey(admin)
+	require.NoError(t, err)
+
+	mocks.UserRepository.EXPECT().
+		Get(adminID).
+		AnyTimes().
+		Return(admin, nil)
+
+	return admin, key
+}
+
+func TestController_ServiceAccount(t *testing.T) {
+	adminID := uuid.New()
+	expiresOn := time.Now().Add(time.Hour)
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_reads_granted_records",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectServiceAccountStore(mocks)
+				admin, key := expectAdmin(t, c, mocks, adminID)
+
+				login := model.LoginRecord{
+					CredentialRecord: model.CredentialRecord{ID: uuid.New(), Name: "Deploy", CreatedBy: adminID, Revision: 1},
+					Password:         pmpointer.String("Test Password"),
+					URL:              pmpointer.String("https://deploy.example.com"),
+				}
+				require.NoError(t, c.encryptRecord(&login, key))
+
+				mocks.RecordRepository.EXPECT().
+					GetRecord(adminID, login.ID).
+					AnyTimes().
+					Return(&login, nil)
+				mocks.RecordRepository.EXPECT().
+					GetVault(adminID, []uuid.UUID{login.ID}).
+					AnyTimes().
+					DoAndReturn(func(_ uuid.UUID, _ []uuid.UUID) (*model.User, *model.Vault, error) {
+						// decryption replaces fields in place
+						result := login
+						result.Password, result.URL = pmpointer.String(*login.Password), pmpointer.String(*login.URL)
+						return admin, &model.Vault{Logins: []model.LoginRecord{result}}, nil
+					})
+				mocks.RecordRepository.EXPECT().
+					GetVaultRecord(adminID, login.ID).
+					AnyTimes().
+					DoAndReturn(func(_ uuid.UUID, _ uuid.UUID) (*model.User, interface{}, error) {
+						result := login
+						result.Password, result.URL = pmpointer.String(*login.Password), pmpointer.String(*login.URL)
+						return admin, &result, nil
+					})
+
+				account, err := c.CreateServiceAccount(&model.ServiceAccountForm{Name: pmpointer.String("Test Pipeline")}, adminID)
+				require.NoError(t, err)
+
+				mocks.UserRepository.EXPECT().
+					Get(account.ID).
+					AnyTimes().
+					Return(nil, pmerror.ErrNotFound)
+				mocks.RecordRepository.EXPECT().
+					GetVault(account.ID, gomock.Any()).
+					AnyTimes().
+					Return(nil, nil, pmerror.ErrNotFound)
+				// service accounts own no records, the repository finds the record of its owner
+				mocks.RecordRepository.EXPECT().
+					GetVaultRecord(account.ID, gomock.Any()).
+					AnyTimes().
+					Return(nil, nil, pmerror.ErrForbidden)
+				mocks.TokenRepository.EXPECT().
+					GetAll(account.ID).
+					Return(nil, nil)
+
+				account, err = c.GrantRecords(account.ID, &model.RecordGrantForm{RecordIDs: []uuid.UUID{login.ID, login.ID}}, adminID)
+				require.NoError(t, err)
+				require.Len(t, account.Grants, 1)
+				require.Equal(t, adminID, account.Grants[0].OwnerID)
+
+				record, err := c.GetRecord(login.ID, account.ID)
+				require.NoError(t, err)
+				require.Equal(t, "Test Password", *record.(*model.LoginRecord).Password)
+
+				_, logins, _, _, err := c.AllRecords(account.ID)
+				require.NoError(t, err)
+				require.Len(t, logins, 1)
+				require.Equal(t, "https://deploy.example.com", *logins[0].URL)
+
+				_, err = c.GetRecord(uuid.New(), account.ID)
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+		{
+			Name: "success_token",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectServiceAccountStore(mocks)
+				expectAccessTokenStore(mocks)
+				expectAdmin(t, c, mocks, adminID)
+
+				account, err := c.CreateServiceAccount(&model.ServiceAccountForm{Name: pmpointer.String("Test Pipeline")}, adminID)
+				require.NoError(t, err)
+
+				_, secret, err := c.CreateServiceAccountToken(account.ID, &model.AccessTokenForm{
+					Name:      pmpointer.String("Test Deploy"),
+					Scopes:    []model.Scope{model.ScopeRecordsRead},
+					ExpiresOn: &expiresOn,
+				}, adminID)
+				require.NoError(t, err)
+
+				token, err := c.AuthenticateAccessToken(secret, "192.0.2.1")
+				require.NoError(t, err)
+				require.Equal(t, account.ID, token.Principal())
+			},
+		},
+		{
+			Name: "error_token_scope",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				_, _, err := c.CreateServiceAccountToken(uuid.New(), &model.AccessTokenForm{
+					Name:      pmpointer.String("Test Deploy"),
+					Scopes:    []model.Scope{model.ScopeRecordsRead, model.ScopeRecordsWrite},
+					ExpiresOn: &expiresOn,
+				}, adminID)
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "error_grant_record_of_other_user",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectServiceAccountStore(mocks)
+				expectAdmin(t, c, mocks, adminID)
+
+				account, err := c.CreateServiceAccount(&model.ServiceAccountForm{Name: pmpointer.String("Test Pipeline")}, adminID)
+				require.NoError(t, err)
+
+				recordID := uuid.New()
+				mocks.RecordRepository.EXPECT().
+					GetRecord(adminID, recordID).
+					Return(nil, pmerror.ErrForbidden)
+
+				_, err = c.GrantRecords(account.ID, &model.RecordGrantForm{RecordIDs: []uuid.UUID{recordID}}, adminID)
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+		{
+			Name: "error_not_admin",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				userID := uuid.New()
+				mocks.UserRepository.EXPECT().
+					Get(userID).
+					Return(&model.User{ID: userID}, nil)
+
+				_, err := c.CreateServiceAccount(&model.ServiceAccountForm{Name: pmpointer.String("Test Pipeline")}, userID)
+				require.True(t, errors.Is(err, pmerror.ErrForbidden))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+)
+
+// maxUserAgentLength caps the user agent stored to tell sessions apart
+const maxUserAgentLength = 256
+
+// CreateSession signs userID in on a new device and returns the session with its first refresh token
+func (c *Controller) CreateSession(userID uuid.UUID, userAgent string) (*model.Session, string, error) {
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("get user: %w", err)
+	}
+
+	if user.DisabledOn != nil {
+		return nil, "", fmt.Errorf("%w: user is disabled", pmerror.ErrForbidden)
+	}
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	session := &model.Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		CreatedOn:  now,
+		LastUsedOn: now,
+		ExpiresOn:  now.Add(c.config.SessionTTL),
+	}
+
+	token, refreshToken, err := newRefreshToken(session.ID, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := c.sessionRepo.Create(session, refreshToken); err != nil {
+		return nil, "", fmt.Errorf("create session: %w", err)
+	}
+
+	return session, token, nil
+}
+
+// RefreshSession exchanges a refresh token for the next one of its session.
+// A token that was already exchanged revokes the session, whoever holds either copy has to sign in again.
+func (c *Controller) RefreshSession(token string) (*model.Session, string, error) {
+	if token == "" {
+		return nil, "", fmt.Errorf("%w: refresh token is empty", pmerror.ErrInvalidInput)
+	}
+
+	hash := hashToken(token)
+	stored, err := c.sessionRepo.GetRefreshToken(hash)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, "", fmt.Errorf("%w: unknown refresh token", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("get refresh token: %w", err)
+	}
+
+	session, err := c.sessionRepo.Get(stored.SessionID)
+	if err != nil {
+		return nil, "", fmt.Errorf("get session: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if !session.Active(now) {
+		return nil, "", fmt.Errorf("%w: session %s is revoked or expired", pmerror.ErrUnauthorized, session.ID)
+	}
+
+	if stored.UsedOn != nil {
+		return nil, "", c.revokeReusedSession(session)
+	}
+
+	next, nextToken, err := newRefreshToken(session.ID, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = c.sessionRepo.Rotate(hash, nextToken, now)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		// a concurrent refresh used the token first
+		return nil, "", c.revokeReusedSession(session)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("rotate refresh token: %w", err)
+	}
+
+	session.LastUsedOn = now
+
+	return session, next, nil
+}
+
+// ValidateSession checks that session id of userID can still be used to access the service
+func (c *Controller) ValidateSession(userID, id uuid.UUID) error {
+	session, err := c.sessionRepo.Get(id)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return fmt.Errorf("%w: session %s does not exist", pmerror.ErrUnauthorized, id)
+	} else if err != nil {
+		return fmt.Errorf("get session: %w", err)
+	}
+
+	if session.UserID != userID {
+		return fmt.Errorf("%w: session %s belongs to another user", pmerror.ErrUnauthorized, id)
+	}
+
+	if !session.Active(time.Now().UTC()) {
+		return fmt.Errorf("%w: session %s is revoked or expired", pmerror.ErrUnauthorized, id)
+	}
+
+	return nil
+}
+
+// AllSessions lists the active sessions of userID, current is the session of the caller
+func (c *Controller) AllSessions(userID, current uuid.UUID) ([]model.Session, error) {
+	sessions, err := c.sessionRepo.GetActive(userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("get sessions: %w", err)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	return sessions, nil
+}
+
+// signOutEverywhere revokes the sessions and access tokens of userID
+func (c *Controller) signOutEverywhere(userID uuid.UUID, now time.Time) error {
+	sessions, err := c.sessionRepo.GetActive(userID, now)
+	if err != nil {
+		return fmt.Errorf("get sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if _, err := c.sessionRepo.Revoke(userID, session.ID, now); err != nil && !errors.Is(err, pmerror.ErrNotFound) {
+			return fmt.Errorf("revoke session: %w", err)
+		}
+	}
+
+	tokens, err := c.tokenRepo.GetAll(userID)
+	if err != nil {
+		return fmt.Errorf("get access tokens: %w", err)
+	}
+
+	for _, token := range tokens {
+		if _, err := c.tokenRepo.Revoke(userID, token.ID, now); err != nil && !errors.Is(err, pmerror.ErrNotFound) {
+			return fmt.Errorf("revoke access token: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RevokeSession signs a device of userID out, its access and refresh tokens stop working
+func (c *Controller) RevokeSession(userID, id uuid.UUID) (*model.Session, error) {
+	session, err := c.sessionRepo.Revoke(userID, id, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("revoke session: %w", err)
+	}
+
+	c.log.Infof("Revoked session %s of user %s", id, userID)
+
+	return session, nil
+}
+
+func (c *Controller) revokeReusedSession(session *model.Session) error {
+	c.log.Warnf("Refresh token of session %s of user %s was reused, revoking the session", session.ID, session.UserID)
+
+	_, err := c.sessionRepo.Revoke(session.UserID, session.ID, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil && !errors.Is(err, pmerror.ErrNotFound) {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	return fmt.Errorf("%w: refresh token reused, session %s is revoked", pmerror.ErrUnauthorized, session.ID)
+}
+
+func newRefreshToken(sessionID uuid.UUID, now time.Time) (string, *model.RefreshToken, error) {
+	token, err := randomToken("")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &model.RefreshToken{
+		Hash:      hashToken(token),
+		SessionID: sessionID,
+		CreatedOn: now,
+	}, nil
+}
+
+// randomToken returns prefix followed by 256 random bits
+func randomToken(prefix string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashToken identifies a bearer token, tokens are random so a plain hash is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
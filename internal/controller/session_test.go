@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// sessionStore backs the session repository mock with memory
+type sessionStore struct {
+	sessions map[uuid.UUID]*model.Session
+	tokens   map[string]*model.RefreshToken
+}
+
+func expectSessionStore(mocks *controllerMocks) *sessionStore {
+	s := &sessionStore{
+		sessions: make(map[uuid.UUID]*model.Session),
+		tokens:   make(map[string]*model.RefreshToken),
+	}
+
+	mocks.UserRepository.EXPECT().Get(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.User, error) {
+		return &model.User{ID: id}, nil
+	})
+
+	r := mocks.SessionRepository.EXPECT()
+	r.Create(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(session *model.Session, token *model.RefreshToken) error {
+		stored := *session
+		s.sessions[session.ID] = &stored
+		s.tokens[token.Hash] = token
+		return nil
+	})
+	r.Get(gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID) (*model.Session, error) {
+		if session, ok := s.sessions[id]; ok {
+			result := *session
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.GetActive(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, now time.Time) ([]model.Session, error) {
+		var result []model.Session
+		for _, session := range s.sessions {
+			if session.UserID == userID && session.Active(now) {
+				result = append(result, *session)
+			}
+		}
+		return result, nil
+	})
+	r.GetRefreshToken(gomock.Any()).AnyTimes().DoAndReturn(func(hash string) (*model.RefreshToken, error) {
+		if token, ok := s.tokens[hash]; ok {
+			result := *token
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.Rotate(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, next *model.RefreshToken, now time.Time) error {
+		token := s.tokens[hash]
+		if token.UsedOn != nil {
+			return pmerror.ErrNotFound
+		}
+		token.UsedOn = &now
+		s.tokens[next.Hash] = next
+		s.sessions[next.SessionID].LastUsedOn = now
+		return nil
+	})
+	r.Revoke(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID, id uuid.UUID, now time.Time) (*model.Session, error) {
+		session, ok := s.sessions[id]
+		if !ok || session.UserID != userID || session.RevokedOn != nil {
+			return nil, pmerror.ErrNotFound
+		}
+		session.RevokedOn = &now
+		result := *session
+		return &result, nil
+	})
+
+	return s
+}
+
+func TestController_Session(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_rotate",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectSessionStore(mocks)
+
+				session, token, err := c.CreateSession(userID, "Test Agent")
+				require.NoError(t, err)
+				require.NoError(t, c.ValidateSession(userID, session.ID))
+
+				refreshed, next, err := c.RefreshSession(token)
+				require.NoError(t, err)
+				require.Equal(t, session.ID, refreshed.ID)
+				require.NotEqual(t, token, next)
+
+				_, _, err = c.RefreshSession(next)
+				require.NoError(t, err)
+
+				sessions, err := c.AllSessions(userID, session.ID)
+				require.NoError(t, err)
+				require.Len(t, sessions, 1)
+				require.True(t, sessions[0].Current)
+			},
+		},
+		{
+			Name: "error_reuse_revokes_session",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				store := expectSessionStore(mocks)
+
+				session, token, err := c.CreateSession(userID, "Test Agent")
+				require.NoError(t, err)
+
+				_, next, err := c.RefreshSession(token)
+				require.NoError(t, err)
+
+				_, _, err = c.RefreshSession(token)
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+				require.NotNil(t, store.sessions[session.ID].RevokedOn)
+
+				// the rotated token dies with the session
+				_, _, err = c.RefreshSession(next)
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+				require.True(t, errors.Is(c.ValidateSession(userID, session.ID), pmerror.ErrUnauthorized))
+			},
+		},
+		{
+			Name: "error_revoked",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectSessionStore(mocks)
+
+				session, token, err := c.CreateSession(userID, "Test Agent")
+				require.NoError(t, err)
+				other, _, err := c.CreateSession(userID, "Other Agent")
+				require.NoError(t, err)
+
+				_, err = c.RevokeSession(userID, session.ID)
+				require.NoError(t, err)
+
+				require.True(t, errors.Is(c.ValidateSession(userID, session.ID), pmerror.ErrUnauthorized))
+				require.NoError(t, c.ValidateSession(userID, other.ID))
+
+				_, _, err = c.RefreshSession(token)
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+
+				sessions, err := c.AllSessions(userID, other.ID)
+				require.NoError(t, err)
+				require.Len(t, sessions, 1)
+				require.Equal(t, other.ID, sessions[0].ID)
+			},
+		},
+		{
+			Name: "error_other_user",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectSessionStore(mocks)
+
+				session, _, err := c.CreateSession(userID, "Test Agent")
+				require.NoError(t, err)
+
+				require.True(t, errors.Is(c.ValidateSession(uuid.New(), session.ID), pmerror.ErrUnauthorized))
+
+				_, err = c.RevokeSession(uuid.New(), session.ID)
+				require.True(t, errors.Is(err, pmerror.ErrNotFound))
+			},
+		},
+		{
+			Name: "error_unknown_token",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectSessionStore(mocks)
+
+				_, _, err := c.RefreshSession("unknown")
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
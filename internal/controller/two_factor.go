@@ -0,0 +1,368 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtotp"
+)
+
+const (
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer = "PasswordManager"
+
+	loginChallengePrefix = "pmlc_"
+	// recoveryCodeSize is 80 bits, recovery codes are only hashed with SHA-256 so they must not be guessable
+	recoveryCodeSize = 10
+)
+
+// EnrollTOTP starts setting up an authenticator app for userID, it is enabled once confirmed with ConfirmTOTP.
+// Starting again replaces a pending enrollment.
+func (c *Controller) EnrollTOTP(userID uuid.UUID) (*model.TOTPEnrollment, error) {
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	enabled, err := c.twoFactorEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", pmerror.ErrInvalidInput)
+	}
+
+	secret, err := pmtotp.NewSecret()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	encrypted, err := c.keys.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt secret: %w", err)
+	}
+
+	totp := &model.TOTP{
+		ID:        userID,
+		Secret:    encrypted,
+		CreatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
+	}
+
+	if err := c.twoFARepo.SaveTOTP(totp); err != nil {
+		return nil, fmt.Errorf("save totp: %w", err)
+	}
+
+	uri, err := pmtotp.URI(TOTPIssuer, user.Name, secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	return &model.TOTPEnrollment{Secret: secret, URI: uri}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication of userID with the first code of its authenticator app.
+// It returns recovery codes, they are not shown again.
+func (c *Controller) ConfirmTOTP(userID uuid.UUID, form *model.TOTPConfirmForm) ([]string, error) {
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	totp, err := c.twoFARepo.GetTOTP(userID)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: enroll an authenticator app first", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return nil, fmt.Errorf("get totp: %w", err)
+	}
+
+	if totp.Enabled() {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", pmerror.ErrInvalidInput)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	step, err := c.validateTOTP(totp, *form.Code, now)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := newRecoveryCodes(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.twoFARepo.ConfirmTOTP(userID, step, now, hashed); err != nil {
+		return nil, fmt.Errorf("confirm totp: %w", err)
+	}
+
+	c.log.Infof("Enabled two-factor authentication of user %s", userID)
+
+	return codes, nil
+}
+
+// DisableTOTP disables two-factor authentication of userID once it signs in again
+func (c *Controller) DisableTOTP(userID uuid.UUID, form *model.ReauthForm) error {
+	if err := c.reauthenticate(userID, form); err != nil {
+		return err
+	}
+
+	if err := c.twoFARepo.DeleteTOTP(userID); err != nil {
+		return fmt.Errorf("delete totp: %w", err)
+	}
+
+	c.log.Infof("Disabled two-factor authentication of user %s", userID)
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID once it signs in again
+func (c *Controller) RegenerateRecoveryCodes(userID uuid.UUID, form *model.ReauthForm) ([]string, error) {
+	if err := c.reauthenticate(userID, form); err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := newRecoveryCodes(userID, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.twoFARepo.ReplaceRecoveryCodes(userID, hashed); err != nil {
+		return nil, fmt.Errorf("replace recovery codes: %w", err)
+	}
+
+	c.log.Infof("Regenerated recovery codes of user %s", userID)
+
+	return codes, nil
+}
+
+// CompleteLogin checks the second factor entered for a challenge Login issued and returns the user signing in.
+// A challenge takes model.MaxLoginChallengeAttempts wrong codes at most.
+func (c *Controller) CompleteLogin(form *model.SecondFactorForm) (uuid.UUID, error) {
+	if err := form.Validate(); err != nil {
+		return uuid.UUID{}, fmt.Errorf("validate: %w", err)
+	}
+
+	hash := hashToken(*form.ChallengeToken)
+	challenge, err := c.twoFARepo.GetChallenge(hash)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return uuid.UUID{}, fmt.Errorf("%w: unknown login challenge", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return uuid.UUID{}, fmt.Errorf("get login challenge: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if !challenge.Active(now) {
+		return uuid.UUID{}, fmt.Errorf("%w: login challenge is used, expired or out of attempts, log in again", pmerror.ErrUnauthorized)
+	}
+
+	user, err := c.userRepo.Get(challenge.UserID)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("get user: %w", err)
+	}
+
+	// wrong codes count toward the lockout of the account like wrong passwords, new challenges do not reset them
+	if err := c.checkLoginFailures(user.Name); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if form.WebAuthn != nil {
+		_, err = c.verifyAssertion(form.WebAuthn, model.WebAuthnSecondFactor, &challenge.UserID, false, now)
+	} else {
+		err = c.verifySecondFactor(challenge.UserID, form.Code, form.RecoveryCode, now)
+	}
+
+	if err != nil {
+		if errors.Is(err, pmerror.ErrInvalidInput) {
+			c.loginFailed(user.Name)
+			if err := c.twoFARepo.FailChallenge(hash); err != nil {
+				return uuid.UUID{}, fmt.Errorf("fail login challenge: %w", err)
+			}
+		}
+
+		return uuid.UUID{}, err
+	}
+
+	if err := c.twoFARepo.UseChallenge(hash, now); errors.Is(err, pmerror.ErrNotFound) {
+		return uuid.UUID{}, fmt.Errorf("%w: login challenge was used meanwhile", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return uuid.UUID{}, fmt.Errorf("use login challenge: %w", err)
+	}
+
+	c.loginSucceeded(user.Name)
+
+	return challenge.UserID, nil
+}
+
+func (c *Controller) twoFactorEnabled(userID uuid.UUID) (bool, error) {
+	totp, err := c.twoFARepo.GetTOTP(userID)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("get totp: %w", err)
+	}
+
+	return totp.Enabled(), nil
+}
+
+func (c *Controller) newLoginChallenge(userID uuid.UUID) (string, error) {
+	token, err := randomToken(loginChallengePrefix)
+	if err != nil {
+		return "", err
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	challenge := &model.LoginChallenge{
+		Hash:      hashToken(token),
+		UserID:    userID,
+		CreatedOn: now,
+		ExpiresOn: now.Add(model.LoginChallengeTTL),
+	}
+
+	if err := c.twoFARepo.CreateChallenge(challenge); err != nil {
+		return "", fmt.Errorf("create login challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// reauthenticate checks the password and second factor of a signed in user before a sensitive change
+func (c *Controller) reauthenticate(userID uuid.UUID, form *model.ReauthForm) error {
+	if err := form.Validate(); err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if err := c.confirmPresence(user, form.Password, form.OIDCState, now); err != nil {
+		return err
+	}
+
+	return c.verifySecondFactor(userID, form.Code, form.RecoveryCode, now)
+}
+
+// confirmPresence checks a signed in user is present. A reauthentication at the identity provider is spent when
+// oidcState is set, directory users bind with their directory password and everybody else enters their password.
+// Directory and provisioned users have a random password nobody knows, so only the first two work for them.
+func (c *Controller) confirmPresence(user *model.User, password, oidcState *string, now time.Time) error {
+	if oidcState != nil && *oidcState != "" {
+		return c.spendOIDCReauth(user.ID, *oidcState, now)
+	}
+
+	if user.DirectoryDN != nil {
+		return c.ldapReauth(user, *password)
+	}
+
+	ok, _, err := c.verifyPassword(user, *password)
+	if err != nil {
+		return fmt.Errorf("verify password: %w", err)
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// verifySecondFactor accepts either a TOTP code or a recovery code of userID, each only once.
+// Codes that do not match are reported as pmerror.ErrInvalidInput.
+func (c *Controller) verifySecondFactor(userID uuid.UUID, code, recoveryCode *string, now time.Time) error {
+	if recoveryCode != nil && *recoveryCode != "" {
+		err := c.twoFARepo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(*recoveryCode)), now)
+		if errors.Is(err, pmerror.ErrNotFound) {
+			return fmt.Errorf("%w: recovery code doesn't match", pmerror.ErrInvalidInput)
+		} else if err != nil {
+			return fmt.Errorf("use recovery code: %w", err)
+		}
+
+		c.log.Infof("User %s used a recovery code", userID)
+
+		return nil
+	}
+
+	if code == nil || *code == "" {
+		return fmt.Errorf("%w: code is empty", pmerror.ErrInvalidInput)
+	}
+
+	totp, err := c.twoFARepo.GetTOTP(userID)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return fmt.Errorf("%w: two-factor authentication is not enabled", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return fmt.Errorf("get totp: %w", err)
+	}
+
+	if !totp.Enabled() {
+		return fmt.Errorf("%w: two-factor authentication is not enabled", pmerror.ErrInvalidInput)
+	}
+
+	step, err := c.validateTOTP(totp, *code, now)
+	if err != nil {
+		return err
+	}
+
+	if step <= totp.LastUsedStep {
+		return fmt.Errorf("%w: code was already used", pmerror.ErrInvalidInput)
+	}
+
+	if err := c.twoFARepo.UseTOTPStep(userID, step); errors.Is(err, pmerror.ErrNotFound) {
+		return fmt.Errorf("%w: code was already used", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return fmt.Errorf("use totp step: %w", err)
+	}
+
+	return nil
+}
+
+// validateTOTP returns the time step code of totp matches at now
+func (c *Controller) validateTOTP(totp *model.TOTP, code string, now time.Time) (int64, error) {
+	secret, err := c.keys.Decrypt(totp.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("%w: decrypt totp secret of user %s: %s", pmerror.ErrInternal, totp.ID, err.Error())
+	}
+
+	step, ok, err := pmtotp.Validate(secret, strings.TrimSpace(code), now)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	if !ok {
+		return 0, fmt.Errorf("%w: code doesn't match", pmerror.ErrInvalidInput)
+	}
+
+	return step, nil
+}
+
+// newRecoveryCodes generates model.RecoveryCodeCount codes formatted for reading and their hashes to store
+func newRecoveryCodes(userID uuid.UUID, now time.Time) ([]string, []model.RecoveryCode, error) {
+	codes := make([]string, model.RecoveryCodeCount)
+	hashed := make([]model.RecoveryCode, model.RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("read random: %w", err)
+		}
+
+		code := base32.StdEncoding.EncodeToString(raw)
+		codes[i] = strings.Join([]string{code[:4], code[4:8], code[8:12], code[12:]}, "-")
+		hashed[i] = model.RecoveryCode{UserID: userID, Hash: hashToken(code), CreatedOn: now}
+	}
+
+	return codes, hashed, nil
+}
+
+// normalizeRecoveryCode undoes the formatting of recovery codes and what users add when typing them
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
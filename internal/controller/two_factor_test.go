@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtotp"
+)
+
+type twoFactorStore struct {
+	totps      map[uuid.UUID]*model.TOTP
+	codes      map[string]*model.RecoveryCode
+	challenges map[string]*model.LoginChallenge
+}
+
+// expectTwoFactorStore backs the two-factor repository mock with memory
+func expectTwoFactorStore(mocks *controllerMocks) *twoFactorStore {
+	store := &twoFactorStore{
+		totps:      make(map[uuid.UUID]*model.TOTP),
+		codes:      make(map[string]*model.RecoveryCode),
+		challenges: make(map[string]*model.LoginChallenge),
+	}
+
+	replaceCodes := func(userID uuid.UUID, codes []model.RecoveryCode) {
+		for hash, code := range store.codes {
+			if code.UserID == userID {
+				delete(store.codes, hash)
+			}
+		}
+		for _, code := range codes {
+			stored := code
+			store.codes[code.Hash] = &stored
+		}
+	}
+
+	r := mocks.TwoFactorRepository.EXPECT()
+	r.GetTOTP(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) (*model.TOTP, error) {
+		if totp, ok := store.totps[userID]; ok {
+			result := *totp
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.SaveTOTP(gomock.Any()).AnyTimes().DoAndReturn(func(totp *model.TOTP) error {
+		stored := *totp
+		store.totps[totp.ID] = &stored
+		return nil
+	})
+	r.ConfirmTOTP(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, step int64, now time.Time, codes []model.RecoveryCode) error {
+		totp := store.totps[userID]
+		totp.ConfirmedOn, totp.LastUsedStep = &now, step
+		replaceCodes(userID, codes)
+		return nil
+	})
+	r.UseTOTPStep(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, step int64) error {
+		totp := store.totps[userID]
+		if totp.LastUsedStep >= step {
+			return pmerror.ErrNotFound
+		}
+		totp.LastUsedStep = step
+		return nil
+	})
+	r.DeleteTOTP(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) error {
+		delete(store.totps, userID)
+		replaceCodes(userID, nil)
+		return nil
+	})
+	r.ReplaceRecoveryCodes(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, codes []model.RecoveryCode) error {
+		replaceCodes(userID, codes)
+		return nil
+	})
+	r.UseRecoveryCode(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID, hash string, now time.Time) error {
+		code, ok := store.codes[hash]
+		if !ok || code.UserID != userID || code.UsedOn != nil {
+			return pmerror.ErrNotFound
+		}
+		code.UsedOn = &now
+		return nil
+	})
+	r.CreateChallenge(gomock.Any()).AnyTimes().DoAndReturn(func(challenge *model.LoginChallenge) error {
+		stored := *challenge
+		store.challenges[challenge.Hash] = &stored
+		return nil
+	})
+	r.GetChallenge(gomock.Any()).AnyTimes().DoAndReturn(func(hash string) (*model.LoginChallenge, error) {
+		if challenge, ok := store.challenges[hash]; ok {
+			result := *challenge
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.FailChallenge(gomock.Any()).AnyTimes().DoAndReturn(func(hash string) error {
+		store.challenges[hash].Attempts++
+		return nil
+	})
+	r.UseChallenge(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, now time.Time) error {
+		challenge := store.challenges[hash]
+		if challenge.UsedOn != nil || challenge.Attempts >= model.MaxLoginChallengeAttempts {
+			return pmerror.ErrNotFound
+		}
+		challenge.UsedOn = &now
+		return nil
+	})
+
+	return store
+}
+
+// enableTestTOTP enrolls user and confirms it with the code of the current step,
+// it returns the secret, the step of that code and the recovery codes
+func enableTestTOTP(t *testing.T, c *Controller, user *model.User) (string, int64, []string) {
+	t.Helper()
+
+	enrollment, err := c.EnrollTOTP(user.ID)
+	require.NoError(t, err)
+	require.Contains(t, enrollment.URI, "otpauth://totp/")
+
+	step := pmtotp.Step(time.Now())
+	code, err := pmtotp.Code(enrollment.Secret, step)
+	require.NoError(t, err)
+
+	recoveryCodes, err := c.ConfirmTOTP(user.ID, &model.TOTPConfirmForm{Code: &code})
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, model.RecoveryCodeCount)
+
+	return enrollment.Secret, step, recoveryCodes
+}
+
+func TestController_TwoFactor(t *testing.T) {
+	password := "Test User Password"
+	hashedPassword, err := pmcrypto.HashPassword(password, testKDFParams)
+	require.NoError(t, err)
+
+	newUser := func(t *testing.T, c *Controller, mocks *controllerMocks) *model.User {
+		user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: hashedPassword, VaultVersion: model.LatestVaultVersion}
+		_, err := c.newDataKey(user)
+		require.NoError(t, err)
+
+		mocks.UserRepository.EXPECT().Get(user.ID).AnyTimes().Return(user, nil)
+		mocks.UserRepository.EXPECT().GetByName(user.Name).AnyTimes().Return(user, nil)
+
+		return user
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_login_with_code_and_recovery_code",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectTwoFactorStore(mocks)
+				user := newUser(t, c, mocks)
+				secret, step, recoveryCodes := enableTestTOTP(t, c, user)
+
+				id, challenge, err := c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+				require.Equal(t, uuid.Nil, id)
+				require.NotEmpty(t, challenge)
+
+				// the code confirming the enrollment is not accepted again
+				used, err := pmtotp.Code(secret, step)
+				require.NoError(t, err)
+				_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: &used})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+
+				next, err := pmtotp.Code(secret, step+1)
+				require.NoError(t, err)
+				id, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: &next})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+
+				_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: &next})
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+
+				_, challenge, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				typed := " " + recoveryCodes[0][:10] + " " + recoveryCodes[0][10:] + " "
+				id, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, RecoveryCode: &typed})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+
+				_, challenge, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, RecoveryCode: &recoveryCodes[0]})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "error_challenge_out_of_attempts",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectTwoFactorStore(mocks)
+				user := newUser(t, c, mocks)
+				secret, step, _ := enableTestTOTP(t, c, user)
+
+				_, challenge, err := c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				for i := 0; i < model.MaxLoginChallengeAttempts; i++ {
+					_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: pmpointer.String("000000")})
+					require.Error(t, err)
+				}
+
+				next, err := pmtotp.Code(secret, step+1)
+				require.NoError(t, err)
+				_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, Code: &next})
+				require.True(t, errors.Is(err, pmerror.ErrUnauthorized))
+			},
+		},
+		{
+			Name: "success_disable_and_regenerate_after_reauth",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				store := expectTwoFactorStore(mocks)
+				user := newUser(t, c, mocks)
+				_, _, recoveryCodes := enableTestTOTP(t, c, user)
+
+				err := c.DisableTOTP(user.ID, &model.ReauthForm{Password: pmpointer.String("wrong"), RecoveryCode: &recoveryCodes[0]})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+
+				regenerated, err := c.RegenerateRecoveryCodes(user.ID, &model.ReauthForm{Password: &password, RecoveryCode: &recoveryCodes[0]})
+				require.NoError(t, err)
+				require.Len(t, regenerated, model.RecoveryCodeCount)
+				require.NotContains(t, store.codes, hashToken(normalizeRecoveryCode(recoveryCodes[1])))
+
+				err = c.DisableTOTP(user.ID, &model.ReauthForm{Password: &password, RecoveryCode: &regenerated[0]})
+				require.NoError(t, err)
+				require.Empty(t, store.totps)
+
+				id, challenge, err := c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+				require.Empty(t, challenge)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
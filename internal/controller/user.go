@@ -1,6 +1,10 @@
 package controller
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,105 +16,300 @@ import (
 	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
 )
 
-func (c *Controller) Login(form *model.UserForm) (uuid.UUID, error) {
+// Login verifies the password of a user. When two-factor authentication is enabled no user is returned
+// but a challenge token, the login is then completed with CompleteLogin.
+func (c *Controller) Login(form *model.UserForm) (uuid.UUID, string, error) {
+	if err := c.passwordLoginAllowed(); err != nil {
+		return uuid.UUID{}, "", err
+	}
+
 	if err := form.Validate(); err != nil {
-		return uuid.UUID{}, fmt.Errorf("validate: %w", err)
+		return uuid.UUID{}, "", fmt.Errorf("validate: %w", err)
+	}
+
+	if err := c.checkLoginFailures(*form.Name); err != nil {
+		return uuid.UUID{}, "", err
+	}
+
+	user, err := c.passwordLogin(*form.Name, *form.Password)
+	if errors.Is(err, pmerror.ErrInvalidInput) || errors.Is(err, pmerror.ErrNotFound) {
+		// unknown names count as well, guessing them must not be any faster
+		c.loginFailed(*form.Name)
 	}
 
-	user, err := c.userRepo.GetByName(*form.Name)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("validate: %w", err)
+		return uuid.UUID{}, "", err
 	}
 
-	decPassword, err := pmcrypto.Decrypt(user.Password, Salt)
+	enabled, err := c.twoFactorEnabled(user.ID)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("decrypt: %w", err)
+		return uuid.UUID{}, "", err
 	}
 
-	if *form.Password != decPassword {
-		return uuid.UUID{}, fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	if enabled {
+		// failures are forgotten once the second factor matches as well, CompleteLogin counts wrong codes
+		challenge, err := c.newLoginChallenge(user.ID)
+		if err != nil {
+			return uuid.UUID{}, "", err
+		}
+
+		return uuid.UUID{}, challenge, nil
 	}
 
-	return user.ID, nil
+	c.loginSucceeded(*form.Name)
+
+	return user.ID, "", nil
 }
 
-func (c *Controller) AllUsers() ([]model.User, error) {
-	return c.userRepo.GetAll()
+// passwordLogin verifies the password of the user signing in with name, against the directory for directory users
+func (c *Controller) passwordLogin(name, password string) (*model.User, error) {
+	user, err := c.userRepo.GetByName(name)
+	switch {
+	case c.config.LDAP != nil && (errors.Is(err, pmerror.ErrNotFound) || err == nil && user.DirectoryDN != nil):
+		// names unknown here may be directory users signing in for the first time, or after a rename
+		return c.ldapLogin(name, password)
+	case err != nil:
+		return nil, fmt.Errorf("validate: %w", err)
+	default:
+		if err := c.localLogin(user, password); err != nil {
+			return nil, err
+		}
+
+		return user, nil
+	}
 }
 
-func (c *Controller) GetUser(id uuid.UUID) (*model.User, error) {
-	repoUser, err := c.userRepo.Get(id)
+// localLogin verifies the password stored for user and upgrades how it and the vault are stored
+func (c *Controller) localLogin(user *model.User, password string) error {
+	ok, rehash, err := c.verifyPassword(user, password)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("verify password: %w", err)
 	}
 
-	v, err := pmcrypto.Decrypt(repoUser.Password, Salt)
-	if err != nil {
-		return nil, err
+	if !ok {
+		return fmt.Errorf("%w: password doesn't match", pmerror.ErrInvalidInput)
+	}
+
+	if rehash {
+		if err := c.rehashPassword(user.ID, password); err != nil {
+			// not fatal, the stored password stays verifiable until the next login
+			c.log.Errorf("Failed to rehash password of user %s: %s", user.ID.String(), err.Error())
+		}
+	}
+
+	if c.config.Mode == CryptoModeServer {
+		if err := c.migrateVault(user, password); err != nil {
+			return fmt.Errorf("migrate vault: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Prelogin returns what a client needs before logging in, in client mode the KDF parameters of the vault key.
+// Unknown names and users without parameters get stable fake ones, so that accounts cannot be enumerated.
+func (c *Controller) Prelogin(name string) (*model.Prelogin, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is empty", pmerror.ErrInvalidInput)
+	}
+
+	prelogin := &model.Prelogin{Mode: string(c.config.Mode)}
+	if c.config.Mode != CryptoModeClient {
+		return prelogin, nil
+	}
+
+	user, err := c.userRepo.GetByName(name)
+	if err != nil && !errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	if err == nil && user.KDFSalt != nil {
+		prelogin.SetKDF(user.KDFSalt, user.KDFParams())
+		return prelogin, nil
+	}
+
+	mac := hmac.New(sha256.New, c.config.PreloginSecret)
+	mac.Write([]byte(name))
+	prelogin.SetKDF(mac.Sum(nil)[:pmcrypto.SaltSize], c.config.KDF)
+
+	return prelogin, nil
+}
+
+// AllUsers lists every user, only admins may
+func (c *Controller) AllUsers(userID uuid.UUID) ([]model.User, error) {
+	if err := c.authorizeAdmin(userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
 	}
 
-	repoUser.Password = v
+	return c.userRepo.GetAll()
+}
+
+// GetUser returns user id to itself or an admin
+func (c *Controller) GetUser(id uuid.UUID, userID uuid.UUID) (*model.User, error) {
+	if err := c.authorizeUser(id, userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
 
-	return repoUser, nil
+	return c.userRepo.Get(id)
 }
 
 func (c *Controller) CreateUser(form *model.UserForm) (*model.User, error) {
+	if err := c.passwordLoginAllowed(); err != nil {
+		return nil, err
+	}
+
 	if err := form.Validate(); err != nil {
 		return nil, fmt.Errorf("validate: %w", err)
 	}
 
-	encPassword, err := pmcrypto.Encrypt(*form.Password, Salt)
+	user, err := c.newUser(*form.Name, *form.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	user := model.User{
-		ID:        uuid.New(),
-		Name:      *form.Name,
-		Password:  encPassword,
-		CreatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-		UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
-	}
+	return c.userRepo.Create(user)
+}
 
-	result, err := c.userRepo.Create(&user)
+// newUser prepares a user with a vault key for the crypto mode of the deployment
+func (c *Controller) newUser(name, password string) (*model.User, error) {
+	hash, err := pmcrypto.HashPassword(password, c.config.PasswordHash)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("hash password: %w", err)
 	}
 
-	result.Password, err = pmcrypto.Decrypt(result.Password, Salt)
-	if err != nil {
-		return nil, err
+	user := model.User{
+		ID:           uuid.New(),
+		Name:         name,
+		Password:     hash,
+		CreatedOn:    pmtime.TruncateToMillisecond(time.Now().UTC()),
+		UpdatedOn:    pmtime.TruncateToMillisecond(time.Now().UTC()),
+		VaultVersion: model.LatestVaultVersion,
 	}
 
-	return result, nil
+	switch c.config.Mode {
+	case CryptoModeServer:
+		if _, err := c.newDataKey(&user); err != nil {
+			return nil, fmt.Errorf("new data key: %w", err)
+		}
+	case CryptoModeClient:
+		salt, err := pmcrypto.NewSalt()
+		if err != nil {
+			return nil, fmt.Errorf("new salt: %w", err)
+		}
+
+		user.SetKDF(salt, c.config.KDF)
+	}
+
+	return &user, nil
 }
 
-func (c *Controller) UpdateUser(id uuid.UUID, form *model.UserForm) (*model.User, error) {
+// UpdateUser renames user id or sets its password on behalf of userID, itself or an admin.
+// The data key is wrapped by the server, whoever sets the password can open the vault, so every session
+// of id ends with it. Directory users and deployments requiring single sign-on have no passwords to set.
+func (c *Controller) UpdateUser(id uuid.UUID, form *model.UserForm, userID uuid.UUID) (*model.User, error) {
 	if form.Empty() {
 		return nil, fmt.Errorf("%w: empty form", pmerror.ErrInvalidInput)
 	}
 
+	if err := c.authorizeUser(id, userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
 	user := model.User{
 		ID:        id,
-		UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
+		UpdatedOn: now,
 	}
 
 	if form.Name != nil {
 		user.Name = *form.Name
 	}
 
-	if form.Password != nil {
-		v, err := pmcrypto.Encrypt(*form.Password, Salt)
+	if form.Password != nil && *form.Password != "" {
+		if err := c.passwordLoginAllowed(); err != nil {
+			return nil, err
+		}
+
+		current, err := c.userRepo.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("get user: %w", err)
+		}
+
+		if current.DirectoryDN != nil {
+			return nil, fmt.Errorf("%w: the password of user %s is managed by the directory", pmerror.ErrForbidden, id)
+		}
+
+		hash, err := pmcrypto.HashPassword(*form.Password, c.config.PasswordHash)
 		if err != nil {
-			return nil, fmt.Errorf("encrypt: %w", err)
+			return nil, fmt.Errorf("hash password: %w", err)
 		}
 
-		user.Password = v
+		user.Password = hash
+	}
+
+	result, err := c.userRepo.Update(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Password != "" {
+		if err := c.signOutEverywhere(id, now); err != nil {
+			return nil, fmt.Errorf("sign out: %w", err)
+		}
+
+		if id != userID {
+			c.log.Warnf("Admin %s set the password of user %s", userID, id)
+		}
 	}
 
-	return c.userRepo.Update(&user)
+	return result, nil
 }
 
-func (c *Controller) DeleteUser(id uuid.UUID) (*model.User, error) {
-	return c.userRepo.Delete(id)
+// verifyPassword checks password against the stored hash, rehash reports that the stored value should be replaced.
+// Passwords stored encrypted before hashing was introduced are decrypted and always rehashed.
+func (c *Controller) verifyPassword(user *model.User, password string) (bool, bool, error) {
+	if pmcrypto.IsPasswordHash(user.Password) {
+		ok, err := pmcrypto.VerifyPassword(password, user.Password)
+		if err != nil {
+			return false, false, fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+		}
+
+		return ok, ok && pmcrypto.PasswordNeedsRehash(user.Password, c.config.PasswordHash), nil
+	}
+
+	decrypted, err := c.keys.Decrypt(user.Password)
+	if err != nil {
+		return false, false, fmt.Errorf("decrypt: %w", err)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(decrypted), []byte(password)) == 1, true, nil
+}
+
+func (c *Controller) rehashPassword(id uuid.UUID, password string) error {
+	hash, err := pmcrypto.HashPassword(password, c.config.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("hash: %w", err)
+	}
+
+	_, err = c.userRepo.Update(&model.User{ID: id, Password: hash})
+
+	return err
+}
+
+// DeleteUser deletes user id on behalf of userID, itself or an admin
+func (c *Controller) DeleteUser(id uuid.UUID, userID uuid.UUID) (*model.User, error) {
+	if err := c.authorizeUser(id, userID); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	user, err := c.userRepo.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != userID {
+		c.log.Warnf("Admin %s deleted user %s", userID, id)
+	}
+
+	return user, nil
 }
@@ -0,0 +1,453 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+)
+
+func TestController_Login(t *testing.T) {
+	password := "Test User Password"
+	legacyPassword, err := pmcrypto.Encrypt(password, Salt)
+	require.NoError(t, err)
+
+	encryptedPassword, err := pmcrypto.EncryptAEAD(password, testServerKey, testServerKeyID)
+	require.NoError(t, err)
+
+	hashedPassword, err := pmcrypto.HashPassword(password, testKDFParams)
+	require.NoError(t, err)
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_migrate_legacy_vault",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: legacyPassword}
+
+				notes := "Test Record Notes"
+				legacyNotes, err := pmcrypto.Encrypt(notes, Salt)
+				require.NoError(t, err)
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				var rotated *model.User
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						rotated = u
+						return u, nil
+					})
+
+				mocks.RecordRepository.EXPECT().
+					GetAll(user.ID).
+					Return([]model.CredentialRecord{{ID: uuid.New(), Notes: &legacyNotes, CreatedBy: user.ID}}, nil, nil, nil, nil)
+
+				var rekeyed *model.User
+				var vault *model.Vault
+				mocks.RecordRepository.EXPECT().
+					Rekey(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(u *model.User, v *model.Vault) error {
+						rekeyed, vault = u, v
+						return nil
+					})
+
+				mocks.TwoFactorRepository.EXPECT().
+					GetTOTP(user.ID).
+					Return(nil, pmerror.ErrNotFound)
+
+				id, _, err := c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+
+				require.Equal(t, user.ID, rotated.ID)
+				ok, err := pmcrypto.VerifyPassword(password, rotated.Password)
+				require.NoError(t, err)
+				require.True(t, ok)
+
+				require.Equal(t, user.ID, rekeyed.ID)
+				key, err := c.unwrapDataKey(rekeyed)
+				require.NoError(t, err)
+				require.Len(t, key, pmcrypto.KeySize)
+
+				require.Len(t, vault.SecureNotes, 1)
+				require.Equal(t, 1, vault.SecureNotes[0].Revision)
+				actual, err := pmcrypto.DecryptWithAAD(*vault.SecureNotes[0].Notes, key, fieldAAD(&vault.SecureNotes[0], "credential_record.notes"))
+				require.NoError(t, err)
+				require.Equal(t, notes, actual)
+			},
+		},
+		{
+			Name: "success_wrap_derived_key",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				salt, err := pmcrypto.NewSalt()
+				require.NoError(t, err)
+
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: hashedPassword, VaultVersion: model.LatestVaultVersion}
+				user.SetKDF(salt, testKDFParams)
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				var updated *model.User
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						updated = u
+						return u, nil
+					})
+
+				mocks.TwoFactorRepository.EXPECT().
+					GetTOTP(user.ID).
+					Return(nil, pmerror.ErrNotFound)
+
+				_, _, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				require.Equal(t, user.ID, updated.ID)
+				key, err := c.unwrapDataKey(updated)
+				require.NoError(t, err)
+				require.Equal(t, string(pmcrypto.DeriveKey(password, salt, testKDFParams)), key)
+			},
+		},
+		{
+			Name: "success_upgrade_vault",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: hashedPassword}
+				key, err := c.newDataKey(user)
+				require.NoError(t, err)
+
+				record := model.NewCredentialRecord("Work Mail", nil, user.ID)
+				require.NoError(t, c.encryptRecord(record, key))
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				mocks.RecordRepository.EXPECT().
+					GetAll(user.ID).
+					Return([]model.CredentialRecord{*record}, nil, nil, nil, nil)
+
+				var upgraded *model.User
+				var vault *model.Vault
+				mocks.RecordRepository.EXPECT().
+					Rekey(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(u *model.User, v *model.Vault) error {
+						upgraded, vault = u, v
+						return nil
+					})
+
+				mocks.TwoFactorRepository.EXPECT().
+					GetTOTP(user.ID).
+					Return(nil, pmerror.ErrNotFound)
+
+				_, _, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				// records written before indexes are indexed, the data key stays the same
+				require.Equal(t, model.LatestVaultVersion, upgraded.VaultVersion)
+				actual, err := c.unwrapDataKey(upgraded)
+				require.NoError(t, err)
+				require.Equal(t, key, actual)
+				require.Len(t, vault.Index[record.ID], 2)
+				require.Equal(t, 2, vault.SecureNotes[0].Revision)
+			},
+		},
+		{
+			Name: "success_rewrap_data_key",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				keyring := c.config.Keys.(*pmcrypto.Keyring)
+				require.NoError(t, keyring.Add("old", []byte("abcdefghijklmnopqrstuvwxyz123456")))
+				require.NoError(t, keyring.SetActive("old"))
+
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: hashedPassword, VaultVersion: model.LatestVaultVersion}
+				key, err := c.newDataKey(user)
+				require.NoError(t, err)
+
+				require.NoError(t, keyring.SetActive(testServerKeyID))
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				var updated *model.User
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						updated = u
+						return u, nil
+					})
+
+				mocks.TwoFactorRepository.EXPECT().
+					GetTOTP(user.ID).
+					Return(nil, pmerror.ErrNotFound)
+
+				_, _, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				keyID, err := pmcrypto.KeyIDOf(updated.DataKey)
+				require.NoError(t, err)
+				require.Equal(t, testServerKeyID, keyID)
+
+				actual, err := c.unwrapDataKey(updated)
+				require.NoError(t, err)
+				require.Equal(t, key, actual)
+			},
+		},
+		{
+			Name: "success_hash_encrypted_password",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: encryptedPassword, VaultVersion: model.LatestVaultVersion}
+				_, err := c.newDataKey(user)
+				require.NoError(t, err)
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				var updated *model.User
+				mocks.UserRepository.EXPECT().
+					Update(gomock.Any()).
+					DoAndReturn(func(u *model.User) (*model.User, error) {
+						updated = u
+						return u, nil
+					})
+
+				mocks.TwoFactorRepository.EXPECT().
+					GetTOTP(user.ID).
+					Return(nil, pmerror.ErrNotFound)
+
+				_, _, err = c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				require.True(t, pmcrypto.IsPasswordHash(updated.Password))
+				ok, err := pmcrypto.VerifyPassword(password, updated.Password)
+				require.NoError(t, err)
+				require.True(t, ok)
+			},
+		},
+		{
+			Name: "error_wrong_password",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				for _, stored := range []string{hashedPassword, encryptedPassword, legacyPassword} {
+					user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: stored}
+
+					mocks.UserRepository.EXPECT().
+						GetByName(user.Name).
+						Return(user, nil)
+
+					_, _, err := c.Login(&model.UserForm{Name: &user.Name, Password: pmpointer.String("wrong")})
+					require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
+
+func TestController_Prelogin(t *testing.T) {
+	testCases := []controllerTestCase{
+		{
+			Name: "success_server_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				prelogin, err := c.Prelogin("Test User Name")
+				require.NoError(t, err)
+				require.Equal(t, &model.Prelogin{Mode: string(CryptoModeServer)}, prelogin)
+			},
+		},
+		{
+			Name: "success_client_mode",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+
+				salt, err := pmcrypto.NewSalt()
+				require.NoError(t, err)
+
+				user := &model.User{ID: uuid.New(), Name: "Test User Name"}
+				user.SetKDF(salt, pmcrypto.DefaultKDFParams)
+
+				mocks.UserRepository.EXPECT().
+					GetByName(user.Name).
+					Return(user, nil)
+
+				prelogin, err := c.Prelogin(user.Name)
+				require.NoError(t, err)
+
+				expected := &model.Prelogin{Mode: string(CryptoModeClient)}
+				expected.SetKDF(salt, pmcrypto.DefaultKDFParams)
+				require.Equal(t, expected, prelogin)
+			},
+		},
+		{
+			Name: "success_client_mode_unknown_user",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				c.config.Mode = CryptoModeClient
+
+				mocks.UserRepository.EXPECT().
+					GetByName(gomock.Any()).
+					Return(nil, pmerror.ErrNotFound).
+					Times(3)
+
+				first, err := c.Prelogin("Unknown User")
+				require.NoError(t, err)
+				require.Len(t, first.Salt, pmcrypto.SaltSize)
+				require.Equal(t, testKDFParams.Time, first.Time)
+
+				second, err := c.Prelogin("Unknown User")
+				require.NoError(t, err)
+				require.Equal(t, first, second)
+
+				other, err := c.Prelogin("Other Unknown User")
+				require.NoError(t, err)
+				require.NotEqual(t, first.Salt, other.Salt)
+
+				// salts only depend on the configured secret, so restarts and other instances report the same ones
+				mac := hmac.New(sha256.New, []byte(testPreloginSecret))
+				mac.Write([]byte("Unknown User"))
+				require.Equal(t, mac.Sum(nil)[:pmcrypto.SaltSize], first.Salt)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
+
+func TestController_UpdateUser(t *testing.T) {
+	admin := &model.User{ID: uuid.New(), Name: "admin", IsAdmin: true}
+	member := &model.User{ID: uuid.New(), Name: "member"}
+	directoryDN := "uid=carol,dc=example,dc=org"
+	carol := &model.User{ID: uuid.New(), Name: "carol", DirectoryDN: &directoryDN}
+
+	// setUp serves the users and starts a session of member, specific lookups go before the session store
+	setUp := func(t *testing.T, c *Controller, mocks *controllerMocks) *model.Session {
+		for _, user := range []*model.User{admin, member, carol} {
+			mocks.UserRepository.EXPECT().Get(user.ID).AnyTimes().Return(user, nil)
+		}
+		mocks.UserRepository.EXPECT().
+			Update(gomock.Any()).
+			AnyTimes().
+			DoAndReturn(func(user *model.User) (*model.User, error) {
+				return user, nil
+			})
+		expectSessionStore(mocks)
+		expectAccessTokenStore(mocks)
+
+		session, _, err := c.CreateSession(member.ID, "Test Agent")
+		require.NoError(t, err)
+
+		return session
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_own_password",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				session := setUp(t, c, mocks)
+
+				_, err := c.UpdateUser(member.ID, &model.UserForm{Password: pmpointer.String("New Password")}, member.ID)
+				require.NoError(t, err)
+				require.ErrorIs(t, c.ValidateSession(member.ID, session.ID), pmerror.ErrUnauthorized)
+			},
+		},
+		{
+			Name: "success_admin",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				session := setUp(t, c, mocks)
+
+				// renaming keeps the user signed in
+				_, err := c.UpdateUser(member.ID, &model.UserForm{Name: pmpointer.String("renamed")}, admin.ID)
+				require.NoError(t, err)
+				require.NoError(t, c.ValidateSession(member.ID, session.ID))
+
+				_, err = c.UpdateUser(member.ID, &model.UserForm{Password: pmpointer.String("New Password")}, admin.ID)
+				require.NoError(t, err)
+				require.ErrorIs(t, c.ValidateSession(member.ID, session.ID), pmerror.ErrUnauthorized)
+
+				users := []model.User{*admin, *member}
+				mocks.UserRepository.EXPECT().GetAll().Return(users, nil)
+				mocks.UserRepository.EXPECT().Delete(member.ID).Return(member, nil)
+
+				all, err := c.AllUsers(admin.ID)
+				require.NoError(t, err)
+				require.Equal(t, users, all)
+
+				_, err = c.GetUser(member.ID, admin.ID)
+				require.NoError(t, err)
+
+				_, err = c.DeleteUser(member.ID, admin.ID)
+				require.NoError(t, err)
+			},
+		},
+		{
+			Name: "error_not_admin",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				setUp(t, c, mocks)
+
+				_, err := c.UpdateUser(admin.ID, &model.UserForm{Password: pmpointer.String("New Password")}, member.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.DeleteUser(admin.ID, member.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.GetUser(admin.ID, member.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.AllUsers(member.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+
+				_, err = c.GetUser(member.ID, member.ID)
+				require.NoError(t, err)
+			},
+		},
+		{
+			Name: "error_directory_user",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				setUp(t, c, mocks)
+
+				_, err := c.UpdateUser(carol.ID, &model.UserForm{Password: pmpointer.String("New Password")}, admin.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+			},
+		},
+		{
+			Name: "error_sso_required",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				session := setUp(t, c, mocks)
+				c.config.OIDC = &OIDCConfig{Required: true}
+				mocks.OIDCRepository.EXPECT().GetPolicy().AnyTimes().Return(nil, pmerror.ErrNotFound)
+
+				_, err := c.UpdateUser(member.ID, &model.UserForm{Password: pmpointer.String("New Password")}, admin.ID)
+				require.ErrorIs(t, err, pmerror.ErrForbidden)
+				require.NoError(t, c.ValidateSession(member.ID, session.ID))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
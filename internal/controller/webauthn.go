@@ -0,0 +1,286 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmtime"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
+)
+
+// BeginWebAuthnRegistration returns options to create a passkey for userID,
+// authenticators already registered for it are excluded
+func (c *Controller) BeginWebAuthnRegistration(userID uuid.UUID) (*pmwebauthn.CreationOptions, error) {
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	credentials, err := c.webauthnRepo.GetCredentials(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get webauthn credentials: %w", err)
+	}
+
+	exclude := make([]pmwebauthn.CredentialDescriptor, len(credentials))
+	for i := range credentials {
+		exclude[i] = credentials[i].Descriptor()
+	}
+
+	challenge, err := c.newWebAuthnCeremony(model.WebAuthnRegistration, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := pmwebauthn.UserEntity{
+		ID:          pmwebauthn.Encoding.EncodeToString(userID[:]),
+		Name:        user.Name,
+		DisplayName: user.Name,
+	}
+
+	return c.config.RelyingParty.CreationOptions(challenge, entity, exclude, model.WebAuthnCeremonyTTL), nil
+}
+
+// FinishWebAuthnRegistration stores the credential created for options of BeginWebAuthnRegistration.
+// A passkey signs in without the password, so the password is checked again.
+func (c *Controller) FinishWebAuthnRegistration(userID uuid.UUID, form *model.WebAuthnRegistrationForm) (*model.WebAuthnCredential, error) {
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	user, err := c.userRepo.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	if err := c.confirmPresence(user, form.Password, form.OIDCState, now); err != nil {
+		return nil, err
+	}
+
+	challenge, err := c.useWebAuthnCeremony(form.Credential.Response.ClientDataJSON, model.WebAuthnRegistration, &userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := c.config.RelyingParty.VerifyRegistration(form.Credential, challenge, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrInvalidInput, err.Error())
+	}
+
+	credential := &model.WebAuthnCredential{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Name:         *form.Name,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		Transports:   form.Credential.Response.Transports,
+		CreatedOn:    now,
+	}
+
+	if err := c.webauthnRepo.CreateCredential(credential); err != nil {
+		return nil, fmt.Errorf("create webauthn credential: %w", err)
+	}
+
+	c.log.Infof("Registered webauthn credential %s of user %s", credential.ID, userID)
+
+	return credential, nil
+}
+
+func (c *Controller) WebAuthnCredentials(userID uuid.UUID) ([]model.WebAuthnCredential, error) {
+	return c.webauthnRepo.GetCredentials(userID)
+}
+
+func (c *Controller) DeleteWebAuthnCredential(userID, id uuid.UUID) (*model.WebAuthnCredential, error) {
+	credential, err := c.webauthnRepo.DeleteCredential(userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("delete webauthn credential: %w", err)
+	}
+
+	c.log.Infof("Deleted webauthn credential %s of user %s", id, userID)
+
+	return credential, nil
+}
+
+// BeginWebAuthnLogin returns options to sign in with any passkey, the user is known once one answers
+func (c *Controller) BeginWebAuthnLogin() (*pmwebauthn.RequestOptions, error) {
+	if err := c.passwordLoginAllowed(); err != nil {
+		return nil, err
+	}
+
+	challenge, err := c.newWebAuthnCeremony(model.WebAuthnLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.config.RelyingParty.RequestOptions(challenge, []pmwebauthn.CredentialDescriptor{}, pmwebauthn.UserVerificationRequired, model.WebAuthnCeremonyTTL), nil
+}
+
+// WebAuthnLogin verifies an assertion answering options of BeginWebAuthnLogin and returns the user signing in.
+// The authenticator must have verified the user, with a PIN or biometrics, so no second factor is asked for.
+func (c *Controller) WebAuthnLogin(form *model.WebAuthnLoginForm) (uuid.UUID, error) {
+	if err := c.passwordLoginAllowed(); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if err := form.Validate(); err != nil {
+		return uuid.UUID{}, fmt.Errorf("validate: %w", err)
+	}
+
+	credential, err := c.verifyAssertion(form.Credential, model.WebAuthnLogin, nil, true, pmtime.TruncateToMillisecond(time.Now().UTC()))
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	c.log.Infof("User %s signed in with webauthn credential %s", credential.UserID, credential.ID)
+
+	return credential.UserID, nil
+}
+
+// BeginWebAuthnSecondFactor returns options to answer a challenge Login issued with a security key of its user
+func (c *Controller) BeginWebAuthnSecondFactor(form *model.WebAuthnOptionsForm) (*pmwebauthn.RequestOptions, error) {
+	if err := form.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	challenge, err := c.twoFARepo.GetChallenge(hashToken(*form.ChallengeToken))
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown login challenge", pmerror.ErrUnauthorized)
+	} else if err != nil {
+		return nil, fmt.Errorf("get login challenge: %w", err)
+	}
+
+	if !challenge.Active(pmtime.TruncateToMillisecond(time.Now().UTC())) {
+		return nil, fmt.Errorf("%w: login challenge is used, expired or out of attempts, log in again", pmerror.ErrUnauthorized)
+	}
+
+	credentials, err := c.webauthnRepo.GetCredentials(challenge.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("get webauthn credentials: %w", err)
+	}
+
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("%w: no security keys are registered", pmerror.ErrInvalidInput)
+	}
+
+	allow := make([]pmwebauthn.CredentialDescriptor, len(credentials))
+	for i := range credentials {
+		allow[i] = credentials[i].Descriptor()
+	}
+
+	webauthnChallenge, err := c.newWebAuthnCeremony(model.WebAuthnSecondFactor, &challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.config.RelyingParty.RequestOptions(webauthnChallenge, allow, pmwebauthn.UserVerificationPreferred, model.WebAuthnCeremonyTTL), nil
+}
+
+func (c *Controller) newWebAuthnCeremony(kind model.WebAuthnCeremonyKind, userID *uuid.UUID) (string, error) {
+	challenge, err := pmwebauthn.NewChallenge()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", pmerror.ErrInternal, err.Error())
+	}
+
+	now := pmtime.TruncateToMillisecond(time.Now().UTC())
+	ceremony := &model.WebAuthnCeremony{
+		Challenge: challenge,
+		Kind:      kind,
+		UserID:    userID,
+		CreatedOn: now,
+		ExpiresOn: now.Add(model.WebAuthnCeremonyTTL),
+	}
+
+	if err := c.webauthnRepo.CreateCeremony(ceremony); err != nil {
+		return "", fmt.Errorf("create webauthn ceremony: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// useWebAuthnCeremony marks the ceremony clientDataJSON answers used and returns its challenge.
+// Ceremonies of another kind or user, expired or used are reported as pmerror.ErrInvalidInput.
+func (c *Controller) useWebAuthnCeremony(clientDataJSON string, kind model.WebAuthnCeremonyKind, userID *uuid.UUID, now time.Time) (string, error) {
+	challenge, err := pmwebauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", pmerror.ErrInvalidInput, err.Error())
+	}
+
+	ceremony, err := c.webauthnRepo.GetCeremony(challenge)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return "", fmt.Errorf("%w: unknown webauthn challenge", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return "", fmt.Errorf("get webauthn ceremony: %w", err)
+	}
+
+	if ceremony.Kind != kind || (userID != nil && (ceremony.UserID == nil || *ceremony.UserID != *userID)) {
+		return "", fmt.Errorf("%w: webauthn challenge was issued for another ceremony", pmerror.ErrInvalidInput)
+	}
+
+	if !ceremony.Active(now) {
+		return "", fmt.Errorf("%w: webauthn challenge is used or expired", pmerror.ErrInvalidInput)
+	}
+
+	if err := c.webauthnRepo.UseCeremony(challenge, now); errors.Is(err, pmerror.ErrNotFound) {
+		return "", fmt.Errorf("%w: webauthn challenge was used meanwhile", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return "", fmt.Errorf("use webauthn ceremony: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// verifyAssertion checks an assertion answering a ceremony of kind and returns the credential that signed it.
+// When userID is set the credential must be one of that user. Assertions that do not verify are reported as
+// pmerror.ErrInvalidInput, as are signature counters that did not increase since they hint at a cloned authenticator.
+func (c *Controller) verifyAssertion(assertion *pmwebauthn.AssertionResponse, kind model.WebAuthnCeremonyKind, userID *uuid.UUID, requireUV bool, now time.Time) (*model.WebAuthnCredential, error) {
+	challenge, err := c.useWebAuthnCeremony(assertion.Response.ClientDataJSON, kind, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID, err := assertion.CredentialID()
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid credential ID", pmerror.ErrInvalidInput)
+	}
+
+	credential, err := c.webauthnRepo.GetCredential(credentialID)
+	if errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown webauthn credential", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return nil, fmt.Errorf("get webauthn credential: %w", err)
+	}
+
+	if userID != nil && credential.UserID != *userID {
+		return nil, fmt.Errorf("%w: unknown webauthn credential", pmerror.ErrInvalidInput)
+	}
+
+	if assertion.Response.UserHandle != "" {
+		handle, err := assertion.UserHandle()
+		if err != nil || !bytes.Equal(handle, credential.UserID[:]) {
+			return nil, fmt.Errorf("%w: user handle does not match the credential", pmerror.ErrInvalidInput)
+		}
+	}
+
+	signCount, err := c.config.RelyingParty.VerifyAssertion(assertion, challenge, credential.Credential(), requireUV)
+	if errors.Is(err, pmwebauthn.ErrSignCount) {
+		c.log.Warnf("Rejected webauthn credential %s of user %s, it may be cloned: %s", credential.ID, credential.UserID, err.Error())
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrInvalidInput, err.Error())
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %s", pmerror.ErrInvalidInput, err.Error())
+	}
+
+	if err := c.webauthnRepo.UseCredential(credential.ID, signCount, now); errors.Is(err, pmerror.ErrNotFound) {
+		return nil, fmt.Errorf("%w: webauthn credential was used meanwhile", pmerror.ErrInvalidInput)
+	} else if err != nil {
+		return nil, fmt.Errorf("use webauthn credential: %w", err)
+	}
+
+	return credential, nil
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmpointer"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn/webauthntest"
+)
+
+type webauthnStore struct {
+	credentials map[uuid.UUID]*model.WebAuthnCredential
+	ceremonies  map[string]*model.WebAuthnCeremony
+}
+
+// expectWebAuthnStore backs the webauthn repository mock with memory
+func expectWebAuthnStore(mocks *controllerMocks) *webauthnStore {
+	store := &webauthnStore{
+		credentials: make(map[uuid.UUID]*model.WebAuthnCredential),
+		ceremonies:  make(map[string]*model.WebAuthnCeremony),
+	}
+
+	r := mocks.WebAuthnRepository.EXPECT()
+	r.CreateCredential(gomock.Any()).AnyTimes().DoAndReturn(func(credential *model.WebAuthnCredential) error {
+		stored := *credential
+		store.credentials[credential.ID] = &stored
+		return nil
+	})
+	r.GetCredentials(gomock.Any()).AnyTimes().DoAndReturn(func(userID uuid.UUID) ([]model.WebAuthnCredential, error) {
+		var result []model.WebAuthnCredential
+		for _, credential := range store.credentials {
+			if credential.UserID == userID {
+				result = append(result, *credential)
+			}
+		}
+		return result, nil
+	})
+	r.GetCredential(gomock.Any()).AnyTimes().DoAndReturn(func(credentialID []byte) (*model.WebAuthnCredential, error) {
+		for _, credential := range store.credentials {
+			if bytes.Equal(credential.CredentialID, credentialID) {
+				result := *credential
+				return &result, nil
+			}
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.UseCredential(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(id uuid.UUID, signCount uint32, now time.Time) error {
+		credential := store.credentials[id]
+		if credential.SignCount >= signCount && (credential.SignCount != 0 || signCount != 0) {
+			return pmerror.ErrNotFound
+		}
+		credential.SignCount, credential.LastUsedOn = signCount, &now
+		return nil
+	})
+	r.DeleteCredential(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(userID, id uuid.UUID) (*model.WebAuthnCredential, error) {
+		credential, ok := store.credentials[id]
+		if !ok || credential.UserID != userID {
+			return nil, pmerror.ErrNotFound
+		}
+		delete(store.credentials, id)
+		return credential, nil
+	})
+	r.CreateCeremony(gomock.Any()).AnyTimes().DoAndReturn(func(ceremony *model.WebAuthnCeremony) error {
+		stored := *ceremony
+		store.ceremonies[ceremony.Challenge] = &stored
+		return nil
+	})
+	r.GetCeremony(gomock.Any()).AnyTimes().DoAndReturn(func(challenge string) (*model.WebAuthnCeremony, error) {
+		if ceremony, ok := store.ceremonies[challenge]; ok {
+			result := *ceremony
+			return &result, nil
+		}
+		return nil, pmerror.ErrNotFound
+	})
+	r.UseCeremony(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(challenge string, now time.Time) error {
+		ceremony := store.ceremonies[challenge]
+		if ceremony.UsedOn != nil {
+			return pmerror.ErrNotFound
+		}
+		ceremony.UsedOn = &now
+		return nil
+	})
+
+	return store
+}
+
+// registerTestAuthenticator registers a software authenticator for user
+func registerTestAuthenticator(t *testing.T, c *Controller, user *model.User, password string) (*webauthntest.Authenticator, *model.WebAuthnCredential) {
+	t.Helper()
+
+	authenticator := webauthntest.New(testRelyingParty.Origins[0])
+
+	options, err := c.BeginWebAuthnRegistration(user.ID)
+	require.NoError(t, err)
+
+	response, err := authenticator.Register(options)
+	require.NoError(t, err)
+
+	credential, err := c.FinishWebAuthnRegistration(user.ID, &model.WebAuthnRegistrationForm{
+		Name:       pmpointer.String("Test Key"),
+		Password:   &password,
+		Credential: response,
+	})
+	require.NoError(t, err)
+
+	return authenticator, credential
+}
+
+func TestController_WebAuthn(t *testing.T) {
+	password := "Test User Password"
+	hashedPassword, err := pmcrypto.HashPassword(password, testKDFParams)
+	require.NoError(t, err)
+
+	newUser := func(t *testing.T, c *Controller, mocks *controllerMocks) *model.User {
+		user := &model.User{ID: uuid.New(), Name: "Test User Name", Password: hashedPassword, VaultVersion: model.LatestVaultVersion}
+		_, err := c.newDataKey(user)
+		require.NoError(t, err)
+
+		mocks.UserRepository.EXPECT().Get(user.ID).AnyTimes().Return(user, nil)
+		mocks.UserRepository.EXPECT().GetByName(user.Name).AnyTimes().Return(user, nil)
+
+		return user
+	}
+
+	testCases := []controllerTestCase{
+		{
+			Name: "success_passwordless_login",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectWebAuthnStore(mocks)
+				user := newUser(t, c, mocks)
+				authenticator, credential := registerTestAuthenticator(t, c, user, password)
+
+				// the same authenticator is not registered twice
+				options, err := c.BeginWebAuthnRegistration(user.ID)
+				require.NoError(t, err)
+				require.Len(t, options.ExcludeCredentials, 1)
+				_, err = authenticator.Register(options)
+				require.Error(t, err)
+
+				loginOptions, err := c.BeginWebAuthnLogin()
+				require.NoError(t, err)
+				require.Empty(t, loginOptions.AllowCredentials)
+
+				assertion, err := authenticator.Assert(loginOptions)
+				require.NoError(t, err)
+
+				id, err := c.WebAuthnLogin(&model.WebAuthnLoginForm{Credential: assertion})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+
+				_, err = c.WebAuthnLogin(&model.WebAuthnLoginForm{Credential: assertion})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+
+				credentials, err := c.WebAuthnCredentials(user.ID)
+				require.NoError(t, err)
+				require.Len(t, credentials, 1)
+				require.Equal(t, uint32(1), credentials[0].SignCount)
+
+				_, err = c.DeleteWebAuthnCredential(user.ID, credential.ID)
+				require.NoError(t, err)
+
+				loginOptions, err = c.BeginWebAuthnLogin()
+				require.NoError(t, err)
+				assertion, err = authenticator.Assert(loginOptions)
+				require.NoError(t, err)
+				_, err = c.WebAuthnLogin(&model.WebAuthnLoginForm{Credential: assertion})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "success_second_factor",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectTwoFactorStore(mocks)
+				expectWebAuthnStore(mocks)
+				user := newUser(t, c, mocks)
+				enableTestTOTP(t, c, user)
+				authenticator, _ := registerTestAuthenticator(t, c, user, password)
+
+				_, challenge, err := c.Login(&model.UserForm{Name: &user.Name, Password: &password})
+				require.NoError(t, err)
+
+				options, err := c.BeginWebAuthnSecondFactor(&model.WebAuthnOptionsForm{ChallengeToken: &challenge})
+				require.NoError(t, err)
+				require.Len(t, options.AllowCredentials, 1)
+
+				// an assertion for a passwordless login does not answer the second factor
+				loginOptions, err := c.BeginWebAuthnLogin()
+				require.NoError(t, err)
+				other, err := authenticator.Assert(loginOptions)
+				require.NoError(t, err)
+				_, err = c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, WebAuthn: other})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+
+				assertion, err := authenticator.Assert(options)
+				require.NoError(t, err)
+
+				id, err := c.CompleteLogin(&model.SecondFactorForm{ChallengeToken: &challenge, WebAuthn: assertion})
+				require.NoError(t, err)
+				require.Equal(t, user.ID, id)
+			},
+		},
+		{
+			Name: "error_sign_count_regression",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectWebAuthnStore(mocks)
+				user := newUser(t, c, mocks)
+				authenticator, credential := registerTestAuthenticator(t, c, user, password)
+
+				for i := 0; i < 2; i++ {
+					options, err := c.BeginWebAuthnLogin()
+					require.NoError(t, err)
+					assertion, err := authenticator.Assert(options)
+					require.NoError(t, err)
+					_, err = c.WebAuthnLogin(&model.WebAuthnLoginForm{Credential: assertion})
+					require.NoError(t, err)
+				}
+
+				authenticator.SetSignCount(credential.Descriptor().ID, 0)
+
+				loginOptions, err := c.BeginWebAuthnLogin()
+				require.NoError(t, err)
+				assertion, err := authenticator.Assert(loginOptions)
+				require.NoError(t, err)
+				_, err = c.WebAuthnLogin(&model.WebAuthnLoginForm{Credential: assertion})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+		{
+			Name: "error_registration_wrong_password",
+			Run: func(t *testing.T, c *Controller, mocks *controllerMocks) {
+				expectWebAuthnStore(mocks)
+				user := newUser(t, c, mocks)
+				authenticator := webauthntest.New(testRelyingParty.Origins[0])
+
+				options, err := c.BeginWebAuthnRegistration(user.ID)
+				require.NoError(t, err)
+				response, err := authenticator.Register(options)
+				require.NoError(t, err)
+
+				_, err = c.FinishWebAuthnRegistration(user.ID, &model.WebAuthnRegistrationForm{
+					Name:       pmpointer.String("Test Key"),
+					Password:   pmpointer.String("wrong"),
+					Credential: response,
+				})
+				require.True(t, errors.Is(err, pmerror.ErrInvalidInput))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			tc.runTests(t)
+		})
+	}
+}
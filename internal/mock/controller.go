@@ -11,8 +11,10 @@ package mock
 
 import (
 	reflect "reflect"
+	time "time"
 
 	model "github.com/ChillyWR/PasswordManager/model"
+	pmratelimit "github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
 	uuid "github.com/google/uuid"
 	gomock "go.uber.org/mock/gomock"
 )
@@ -41,78 +43,78 @@ func (m *MockRecordRepository) EXPECT() *MockRecordRepositoryMockRecorder {
 }
 
 // CreateCard mocks base method.
-func (m *MockRecordRepository) CreateCard(record *model.CardRecord) (*model.CardRecord, error) {
+func (m *MockRecordRepository) CreateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateCard", record)
+	ret := m.ctrl.Call(m, "CreateCard", userID, record, index)
 	ret0, _ := ret[0].(*model.CardRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateCard indicates an expected call of CreateCard.
-func (mr *MockRecordRepositoryMockRecorder) CreateCard(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) CreateCard(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCard", reflect.TypeOf((*MockRecordRepository)(nil).CreateCard), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCard", reflect.TypeOf((*MockRecordRepository)(nil).CreateCard), userID, record, index)
 }
 
 // CreateCredentialRecord mocks base method.
-func (m *MockRecordRepository) CreateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error) {
+func (m *MockRecordRepository) CreateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateCredentialRecord", record)
+	ret := m.ctrl.Call(m, "CreateCredentialRecord", userID, record, index)
 	ret0, _ := ret[0].(*model.CredentialRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateCredentialRecord indicates an expected call of CreateCredentialRecord.
-func (mr *MockRecordRepositoryMockRecorder) CreateCredentialRecord(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) CreateCredentialRecord(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCredentialRecord", reflect.TypeOf((*MockRecordRepository)(nil).CreateCredentialRecord), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCredentialRecord", reflect.TypeOf((*MockRecordRepository)(nil).CreateCredentialRecord), userID, record, index)
 }
 
 // CreateIdentity mocks base method.
-func (m *MockRecordRepository) CreateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error) {
+func (m *MockRecordRepository) CreateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateIdentity", record)
+	ret := m.ctrl.Call(m, "CreateIdentity", userID, record, index)
 	ret0, _ := ret[0].(*model.IdentityRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateIdentity indicates an expected call of CreateIdentity.
-func (mr *MockRecordRepositoryMockRecorder) CreateIdentity(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) CreateIdentity(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdentity", reflect.TypeOf((*MockRecordRepository)(nil).CreateIdentity), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdentity", reflect.TypeOf((*MockRecordRepository)(nil).CreateIdentity), userID, record, index)
 }
 
 // CreateLogin mocks base method.
-func (m *MockRecordRepository) CreateLogin(record *model.LoginRecord) (*model.LoginRecord, error) {
+func (m *MockRecordRepository) CreateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateLogin", record)
+	ret := m.ctrl.Call(m, "CreateLogin", userID, record, index)
 	ret0, _ := ret[0].(*model.LoginRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateLogin indicates an expected call of CreateLogin.
-func (mr *MockRecordRepositoryMockRecorder) CreateLogin(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) CreateLogin(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLogin", reflect.TypeOf((*MockRecordRepository)(nil).CreateLogin), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLogin", reflect.TypeOf((*MockRecordRepository)(nil).CreateLogin), userID, record, index)
 }
 
 // Delete mocks base method.
-func (m *MockRecordRepository) Delete(id uuid.UUID) (*model.CredentialRecord, error) {
+func (m *MockRecordRepository) Delete(userID, id uuid.UUID) (*model.CredentialRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", id)
+	ret := m.ctrl.Call(m, "Delete", userID, id)
 	ret0, _ := ret[0].(*model.CredentialRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockRecordRepositoryMockRecorder) Delete(id any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) Delete(userID, id any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRecordRepository)(nil).Delete), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRecordRepository)(nil).Delete), userID, id)
 }
 
 // GetAll mocks base method.
@@ -133,124 +135,140 @@ func (mr *MockRecordRepositoryMockRecorder) GetAll(userID any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockRecordRepository)(nil).GetAll), userID)
 }
 
-// GetCard mocks base method.
-func (m *MockRecordRepository) GetCard(id uuid.UUID) (*model.CardRecord, error) {
+// GetRecord mocks base method.
+func (m *MockRecordRepository) GetRecord(userID, id uuid.UUID) (any, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCard", id)
-	ret0, _ := ret[0].(*model.CardRecord)
+	ret := m.ctrl.Call(m, "GetRecord", userID, id)
+	ret0, _ := ret[0].(any)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetCard indicates an expected call of GetCard.
-func (mr *MockRecordRepositoryMockRecorder) GetCard(id any) *gomock.Call {
+// GetRecord indicates an expected call of GetRecord.
+func (mr *MockRecordRepositoryMockRecorder) GetRecord(userID, id any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCard", reflect.TypeOf((*MockRecordRepository)(nil).GetCard), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecord", reflect.TypeOf((*MockRecordRepository)(nil).GetRecord), userID, id)
 }
 
-// GetCredentialRecord mocks base method.
-func (m *MockRecordRepository) GetCredentialRecord(id uuid.UUID) (*model.CredentialRecord, error) {
+// GetVault mocks base method.
+func (m *MockRecordRepository) GetVault(userID uuid.UUID, ids []uuid.UUID) (*model.User, *model.Vault, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCredentialRecord", id)
-	ret0, _ := ret[0].(*model.CredentialRecord)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetVault", userID, ids)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(*model.Vault)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
-// GetCredentialRecord indicates an expected call of GetCredentialRecord.
-func (mr *MockRecordRepositoryMockRecorder) GetCredentialRecord(id any) *gomock.Call {
+// GetVault indicates an expected call of GetVault.
+func (mr *MockRecordRepositoryMockRecorder) GetVault(userID, ids any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredentialRecord", reflect.TypeOf((*MockRecordRepository)(nil).GetCredentialRecord), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVault", reflect.TypeOf((*MockRecordRepository)(nil).GetVault), userID, ids)
 }
 
-// GetIdentity mocks base method.
-func (m *MockRecordRepository) GetIdentity(id uuid.UUID) (*model.IdentityRecord, error) {
+// GetVaultRecord mocks base method.
+func (m *MockRecordRepository) GetVaultRecord(userID, id uuid.UUID) (*model.User, any, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetIdentity", id)
-	ret0, _ := ret[0].(*model.IdentityRecord)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret := m.ctrl.Call(m, "GetVaultRecord", userID, id)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(any)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
-// GetIdentity indicates an expected call of GetIdentity.
-func (mr *MockRecordRepositoryMockRecorder) GetIdentity(id any) *gomock.Call {
+// GetVaultRecord indicates an expected call of GetVaultRecord.
+func (mr *MockRecordRepositoryMockRecorder) GetVaultRecord(userID, id any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentity", reflect.TypeOf((*MockRecordRepository)(nil).GetIdentity), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVaultRecord", reflect.TypeOf((*MockRecordRepository)(nil).GetVaultRecord), userID, id)
 }
 
-// GetLogin mocks base method.
-func (m *MockRecordRepository) GetLogin(id uuid.UUID) (*model.LoginRecord, error) {
+// Rekey mocks base method.
+func (m *MockRecordRepository) Rekey(user *model.User, vault *model.Vault) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetLogin", id)
-	ret0, _ := ret[0].(*model.LoginRecord)
+	ret := m.ctrl.Call(m, "Rekey", user, vault)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rekey indicates an expected call of Rekey.
+func (mr *MockRecordRepositoryMockRecorder) Rekey(user, vault any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rekey", reflect.TypeOf((*MockRecordRepository)(nil).Rekey), user, vault)
+}
+
+// Search mocks base method.
+func (m *MockRecordRepository) Search(userID uuid.UUID, tokens []string) (map[string][]uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", userID, tokens)
+	ret0, _ := ret[0].(map[string][]uuid.UUID)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetLogin indicates an expected call of GetLogin.
-func (mr *MockRecordRepositoryMockRecorder) GetLogin(id any) *gomock.Call {
+// Search indicates an expected call of Search.
+func (mr *MockRecordRepositoryMockRecorder) Search(userID, tokens any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLogin", reflect.TypeOf((*MockRecordRepository)(nil).GetLogin), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockRecordRepository)(nil).Search), userID, tokens)
 }
 
 // UpdateCard mocks base method.
-func (m *MockRecordRepository) UpdateCard(record *model.CardRecord) (*model.CardRecord, error) {
+func (m *MockRecordRepository) UpdateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCard", record)
+	ret := m.ctrl.Call(m, "UpdateCard", userID, record, index)
 	ret0, _ := ret[0].(*model.CardRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateCard indicates an expected call of UpdateCard.
-func (mr *MockRecordRepositoryMockRecorder) UpdateCard(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) UpdateCard(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCard", reflect.TypeOf((*MockRecordRepository)(nil).UpdateCard), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCard", reflect.TypeOf((*MockRecordRepository)(nil).UpdateCard), userID, record, index)
 }
 
 // UpdateCredentialRecord mocks base method.
-func (m *MockRecordRepository) UpdateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error) {
+func (m *MockRecordRepository) UpdateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCredentialRecord", record)
+	ret := m.ctrl.Call(m, "UpdateCredentialRecord", userID, record, index)
 	ret0, _ := ret[0].(*model.CredentialRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateCredentialRecord indicates an expected call of UpdateCredentialRecord.
-func (mr *MockRecordRepositoryMockRecorder) UpdateCredentialRecord(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) UpdateCredentialRecord(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCredentialRecord", reflect.TypeOf((*MockRecordRepository)(nil).UpdateCredentialRecord), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCredentialRecord", reflect.TypeOf((*MockRecordRepository)(nil).UpdateCredentialRecord), userID, record, index)
 }
 
 // UpdateIdentity mocks base method.
-func (m *MockRecordRepository) UpdateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error) {
+func (m *MockRecordRepository) UpdateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateIdentity", record)
+	ret := m.ctrl.Call(m, "UpdateIdentity", userID, record, index)
 	ret0, _ := ret[0].(*model.IdentityRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateIdentity indicates an expected call of UpdateIdentity.
-func (mr *MockRecordRepositoryMockRecorder) UpdateIdentity(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) UpdateIdentity(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIdentity", reflect.TypeOf((*MockRecordRepository)(nil).UpdateIdentity), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIdentity", reflect.TypeOf((*MockRecordRepository)(nil).UpdateIdentity), userID, record, index)
 }
 
 // UpdateLogin mocks base method.
-func (m *MockRecordRepository) UpdateLogin(record *model.LoginRecord) (*model.LoginRecord, error) {
+func (m *MockRecordRepository) UpdateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateLogin", record)
+	ret := m.ctrl.Call(m, "UpdateLogin", userID, record, index)
 	ret0, _ := ret[0].(*model.LoginRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateLogin indicates an expected call of UpdateLogin.
-func (mr *MockRecordRepositoryMockRecorder) UpdateLogin(record any) *gomock.Call {
+func (mr *MockRecordRepositoryMockRecorder) UpdateLogin(userID, record, index any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLogin", reflect.TypeOf((*MockRecordRepository)(nil).UpdateLogin), record)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLogin", reflect.TypeOf((*MockRecordRepository)(nil).UpdateLogin), userID, record, index)
 }
 
 // MockUserRepository is a mock of UserRepository interface.
@@ -336,6 +354,21 @@ func (mr *MockUserRepositoryMockRecorder) GetAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockUserRepository)(nil).GetAll))
 }
 
+// GetByDirectoryDN mocks base method.
+func (m *MockUserRepository) GetByDirectoryDN(dn string) (*model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByDirectoryDN", dn)
+	ret0, _ := ret[0].(*model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByDirectoryDN indicates an expected call of GetByDirectoryDN.
+func (mr *MockUserRepositoryMockRecorder) GetByDirectoryDN(dn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByDirectoryDN", reflect.TypeOf((*MockUserRepository)(nil).GetByDirectoryDN), dn)
+}
+
 // GetByName mocks base method.
 func (m *MockUserRepository) GetByName(name string) (*model.User, error) {
 	m.ctrl.T.Helper()
@@ -351,6 +384,35 @@ func (mr *MockUserRepositoryMockRecorder) GetByName(name any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByName", reflect.TypeOf((*MockUserRepository)(nil).GetByName), name)
 }
 
+// GetDirectoryUsers mocks base method.
+func (m *MockUserRepository) GetDirectoryUsers() ([]model.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDirectoryUsers")
+	ret0, _ := ret[0].([]model.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDirectoryUsers indicates an expected call of GetDirectoryUsers.
+func (mr *MockUserRepositoryMockRecorder) GetDirectoryUsers() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirectoryUsers", reflect.TypeOf((*MockUserRepository)(nil).GetDirectoryUsers))
+}
+
+// SetDisabled mocks base method.
+func (m *MockUserRepository) SetDisabled(id uuid.UUID, disabledOn *time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetDisabled", id, disabledOn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDisabled indicates an expected call of SetDisabled.
+func (mr *MockUserRepositoryMockRecorder) SetDisabled(id, disabledOn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDisabled", reflect.TypeOf((*MockUserRepository)(nil).SetDisabled), id, disabledOn)
+}
+
 // Update mocks base method.
 func (m *MockUserRepository) Update(user *model.User) (*model.User, error) {
 	m.ctrl.T.Helper()
@@ -365,3 +427,1288 @@ func (mr *MockUserRepositoryMockRecorder) Update(user any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockUserRepository)(nil).Update), user)
 }
+
+// MockKeyRepository is a mock of KeyRepository interface.
+type MockKeyRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyRepositoryMockRecorder
+}
+
+// MockKeyRepositoryMockRecorder is the mock recorder for MockKeyRepository.
+type MockKeyRepositoryMockRecorder struct {
+	mock *MockKeyRepository
+}
+
+// NewMockKeyRepository creates a new mock instance.
+func NewMockKeyRepository(ctrl *gomock.Controller) *MockKeyRepository {
+	mock := &MockKeyRepository{ctrl: ctrl}
+	mock.recorder = &MockKeyRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeyRepository) EXPECT() *MockKeyRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ForEachEncrypted mocks base method.
+func (m *MockKeyRepository) ForEachEncrypted(fn func(string, bool) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForEachEncrypted", fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForEachEncrypted indicates an expected call of ForEachEncrypted.
+func (mr *MockKeyRepositoryMockRecorder) ForEachEncrypted(fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForEachEncrypted", reflect.TypeOf((*MockKeyRepository)(nil).ForEachEncrypted), fn)
+}
+
+// MockRecoveryRepository is a mock of RecoveryRepository interface.
+type MockRecoveryRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRecoveryRepositoryMockRecorder
+}
+
+// MockRecoveryRepositoryMockRecorder is the mock recorder for MockRecoveryRepository.
+type MockRecoveryRepositoryMockRecorder struct {
+	mock *MockRecoveryRepository
+}
+
+// NewMockRecoveryRepository creates a new mock instance.
+func NewMockRecoveryRepository(ctrl *gomock.Controller) *MockRecoveryRepository {
+	mock := &MockRecoveryRepository{ctrl: ctrl}
+	mock.recorder = &MockRecoveryRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRecoveryRepository) EXPECT() *MockRecoveryRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddEvent mocks base method.
+func (m *MockRecoveryRepository) AddEvent(event *model.RecoveryEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddEvent", event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddEvent indicates an expected call of AddEvent.
+func (mr *MockRecoveryRepositoryMockRecorder) AddEvent(event any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddEvent", reflect.TypeOf((*MockRecoveryRepository)(nil).AddEvent), event)
+}
+
+// AddShare mocks base method.
+func (m *MockRecoveryRepository) AddShare(ceremonyID, trusteeID uuid.UUID, share string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddShare", ceremonyID, trusteeID, share)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddShare indicates an expected call of AddShare.
+func (mr *MockRecoveryRepositoryMockRecorder) AddShare(ceremonyID, trusteeID, share any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddShare", reflect.TypeOf((*MockRecoveryRepository)(nil).AddShare), ceremonyID, trusteeID, share)
+}
+
+// CloseCeremony mocks base method.
+func (m *MockRecoveryRepository) CloseCeremony(id uuid.UUID, status model.RecoveryCeremonyStatus) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseCeremony", id, status)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseCeremony indicates an expected call of CloseCeremony.
+func (mr *MockRecoveryRepositoryMockRecorder) CloseCeremony(id, status any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseCeremony", reflect.TypeOf((*MockRecoveryRepository)(nil).CloseCeremony), id, status)
+}
+
+// CreateCeremony mocks base method.
+func (m *MockRecoveryRepository) CreateCeremony(ceremony *model.RecoveryCeremony) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCeremony", ceremony)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateCeremony indicates an expected call of CreateCeremony.
+func (mr *MockRecoveryRepositoryMockRecorder) CreateCeremony(ceremony any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCeremony", reflect.TypeOf((*MockRecoveryRepository)(nil).CreateCeremony), ceremony)
+}
+
+// DeletePendingShares mocks base method.
+func (m *MockRecoveryRepository) DeletePendingShares(trusteeID uuid.UUID, userIDs []uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePendingShares", trusteeID, userIDs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePendingShares indicates an expected call of DeletePendingShares.
+func (mr *MockRecoveryRepositoryMockRecorder) DeletePendingShares(trusteeID, userIDs any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePendingShares", reflect.TypeOf((*MockRecoveryRepository)(nil).DeletePendingShares), trusteeID, userIDs)
+}
+
+// GetCeremonies mocks base method.
+func (m *MockRecoveryRepository) GetCeremonies(userID uuid.UUID) ([]model.RecoveryCeremony, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCeremonies", userID)
+	ret0, _ := ret[0].([]model.RecoveryCeremony)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCeremonies indicates an expected call of GetCeremonies.
+func (mr *MockRecoveryRepositoryMockRecorder) GetCeremonies(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCeremonies", reflect.TypeOf((*MockRecoveryRepository)(nil).GetCeremonies), userID)
+}
+
+// GetCeremony mocks base method.
+func (m *MockRecoveryRepository) GetCeremony(id uuid.UUID) (*model.RecoveryCeremony, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCeremony", id)
+	ret0, _ := ret[0].(*model.RecoveryCeremony)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCeremony indicates an expected call of GetCeremony.
+func (mr *MockRecoveryRepositoryMockRecorder) GetCeremony(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCeremony", reflect.TypeOf((*MockRecoveryRepository)(nil).GetCeremony), id)
+}
+
+// GetEvents mocks base method.
+func (m *MockRecoveryRepository) GetEvents(ceremonyID uuid.UUID) ([]model.RecoveryEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEvents", ceremonyID)
+	ret0, _ := ret[0].([]model.RecoveryEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEvents indicates an expected call of GetEvents.
+func (mr *MockRecoveryRepositoryMockRecorder) GetEvents(ceremonyID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEvents", reflect.TypeOf((*MockRecoveryRepository)(nil).GetEvents), ceremonyID)
+}
+
+// GetPendingShares mocks base method.
+func (m *MockRecoveryRepository) GetPendingShares(trusteeID uuid.UUID) ([]model.RecoveryShare, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPendingShares", trusteeID)
+	ret0, _ := ret[0].([]model.RecoveryShare)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingShares indicates an expected call of GetPendingShares.
+func (mr *MockRecoveryRepositoryMockRecorder) GetPendingShares(trusteeID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingShares", reflect.TypeOf((*MockRecoveryRepository)(nil).GetPendingShares), trusteeID)
+}
+
+// GetSetup mocks base method.
+func (m *MockRecoveryRepository) GetSetup(userID uuid.UUID) (*model.RecoverySetup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSetup", userID)
+	ret0, _ := ret[0].(*model.RecoverySetup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSetup indicates an expected call of GetSetup.
+func (mr *MockRecoveryRepositoryMockRecorder) GetSetup(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSetup", reflect.TypeOf((*MockRecoveryRepository)(nil).GetSetup), userID)
+}
+
+// GetShares mocks base method.
+func (m *MockRecoveryRepository) GetShares(ceremonyID uuid.UUID) (map[uuid.UUID]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetShares", ceremonyID)
+	ret0, _ := ret[0].(map[uuid.UUID]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetShares indicates an expected call of GetShares.
+func (mr *MockRecoveryRepositoryMockRecorder) GetShares(ceremonyID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetShares", reflect.TypeOf((*MockRecoveryRepository)(nil).GetShares), ceremonyID)
+}
+
+// SaveSetup mocks base method.
+func (m *MockRecoveryRepository) SaveSetup(setup *model.RecoverySetup, shares []model.RecoveryShare) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveSetup", setup, shares)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveSetup indicates an expected call of SaveSetup.
+func (mr *MockRecoveryRepositoryMockRecorder) SaveSetup(setup, shares any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveSetup", reflect.TypeOf((*MockRecoveryRepository)(nil).SaveSetup), setup, shares)
+}
+
+// MockSessionRepository is a mock of SessionRepository interface.
+type MockSessionRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSessionRepositoryMockRecorder
+}
+
+// MockSessionRepositoryMockRecorder is the mock recorder for MockSessionRepository.
+type MockSessionRepositoryMockRecorder struct {
+	mock *MockSessionRepository
+}
+
+// NewMockSessionRepository creates a new mock instance.
+func NewMockSessionRepository(ctrl *gomock.Controller) *MockSessionRepository {
+	mock := &MockSessionRepository{ctrl: ctrl}
+	mock.recorder = &MockSessionRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSessionRepository) EXPECT() *MockSessionRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockSessionRepository) Create(session *model.Session, token *model.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", session, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockSessionRepositoryMockRecorder) Create(session, token any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionRepository)(nil).Create), session, token)
+}
+
+// Get mocks base method.
+func (m *MockSessionRepository) Get(id uuid.UUID) (*model.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", id)
+	ret0, _ := ret[0].(*model.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockSessionRepositoryMockRecorder) Get(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockSessionRepository)(nil).Get), id)
+}
+
+// GetActive mocks base method.
+func (m *MockSessionRepository) GetActive(userID uuid.UUID, now time.Time) ([]model.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActive", userID, now)
+	ret0, _ := ret[0].([]model.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActive indicates an expected call of GetActive.
+func (mr *MockSessionRepositoryMockRecorder) GetActive(userID, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActive", reflect.TypeOf((*MockSessionRepository)(nil).GetActive), userID, now)
+}
+
+// GetRefreshToken mocks base method.
+func (m *MockSessionRepository) GetRefreshToken(hash string) (*model.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshToken", hash)
+	ret0, _ := ret[0].(*model.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshToken indicates an expected call of GetRefreshToken.
+func (mr *MockSessionRepositoryMockRecorder) GetRefreshToken(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshToken", reflect.TypeOf((*MockSessionRepository)(nil).GetRefreshToken), hash)
+}
+
+// Revoke mocks base method.
+func (m *MockSessionRepository) Revoke(userID, id uuid.UUID, now time.Time) (*model.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", userID, id, now)
+	ret0, _ := ret[0].(*model.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Revoke indicates an expected call of Revoke.
+func (mr *MockSessionRepositoryMockRecorder) Revoke(userID, id, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockSessionRepository)(nil).Revoke), userID, id, now)
+}
+
+// Rotate mocks base method.
+func (m *MockSessionRepository) Rotate(hash string, next *model.RefreshToken, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rotate", hash, next, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rotate indicates an expected call of Rotate.
+func (mr *MockSessionRepositoryMockRecorder) Rotate(hash, next, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rotate", reflect.TypeOf((*MockSessionRepository)(nil).Rotate), hash, next, now)
+}
+
+// MockAccessTokenRepository is a mock of AccessTokenRepository interface.
+type MockAccessTokenRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccessTokenRepositoryMockRecorder
+}
+
+// MockAccessTokenRepositoryMockRecorder is the mock recorder for MockAccessTokenRepository.
+type MockAccessTokenRepositoryMockRecorder struct {
+	mock *MockAccessTokenRepository
+}
+
+// NewMockAccessTokenRepository creates a new mock instance.
+func NewMockAccessTokenRepository(ctrl *gomock.Controller) *MockAccessTokenRepository {
+	mock := &MockAccessTokenRepository{ctrl: ctrl}
+	mock.recorder = &MockAccessTokenRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccessTokenRepository) EXPECT() *MockAccessTokenRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockAccessTokenRepository) Create(token *model.AccessToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAccessTokenRepositoryMockRecorder) Create(token any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAccessTokenRepository)(nil).Create), token)
+}
+
+// GetAll mocks base method.
+func (m *MockAccessTokenRepository) GetAll(principalID uuid.UUID) ([]model.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", principalID)
+	ret0, _ := ret[0].([]model.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockAccessTokenRepositoryMockRecorder) GetAll(principalID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAccessTokenRepository)(nil).GetAll), principalID)
+}
+
+// GetByHash mocks base method.
+func (m *MockAccessTokenRepository) GetByHash(hash string) (*model.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByHash", hash)
+	ret0, _ := ret[0].(*model.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByHash indicates an expected call of GetByHash.
+func (mr *MockAccessTokenRepositoryMockRecorder) GetByHash(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByHash", reflect.TypeOf((*MockAccessTokenRepository)(nil).GetByHash), hash)
+}
+
+// Revoke mocks base method.
+func (m *MockAccessTokenRepository) Revoke(principalID, id uuid.UUID, now time.Time) (*model.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", principalID, id, now)
+	ret0, _ := ret[0].(*model.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Revoke indicates an expected call of Revoke.
+func (mr *MockAccessTokenRepositoryMockRecorder) Revoke(principalID, id, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockAccessTokenRepository)(nil).Revoke), principalID, id, now)
+}
+
+// Touch mocks base method.
+func (m *MockAccessTokenRepository) Touch(id uuid.UUID, now time.Time, ip string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Touch", id, now, ip)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Touch indicates an expected call of Touch.
+func (mr *MockAccessTokenRepositoryMockRecorder) Touch(id, now, ip any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Touch", reflect.TypeOf((*MockAccessTokenRepository)(nil).Touch), id, now, ip)
+}
+
+// MockServiceAccountRepository is a mock of ServiceAccountRepository interface.
+type MockServiceAccountRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceAccountRepositoryMockRecorder
+}
+
+// MockServiceAccountRepositoryMockRecorder is the mock recorder for MockServiceAccountRepository.
+type MockServiceAccountRepositoryMockRecorder struct {
+	mock *MockServiceAccountRepository
+}
+
+// NewMockServiceAccountRepository creates a new mock instance.
+func NewMockServiceAccountRepository(ctrl *gomock.Controller) *MockServiceAccountRepository {
+	mock := &MockServiceAccountRepository{ctrl: ctrl}
+	mock.recorder = &MockServiceAccountRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockServiceAccountRepository) EXPECT() *MockServiceAccountRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddGrants mocks base method.
+func (m *MockServiceAccountRepository) AddGrants(grants []model.RecordGrant) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddGrants", grants)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddGrants indicates an expected call of AddGrants.
+func (mr *MockServiceAccountRepositoryMockRecorder) AddGrants(grants any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddGrants", reflect.TypeOf((*MockServiceAccountRepository)(nil).AddGrants), grants)
+}
+
+// Create mocks base method.
+func (m *MockServiceAccountRepository) Create(account *model.ServiceAccount) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", account)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockServiceAccountRepositoryMockRecorder) Create(account any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockServiceAccountRepository)(nil).Create), account)
+}
+
+// Delete mocks base method.
+func (m *MockServiceAccountRepository) Delete(id uuid.UUID) (*model.ServiceAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", id)
+	ret0, _ := ret[0].(*model.ServiceAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockServiceAccountRepositoryMockRecorder) Delete(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockServiceAccountRepository)(nil).Delete), id)
+}
+
+// Get mocks base method.
+func (m *MockServiceAccountRepository) Get(id uuid.UUID) (*model.ServiceAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", id)
+	ret0, _ := ret[0].(*model.ServiceAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockServiceAccountRepositoryMockRecorder) Get(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockServiceAccountRepository)(nil).Get), id)
+}
+
+// GetAll mocks base method.
+func (m *MockServiceAccountRepository) GetAll() ([]model.ServiceAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll")
+	ret0, _ := ret[0].([]model.ServiceAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockServiceAccountRepositoryMockRecorder) GetAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockServiceAccountRepository)(nil).GetAll))
+}
+
+// RemoveGrant mocks base method.
+func (m *MockServiceAccountRepository) RemoveGrant(accountID, recordID uuid.UUID) (*model.RecordGrant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveGrant", accountID, recordID)
+	ret0, _ := ret[0].(*model.RecordGrant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveGrant indicates an expected call of RemoveGrant.
+func (mr *MockServiceAccountRepositoryMockRecorder) RemoveGrant(accountID, recordID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveGrant", reflect.TypeOf((*MockServiceAccountRepository)(nil).RemoveGrant), accountID, recordID)
+}
+
+// MockTwoFactorRepository is a mock of TwoFactorRepository interface.
+type MockTwoFactorRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockTwoFactorRepositoryMockRecorder
+}
+
+// MockTwoFactorRepositoryMockRecorder is the mock recorder for MockTwoFactorRepository.
+type MockTwoFactorRepositoryMockRecorder struct {
+	mock *MockTwoFactorRepository
+}
+
+// NewMockTwoFactorRepository creates a new mock instance.
+func NewMockTwoFactorRepository(ctrl *gomock.Controller) *MockTwoFactorRepository {
+	mock := &MockTwoFactorRepository{ctrl: ctrl}
+	mock.recorder = &MockTwoFactorRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTwoFactorRepository) EXPECT() *MockTwoFactorRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ConfirmTOTP mocks base method.
+func (m *MockTwoFactorRepository) ConfirmTOTP(userID uuid.UUID, step int64, now time.Time, codes []model.RecoveryCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTP", userID, step, now, codes)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConfirmTOTP indicates an expected call of ConfirmTOTP.
+func (mr *MockTwoFactorRepositoryMockRecorder) ConfirmTOTP(userID, step, now, codes any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTP", reflect.TypeOf((*MockTwoFactorRepository)(nil).ConfirmTOTP), userID, step, now, codes)
+}
+
+// CreateChallenge mocks base method.
+func (m *MockTwoFactorRepository) CreateChallenge(challenge *model.LoginChallenge) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateChallenge", challenge)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateChallenge indicates an expected call of CreateChallenge.
+func (mr *MockTwoFactorRepositoryMockRecorder) CreateChallenge(challenge any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateChallenge", reflect.TypeOf((*MockTwoFactorRepository)(nil).CreateChallenge), challenge)
+}
+
+// DeleteTOTP mocks base method.
+func (m *MockTwoFactorRepository) DeleteTOTP(userID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTOTP", userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTOTP indicates an expected call of DeleteTOTP.
+func (mr *MockTwoFactorRepositoryMockRecorder) DeleteTOTP(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTOTP", reflect.TypeOf((*MockTwoFactorRepository)(nil).DeleteTOTP), userID)
+}
+
+// FailChallenge mocks base method.
+func (m *MockTwoFactorRepository) FailChallenge(hash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailChallenge", hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FailChallenge indicates an expected call of FailChallenge.
+func (mr *MockTwoFactorRepositoryMockRecorder) FailChallenge(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailChallenge", reflect.TypeOf((*MockTwoFactorRepository)(nil).FailChallenge), hash)
+}
+
+// GetChallenge mocks base method.
+func (m *MockTwoFactorRepository) GetChallenge(hash string) (*model.LoginChallenge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChallenge", hash)
+	ret0, _ := ret[0].(*model.LoginChallenge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChallenge indicates an expected call of GetChallenge.
+func (mr *MockTwoFactorRepositoryMockRecorder) GetChallenge(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChallenge", reflect.TypeOf((*MockTwoFactorRepository)(nil).GetChallenge), hash)
+}
+
+// GetTOTP mocks base method.
+func (m *MockTwoFactorRepository) GetTOTP(userID uuid.UUID) (*model.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTP", userID)
+	ret0, _ := ret[0].(*model.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTP indicates an expected call of GetTOTP.
+func (mr *MockTwoFactorRepositoryMockRecorder) GetTOTP(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTP", reflect.TypeOf((*MockTwoFactorRepository)(nil).GetTOTP), userID)
+}
+
+// ReplaceRecoveryCodes mocks base method.
+func (m *MockTwoFactorRepository) ReplaceRecoveryCodes(userID uuid.UUID, codes []model.RecoveryCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceRecoveryCodes", userID, codes)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceRecoveryCodes indicates an expected call of ReplaceRecoveryCodes.
+func (mr *MockTwoFactorRepositoryMockRecorder) ReplaceRecoveryCodes(userID, codes any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceRecoveryCodes", reflect.TypeOf((*MockTwoFactorRepository)(nil).ReplaceRecoveryCodes), userID, codes)
+}
+
+// SaveTOTP mocks base method.
+func (m *MockTwoFactorRepository) SaveTOTP(totp *model.TOTP) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTOTP", totp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveTOTP indicates an expected call of SaveTOTP.
+func (mr *MockTwoFactorRepositoryMockRecorder) SaveTOTP(totp any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTOTP", reflect.TypeOf((*MockTwoFactorRepository)(nil).SaveTOTP), totp)
+}
+
+// UseChallenge mocks base method.
+func (m *MockTwoFactorRepository) UseChallenge(hash string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseChallenge", hash, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseChallenge indicates an expected call of UseChallenge.
+func (mr *MockTwoFactorRepositoryMockRecorder) UseChallenge(hash, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseChallenge", reflect.TypeOf((*MockTwoFactorRepository)(nil).UseChallenge), hash, now)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockTwoFactorRepository) UseRecoveryCode(userID uuid.UUID, hash string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", userID, hash, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockTwoFactorRepositoryMockRecorder) UseRecoveryCode(userID, hash, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockTwoFactorRepository)(nil).UseRecoveryCode), userID, hash, now)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockTwoFactorRepository) UseTOTPStep(userID uuid.UUID, step int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", userID, step)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockTwoFactorRepositoryMockRecorder) UseTOTPStep(userID, step any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockTwoFactorRepository)(nil).UseTOTPStep), userID, step)
+}
+
+// MockWebAuthnRepository is a mock of WebAuthnRepository interface.
+type MockWebAuthnRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebAuthnRepositoryMockRecorder
+}
+
+// MockWebAuthnRepositoryMockRecorder is the mock recorder for MockWebAuthnRepository.
+type MockWebAuthnRepositoryMockRecorder struct {
+	mock *MockWebAuthnRepository
+}
+
+// NewMockWebAuthnRepository creates a new mock instance.
+func NewMockWebAuthnRepository(ctrl *gomock.Controller) *MockWebAuthnRepository {
+	mock := &MockWebAuthnRepository{ctrl: ctrl}
+	mock.recorder = &MockWebAuthnRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebAuthnRepository) EXPECT() *MockWebAuthnRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateCeremony mocks base method.
+func (m *MockWebAuthnRepository) CreateCeremony(ceremony *model.WebAuthnCeremony) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCeremony", ceremony)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateCeremony indicates an expected call of CreateCeremony.
+func (mr *MockWebAuthnRepositoryMockRecorder) CreateCeremony(ceremony any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCeremony", reflect.TypeOf((*MockWebAuthnRepository)(nil).CreateCeremony), ceremony)
+}
+
+// CreateCredential mocks base method.
+func (m *MockWebAuthnRepository) CreateCredential(credential *model.WebAuthnCredential) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCredential", credential)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateCredential indicates an expected call of CreateCredential.
+func (mr *MockWebAuthnRepositoryMockRecorder) CreateCredential(credential any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCredential", reflect.TypeOf((*MockWebAuthnRepository)(nil).CreateCredential), credential)
+}
+
+// DeleteCredential mocks base method.
+func (m *MockWebAuthnRepository) DeleteCredential(userID, id uuid.UUID) (*model.WebAuthnCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCredential", userID, id)
+	ret0, _ := ret[0].(*model.WebAuthnCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteCredential indicates an expected call of DeleteCredential.
+func (mr *MockWebAuthnRepositoryMockRecorder) DeleteCredential(userID, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCredential", reflect.TypeOf((*MockWebAuthnRepository)(nil).DeleteCredential), userID, id)
+}
+
+// GetCeremony mocks base method.
+func (m *MockWebAuthnRepository) GetCeremony(challenge string) (*model.WebAuthnCeremony, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCeremony", challenge)
+	ret0, _ := ret[0].(*model.WebAuthnCeremony)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCeremony indicates an expected call of GetCeremony.
+func (mr *MockWebAuthnRepositoryMockRecorder) GetCeremony(challenge any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCeremony", reflect.TypeOf((*MockWebAuthnRepository)(nil).GetCeremony), challenge)
+}
+
+// GetCredential mocks base method.
+func (m *MockWebAuthnRepository) GetCredential(credentialID []byte) (*model.WebAuthnCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCredential", credentialID)
+	ret0, _ := ret[0].(*model.WebAuthnCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCredential indicates an expected call of GetCredential.
+func (mr *MockWebAuthnRepositoryMockRecorder) GetCredential(credentialID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredential", reflect.TypeOf((*MockWebAuthnRepository)(nil).GetCredential), credentialID)
+}
+
+// GetCredentials mocks base method.
+func (m *MockWebAuthnRepository) GetCredentials(userID uuid.UUID) ([]model.WebAuthnCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCredentials", userID)
+	ret0, _ := ret[0].([]model.WebAuthnCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCredentials indicates an expected call of GetCredentials.
+func (mr *MockWebAuthnRepositoryMockRecorder) GetCredentials(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredentials", reflect.TypeOf((*MockWebAuthnRepository)(nil).GetCredentials), userID)
+}
+
+// UseCeremony mocks base method.
+func (m *MockWebAuthnRepository) UseCeremony(challenge string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseCeremony", challenge, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseCeremony indicates an expected call of UseCeremony.
+func (mr *MockWebAuthnRepositoryMockRecorder) UseCeremony(challenge, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseCeremony", reflect.TypeOf((*MockWebAuthnRepository)(nil).UseCeremony), challenge, now)
+}
+
+// UseCredential mocks base method.
+func (m *MockWebAuthnRepository) UseCredential(id uuid.UUID, signCount uint32, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseCredential", id, signCount, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseCredential indicates an expected call of UseCredential.
+func (mr *MockWebAuthnRepositoryMockRecorder) UseCredential(id, signCount, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseCredential", reflect.TypeOf((*MockWebAuthnRepository)(nil).UseCredential), id, signCount, now)
+}
+
+// MockOIDCRepository is a mock of OIDCRepository interface.
+type MockOIDCRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockOIDCRepositoryMockRecorder
+}
+
+// MockOIDCRepositoryMockRecorder is the mock recorder for MockOIDCRepository.
+type MockOIDCRepositoryMockRecorder struct {
+	mock *MockOIDCRepository
+}
+
+// NewMockOIDCRepository creates a new mock instance.
+func NewMockOIDCRepository(ctrl *gomock.Controller) *MockOIDCRepository {
+	mock := &MockOIDCRepository{ctrl: ctrl}
+	mock.recorder = &MockOIDCRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOIDCRepository) EXPECT() *MockOIDCRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AuthenticateLogin mocks base method.
+func (m *MockOIDCRepository) AuthenticateLogin(hash string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AuthenticateLogin", hash, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AuthenticateLogin indicates an expected call of AuthenticateLogin.
+func (mr *MockOIDCRepositoryMockRecorder) AuthenticateLogin(hash, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateLogin", reflect.TypeOf((*MockOIDCRepository)(nil).AuthenticateLogin), hash, now)
+}
+
+// CreateIdentity mocks base method.
+func (m *MockOIDCRepository) CreateIdentity(identity *model.Identity) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdentity", identity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateIdentity indicates an expected call of CreateIdentity.
+func (mr *MockOIDCRepositoryMockRecorder) CreateIdentity(identity any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdentity", reflect.TypeOf((*MockOIDCRepository)(nil).CreateIdentity), identity)
+}
+
+// CreateLogin mocks base method.
+func (m *MockOIDCRepository) CreateLogin(login *model.OIDCLogin) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLogin", login)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateLogin indicates an expected call of CreateLogin.
+func (mr *MockOIDCRepositoryMockRecorder) CreateLogin(login any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLogin", reflect.TypeOf((*MockOIDCRepository)(nil).CreateLogin), login)
+}
+
+// GetIdentities mocks base method.
+func (m *MockOIDCRepository) GetIdentities(userID uuid.UUID) ([]model.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdentities", userID)
+	ret0, _ := ret[0].([]model.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdentities indicates an expected call of GetIdentities.
+func (mr *MockOIDCRepositoryMockRecorder) GetIdentities(userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentities", reflect.TypeOf((*MockOIDCRepository)(nil).GetIdentities), userID)
+}
+
+// GetIdentity mocks base method.
+func (m *MockOIDCRepository) GetIdentity(issuer, subject string) (*model.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdentity", issuer, subject)
+	ret0, _ := ret[0].(*model.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdentity indicates an expected call of GetIdentity.
+func (mr *MockOIDCRepositoryMockRecorder) GetIdentity(issuer, subject any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentity", reflect.TypeOf((*MockOIDCRepository)(nil).GetIdentity), issuer, subject)
+}
+
+// GetLogin mocks base method.
+func (m *MockOIDCRepository) GetLogin(hash string) (*model.OIDCLogin, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLogin", hash)
+	ret0, _ := ret[0].(*model.OIDCLogin)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLogin indicates an expected call of GetLogin.
+func (mr *MockOIDCRepositoryMockRecorder) GetLogin(hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLogin", reflect.TypeOf((*MockOIDCRepository)(nil).GetLogin), hash)
+}
+
+// GetPolicy mocks base method.
+func (m *MockOIDCRepository) GetPolicy() (*model.SSOPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPolicy")
+	ret0, _ := ret[0].(*model.SSOPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPolicy indicates an expected call of GetPolicy.
+func (mr *MockOIDCRepositoryMockRecorder) GetPolicy() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPolicy", reflect.TypeOf((*MockOIDCRepository)(nil).GetPolicy))
+}
+
+// Provision mocks base method.
+func (m *MockOIDCRepository) Provision(user *model.User, identity *model.Identity) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Provision", user, identity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Provision indicates an expected call of Provision.
+func (mr *MockOIDCRepositoryMockRecorder) Provision(user, identity any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Provision", reflect.TypeOf((*MockOIDCRepository)(nil).Provision), user, identity)
+}
+
+// SetAdmin mocks base method.
+func (m *MockOIDCRepository) SetAdmin(userID uuid.UUID, isAdmin bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetAdmin", userID, isAdmin)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAdmin indicates an expected call of SetAdmin.
+func (mr *MockOIDCRepositoryMockRecorder) SetAdmin(userID, isAdmin any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAdmin", reflect.TypeOf((*MockOIDCRepository)(nil).SetAdmin), userID, isAdmin)
+}
+
+// SetPolicy mocks base method.
+func (m *MockOIDCRepository) SetPolicy(policy *model.SSOPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPolicy", policy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPolicy indicates an expected call of SetPolicy.
+func (mr *MockOIDCRepositoryMockRecorder) SetPolicy(policy any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPolicy", reflect.TypeOf((*MockOIDCRepository)(nil).SetPolicy), policy)
+}
+
+// SpendReauth mocks base method.
+func (m *MockOIDCRepository) SpendReauth(hash string, userID uuid.UUID, since time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SpendReauth", hash, userID, since)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SpendReauth indicates an expected call of SpendReauth.
+func (mr *MockOIDCRepositoryMockRecorder) SpendReauth(hash, userID, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SpendReauth", reflect.TypeOf((*MockOIDCRepository)(nil).SpendReauth), hash, userID, since)
+}
+
+// TouchIdentity mocks base method.
+func (m *MockOIDCRepository) TouchIdentity(issuer, subject string, groups []string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchIdentity", issuer, subject, groups, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchIdentity indicates an expected call of TouchIdentity.
+func (mr *MockOIDCRepositoryMockRecorder) TouchIdentity(issuer, subject, groups, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchIdentity", reflect.TypeOf((*MockOIDCRepository)(nil).TouchIdentity), issuer, subject, groups, now)
+}
+
+// UseLogin mocks base method.
+func (m *MockOIDCRepository) UseLogin(hash string, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseLogin", hash, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseLogin indicates an expected call of UseLogin.
+func (mr *MockOIDCRepositoryMockRecorder) UseLogin(hash, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseLogin", reflect.TypeOf((*MockOIDCRepository)(nil).UseLogin), hash, now)
+}
+
+// MockCertificateRepository is a mock of CertificateRepository interface.
+type MockCertificateRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockCertificateRepositoryMockRecorder
+}
+
+// MockCertificateRepositoryMockRecorder is the mock recorder for MockCertificateRepository.
+type MockCertificateRepositoryMockRecorder struct {
+	mock *MockCertificateRepository
+}
+
+// NewMockCertificateRepository creates a new mock instance.
+func NewMockCertificateRepository(ctrl *gomock.Controller) *MockCertificateRepository {
+	mock := &MockCertificateRepository{ctrl: ctrl}
+	mock.recorder = &MockCertificateRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCertificateRepository) EXPECT() *MockCertificateRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockCertificateRepository) Create(mapping *model.CertificateMapping) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", mapping)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockCertificateRepositoryMockRecorder) Create(mapping any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockCertificateRepository)(nil).Create), mapping)
+}
+
+// Delete mocks base method.
+func (m *MockCertificateRepository) Delete(id uuid.UUID) (*model.CertificateMapping, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", id)
+	ret0, _ := ret[0].(*model.CertificateMapping)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockCertificateRepositoryMockRecorder) Delete(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockCertificateRepository)(nil).Delete), id)
+}
+
+// GetAll mocks base method.
+func (m *MockCertificateRepository) GetAll() ([]model.CertificateMapping, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll")
+	ret0, _ := ret[0].([]model.CertificateMapping)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockCertificateRepositoryMockRecorder) GetAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockCertificateRepository)(nil).GetAll))
+}
+
+// GetBySubject mocks base method.
+func (m *MockCertificateRepository) GetBySubject(subject string) (*model.CertificateMapping, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBySubject", subject)
+	ret0, _ := ret[0].(*model.CertificateMapping)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBySubject indicates an expected call of GetBySubject.
+func (mr *MockCertificateRepositoryMockRecorder) GetBySubject(subject any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBySubject", reflect.TypeOf((*MockCertificateRepository)(nil).GetBySubject), subject)
+}
+
+// Touch mocks base method.
+func (m *MockCertificateRepository) Touch(id uuid.UUID, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Touch", id, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Touch indicates an expected call of Touch.
+func (mr *MockCertificateRepositoryMockRecorder) Touch(id, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Touch", reflect.TypeOf((*MockCertificateRepository)(nil).Touch), id, now)
+}
+
+// MockRateLimitRepository is a mock of RateLimitRepository interface.
+type MockRateLimitRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRateLimitRepositoryMockRecorder
+}
+
+// MockRateLimitRepositoryMockRecorder is the mock recorder for MockRateLimitRepository.
+type MockRateLimitRepositoryMockRecorder struct {
+	mock *MockRateLimitRepository
+}
+
+// NewMockRateLimitRepository creates a new mock instance.
+func NewMockRateLimitRepository(ctrl *gomock.Controller) *MockRateLimitRepository {
+	mock := &MockRateLimitRepository{ctrl: ctrl}
+	mock.recorder = &MockRateLimitRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRateLimitRepository) EXPECT() *MockRateLimitRepositoryMockRecorder {
+	return m.recorder
+}
+
+// AddLoginFailure mocks base method.
+func (m *MockRateLimitRepository) AddLoginFailure(account string, since, now time.Time) (*model.LoginFailure, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLoginFailure", account, since, now)
+	ret0, _ := ret[0].(*model.LoginFailure)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddLoginFailure indicates an expected call of AddLoginFailure.
+func (mr *MockRateLimitRepositoryMockRecorder) AddLoginFailure(account, since, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLoginFailure", reflect.TypeOf((*MockRateLimitRepository)(nil).AddLoginFailure), account, since, now)
+}
+
+// GetLoginFailure mocks base method.
+func (m *MockRateLimitRepository) GetLoginFailure(account string) (*model.LoginFailure, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLoginFailure", account)
+	ret0, _ := ret[0].(*model.LoginFailure)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoginFailure indicates an expected call of GetLoginFailure.
+func (mr *MockRateLimitRepositoryMockRecorder) GetLoginFailure(account any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginFailure", reflect.TypeOf((*MockRateLimitRepository)(nil).GetLoginFailure), account)
+}
+
+// LockAccount mocks base method.
+func (m *MockRateLimitRepository) LockAccount(account string, until time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockAccount", account, until)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LockAccount indicates an expected call of LockAccount.
+func (mr *MockRateLimitRepositoryMockRecorder) LockAccount(account, until any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockAccount", reflect.TypeOf((*MockRateLimitRepository)(nil).LockAccount), account, until)
+}
+
+// Prune mocks base method.
+func (m *MockRateLimitRepository) Prune(before, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Prune", before, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Prune indicates an expected call of Prune.
+func (mr *MockRateLimitRepositoryMockRecorder) Prune(before, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Prune", reflect.TypeOf((*MockRateLimitRepository)(nil).Prune), before, now)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockRateLimitRepository) ResetLoginFailures(account string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", account)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockRateLimitRepositoryMockRecorder) ResetLoginFailures(account any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockRateLimitRepository)(nil).ResetLoginFailures), account)
+}
+
+// Take mocks base method.
+func (m *MockRateLimitRepository) Take(key string, limit pmratelimit.Limit, now time.Time) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Take", key, limit, now)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Take indicates an expected call of Take.
+func (mr *MockRateLimitRepositoryMockRecorder) Take(key, limit, now any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Take", reflect.TypeOf((*MockRateLimitRepository)(nil).Take), key, limit, now)
+}
@@ -0,0 +1,84 @@
+package rekey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint records the last row rewritten in each table, so that an interrupted run can resume
+type Checkpoint struct {
+	path   string
+	Tables map[string]string `json:"tables"`
+}
+
+// LoadCheckpoint reads the checkpoint at path, a missing file means a fresh run
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, Tables: make(map[string]string)}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	if c.Tables == nil {
+		c.Tables = make(map[string]string)
+	}
+
+	return c, nil
+}
+
+func (c *Checkpoint) Last(table string) string {
+	return c.Tables[table]
+}
+
+// Save stores lastID of table, the file is replaced atomically
+func (c *Checkpoint) Save(table, lastID string) error {
+	c.Tables[table] = lastID
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Remove deletes the checkpoint after a completed run
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,398 @@
+package rekey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/internal/controller"
+	pmlogger "github.com/ChillyWR/PasswordManager/internal/logger"
+	"github.com/ChillyWR/PasswordManager/internal/repo"
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+// KeySource resolves keys protecting encrypted values
+type KeySource interface {
+	// KeysFor returns the keys for values of column owned by owner.
+	// Nil keys mean the key is not available offline and the values are skipped.
+	KeysFor(column repo.EncryptedColumn, owner uuid.UUID) (pmcrypto.KeyProvider, error)
+}
+
+// VaultMigrator migrates vaults a whole vault at a time. Their fields are bound to the record they belong to
+// and are encrypted with the data key of their owner, so unlike server key columns they are not rewritten value by value.
+type VaultMigrator interface {
+	MigrateVault(userID uuid.UUID, dryRun bool) (controller.VaultMigration, error)
+}
+
+// VaultStats keys the stats of vaults: Fields counts users, Rewritten migrated vaults and Skipped vaults
+// that only their owner's password opens
+const VaultStats = "vaults"
+
+// ServerKeys resolves the server master keys for server key columns.
+// Vault columns are encrypted with user data keys, which stay the same when the server key rotates.
+type ServerKeys struct {
+	Keys pmcrypto.KeyProvider
+}
+
+func (k ServerKeys) KeysFor(column repo.EncryptedColumn, _ uuid.UUID) (pmcrypto.KeyProvider, error) {
+	if column.ServerKey {
+		return k.Keys, nil
+	}
+
+	return nil, nil
+}
+
+type Config struct {
+	BatchSize int
+	// DryRun counts affected fields and checks that they decrypt without writing anything
+	DryRun bool
+}
+
+// Stats are per column counters, in a dry run Rewritten is the number of fields that would be rewritten
+type Stats struct {
+	Fields    int
+	Rewritten int
+	Skipped   int
+	Failed    int
+}
+
+type Rekeyer struct {
+	db         *gorm.DB
+	keys       KeySource
+	vaults     VaultMigrator
+	checkpoint *Checkpoint
+	config     Config
+	log        pmlogger.Logger
+}
+
+func New(db *gorm.DB, keys KeySource, vaults VaultMigrator, checkpoint *Checkpoint, config Config, logger pmlogger.Logger) (*Rekeyer, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	if keys == nil {
+		return nil, errors.New("keys is nil")
+	}
+
+	if vaults == nil {
+		return nil, errors.New("vaults is nil")
+	}
+
+	if checkpoint == nil && !config.DryRun {
+		return nil, errors.New("checkpoint is nil")
+	}
+
+	if config.BatchSize <= 0 {
+		return nil, errors.New("batch size must be positive")
+	}
+
+	return &Rekeyer{
+		db:         db,
+		keys:       keys,
+		vaults:     vaults,
+		checkpoint: checkpoint,
+		config:     config,
+		log:        logger.WithFields(pmlogger.Fields{"module": "Rekeyer"}),
+	}, nil
+}
+
+type table struct {
+	name string
+	// owner selects the ID of the user owning a row
+	owner   string
+	join    string
+	columns []repo.EncryptedColumn
+}
+
+// tables groups the server key columns of repo.EncryptedColumns by table in a stable order
+func tables() []table {
+	byName := make(map[string]*table)
+	var names []string
+	for _, column := range repo.EncryptedColumns {
+		if !column.ServerKey {
+			continue
+		}
+
+		t, ok := byName[column.Table]
+		if !ok {
+			t = &table{name: column.Table}
+			switch column.Table {
+			case "reg_user", "user_totp":
+				t.owner = "t.id"
+			case "credential_record":
+				t.owner = "t.created_by"
+			case "recovery_ceremony_share", "recovery_share_delivery":
+				t.owner = "t.trustee_id"
+			default:
+				t.owner = "cr.created_by"
+				t.join = "INNER JOIN credential_record cr ON cr.id = t.id"
+			}
+
+			byName[column.Table] = t
+			names = append(names, column.Table)
+		}
+
+		t.columns = append(t.columns, column)
+	}
+
+	result := make([]table, len(names))
+	for i, name := range names {
+		result[i] = *byName[name]
+	}
+
+	return result
+}
+
+// Run rewrites every server key field that does not use the active key, then migrates the vaults.
+// Data keys are re-wrapped before vaults are migrated. Stats are keyed by "table.column" and VaultStats.
+func (r *Rekeyer) Run(ctx context.Context) (map[string]*Stats, error) {
+	stats := map[string]*Stats{VaultStats: {}}
+	for _, column := range repo.EncryptedColumns {
+		if column.ServerKey {
+			stats[column.Table+"."+column.Column] = &Stats{}
+		}
+	}
+
+	for _, t := range tables() {
+		last := uuid.Nil.String()
+		if !r.config.DryRun && r.checkpoint.Last(t.name) != "" {
+			last = r.checkpoint.Last(t.name)
+			r.log.Infof("Resuming %s after %s", t.name, last)
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+
+			next, n, err := r.batch(t, last, stats)
+			if err != nil {
+				return stats, fmt.Errorf("%s after %s: %w", t.name, last, err)
+			}
+
+			if n == 0 {
+				break
+			}
+
+			last = next
+			if !r.config.DryRun {
+				if err := r.checkpoint.Save(t.name, last); err != nil {
+					return stats, fmt.Errorf("save checkpoint: %w", err)
+				}
+			}
+		}
+	}
+
+	if err := r.migrateVaults(ctx, stats[VaultStats]); err != nil {
+		return stats, fmt.Errorf("vaults: %w", err)
+	}
+
+	if !r.config.DryRun {
+		if err := r.checkpoint.Remove(); err != nil {
+			return stats, fmt.Errorf("remove checkpoint: %w", err)
+		}
+	}
+
+	return stats, nil
+}
+
+// batch rewrites up to BatchSize rows of t with IDs after last in a single transaction
+func (r *Rekeyer) batch(t table, last string, stats map[string]*Stats) (string, int, error) {
+	columns := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		columns[i] = "t." + column.Column
+	}
+
+	query := fmt.Sprintf("SELECT t.id, %s, %s FROM %s t %s WHERE t.id > ? ORDER BY t.id LIMIT ?",
+		t.owner, strings.Join(columns, ", "), t.name, t.join)
+	if !r.config.DryRun {
+		query += " FOR UPDATE OF t"
+	}
+
+	var n int
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		rows, err := tx.Raw(query, last, r.config.BatchSize).Rows()
+		if err != nil {
+			return fmt.Errorf("select: %w", err)
+		}
+
+		type row struct {
+			id     uuid.UUID
+			owner  uuid.UUID
+			values []sql.NullString
+		}
+
+		var batch []row
+		for rows.Next() {
+			rw := row{values: make([]sql.NullString, len(t.columns))}
+			dest := []any{&rw.id, &rw.owner}
+			for i := range rw.values {
+				dest = append(dest, &rw.values[i])
+			}
+
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan: %w", err)
+			}
+
+			batch = append(batch, rw)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("rows: %w", err)
+		}
+
+		for _, rw := range batch {
+			updates := make(map[string]any)
+			for i, column := range t.columns {
+				if !rw.values[i].Valid || pmcrypto.IsPasswordHash(rw.values[i].String) {
+					continue
+				}
+
+				s := stats[column.Table+"."+column.Column]
+				s.Fields++
+
+				keys, err := r.keys.KeysFor(column, rw.owner)
+				if err != nil {
+					return fmt.Errorf("resolve key of %s: %w", rw.id.String(), err)
+				}
+
+				if keys == nil {
+					s.Skipped++
+					continue
+				}
+
+				value, rewritten, err := rekeyValue(keys, rw.values[i].String)
+				if err != nil {
+					if r.config.DryRun {
+						r.log.Errorf("%s.%s of %s does not decrypt: %s", column.Table, column.Column, rw.id.String(), err.Error())
+						s.Failed++
+						continue
+					}
+
+					return fmt.Errorf("rekey %s.%s of %s: %w", column.Table, column.Column, rw.id.String(), err)
+				}
+
+				if rewritten {
+					s.Rewritten++
+					updates[column.Column] = value
+				}
+			}
+
+			if len(updates) == 0 || r.config.DryRun {
+				continue
+			}
+
+			if err := tx.Table(t.name).Where("id = ?", rw.id).Updates(updates).Error; err != nil {
+				return fmt.Errorf("update %s: %w", rw.id.String(), err)
+			}
+		}
+
+		n = len(batch)
+		if n > 0 {
+			last = batch[n-1].id.String()
+		}
+
+		return nil
+	})
+
+	return last, n, err
+}
+
+// migrateVaults migrates the vaults of users in batches of BatchSize, migrating a vault twice does nothing
+func (r *Rekeyer) migrateVaults(ctx context.Context, stats *Stats) error {
+	last := uuid.Nil.String()
+	if !r.config.DryRun && r.checkpoint.Last(VaultStats) != "" {
+		last = r.checkpoint.Last(VaultStats)
+		r.log.Infof("Resuming vaults after %s", last)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var ids []uuid.UUID
+		if err := r.db.Table("reg_user").Where("id > ?", last).Order("id").Limit(r.config.BatchSize).Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("select users after %s: %w", last, err)
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for _, id := range ids {
+			stats.Fields++
+
+			migration, err := r.vaults.MigrateVault(id, r.config.DryRun)
+			if err != nil {
+				if r.config.DryRun {
+					r.log.Errorf("Vault of %s does not decrypt: %s", id.String(), err.Error())
+					stats.Failed++
+					continue
+				}
+
+				return fmt.Errorf("migrate vault of %s: %w", id.String(), err)
+			}
+
+			switch migration {
+			case controller.VaultMigrated:
+				stats.Rewritten++
+			case controller.VaultLocked:
+				stats.Skipped++
+			}
+		}
+
+		last = ids[len(ids)-1].String()
+		if !r.config.DryRun {
+			if err := r.checkpoint.Save(VaultStats, last); err != nil {
+				return fmt.Errorf("save checkpoint: %w", err)
+			}
+		}
+	}
+}
+
+// rekeyValue decrypts value and re-encrypts it with the active key if it uses another key or the legacy format.
+// Values that already use the active key are only checked to decrypt.
+func rekeyValue(keys pmcrypto.KeyProvider, value string) (string, bool, error) {
+	plainText, err := keys.Decrypt(value)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !pmcrypto.NeedsRotation(keys, value) {
+		return value, false, nil
+	}
+
+	v, err := keys.Encrypt(plainText)
+	if err != nil {
+		return "", false, err
+	}
+
+	return v, true, nil
+}
+
+// SortedColumns returns keys of stats in the order of repo.EncryptedColumns, followed by VaultStats
+func SortedColumns(stats map[string]*Stats) []string {
+	order := map[string]int{VaultStats: len(repo.EncryptedColumns)}
+	for i, column := range repo.EncryptedColumns {
+		order[column.Table+"."+column.Column] = i
+	}
+
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return order[keys[i]] < order[keys[j]] })
+
+	return keys
+}
@@ -0,0 +1,102 @@
+package rekey
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.Empty(t, c.Last("reg_user"))
+
+	require.NoError(t, c.Save("reg_user", "a"))
+	require.NoError(t, c.Save("login", "b"))
+	require.NoError(t, c.Save("reg_user", "c"))
+
+	resumed, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.Equal(t, "c", resumed.Last("reg_user"))
+	require.Equal(t, "b", resumed.Last("login"))
+
+	require.NoError(t, resumed.Remove())
+	require.NoError(t, resumed.Remove())
+
+	fresh, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.Empty(t, fresh.Tables)
+}
+
+func TestRekeyValue(t *testing.T) {
+	keyring := pmcrypto.NewKeyring()
+	require.NoError(t, keyring.Add("k1", []byte("12345678901234567890123456789012")))
+	require.NoError(t, keyring.SetActive("k1"))
+
+	oldValue, err := keyring.Encrypt("test input")
+	require.NoError(t, err)
+
+	legacyValue, err := pmcrypto.Encrypt("test input", "1234567890123456")
+	require.NoError(t, err)
+
+	require.NoError(t, keyring.Add("k2", []byte("abcdefghijklmnopqrstuvwxyz123456")))
+	require.NoError(t, keyring.SetActive("k2"))
+	keys := pmcrypto.WithLegacy(keyring, "1234567890123456")
+
+	t.Run("success_rewrite", func(t *testing.T) {
+		for _, value := range []string{oldValue, legacyValue} {
+			v, rewritten, err := rekeyValue(keys, value)
+			require.NoError(t, err)
+			require.True(t, rewritten)
+
+			id, err := pmcrypto.KeyIDOf(v)
+			require.NoError(t, err)
+			require.Equal(t, "k2", id)
+
+			decrypted, err := keys.Decrypt(v)
+			require.NoError(t, err)
+			require.Equal(t, "test input", decrypted)
+		}
+	})
+
+	t.Run("success_current", func(t *testing.T) {
+		current, err := keys.Encrypt("test input")
+		require.NoError(t, err)
+
+		v, rewritten, err := rekeyValue(keys, current)
+		require.NoError(t, err)
+		require.False(t, rewritten)
+		require.Equal(t, current, v)
+	})
+
+	t.Run("error_does_not_decrypt", func(t *testing.T) {
+		e, err := pmcrypto.ParseEnvelope(oldValue)
+		require.NoError(t, err)
+		e.Ciphertext[0] ^= 1
+
+		_, _, err = rekeyValue(keys, e.String())
+		require.ErrorIs(t, err, pmcrypto.ErrIntegrity)
+	})
+}
+
+func TestTables(t *testing.T) {
+	var names []string
+	for _, table := range tables() {
+		names = append(names, table.name)
+		require.NotEmpty(t, table.owner)
+		require.NotEmpty(t, table.columns)
+	}
+
+	// vault columns are migrated by VaultMigrator
+	require.Equal(t, []string{"reg_user", "recovery_ceremony_share", "recovery_share_delivery", "user_totp"}, names)
+}
+
+func TestSortedColumns(t *testing.T) {
+	stats := map[string]*Stats{VaultStats: {}, "user_totp.secret": {}, "reg_user.data_key": {}}
+	require.Equal(t, []string{"reg_user.data_key", "user_totp.secret", VaultStats}, SortedColumns(stats))
+}
@@ -0,0 +1,169 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type AccessToken struct {
+	ID               uuid.UUID
+	UserID           *uuid.UUID
+	ServiceAccountID *uuid.UUID
+	Name             string
+	Hash             string
+	Scopes           string
+	CreatedOn        time.Time
+	ExpiresOn        time.Time
+	LastUsedOn       *time.Time
+	LastUsedIP       string
+	RevokedOn        *time.Time
+}
+
+func (AccessToken) TableName() string {
+	return "access_token"
+}
+
+func newAccessToken(token *model.AccessToken) *AccessToken {
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	// exactly one of the principals is set, see the access_token_principal constraint
+	var userID *uuid.UUID
+	if token.ServiceAccountID == nil {
+		userID = &token.UserID
+	}
+
+	return &AccessToken{
+		ID:               token.ID,
+		UserID:           userID,
+		ServiceAccountID: token.ServiceAccountID,
+		Name:             token.Name,
+		Hash:             token.Hash,
+		Scopes:           strings.Join(scopes, " "),
+		CreatedOn:        token.CreatedOn,
+		ExpiresOn:        token.ExpiresOn,
+		LastUsedOn:       token.LastUsedOn,
+		LastUsedIP:       token.LastUsedIP,
+		RevokedOn:        token.RevokedOn,
+	}
+}
+
+func (t *AccessToken) model() *model.AccessToken {
+	var scopes []model.Scope
+	for _, scope := range strings.Fields(t.Scopes) {
+		scopes = append(scopes, model.Scope(scope))
+	}
+
+	var userID uuid.UUID
+	if t.UserID != nil {
+		userID = *t.UserID
+	}
+
+	return &model.AccessToken{
+		ID:               t.ID,
+		UserID:           userID,
+		ServiceAccountID: t.ServiceAccountID,
+		Name:             t.Name,
+		Hash:             t.Hash,
+		Scopes:           scopes,
+		CreatedOn:        t.CreatedOn,
+		ExpiresOn:        t.ExpiresOn,
+		LastUsedOn:       t.LastUsedOn,
+		LastUsedIP:       t.LastUsedIP,
+		RevokedOn:        t.RevokedOn,
+	}
+}
+
+func NewAccessTokenRepository(db *gorm.DB) (*AccessTokenRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &AccessTokenRepository{db: db}, nil
+}
+
+type AccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *AccessTokenRepository) Create(token *model.AccessToken) error {
+	if err := r.db.Create(newAccessToken(token)).Error; err != nil {
+		return fmt.Errorf("create access token: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetAll returns the access tokens of user or service account principalID that are not revoked, newest first
+func (r *AccessTokenRepository) GetAll(principalID uuid.UUID) ([]model.AccessToken, error) {
+	var tokens []AccessToken
+	err := r.db.
+		Where("(user_id = ? OR service_account_id = ?) AND revoked_on IS NULL", principalID, principalID).
+		Order("created_on DESC, id").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, fmt.Errorf("get access tokens: %w", convertError(err))
+	}
+
+	result := make([]model.AccessToken, len(tokens))
+	for i, token := range tokens {
+		result[i] = *token.model()
+	}
+
+	return result, nil
+}
+
+func (r *AccessTokenRepository) GetByHash(hash string) (*model.AccessToken, error) {
+	var token AccessToken
+	if err := r.db.First(&token, "hash = ?", hash).Error; err != nil {
+		return nil, fmt.Errorf("get access token: %w", convertError(err))
+	}
+
+	return token.model(), nil
+}
+
+// Touch records when and from where access token id was last used
+func (r *AccessTokenRepository) Touch(id uuid.UUID, now time.Time, ip string) error {
+	err := r.db.Model(&AccessToken{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_on": now, "last_used_ip": ip}).Error
+	if err != nil {
+		return fmt.Errorf("touch access token: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// Revoke revokes access token id of user or service account principalID,
+// tokens already revoked are reported as pmerror.ErrNotFound
+func (r *AccessTokenRepository) Revoke(principalID, id uuid.UUID, now time.Time) (*model.AccessToken, error) {
+	var token AccessToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&AccessToken{}).
+			Where("id = ? AND (user_id = ? OR service_account_id = ?) AND revoked_on IS NULL", id, principalID, principalID).
+			Update("revoked_on", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.First(&token, id).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("revoke: %w", convertError(err))
+	}
+
+	return token.model(), nil
+}
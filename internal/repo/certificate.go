@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type ClientCertificate struct {
+	ID               uuid.UUID
+	Subject          string
+	UserID           *uuid.UUID
+	ServiceAccountID *uuid.UUID
+	Scopes           string
+	CreatedBy        *uuid.UUID
+	CreatedOn        time.Time
+	LastUsedOn       *time.Time
+}
+
+func (ClientCertificate) TableName() string {
+	return "client_certificate"
+}
+
+func newClientCertificate(mapping *model.CertificateMapping) *ClientCertificate {
+	scopes := make([]string, len(mapping.Scopes))
+	for i, scope := range mapping.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	// exactly one of the principals is set, see the client_certificate_principal constraint
+	var userID *uuid.UUID
+	if mapping.ServiceAccountID == nil {
+		userID = &mapping.UserID
+	}
+
+	return &ClientCertificate{
+		ID:               mapping.ID,
+		Subject:          mapping.Subject,
+		UserID:           userID,
+		ServiceAccountID: mapping.ServiceAccountID,
+		Scopes:           strings.Join(scopes, " "),
+		CreatedBy:        mapping.CreatedBy,
+		CreatedOn:        mapping.CreatedOn,
+		LastUsedOn:       mapping.LastUsedOn,
+	}
+}
+
+func (c *ClientCertificate) model() *model.CertificateMapping {
+	var scopes []model.Scope
+	for _, scope := range strings.Fields(c.Scopes) {
+		scopes = append(scopes, model.Scope(scope))
+	}
+
+	var userID uuid.UUID
+	if c.UserID != nil {
+		userID = *c.UserID
+	}
+
+	return &model.CertificateMapping{
+		ID:               c.ID,
+		Subject:          c.Subject,
+		UserID:           userID,
+		ServiceAccountID: c.ServiceAccountID,
+		Scopes:           scopes,
+		CreatedBy:        c.CreatedBy,
+		CreatedOn:        c.CreatedOn,
+		LastUsedOn:       c.LastUsedOn,
+	}
+}
+
+func NewCertificateRepository(db *gorm.DB) (*CertificateRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &CertificateRepository{db: db}, nil
+}
+
+type CertificateRepository struct {
+	db *gorm.DB
+}
+
+func (r *CertificateRepository) Create(mapping *model.CertificateMapping) error {
+	if err := r.db.Create(newClientCertificate(mapping)).Error; err != nil {
+		return fmt.Errorf("create certificate mapping: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetAll returns all certificate mappings in order of creation
+func (r *CertificateRepository) GetAll() ([]model.CertificateMapping, error) {
+	var certificates []ClientCertificate
+	if err := r.db.Order("created_on, id").Find(&certificates).Error; err != nil {
+		return nil, fmt.Errorf("get certificate mappings: %w", convertError(err))
+	}
+
+	result := make([]model.CertificateMapping, len(certificates))
+	for i, certificate := range certificates {
+		result[i] = *certificate.model()
+	}
+
+	return result, nil
+}
+
+func (r *CertificateRepository) GetBySubject(subject string) (*model.CertificateMapping, error) {
+	var certificate ClientCertificate
+	if err := r.db.First(&certificate, "subject = ?", subject).Error; err != nil {
+		return nil, fmt.Errorf("get certificate mapping: %w", convertError(err))
+	}
+
+	return certificate.model(), nil
+}
+
+// Touch records when certificate mapping id was last used
+func (r *CertificateRepository) Touch(id uuid.UUID, now time.Time) error {
+	if err := r.db.Model(&ClientCertificate{}).Where("id = ?", id).Update("last_used_on", now).Error; err != nil {
+		return fmt.Errorf("touch certificate mapping: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *CertificateRepository) Delete(id uuid.UUID) (*model.CertificateMapping, error) {
+	var certificate ClientCertificate
+	result := r.db.Clauses(clause.Returning{}).Where("id = ?", id).Delete(&certificate)
+	if result.Error != nil {
+		return nil, fmt.Errorf("delete certificate mapping: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("delete certificate mapping: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return certificate.model(), nil
+}
@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+)
+
+// EncryptedColumn is a column holding ciphertext. Record columns have to match the pm:"encrypt" tags of the record
+// models, TestEncryptedColumns of the controller checks that they do.
+type EncryptedColumn struct {
+	Table  string
+	Column string
+	// ServerKey is set for columns encrypted with the server keyring rather than a user's data key
+	ServerKey bool
+	// Seal is the record seal the column is encrypted from, rows of records sealed below it hold plaintext
+	Seal int
+}
+
+var EncryptedColumns = []EncryptedColumn{
+	// password holds ciphertext until the user's next login replaces it with a hash, hashes are skipped
+	{Table: "reg_user", Column: "password", ServerKey: true},
+	{Table: "reg_user", Column: "data_key", ServerKey: true},
+	{Table: "credential_record", Column: "name", Seal: 1},
+	{Table: "credential_record", Column: "notes"},
+	{Table: "login", Column: "username"},
+	{Table: "login", Column: "password"},
+	{Table: "login", Column: "url", Seal: 1},
+	{Table: "card", Column: "brand", Seal: 2},
+	{Table: "card", Column: "number"},
+	{Table: "card", Column: "expiration_month"},
+	{Table: "card", Column: "expiration_year"},
+	{Table: "card", Column: "cvv"},
+	{Table: "identity", Column: "first_name"},
+	{Table: "identity", Column: "middle_name"},
+	{Table: "identity", Column: "last_name"},
+	{Table: "identity", Column: "address"},
+	{Table: "identity", Column: "email"},
+	{Table: "identity", Column: "phone_number"},
+	{Table: "identity", Column: "passport_number"},
+	{Table: "identity", Column: "country", Seal: 1},
+	// recovery key shares submitted by trustees, kept until their ceremony is over
+	{Table: "recovery_ceremony_share", Column: "share", ServerKey: true},
+	// recovery key shares waiting for their trustee to collect them
+	{Table: "recovery_share_delivery", Column: "share", ServerKey: true},
+	// the id of a TOTP enrollment is the ID of its user
+	{Table: "user_totp", Column: "secret", ServerKey: true},
+}
+
+func NewKeyRepository(db *gorm.DB) (*KeyRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &KeyRepository{db: db}, nil
+}
+
+type KeyRepository struct {
+	db *gorm.DB
+}
+
+// ForEachEncrypted calls fn for every non-null value of EncryptedColumns
+func (r *KeyRepository) ForEachEncrypted(fn func(value string, serverKey bool) error) error {
+	for _, column := range EncryptedColumns {
+		name := column.Table + "." + column.Column
+		q := r.db.Table(column.Table).Select(name).Where(name + " IS NOT NULL")
+		if column.Seal > 0 {
+			if column.Table != "credential_record" {
+				q = q.Joins("INNER JOIN credential_record ON credential_record.id = " + column.Table + ".id")
+			}
+			q = q.Where("credential_record.seal >= ?", column.Seal)
+		}
+
+		rows, err := q.Rows()
+		if err != nil {
+			return fmt.Errorf("select %s.%s: %w", column.Table, column.Column, convertError(err))
+		}
+
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan %s.%s: %w", column.Table, column.Column, convertError(err))
+			}
+
+			if pmcrypto.IsPasswordHash(value) {
+				continue
+			}
+
+			if err := fn(value, column.ServerKey); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("rows %s.%s: %w", column.Table, column.Column, convertError(err))
+		}
+	}
+
+	return nil
+}
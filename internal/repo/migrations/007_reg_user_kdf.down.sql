@@ -0,0 +1,5 @@
+ALTER TABLE reg_user
+	DROP COLUMN IF EXISTS kdf_salt,
+	DROP COLUMN IF EXISTS kdf_time,
+	DROP COLUMN IF EXISTS kdf_memory,
+	DROP COLUMN IF EXISTS kdf_threads;
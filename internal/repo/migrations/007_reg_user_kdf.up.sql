@@ -0,0 +1,5 @@
+ALTER TABLE reg_user
+	ADD COLUMN IF NOT EXISTS kdf_salt bytea,
+	ADD COLUMN IF NOT EXISTS kdf_time integer,
+	ADD COLUMN IF NOT EXISTS kdf_memory integer,
+	ADD COLUMN IF NOT EXISTS kdf_threads smallint;
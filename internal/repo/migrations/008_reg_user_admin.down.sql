@@ -0,0 +1 @@
+ALTER TABLE reg_user DROP COLUMN IF EXISTS is_admin;
@@ -0,0 +1,2 @@
+-- Admins are granted manually: UPDATE reg_user SET is_admin = true WHERE name = '...';
+ALTER TABLE reg_user ADD COLUMN IF NOT EXISTS is_admin boolean NOT NULL DEFAULT false;
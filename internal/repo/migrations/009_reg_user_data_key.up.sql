@@ -0,0 +1,3 @@
+-- data_key is filled on the next login of users created before data keys
+ALTER TABLE reg_user
+	ADD COLUMN IF NOT EXISTS data_key text;
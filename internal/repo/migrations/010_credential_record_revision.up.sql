@@ -0,0 +1,4 @@
+-- encrypted fields of rows with revision 0 are not bound to their record yet,
+-- they are re-encrypted on the next update
+ALTER TABLE credential_record
+	ADD COLUMN IF NOT EXISTS revision integer NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS recovery_event;
+DROP TABLE IF EXISTS recovery_ceremony_share;
+DROP TABLE IF EXISTS recovery_ceremony;
+DROP TABLE IF EXISTS recovery_trustee;
+DROP TABLE IF EXISTS recovery_setup;
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS recovery_setup (
+	user_id uuid PRIMARY KEY REFERENCES reg_user(id) ON DELETE CASCADE,
+	threshold integer NOT NULL,
+	key_id text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recovery_trustee (
+	user_id uuid NOT NULL REFERENCES recovery_setup(user_id) ON DELETE CASCADE,
+	trustee_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, trustee_id)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_ceremony (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	status text NOT NULL,
+	secret_hash text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL
+);
+
+-- shares are encrypted with the server keys while a ceremony is open
+CREATE TABLE IF NOT EXISTS recovery_ceremony_share (
+	id uuid PRIMARY KEY,
+	ceremony_id uuid NOT NULL REFERENCES recovery_ceremony(id) ON DELETE CASCADE,
+	trustee_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	share text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (ceremony_id, trustee_id)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_event (
+	id uuid PRIMARY KEY,
+	ceremony_id uuid NOT NULL REFERENCES recovery_ceremony(id) ON DELETE CASCADE,
+	kind text NOT NULL,
+	actor_id uuid REFERENCES reg_user(id) ON DELETE SET NULL,
+	detail text NOT NULL DEFAULT '',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+-- blind index tokens are keyed per user, they can only be matched against tokens computed with the owner's key
+CREATE TABLE IF NOT EXISTS record_index (
+	record_id uuid NOT NULL REFERENCES credential_record ON DELETE CASCADE,
+	kind text NOT NULL,
+	token text NOT NULL,
+	PRIMARY KEY (record_id, kind, token)
+);
+
+CREATE INDEX IF NOT EXISTS record_index_token ON record_index (token);
@@ -0,0 +1,2 @@
+ALTER TABLE credential_record
+	DROP COLUMN IF EXISTS seal;
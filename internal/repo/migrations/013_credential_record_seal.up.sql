@@ -0,0 +1,4 @@
+-- fields tagged with a seal above the seal of a row are still plaintext on it, see model.LatestSeal.
+-- They are encrypted on the next update
+ALTER TABLE credential_record
+	ADD COLUMN IF NOT EXISTS seal integer NOT NULL DEFAULT 0;
@@ -0,0 +1,20 @@
+DROP POLICY IF EXISTS reg_user_owner ON reg_user;
+DROP POLICY IF EXISTS record_index_owner ON record_index;
+DROP POLICY IF EXISTS identity_owner ON identity;
+DROP POLICY IF EXISTS card_owner ON card;
+DROP POLICY IF EXISTS login_owner ON login;
+DROP POLICY IF EXISTS credential_record_owner ON credential_record;
+
+ALTER TABLE reg_user DISABLE ROW LEVEL SECURITY;
+ALTER TABLE record_index DISABLE ROW LEVEL SECURITY;
+ALTER TABLE identity DISABLE ROW LEVEL SECURITY;
+ALTER TABLE card DISABLE ROW LEVEL SECURITY;
+ALTER TABLE login DISABLE ROW LEVEL SECURITY;
+ALTER TABLE credential_record DISABLE ROW LEVEL SECURITY;
+
+DROP FUNCTION IF EXISTS app_user_id();
+
+REVOKE ALL ON reg_user FROM pm_record_owner;
+REVOKE ALL ON credential_record, login, card, identity, record_index FROM pm_record_owner;
+REVOKE pm_record_owner FROM CURRENT_USER;
+DROP ROLE IF EXISTS pm_record_owner;
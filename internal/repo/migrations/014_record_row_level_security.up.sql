@@ -0,0 +1,50 @@
+-- Record queries made on behalf of a user run as pm_record_owner with app.user_id set for the transaction,
+-- policies only let them see and write rows of that user. The role the server connects with owns the tables
+-- and keeps bypassing the policies for maintenance like key rotation.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'pm_record_owner') THEN
+		CREATE ROLE pm_record_owner NOLOGIN NOBYPASSRLS;
+	END IF;
+END
+$$;
+
+GRANT pm_record_owner TO CURRENT_USER;
+GRANT SELECT, INSERT, UPDATE, DELETE ON credential_record, login, card, identity, record_index TO pm_record_owner;
+-- vault rekeys update the owner in the same transaction, the reg_user_owner policy limits that to the owner
+GRANT SELECT, UPDATE ON reg_user TO pm_record_owner;
+
+CREATE OR REPLACE FUNCTION app_user_id() RETURNS uuid
+	LANGUAGE sql STABLE
+	AS $$ SELECT NULLIF(current_setting('app.user_id', true), '')::uuid $$;
+
+ALTER TABLE credential_record ENABLE ROW LEVEL SECURITY;
+ALTER TABLE login ENABLE ROW LEVEL SECURITY;
+ALTER TABLE card ENABLE ROW LEVEL SECURITY;
+ALTER TABLE identity ENABLE ROW LEVEL SECURITY;
+ALTER TABLE record_index ENABLE ROW LEVEL SECURITY;
+ALTER TABLE reg_user ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY reg_user_owner ON reg_user TO pm_record_owner
+	USING (id = app_user_id())
+	WITH CHECK (id = app_user_id());
+
+CREATE POLICY credential_record_owner ON credential_record TO pm_record_owner
+	USING (created_by = app_user_id())
+	WITH CHECK (created_by = app_user_id());
+
+CREATE POLICY login_owner ON login TO pm_record_owner
+	USING (EXISTS (SELECT FROM credential_record cr WHERE cr.id = login.id AND cr.created_by = app_user_id()))
+	WITH CHECK (EXISTS (SELECT FROM credential_record cr WHERE cr.id = login.id AND cr.created_by = app_user_id()));
+
+CREATE POLICY card_owner ON card TO pm_record_owner
+	USING (EXISTS (SELECT FROM credential_record cr WHERE cr.id = card.id AND cr.created_by = app_user_id()))
+	WITH CHECK (EXISTS (SELECT FROM credential_record cr WHERE cr.id = card.id AND cr.created_by = app_user_id()));
+
+CREATE POLICY identity_owner ON identity TO pm_record_owner
+	USING (EXISTS (SELECT FROM credential_record cr WHERE cr.id = identity.id AND cr.created_by = app_user_id()))
+	WITH CHECK (EXISTS (SELECT FROM credential_record cr WHERE cr.id = identity.id AND cr.created_by = app_user_id()));
+
+CREATE POLICY record_index_owner ON record_index TO pm_record_owner
+	USING (EXISTS (SELECT FROM credential_record cr WHERE cr.id = record_index.record_id AND cr.created_by = app_user_id()))
+	WITH CHECK (EXISTS (SELECT FROM credential_record cr WHERE cr.id = record_index.record_id AND cr.created_by = app_user_id()));
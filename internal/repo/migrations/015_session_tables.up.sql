@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS session (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	user_agent text NOT NULL DEFAULT '',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL,
+	revoked_on timestamp
+);
+
+CREATE INDEX IF NOT EXISTS session_user_id ON session (user_id);
+
+-- every refresh token a session was issued is kept until the session is deleted, so that reuse of a rotated one is detected
+CREATE TABLE IF NOT EXISTS refresh_token (
+	hash text PRIMARY KEY,
+	session_id uuid NOT NULL REFERENCES session(id) ON DELETE CASCADE,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	used_on timestamp
+);
@@ -0,0 +1,15 @@
+-- scopes are stored space separated, as in OAuth
+CREATE TABLE IF NOT EXISTS access_token (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	name text NOT NULL,
+	hash text NOT NULL UNIQUE,
+	scopes text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL,
+	last_used_on timestamp,
+	last_used_ip text NOT NULL DEFAULT '',
+	revoked_on timestamp
+);
+
+CREATE INDEX IF NOT EXISTS access_token_user_id ON access_token (user_id);
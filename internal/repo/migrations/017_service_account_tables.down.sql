@@ -0,0 +1,9 @@
+DELETE FROM access_token WHERE service_account_id IS NOT NULL;
+
+ALTER TABLE access_token
+	DROP CONSTRAINT IF EXISTS access_token_principal,
+	DROP COLUMN IF EXISTS service_account_id,
+	ALTER COLUMN user_id SET NOT NULL;
+
+DROP TABLE IF EXISTS record_grant;
+DROP TABLE IF EXISTS service_account;
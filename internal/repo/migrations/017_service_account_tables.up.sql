@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS service_account (
+	id uuid PRIMARY KEY,
+	name text NOT NULL,
+	created_by uuid REFERENCES reg_user(id) ON DELETE SET NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- owner_id is the creator of the record, it never changes and is kept so that grants resolve without reading the record
+CREATE TABLE IF NOT EXISTS record_grant (
+	service_account_id uuid NOT NULL REFERENCES service_account(id) ON DELETE CASCADE,
+	record_id uuid NOT NULL REFERENCES credential_record(id) ON DELETE CASCADE,
+	owner_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	granted_by uuid REFERENCES reg_user(id) ON DELETE SET NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (service_account_id, record_id)
+);
+
+-- access tokens belong to either a user or a service account
+ALTER TABLE access_token
+	ALTER COLUMN user_id DROP NOT NULL,
+	ADD COLUMN IF NOT EXISTS service_account_id uuid REFERENCES service_account(id) ON DELETE CASCADE,
+	ADD CONSTRAINT access_token_principal CHECK ((user_id IS NULL) <> (service_account_id IS NULL));
+
+CREATE INDEX IF NOT EXISTS access_token_service_account_id ON access_token (service_account_id);
@@ -0,0 +1,26 @@
+-- id is the ID of the user, secret is encrypted with the server keyring
+CREATE TABLE IF NOT EXISTS user_totp (
+	id uuid PRIMARY KEY REFERENCES reg_user(id) ON DELETE CASCADE,
+	secret text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	confirmed_on timestamp,
+	last_used_step bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+	hash text PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	used_on timestamp
+);
+
+CREATE INDEX IF NOT EXISTS recovery_code_user_id ON recovery_code (user_id);
+
+CREATE TABLE IF NOT EXISTS login_challenge (
+	hash text PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	used_on timestamp
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webauthn_ceremony;
+DROP TABLE IF EXISTS webauthn_credential;
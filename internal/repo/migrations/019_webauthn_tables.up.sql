@@ -0,0 +1,24 @@
+-- transports are stored space separated, public_key is a COSE_Key
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	name text NOT NULL,
+	credential_id bytea NOT NULL UNIQUE,
+	public_key bytea NOT NULL,
+	sign_count bigint NOT NULL DEFAULT 0,
+	transports text NOT NULL DEFAULT '',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_on timestamp
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credential_user_id ON webauthn_credential (user_id);
+
+-- user_id is null for passwordless logins, the user is only known once a passkey answers
+CREATE TABLE IF NOT EXISTS webauthn_ceremony (
+	challenge text PRIMARY KEY,
+	kind text NOT NULL,
+	user_id uuid REFERENCES reg_user(id) ON DELETE CASCADE,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL,
+	used_on timestamp
+);
@@ -0,0 +1,23 @@
+-- groups are stored as a JSON array, group names may contain spaces
+CREATE TABLE IF NOT EXISTS user_identity (
+	issuer text NOT NULL,
+	subject text NOT NULL,
+	user_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	groups text NOT NULL DEFAULT '[]',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_login_on timestamp,
+	PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identity_user_id ON user_identity (user_id);
+
+-- hash is the SHA-256 of the state sent to the provider
+CREATE TABLE IF NOT EXISTS oidc_login (
+	hash text PRIMARY KEY,
+	nonce text NOT NULL,
+	verifier text NOT NULL,
+	link_user_id uuid REFERENCES reg_user(id) ON DELETE CASCADE,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_on timestamp NOT NULL,
+	used_on timestamp
+);
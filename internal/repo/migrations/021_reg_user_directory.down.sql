@@ -0,0 +1,2 @@
+ALTER TABLE reg_user DROP COLUMN IF EXISTS disabled_on;
+ALTER TABLE reg_user DROP COLUMN IF EXISTS directory_dn;
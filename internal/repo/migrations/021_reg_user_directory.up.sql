@@ -0,0 +1,3 @@
+-- directory_dn is the normalized DN of users managed by the LDAP sync
+ALTER TABLE reg_user ADD COLUMN IF NOT EXISTS directory_dn text UNIQUE;
+ALTER TABLE reg_user ADD COLUMN IF NOT EXISTS disabled_on timestamp;
@@ -0,0 +1,13 @@
+-- subject is "CN=<common name>" or a URI SAN of client certificates issued by the trusted CAs,
+-- scopes are stored space separated as for access tokens
+CREATE TABLE IF NOT EXISTS client_certificate (
+	id uuid PRIMARY KEY,
+	subject text NOT NULL UNIQUE,
+	user_id uuid REFERENCES reg_user(id) ON DELETE CASCADE,
+	service_account_id uuid REFERENCES service_account(id) ON DELETE CASCADE,
+	scopes text NOT NULL,
+	created_by uuid REFERENCES reg_user(id) ON DELETE SET NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_on timestamp,
+	CONSTRAINT client_certificate_principal CHECK ((user_id IS NULL) <> (service_account_id IS NULL))
+);
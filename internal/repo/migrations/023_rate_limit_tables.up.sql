@@ -0,0 +1,15 @@
+-- token buckets and failed sign ins live here so that every instance enforces the same limits,
+-- keys are prefixed with what they limit, such as "ip:" or "login-account:"
+CREATE TABLE IF NOT EXISTS rate_limit_bucket (
+	key text PRIMARY KEY,
+	tokens double precision NOT NULL,
+	updated_on timestamp NOT NULL
+);
+
+-- account is the name signed in with, which may not belong to a user
+CREATE TABLE IF NOT EXISTS login_failure (
+	account text PRIMARY KEY,
+	failures integer NOT NULL,
+	last_failure_on timestamp NOT NULL,
+	locked_until timestamp
+);
@@ -0,0 +1,2 @@
+ALTER TABLE reg_user
+	DROP COLUMN IF EXISTS vault_version;
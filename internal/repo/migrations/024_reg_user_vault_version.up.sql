@@ -0,0 +1,3 @@
+-- vaults below model.LatestVaultVersion are rewritten on the next login of their owner or by cmd/rekey
+ALTER TABLE reg_user
+	ADD COLUMN IF NOT EXISTS vault_version integer NOT NULL DEFAULT 0;
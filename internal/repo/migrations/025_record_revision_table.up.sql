@@ -0,0 +1,14 @@
+-- revision high-water marks of records, a record row replayed on its own no longer matches its mark
+CREATE TABLE IF NOT EXISTS record_revision (
+	record_id uuid PRIMARY KEY REFERENCES credential_record ON DELETE CASCADE,
+	revision integer NOT NULL,
+	mac text NOT NULL
+);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON record_revision TO pm_record_owner;
+
+ALTER TABLE record_revision ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY record_revision_owner ON record_revision TO pm_record_owner
+	USING (EXISTS (SELECT FROM credential_record cr WHERE cr.id = record_revision.record_id AND cr.created_by = app_user_id()))
+	WITH CHECK (EXISTS (SELECT FROM credential_record cr WHERE cr.id = record_revision.record_id AND cr.created_by = app_user_id()));
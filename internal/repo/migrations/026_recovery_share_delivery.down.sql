@@ -0,0 +1,5 @@
+ALTER TABLE recovery_ceremony DROP COLUMN IF EXISTS ready_on;
+
+DROP TABLE IF EXISTS recovery_share_delivery;
+
+ALTER TABLE recovery_setup DROP COLUMN IF EXISTS wrapped_key;
@@ -0,0 +1,15 @@
+-- the vault key of the user encrypted with the recovery key, empty for setups made before
+ALTER TABLE recovery_setup ADD COLUMN IF NOT EXISTS wrapped_key text NOT NULL DEFAULT '';
+
+-- shares are encrypted with the server keys until their trustee collects them
+CREATE TABLE IF NOT EXISTS recovery_share_delivery (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES recovery_setup(user_id) ON DELETE CASCADE,
+	trustee_id uuid NOT NULL REFERENCES reg_user(id) ON DELETE CASCADE,
+	share text NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, trustee_id)
+);
+
+-- ceremonies can be completed from ready_on on, their owner can cancel them until then
+ALTER TABLE recovery_ceremony ADD COLUMN IF NOT EXISTS ready_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP;
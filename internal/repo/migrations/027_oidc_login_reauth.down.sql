@@ -0,0 +1,2 @@
+ALTER TABLE oidc_login DROP COLUMN IF EXISTS authenticated_on;
+ALTER TABLE oidc_login DROP COLUMN IF EXISTS reauth;
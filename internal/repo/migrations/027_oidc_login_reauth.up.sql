@@ -0,0 +1,4 @@
+-- reauth logins confirm a signed in user at the provider instead of signing in,
+-- authenticated_on is set once the provider did and the state is then spent on a sensitive change
+ALTER TABLE oidc_login ADD COLUMN IF NOT EXISTS reauth boolean NOT NULL DEFAULT false;
+ALTER TABLE oidc_login ADD COLUMN IF NOT EXISTS authenticated_on timestamp;
@@ -0,0 +1,7 @@
+-- a single row, the policy admins set for single sign-on replaces the one the server was started with
+CREATE TABLE IF NOT EXISTS sso_policy (
+	id boolean PRIMARY KEY DEFAULT true CHECK (id),
+	required boolean NOT NULL,
+	updated_by uuid REFERENCES reg_user(id) ON DELETE SET NULL,
+	updated_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,289 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type Identity struct {
+	Issuer      string
+	Subject     string
+	UserID      uuid.UUID
+	Groups      string
+	CreatedOn   time.Time
+	LastLoginOn *time.Time
+}
+
+func (Identity) TableName() string {
+	return "user_identity"
+}
+
+// newIdentity encodes groups as JSON, unlike scopes group names may contain spaces
+func newIdentity(identity *model.Identity) (*Identity, error) {
+	groups := identity.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	encoded, err := json.Marshal(groups)
+	if err != nil {
+		return nil, fmt.Errorf("marshal groups: %w", err)
+	}
+
+	return &Identity{
+		Issuer:      identity.Issuer,
+		Subject:     identity.Subject,
+		UserID:      identity.UserID,
+		Groups:      string(encoded),
+		CreatedOn:   identity.CreatedOn,
+		LastLoginOn: identity.LastLoginOn,
+	}, nil
+}
+
+func (i *Identity) model() (*model.Identity, error) {
+	var groups []string
+	if err := json.Unmarshal([]byte(i.Groups), &groups); err != nil {
+		return nil, fmt.Errorf("unmarshal groups: %w", err)
+	}
+
+	return &model.Identity{
+		Issuer:      i.Issuer,
+		Subject:     i.Subject,
+		UserID:      i.UserID,
+		Groups:      groups,
+		CreatedOn:   i.CreatedOn,
+		LastLoginOn: i.LastLoginOn,
+	}, nil
+}
+
+type OIDCLogin model.OIDCLogin
+
+// SSOPolicy is the only row of its table
+type SSOPolicy struct {
+	ID        bool `gorm:"primaryKey"`
+	Required  bool
+	UpdatedBy *uuid.UUID
+	UpdatedOn time.Time
+}
+
+func (SSOPolicy) TableName() string {
+	return "sso_policy"
+}
+
+func (OIDCLogin) TableName() string {
+	return "oidc_login"
+}
+
+func NewOIDCRepository(db *gorm.DB) (*OIDCRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &OIDCRepository{db: db}, nil
+}
+
+type OIDCRepository struct {
+	db *gorm.DB
+}
+
+func (r *OIDCRepository) GetIdentity(issuer, subject string) (*model.Identity, error) {
+	var identity Identity
+	if err := r.db.First(&identity, "issuer = ? AND subject = ?", issuer, subject).Error; err != nil {
+		return nil, fmt.Errorf("get identity: %w", convertError(err))
+	}
+
+	return identity.model()
+}
+
+// GetIdentities returns the identities linked to userID, oldest first
+func (r *OIDCRepository) GetIdentities(userID uuid.UUID) ([]model.Identity, error) {
+	var identities []Identity
+	if err := r.db.Where("user_id = ?", userID).Order("created_on, issuer, subject").Find(&identities).Error; err != nil {
+		return nil, fmt.Errorf("get identities: %w", convertError(err))
+	}
+
+	result := make([]model.Identity, len(identities))
+	for i, identity := range identities {
+		m, err := identity.model()
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = *m
+	}
+
+	return result, nil
+}
+
+func (r *OIDCRepository) CreateIdentity(identity *model.Identity) error {
+	i, err := newIdentity(identity)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.Create(i).Error; err != nil {
+		return fmt.Errorf("create identity: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// Provision creates user along with its identity, so that no user is left without a way to sign in
+func (r *OIDCRepository) Provision(user *model.User, identity *model.Identity) error {
+	i, err := newIdentity(identity)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		core := User(*user)
+		if err := tx.Create(&core).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(i).Error
+	})
+	if err != nil {
+		return fmt.Errorf("provision: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// TouchIdentity stores the groups the provider reported on a sign in
+func (r *OIDCRepository) TouchIdentity(issuer, subject string, groups []string, now time.Time) error {
+	if groups == nil {
+		groups = []string{}
+	}
+
+	encoded, err := json.Marshal(groups)
+	if err != nil {
+		return fmt.Errorf("marshal groups: %w", err)
+	}
+
+	result := r.db.Model(&Identity{}).
+		Where("issuer = ? AND subject = ?", issuer, subject).
+		Updates(map[string]interface{}{"groups": string(encoded), "last_login_on": now})
+	if result.Error != nil {
+		return fmt.Errorf("touch identity: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("touch identity: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// SetAdmin grants or revokes admin rights of userID, Updates of a user struct skips revoking as false is a zero value
+func (r *OIDCRepository) SetAdmin(userID uuid.UUID, isAdmin bool) error {
+	result := r.db.Model(&User{}).Where("id = ?", userID).Update("is_admin", isAdmin)
+	if result.Error != nil {
+		return fmt.Errorf("set admin: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("set admin: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+func (r *OIDCRepository) CreateLogin(login *model.OIDCLogin) error {
+	l := OIDCLogin(*login)
+	if err := r.db.Create(&l).Error; err != nil {
+		return fmt.Errorf("create oidc login: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *OIDCRepository) GetLogin(hash string) (*model.OIDCLogin, error) {
+	var login OIDCLogin
+	if err := r.db.First(&login, "hash = ?", hash).Error; err != nil {
+		return nil, fmt.Errorf("get oidc login: %w", convertError(err))
+	}
+
+	return (*model.OIDCLogin)(&login), nil
+}
+
+// UseLogin marks login hash used, logins used meanwhile are reported as pmerror.ErrNotFound
+func (r *OIDCRepository) UseLogin(hash string, now time.Time) error {
+	result := r.db.Model(&OIDCLogin{}).
+		Where("hash = ? AND used_on IS NULL", hash).
+		Update("used_on", now)
+	if result.Error != nil {
+		return fmt.Errorf("use oidc login: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use oidc login: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// AuthenticateLogin records that the provider signed the user of reauth login hash in again
+func (r *OIDCRepository) AuthenticateLogin(hash string, now time.Time) error {
+	result := r.db.Model(&OIDCLogin{}).
+		Where("hash = ? AND reauth", hash).
+		Update("authenticated_on", now)
+	if result.Error != nil {
+		return fmt.Errorf("authenticate oidc login: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("authenticate oidc login: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// SpendReauth deletes reauth login hash of userID authenticated since, so that its state proves presence once.
+// Logins that are unknown, of another user, not authenticated or too old are reported as pmerror.ErrNotFound.
+func (r *OIDCRepository) SpendReauth(hash string, userID uuid.UUID, since time.Time) error {
+	result := r.db.
+		Where("hash = ? AND reauth AND link_user_id = ? AND authenticated_on >= ?", hash, userID, since).
+		Delete(&OIDCLogin{})
+	if result.Error != nil {
+		return fmt.Errorf("spend oidc reauth: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("spend oidc reauth: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// GetPolicy returns the single sign-on policy admins set, pmerror.ErrNotFound while none did
+func (r *OIDCRepository) GetPolicy() (*model.SSOPolicy, error) {
+	var policy SSOPolicy
+	if err := r.db.First(&policy, "id").Error; err != nil {
+		return nil, fmt.Errorf("get sso policy: %w", convertError(err))
+	}
+
+	return &model.SSOPolicy{Required: policy.Required, UpdatedBy: policy.UpdatedBy, UpdatedOn: &policy.UpdatedOn}, nil
+}
+
+// SetPolicy stores the single sign-on policy, replacing the former one
+func (r *OIDCRepository) SetPolicy(policy *model.SSOPolicy) error {
+	p := SSOPolicy{ID: true, Required: policy.Required, UpdatedBy: policy.UpdatedBy, UpdatedOn: *policy.UpdatedOn}
+	err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"required", "updated_by", "updated_on"}),
+	}).Create(&p).Error
+	if err != nil {
+		return fmt.Errorf("set sso policy: %w", convertError(err))
+	}
+
+	return nil
+}
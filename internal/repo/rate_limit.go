@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmratelimit"
+)
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedOn time.Time
+}
+
+func (RateLimitBucket) TableName() string {
+	return "rate_limit_bucket"
+}
+
+type LoginFailure struct {
+	Account       string
+	Failures      int
+	LastFailureOn time.Time
+	LockedUntil   *time.Time
+}
+
+func (LoginFailure) TableName() string {
+	return "login_failure"
+}
+
+func (f *LoginFailure) model() *model.LoginFailure {
+	return &model.LoginFailure{
+		Account:       f.Account,
+		Failures:      f.Failures,
+		LastFailureOn: f.LastFailureOn,
+		LockedUntil:   f.LockedUntil,
+	}
+}
+
+func NewRateLimitRepository(db *gorm.DB) (*RateLimitRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &RateLimitRepository{db: db}, nil
+}
+
+type RateLimitRepository struct {
+	db *gorm.DB
+}
+
+// Take takes a token from bucket key, the row stays locked while it is refilled so that concurrent
+// requests to any instance take turns
+func (r *RateLimitRepository) Take(key string, limit pmratelimit.Limit, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// buckets are created full, so that there is a row to lock
+		full := limit.New(now)
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RateLimitBucket{Key: key, Tokens: full.Tokens, UpdatedOn: full.UpdatedOn}).Error; err != nil {
+			return err
+		}
+
+		var stored RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, "key = ?", key).Error; err != nil {
+			return err
+		}
+
+		bucket := pmratelimit.Bucket{Tokens: stored.Tokens, UpdatedOn: stored.UpdatedOn}
+		wait = limit.Take(&bucket, now)
+
+		return tx.Model(&RateLimitBucket{}).Where("key = ?", key).
+			Updates(map[string]interface{}{"tokens": bucket.Tokens, "updated_on": bucket.UpdatedOn}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("take token: %w", convertError(err))
+	}
+
+	return wait, nil
+}
+
+func (r *RateLimitRepository) GetLoginFailure(account string) (*model.LoginFailure, error) {
+	var failure LoginFailure
+	if err := r.db.First(&failure, "account = ?", account).Error; err != nil {
+		return nil, fmt.Errorf("get login failure: %w", convertError(err))
+	}
+
+	return failure.model(), nil
+}
+
+// AddLoginFailure counts a failed sign in with account, failures before since are forgotten
+func (r *RateLimitRepository) AddLoginFailure(account string, since, now time.Time) (*model.LoginFailure, error) {
+	var failure LoginFailure
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// counted in a single statement, concurrent failures on other instances must not be lost
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "account"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("CASE WHEN login_failure.last_failure_on < ? THEN 1 ELSE login_failure.failures + 1 END", since),
+				"last_failure_on": now,
+			}),
+		}).Create(&LoginFailure{Account: account, Failures: 1, LastFailureOn: now}).Error; err != nil {
+			return err
+		}
+
+		return tx.First(&failure, "account = ?", account).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add login failure: %w", convertError(err))
+	}
+
+	return failure.model(), nil
+}
+
+func (r *RateLimitRepository) LockAccount(account string, until time.Time) error {
+	if err := r.db.Model(&LoginFailure{}).Where("account = ?", account).Update("locked_until", until).Error; err != nil {
+		return fmt.Errorf("lock account: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// ResetLoginFailures forgets the failed sign ins with account, along with a lockout
+func (r *RateLimitRepository) ResetLoginFailures(account string) error {
+	if err := r.db.Where("account = ?", account).Delete(&LoginFailure{}).Error; err != nil {
+		return fmt.Errorf("reset login failures: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// Prune deletes buckets and login failures last updated before before, accounts still locked at now are kept
+func (r *RateLimitRepository) Prune(before, now time.Time) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("updated_on < ?", before).Delete(&RateLimitBucket{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("last_failure_on < ? AND (locked_until IS NULL OR locked_until < ?)", before, now).
+			Delete(&LoginFailure{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("prune rate limits: %w", convertError(err))
+	}
+
+	return nil
+}
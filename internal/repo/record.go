@@ -58,6 +58,52 @@ func (IdentityRecord) TableName() string {
 	return "identity"
 }
 
+type RecordIndex struct {
+	RecordID uuid.UUID
+	Kind     model.IndexKind
+	Token    string
+}
+
+func (RecordIndex) TableName() string {
+	return "record_index"
+}
+
+type RecordRevision struct {
+	RecordID uuid.UUID
+	Revision int
+	MAC      string
+}
+
+func (RecordRevision) TableName() string {
+	return "record_revision"
+}
+
+func (r *RecordRevision) model() *model.RevisionMark {
+	if r.RecordID == uuid.Nil {
+		return nil
+	}
+
+	return &model.RevisionMark{Revision: r.Revision, MAC: r.MAC}
+}
+
+// RecordOwnerRole is the database role record queries made on behalf of a user run as,
+// row level security only lets it see rows owned by the user set with SetRecordOwner
+const RecordOwnerRole = "pm_record_owner"
+
+// SetRecordOwner restricts the rest of transaction tx to records owned by userID, and to the row of userID itself.
+// SET does not take parameters, so the user goes through set_config.
+func SetRecordOwner(tx *gorm.DB, userID uuid.UUID) error {
+	if err := tx.Exec("SET LOCAL ROLE " + RecordOwnerRole).Error; err != nil {
+		return fmt.Errorf("set record owner role: %w", err)
+	}
+
+	if err := tx.Exec("SELECT set_config('app.user_id', ?, true)", userID.String()).Error; err != nil {
+		return fmt.Errorf("set record owner: %w", err)
+	}
+
+	return nil
+}
+
 func NewRecordRepository(db *gorm.DB) (*RecordRepository, error) {
 	if db == nil {
 		return nil, errors.New("db is nil")
@@ -70,35 +116,112 @@ type RecordRepository struct {
 	db *gorm.DB
 }
 
+// asOwner runs fn in a transaction that can only reach records owned by userID
+func (r *RecordRepository) asOwner(userID uuid.UUID, fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := SetRecordOwner(tx, userID); err != nil {
+			return err
+		}
+
+		return fn(tx)
+	})
+}
+
 func (r *RecordRepository) GetAll(userID uuid.UUID) ([]model.CredentialRecord, []model.LoginRecord, []model.CardRecord, []model.IdentityRecord, error) {
-	var credentialRecords []CredentialRecord
-	if err := r.db.Where("created_by = ?", userID).Order("created_on, name, id").Find(&credentialRecords).Error; err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("get credential records: %w", convertError(err))
+	var vault *model.Vault
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		var err error
+		vault, err = readVault(tx, userID, nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, nil, convertError(err)
+	}
+
+	return vault.SecureNotes, vault.Logins, vault.Cards, vault.Identities, nil
+}
+
+// GetVault returns userID with its wrapped data key and the records of userID with ids, all of them when ids is nil.
+// Both are read in the same transaction, the records are always encrypted with the returned key.
+func (r *RecordRepository) GetVault(userID uuid.UUID, ids []uuid.UUID) (*model.User, *model.Vault, error) {
+	var owner User
+	var vault *model.Vault
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		// not wrapped, unknown owners are not found
+		if err := tx.First(&owner, userID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		vault, err = readVault(tx, userID, ids)
+		return err
+	})
+	if err != nil {
+		return nil, nil, convertError(err)
 	}
 
+	return (*model.User)(&owner), vault, nil
+}
+
+// readVault reads the records of userID with ids, all of them when ids is nil, in order of creation
+func readVault(tx *gorm.DB, userID uuid.UUID, ids []uuid.UUID) (*model.Vault, error) {
+	if ids != nil && len(ids) == 0 {
+		return &model.Vault{}, nil
+	}
+
+	byID := func(q *gorm.DB, column string) *gorm.DB {
+		if ids == nil {
+			return q
+		}
+
+		return q.Where(column+" IN ?", ids)
+	}
+
+	var credentialRecords []CredentialRecord
 	var loginRecords []LoginRecord
-	if err := r.db.Model(&LoginRecord{}).Order("created_on, name, id").
+	var cardRecords []CardRecord
+	var identityRecords []IdentityRecord
+	var marks []RecordRevision
+	if err := byID(tx.Where("created_by = ?", userID), "id").Order("created_on, id").Find(&credentialRecords).Error; err != nil {
+		return nil, fmt.Errorf("get credential records: %w", err)
+	}
+
+	if err := byID(tx.Model(&LoginRecord{}), "cd.id").Order("cd.created_on, cd.id").
 		Joins("INNER JOIN credential_record cd ON cd.id = login.id AND cd.created_by = ?", userID).
 		Scan(&loginRecords).Error; err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("get logins: %w", convertError(err))
+		return nil, fmt.Errorf("get logins: %w", err)
 	}
 
-	var cardRecords []CardRecord
-	if err := r.db.Model(&CardRecord{}).Order("created_on, name, id").
+	if err := byID(tx.Model(&CardRecord{}), "cd.id").Order("cd.created_on, cd.id").
 		Joins("INNER JOIN credential_record cd ON cd.id = card.id AND cd.created_by = ?", userID).
 		Scan(&cardRecords).Error; err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("get cards: %w", convertError(err))
+		return nil, fmt.Errorf("get cards: %w", err)
 	}
 
-	var identityRecords []IdentityRecord
-	if err := r.db.Model(&IdentityRecord{}).Order("created_on, name, id").
+	if err := byID(tx.Model(&IdentityRecord{}), "cd.id").Order("cd.created_on, cd.id").
 		Joins("INNER JOIN credential_record cd ON cd.id = identity.id AND cd.created_by = ?", userID).
 		Scan(&identityRecords).Error; err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("get identities: %w", convertError(err))
+		return nil, fmt.Errorf("get identities: %w", err)
+	}
+
+	if err := byID(tx.Model(&RecordRevision{}), "cd.id").
+		Joins("INNER JOIN credential_record cd ON cd.id = record_revision.record_id AND cd.created_by = ?", userID).
+		Scan(&marks).Error; err != nil {
+		return nil, fmt.Errorf("get revision marks: %w", err)
+	}
+
+	byRecord := make(map[uuid.UUID]*RecordRevision, len(marks))
+	for i := range marks {
+		byRecord[marks[i].RecordID] = &marks[i]
 	}
 
 	core := make(map[uuid.UUID]CredentialRecord, len(credentialRecords))
-	for _, record := range credentialRecords {
+	for i, record := range credentialRecords {
+		if mark, ok := byRecord[record.ID]; ok {
+			record.Mark = mark.model()
+			credentialRecords[i] = record
+		}
+
 		core[record.ID] = record
 	}
 
@@ -153,85 +276,137 @@ func (r *RecordRepository) GetAll(userID uuid.UUID) ([]model.CredentialRecord, [
 		}
 	}
 
-	return secureNotes, logins, cards, identities, nil
+	return &model.Vault{SecureNotes: secureNotes, Logins: logins, Cards: cards, Identities: identities}, nil
 }
 
-func (r *RecordRepository) GetCredentialRecord(id uuid.UUID) (*model.CredentialRecord, error) {
-	var record CredentialRecord
-	if err := r.db.First(&record, id).Error; err != nil {
-		return nil, fmt.Errorf("get record: %w", convertError(err))
-	}
-
-	return (*model.CredentialRecord)(&record), nil
+// recordRow is a core row joined with the type specific rows, only the one matching the record type is set
+type recordRow struct {
+	CredentialRecord
+	Login    LoginRecord    `gorm:"embedded;embeddedPrefix:login_"`
+	Card     CardRecord     `gorm:"embedded;embeddedPrefix:card_"`
+	Identity IdentityRecord `gorm:"embedded;embeddedPrefix:identity_"`
+	Mark     RecordRevision `gorm:"embedded;embeddedPrefix:mark_"`
+	Owner    User           `gorm:"embedded;embeddedPrefix:owner_"`
 }
 
-func (r *RecordRepository) GetLogin(id uuid.UUID) (*model.LoginRecord, error) {
-	var core CredentialRecord
-	if err := r.db.First(&core, id).Error; err != nil {
-		return nil, fmt.Errorf("get core: %w", convertError(err))
-	}
-
-	var record LoginRecord
-	if err := r.db.First(&record, id).Error; err != nil {
-		return nil, fmt.Errorf("get login: %w", convertError(err))
-	}
-
-	return &model.LoginRecord{
-		CredentialRecord: model.CredentialRecord(core),
-		Username:         record.Username,
-		Password:         record.Password,
-		URL:              record.URL,
-	}, nil
+const recordRowColumns = `cr.*,
+	l.id AS login_id, l.username AS login_username, l.password AS login_password, l.url AS login_url,
+	c.id AS card_id, c.brand AS card_brand, c.number AS card_number, c.expiration_month AS card_expiration_month,
+	c.expiration_year AS card_expiration_year, c.cvv AS card_cvv,
+	i.id AS identity_id, i.first_name AS identity_first_name, i.middle_name AS identity_middle_name,
+	i.last_name AS identity_last_name, i.address AS identity_address, i.email AS identity_email,
+	i.phone_number AS identity_phone_number, i.passport_number AS identity_passport_number, i.country AS identity_country,
+	rr.record_id AS mark_record_id, rr.revision AS mark_revision, rr.mac AS mark_mac,
+	u.id AS owner_id, u.name AS owner_name, u.data_key AS owner_data_key, u.vault_version AS owner_vault_version`
+
+// GetRecord returns record id of userID with its type specific fields in a single query, as one of
+// *model.CredentialRecord, *model.LoginRecord, *model.CardRecord and *model.IdentityRecord
+func (r *RecordRepository) GetRecord(userID, id uuid.UUID) (interface{}, error) {
+	_, record, err := r.GetVaultRecord(userID, id)
+	return record, err
 }
 
-func (r *RecordRepository) GetCard(id uuid.UUID) (*model.CardRecord, error) {
-	var core CredentialRecord
-	if err := r.db.First(&core, id).Error; err != nil {
-		return nil, fmt.Errorf("get core: %w", convertError(err))
+// GetVaultRecord returns record id of userID like GetRecord along with its owner, whose wrapped data key and vault version
+// are read by the same query. Records of other users are pmerror.ErrForbidden.
+func (r *RecordRepository) GetVaultRecord(userID, id uuid.UUID) (*model.User, interface{}, error) {
+	var rows []recordRow
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		return tx.Table("credential_record cr").
+			Select(recordRowColumns).
+			Joins("INNER JOIN reg_user u ON u.id = cr.created_by").
+			Joins("LEFT JOIN login l ON l.id = cr.id").
+			Joins("LEFT JOIN card c ON c.id = cr.id").
+			Joins("LEFT JOIN identity i ON i.id = cr.id").
+			Joins("LEFT JOIN record_revision rr ON rr.record_id = cr.id").
+			Where("cr.id = ? AND cr.created_by = ?", id, userID).
+			Limit(1).
+			Scan(&rows).Error
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("get record: %w", convertError(err))
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("get record: %w", r.missing(userID, id))
+	}
+
+	row := rows[0]
+	owner := model.User(row.Owner)
+	core := model.CredentialRecord(row.CredentialRecord)
+	core.Mark = row.Mark.model()
+	switch {
+	case row.Login.ID != uuid.Nil:
+		return &owner, &model.LoginRecord{
+			CredentialRecord: core,
+			Username:         row.Login.Username,
+			Password:         row.Login.Password,
+			URL:              row.Login.URL,
+		}, nil
+	case row.Card.ID != uuid.Nil:
+		return &owner, &model.CardRecord{
+			CredentialRecord: core,
+			Brand:            row.Card.Brand,
+			Number:           row.Card.Number,
+			ExpirationMonth:  row.Card.ExpirationMonth,
+			ExpirationYear:   row.Card.ExpirationYear,
+			CVV:              row.Card.CVV,
+		}, nil
+	case row.Identity.ID != uuid.Nil:
+		return &owner, &model.IdentityRecord{
+			CredentialRecord: core,
+			FirstName:        row.Identity.FirstName,
+			MiddleName:       row.Identity.MiddleName,
+			LastName:         row.Identity.LastName,
+			Address:          row.Identity.Address,
+			Email:            row.Identity.Email,
+			PhoneNumber:      row.Identity.PhoneNumber,
+			PassportNumber:   row.Identity.PassportNumber,
+			Country:          row.Identity.Country,
+		}, nil
+	default:
+		return &owner, &core, nil
 	}
+}
 
-	var record CardRecord
-	if err := r.db.First(&record, id).Error; err != nil {
-		return nil, fmt.Errorf("get login: %w", convertError(err))
+// ownedError converts err of a query on behalf of userID that had to reach record id
+func (r *RecordRepository) ownedError(userID, id uuid.UUID, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return r.missing(userID, id)
 	}
 
-	return &model.CardRecord{
-		CredentialRecord: model.CredentialRecord(core),
-		Brand:            record.Brand,
-		Number:           record.Number,
-		ExpirationMonth:  record.ExpirationMonth,
-		ExpirationYear:   record.ExpirationYear,
-		CVV:              record.CVV,
-	}, nil
+	return convertError(err)
 }
 
-func (r *RecordRepository) GetIdentity(id uuid.UUID) (*model.IdentityRecord, error) {
-	var core CredentialRecord
-	if err := r.db.First(&core, id).Error; err != nil {
-		return nil, fmt.Errorf("get core: %w", convertError(err))
+// missing tells a record that does not exist from one that userID does not own,
+// once a query on behalf of userID did not find record id
+func (r *RecordRepository) missing(userID, id uuid.UUID) error {
+	var record CredentialRecord
+	err := r.db.Select("created_by").First(&record, id).Error
+	if err != nil {
+		return convertError(err)
 	}
 
-	var record IdentityRecord
-	if err := r.db.First(&record, id).Error; err != nil {
-		return nil, fmt.Errorf("get login: %w", convertError(err))
+	if record.CreatedBy == userID {
+		return pmerror.ErrNotFound
 	}
 
-	return &model.IdentityRecord{
-		CredentialRecord: model.CredentialRecord(core),
-		FirstName:        record.FirstName,
-		MiddleName:       record.MiddleName,
-		LastName:         record.LastName,
-		Address:          record.Address,
-		Email:            record.Email,
-		PhoneNumber:      record.PhoneNumber,
-		PassportNumber:   record.PassportNumber,
-		Country:          record.Country,
-	}, nil
+	return fmt.Errorf("%w: user %s does not own record %s", pmerror.ErrForbidden, userID.String(), id.String())
 }
 
-func (r *RecordRepository) CreateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error) {
+func (r *RecordRepository) CreateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error) {
 	credentialRecord := CredentialRecord(*record)
-	if err := r.db.Create(&credentialRecord).Error; err != nil {
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		if err := tx.Create(&credentialRecord).Error; err != nil {
+			return err
+		}
+
+		if err := setMark(tx, credentialRecord.ID, record.Mark); err != nil {
+			return err
+		}
+
+		return setIndex(tx, credentialRecord.ID, index)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("create: %w", convertError(err))
 	}
 
@@ -240,159 +415,323 @@ func (r *RecordRepository) CreateCredentialRecord(record *model.CredentialRecord
 	return record, nil
 }
 
-func (r *RecordRepository) CreateLogin(record *model.LoginRecord) (*model.LoginRecord, error) {
-	core := CredentialRecord(record.CredentialRecord)
-	if err := r.db.Create(&core).Error; err != nil {
-		return nil, fmt.Errorf("create core: %w", convertError(err))
+func (r *RecordRepository) CreateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error) {
+	err := r.createRecord(userID, &record.CredentialRecord, index, func(id uuid.UUID) any {
+		return r.buildLogin(id, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create login: %w", err)
 	}
 
-	login := r.buildLogin(core.ID, record)
+	return record, nil
+}
 
-	if err := r.db.Create(login).Error; err != nil {
-		return nil, fmt.Errorf("create login: %w", convertError(err))
+func (r *RecordRepository) CreateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error) {
+	err := r.createRecord(userID, &record.CredentialRecord, index, func(id uuid.UUID) any {
+		return r.buildCard(id, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create card: %w", err)
 	}
 
-	record.ID = login.ID
-
 	return record, nil
 }
 
-func (r *RecordRepository) CreateCard(record *model.CardRecord) (*model.CardRecord, error) {
-	core := CredentialRecord(record.CredentialRecord)
-	if err := r.db.Create(&core).Error; err != nil {
-		return nil, fmt.Errorf("create core: %w", convertError(err))
+func (r *RecordRepository) CreateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error) {
+	err := r.createRecord(userID, &record.CredentialRecord, index, func(id uuid.UUID) any {
+		return r.buildIdentity(id, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
 	}
 
-	card := r.buildCard(core.ID, record)
+	return record, nil
+}
 
-	if err := r.db.Create(card).Error; err != nil {
-		return nil, fmt.Errorf("create card: %w", convertError(err))
-	}
+// createRecord writes the core and type specific rows of a record and its index in a single transaction
+func (r *RecordRepository) createRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry, details func(id uuid.UUID) any) error {
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		core := CredentialRecord(*record)
+		if err := tx.Create(&core).Error; err != nil {
+			return fmt.Errorf("create core: %w", err)
+		}
 
-	record.ID = card.ID
+		if err := tx.Create(details(core.ID)).Error; err != nil {
+			return fmt.Errorf("create details: %w", err)
+		}
 
-	return record, nil
+		if err := setMark(tx, core.ID, record.Mark); err != nil {
+			return err
+		}
+
+		if err := setIndex(tx, core.ID, index); err != nil {
+			return err
+		}
+
+		record.ID = core.ID
+
+		return nil
+	})
+
+	return convertError(err)
 }
 
-func (r *RecordRepository) CreateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error) {
-	core := CredentialRecord(record.CredentialRecord)
-	if err := r.db.Create(&core).Error; err != nil {
-		return nil, fmt.Errorf("create core: %w", convertError(err))
-	}
+// UpdateCredentialRecord writes every column along with the index, encrypted fields are only valid together with their revision
+func (r *RecordRepository) UpdateCredentialRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry) (*model.CredentialRecord, error) {
+	credentialRecord := CredentialRecord(*record)
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		result := tx.Model(credentialRecord).Where("created_by = ?", userID).Select("*").Updates(credentialRecord)
+		if result.Error != nil {
+			return result.Error
+		}
 
-	identity := r.buildIdentity(core.ID, record)
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
-	if err := r.db.Create(identity).Error; err != nil {
-		return nil, fmt.Errorf("create identity: %w", convertError(err))
-	}
+		if err := setMark(tx, record.ID, record.Mark); err != nil {
+			return err
+		}
 
-	record.ID = identity.ID
+		return setIndex(tx, record.ID, index)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update: %w", r.ownedError(userID, record.ID, err))
+	}
 
 	return record, nil
 }
 
-func (r *RecordRepository) UpdateCredentialRecord(record *model.CredentialRecord) (*model.CredentialRecord, error) {
-	credentialRecord := CredentialRecord(*record)
-	result := r.db.Model(credentialRecord).Clauses(clause.Returning{}).Updates(credentialRecord)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update: %w", convertError(result.Error))
+func (r *RecordRepository) UpdateLogin(userID uuid.UUID, record *model.LoginRecord, index []model.IndexEntry) (*model.LoginRecord, error) {
+	err := r.updateRecord(userID, &record.CredentialRecord, index, r.buildLogin(record.ID, record))
+	if err != nil {
+		return nil, fmt.Errorf("update login: %w", err)
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	return record, nil
+}
+
+func (r *RecordRepository) UpdateCard(userID uuid.UUID, record *model.CardRecord, index []model.IndexEntry) (*model.CardRecord, error) {
+	err := r.updateRecord(userID, &record.CredentialRecord, index, r.buildCard(record.ID, record))
+	if err != nil {
+		return nil, fmt.Errorf("update card: %w", err)
 	}
 
 	return record, nil
 }
 
-func (r *RecordRepository) UpdateLogin(record *model.LoginRecord) (*model.LoginRecord, error) {
-	// FIXME: empty cred
-	core := CredentialRecord(record.CredentialRecord)
-	result := r.db.Model(core).Clauses(clause.Returning{}).Updates(core)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update core: %w", convertError(result.Error))
+func (r *RecordRepository) UpdateIdentity(userID uuid.UUID, record *model.IdentityRecord, index []model.IndexEntry) (*model.IdentityRecord, error) {
+	err := r.updateRecord(userID, &record.CredentialRecord, index, r.buildIdentity(record.ID, record))
+	if err != nil {
+		return nil, fmt.Errorf("update identity: %w", err)
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
-	}
+	return record, nil
+}
 
-	login := r.buildLogin(core.ID, record)
+// updateRecord writes the core and type specific rows of a record and its index in a single transaction
+func (r *RecordRepository) updateRecord(userID uuid.UUID, record *model.CredentialRecord, index []model.IndexEntry, details any) error {
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		core := CredentialRecord(*record)
+		result := tx.Model(&core).Where("created_by = ?", userID).Select("*").Updates(&core)
+		if result.Error != nil {
+			return fmt.Errorf("update core: %w", result.Error)
+		}
 
-	result = r.db.Model(login).Clauses(clause.Returning{}).Updates(login)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update login: %w", convertError(result.Error))
-	}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
-	}
+		result = tx.Model(details).Select("*").Updates(details)
+		if result.Error != nil {
+			return fmt.Errorf("update details: %w", result.Error)
+		}
 
-	return record, nil
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := setMark(tx, record.ID, record.Mark); err != nil {
+			return err
+		}
+
+		return setIndex(tx, record.ID, index)
+	})
+
+	return r.ownedError(userID, record.ID, err)
 }
 
-func (r *RecordRepository) UpdateCard(record *model.CardRecord) (*model.CardRecord, error) {
-	core := CredentialRecord(record.CredentialRecord)
-	result := r.db.Model(core).Clauses(clause.Returning{}).Updates(core)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update core: %w", convertError(result.Error))
+func (r *RecordRepository) Delete(userID, id uuid.UUID) (*model.CredentialRecord, error) {
+	var record CredentialRecord
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Returning{}).Where("id = ? AND created_by = ?", id, userID).Delete(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete: %w", r.ownedError(userID, id, err))
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	return (*model.CredentialRecord)(&record), nil
+}
+
+func (r *RecordRepository) Rekey(user *model.User, vault *model.Vault) error {
+	err := r.asOwner(user.ID, func(tx *gorm.DB) error {
+		for _, record := range vault.SecureNotes {
+			core := CredentialRecord(record)
+			if err := tx.Model(&core).Select("name", "notes", "revision", "seal").Updates(&core).Error; err != nil {
+				return fmt.Errorf("update secure note: %w", err)
+			}
+
+			if err := setMark(tx, record.ID, record.Mark); err != nil {
+				return err
+			}
+		}
+
+		for _, record := range vault.Logins {
+			core := CredentialRecord(record.CredentialRecord)
+			if err := tx.Model(&core).Select("name", "notes", "revision", "seal").Updates(&core).Error; err != nil {
+				return fmt.Errorf("update login core: %w", err)
+			}
+
+			if err := setMark(tx, record.ID, record.Mark); err != nil {
+				return err
+			}
+
+			login := r.buildLogin(record.ID, &record)
+			if err := tx.Model(login).Select("*").Updates(login).Error; err != nil {
+				return fmt.Errorf("update login: %w", err)
+			}
+		}
+
+		for _, record := range vault.Cards {
+			core := CredentialRecord(record.CredentialRecord)
+			if err := tx.Model(&core).Select("name", "notes", "revision", "seal").Updates(&core).Error; err != nil {
+				return fmt.Errorf("update card core: %w", err)
+			}
+
+			if err := setMark(tx, record.ID, record.Mark); err != nil {
+				return err
+			}
+
+			card := r.buildCard(record.ID, &record)
+			if err := tx.Model(card).Select("*").Updates(card).Error; err != nil {
+				return fmt.Errorf("update card: %w", err)
+			}
+		}
+
+		for _, record := range vault.Identities {
+			core := CredentialRecord(record.CredentialRecord)
+			if err := tx.Model(&core).Select("name", "notes", "revision", "seal").Updates(&core).Error; err != nil {
+				return fmt.Errorf("update identity core: %w", err)
+			}
+
+			if err := setMark(tx, record.ID, record.Mark); err != nil {
+				return err
+			}
+
+			identity := r.buildIdentity(record.ID, &record)
+			if err := tx.Model(identity).Select("*").Updates(identity).Error; err != nil {
+				return fmt.Errorf("update identity: %w", err)
+			}
+		}
+
+		for id, entries := range vault.Index {
+			if err := setIndex(tx, id, entries); err != nil {
+				return err
+			}
+		}
+
+		u := User(*user)
+		result := tx.Model(&u).Updates(&u)
+		if result.Error != nil {
+			return fmt.Errorf("update user: %w", result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rekey: %w", convertError(err))
 	}
 
-	card := r.buildCard(core.ID, record)
+	return nil
+}
 
-	result = r.db.Model(card).Clauses(clause.Returning{}).Updates(card)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update card: %w", convertError(result.Error))
+// setMark stores the revision mark of record id, records encrypted by clients have none
+func setMark(tx *gorm.DB, id uuid.UUID, mark *model.RevisionMark) error {
+	if mark == nil {
+		return nil
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "record_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revision", "mac"}),
+	}).Create(&RecordRevision{RecordID: id, Revision: mark.Revision, MAC: mark.MAC}).Error
+	if err != nil {
+		return fmt.Errorf("set revision mark of %s: %w", id, err)
 	}
 
-	return record, nil
+	return nil
 }
 
-func (r *RecordRepository) UpdateIdentity(record *model.IdentityRecord) (*model.IdentityRecord, error) {
-	core := CredentialRecord(record.CredentialRecord)
-	result := r.db.Model(core).Clauses(clause.Returning{}).Updates(core)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update core: %w", convertError(result.Error))
+// setIndex replaces the blind index entries of record id
+func setIndex(tx *gorm.DB, id uuid.UUID, entries []model.IndexEntry) error {
+	if err := tx.Where("record_id = ?", id).Delete(&RecordIndex{}).Error; err != nil {
+		return fmt.Errorf("delete index of %s: %w", id, err)
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	if len(entries) == 0 {
+		return nil
 	}
 
-	identity := r.buildIdentity(core.ID, record)
-
-	result = r.db.Model(identity).Clauses(clause.Returning{}).Updates(identity)
-	if result.Error != nil {
-		return nil, fmt.Errorf("update card: %w", convertError(result.Error))
+	rows := make([]RecordIndex, len(entries))
+	for i, entry := range entries {
+		rows[i] = RecordIndex{RecordID: id, Kind: entry.Kind, Token: entry.Token}
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return fmt.Errorf("create index of %s: %w", id, err)
 	}
 
-	return record, nil
+	return nil
 }
 
-func (r *RecordRepository) Delete(id uuid.UUID) (*model.CredentialRecord, error) {
-	var record CredentialRecord
-	result := r.db.Clauses(clause.Returning{}).Where("id = ?", id).Delete(&record)
-	if result.Error != nil {
-		return nil, fmt.Errorf("delete: %w", convertError(result.Error))
+// Search returns the IDs of records owned by userID by blind index token in a single query,
+// tokens no record has are left out
+func (r *RecordRepository) Search(userID uuid.UUID, tokens []string) (map[string][]uuid.UUID, error) {
+	var rows []struct {
+		Token    string
+		RecordID uuid.UUID
+	}
+	err := r.asOwner(userID, func(tx *gorm.DB) error {
+		return tx.Table("record_index ri").
+			Select("DISTINCT ri.token, ri.record_id, cr.created_on").
+			Joins("INNER JOIN credential_record cr ON cr.id = ri.record_id AND cr.created_by = ?", userID).
+			Where("ri.token IN ?", tokens).
+			Order("cr.created_on, ri.record_id").
+			Scan(&rows).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", convertError(err))
 	}
 
-	if result.RowsAffected == 0 {
-		return nil, pmerror.ErrNotFound
+	ids := make(map[string][]uuid.UUID)
+	for _, row := range rows {
+		ids[row.Token] = append(ids[row.Token], row.RecordID)
 	}
 
-	return (*model.CredentialRecord)(&record), nil
+	return ids, nil
 }
 
 func (r *RecordRepository) buildLogin(id uuid.UUID, record *model.LoginRecord) *LoginRecord {
@@ -0,0 +1,317 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/model"
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+type RecoverySetup struct {
+	UserID     uuid.UUID `gorm:"primaryKey"`
+	Threshold  int
+	KeyID      string
+	WrappedKey string
+	CreatedOn  time.Time
+}
+
+func (RecoverySetup) TableName() string {
+	return "recovery_setup"
+}
+
+type RecoveryTrustee struct {
+	UserID    uuid.UUID `gorm:"primaryKey"`
+	TrusteeID uuid.UUID `gorm:"primaryKey"`
+}
+
+func (RecoveryTrustee) TableName() string {
+	return "recovery_trustee"
+}
+
+type RecoveryCeremony model.RecoveryCeremony
+
+func (RecoveryCeremony) TableName() string {
+	return "recovery_ceremony"
+}
+
+type RecoveryCeremonyShare struct {
+	ID         uuid.UUID
+	CeremonyID uuid.UUID
+	TrusteeID  uuid.UUID
+	Share      string
+	CreatedOn  time.Time
+}
+
+func (RecoveryCeremonyShare) TableName() string {
+	return "recovery_ceremony_share"
+}
+
+type RecoveryShareDelivery struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TrusteeID uuid.UUID
+	Share     string
+	CreatedOn time.Time
+}
+
+func (RecoveryShareDelivery) TableName() string {
+	return "recovery_share_delivery"
+}
+
+type RecoveryEvent model.RecoveryEvent
+
+func (RecoveryEvent) TableName() string {
+	return "recovery_event"
+}
+
+func NewRecoveryRepository(db *gorm.DB) (*RecoveryRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &RecoveryRepository{db: db}, nil
+}
+
+type RecoveryRepository struct {
+	db *gorm.DB
+}
+
+func (r *RecoveryRepository) GetSetup(userID uuid.UUID) (*model.RecoverySetup, error) {
+	var setup RecoverySetup
+	if err := r.db.First(&setup, "user_id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("get setup: %w", convertError(err))
+	}
+
+	var trustees []RecoveryTrustee
+	if err := r.db.Where("user_id = ?", userID).Order("trustee_id").Find(&trustees).Error; err != nil {
+		return nil, fmt.Errorf("get trustees: %w", convertError(err))
+	}
+
+	result := &model.RecoverySetup{
+		UserID:     setup.UserID,
+		Threshold:  setup.Threshold,
+		KeyID:      setup.KeyID,
+		WrappedKey: setup.WrappedKey,
+		CreatedOn:  setup.CreatedOn,
+		Trustees:   make([]uuid.UUID, len(trustees)),
+	}
+	for i, trustee := range trustees {
+		result.Trustees[i] = trustee.TrusteeID
+	}
+
+	return result, nil
+}
+
+// SaveSetup replaces the recovery setup of setup.UserID along with its trustees and the shares held for them,
+// shares of a previous setup that were not collected yet are dropped
+func (r *RecoveryRepository) SaveSetup(setup *model.RecoverySetup, shares []model.RecoveryShare) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", setup.UserID).Delete(&RecoverySetup{}).Error; err != nil {
+			return fmt.Errorf("delete setup: %w", err)
+		}
+
+		err := tx.Create(&RecoverySetup{
+			UserID:     setup.UserID,
+			Threshold:  setup.Threshold,
+			KeyID:      setup.KeyID,
+			WrappedKey: setup.WrappedKey,
+			CreatedOn:  setup.CreatedOn,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("create setup: %w", err)
+		}
+
+		trustees := make([]RecoveryTrustee, len(setup.Trustees))
+		for i, trustee := range setup.Trustees {
+			trustees[i] = RecoveryTrustee{UserID: setup.UserID, TrusteeID: trustee}
+		}
+
+		if err := tx.Create(&trustees).Error; err != nil {
+			return fmt.Errorf("create trustees: %w", err)
+		}
+
+		deliveries := make([]RecoveryShareDelivery, len(shares))
+		for i, share := range shares {
+			deliveries[i] = RecoveryShareDelivery{
+				ID:        uuid.New(),
+				UserID:    setup.UserID,
+				TrusteeID: share.TrusteeID,
+				Share:     share.Share,
+				CreatedOn: setup.CreatedOn,
+			}
+		}
+
+		if err := tx.Create(&deliveries).Error; err != nil {
+			return fmt.Errorf("create shares: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save setup: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *RecoveryRepository) CreateCeremony(ceremony *model.RecoveryCeremony) error {
+	c := RecoveryCeremony(*ceremony)
+	if err := r.db.Create(&c).Error; err != nil {
+		return fmt.Errorf("create ceremony: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetPendingShares returns the shares held for trusteeID that it did not collect yet
+func (r *RecoveryRepository) GetPendingShares(trusteeID uuid.UUID) ([]model.RecoveryShare, error) {
+	var deliveries []RecoveryShareDelivery
+	if err := r.db.Where("trustee_id = ?", trusteeID).Order("created_on, id").Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("get pending shares: %w", convertError(err))
+	}
+
+	shares := make([]model.RecoveryShare, len(deliveries))
+	for i, delivery := range deliveries {
+		shares[i] = model.RecoveryShare{UserID: delivery.UserID, TrusteeID: delivery.TrusteeID, Share: delivery.Share}
+	}
+
+	return shares, nil
+}
+
+// DeletePendingShares forgets the shares held for trusteeID of the recovery setups of userIDs once collected
+func (r *RecoveryRepository) DeletePendingShares(trusteeID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	if err := r.db.Where("trustee_id = ? AND user_id IN ?", trusteeID, userIDs).Delete(&RecoveryShareDelivery{}).Error; err != nil {
+		return fmt.Errorf("delete pending shares: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetCeremonies returns the open ceremonies recovering userID
+func (r *RecoveryRepository) GetCeremonies(userID uuid.UUID) ([]model.RecoveryCeremony, error) {
+	var ceremonies []RecoveryCeremony
+	err := r.db.Where("user_id = ? AND status = ?", userID, model.RecoveryCeremonyOpen).
+		Order("created_on, id").
+		Find(&ceremonies).Error
+	if err != nil {
+		return nil, fmt.Errorf("get ceremonies: %w", convertError(err))
+	}
+
+	result := make([]model.RecoveryCeremony, len(ceremonies))
+	for i, ceremony := range ceremonies {
+		result[i] = model.RecoveryCeremony(ceremony)
+	}
+
+	return result, nil
+}
+
+func (r *RecoveryRepository) GetCeremony(id uuid.UUID) (*model.RecoveryCeremony, error) {
+	var ceremony RecoveryCeremony
+	if err := r.db.First(&ceremony, id).Error; err != nil {
+		return nil, fmt.Errorf("get ceremony: %w", convertError(err))
+	}
+
+	return (*model.RecoveryCeremony)(&ceremony), nil
+}
+
+// CloseCeremony sets the final status of an open ceremony and deletes its shares
+func (r *RecoveryRepository) CloseCeremony(id uuid.UUID, status model.RecoveryCeremonyStatus) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RecoveryCeremony{}).
+			Where("id = ? AND status = ?", id, model.RecoveryCeremonyOpen).
+			Update("status", status)
+		if result.Error != nil {
+			return fmt.Errorf("update status: %w", result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("ceremony_id = ?", id).Delete(&RecoveryCeremonyShare{}).Error; err != nil {
+			return fmt.Errorf("delete shares: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("close ceremony: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// AddShare stores the share a trustee submitted, a trustee submits once per ceremony
+func (r *RecoveryRepository) AddShare(ceremonyID, trusteeID uuid.UUID, share string) error {
+	var count int64
+	err := r.db.Model(&RecoveryCeremonyShare{}).
+		Where("ceremony_id = ? AND trustee_id = ?", ceremonyID, trusteeID).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("count shares: %w", convertError(err))
+	}
+
+	if count > 0 {
+		return fmt.Errorf("%w: share already submitted", pmerror.ErrInvalidInput)
+	}
+
+	err = r.db.Create(&RecoveryCeremonyShare{
+		ID:         uuid.New(),
+		CeremonyID: ceremonyID,
+		TrusteeID:  trusteeID,
+		Share:      share,
+		CreatedOn:  time.Now().UTC(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("create share: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetShares returns the submitted shares of a ceremony keyed by trustee
+func (r *RecoveryRepository) GetShares(ceremonyID uuid.UUID) (map[uuid.UUID]string, error) {
+	var shares []RecoveryCeremonyShare
+	if err := r.db.Where("ceremony_id = ?", ceremonyID).Find(&shares).Error; err != nil {
+		return nil, fmt.Errorf("get shares: %w", convertError(err))
+	}
+
+	result := make(map[uuid.UUID]string, len(shares))
+	for _, share := range shares {
+		result[share.TrusteeID] = share.Share
+	}
+
+	return result, nil
+}
+
+func (r *RecoveryRepository) AddEvent(event *model.RecoveryEvent) error {
+	e := RecoveryEvent(*event)
+	if err := r.db.Create(&e).Error; err != nil {
+		return fmt.Errorf("create event: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *RecoveryRepository) GetEvents(ceremonyID uuid.UUID) ([]model.RecoveryEvent, error) {
+	var events []RecoveryEvent
+	if err := r.db.Where("ceremony_id = ?", ceremonyID).Order("created_on, id").Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("get events: %w", convertError(err))
+	}
+
+	result := make([]model.RecoveryEvent, len(events))
+	for i, event := range events {
+		result[i] = model.RecoveryEvent(event)
+	}
+
+	return result, nil
+}
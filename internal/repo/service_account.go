@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type ServiceAccount model.ServiceAccount
+
+func (ServiceAccount) TableName() string {
+	return "service_account"
+}
+
+type RecordGrant model.RecordGrant
+
+func (RecordGrant) TableName() string {
+	return "record_grant"
+}
+
+func NewServiceAccountRepository(db *gorm.DB) (*ServiceAccountRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &ServiceAccountRepository{db: db}, nil
+}
+
+type ServiceAccountRepository struct {
+	db *gorm.DB
+}
+
+func (r *ServiceAccountRepository) Create(account *model.ServiceAccount) error {
+	a := ServiceAccount(*account)
+	if err := r.db.Create(&a).Error; err != nil {
+		return fmt.Errorf("create service account: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetAll returns all service accounts in order of creation, without their grants
+func (r *ServiceAccountRepository) GetAll() ([]model.ServiceAccount, error) {
+	var accounts []ServiceAccount
+	if err := r.db.Order("created_on, id").Find(&accounts).Error; err != nil {
+		return nil, fmt.Errorf("get service accounts: %w", convertError(err))
+	}
+
+	result := make([]model.ServiceAccount, len(accounts))
+	for i, account := range accounts {
+		result[i] = model.ServiceAccount(account)
+	}
+
+	return result, nil
+}
+
+// Get returns service account id along with its grants
+func (r *ServiceAccountRepository) Get(id uuid.UUID) (*model.ServiceAccount, error) {
+	var account ServiceAccount
+	if err := r.db.First(&account, id).Error; err != nil {
+		return nil, fmt.Errorf("get service account: %w", convertError(err))
+	}
+
+	grants, err := r.GetGrants(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := model.ServiceAccount(account)
+	result.Grants = grants
+
+	return &result, nil
+}
+
+// Delete deletes service account id, its grants and tokens go with it
+func (r *ServiceAccountRepository) Delete(id uuid.UUID) (*model.ServiceAccount, error) {
+	var account ServiceAccount
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&account, id).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&ServiceAccount{}, id).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete service account: %w", convertError(err))
+	}
+
+	return (*model.ServiceAccount)(&account), nil
+}
+
+// AddGrants stores grants in one statement, records already granted to the account are left as they are
+func (r *ServiceAccountRepository) AddGrants(grants []model.RecordGrant) error {
+	rows := make([]RecordGrant, len(grants))
+	for i, grant := range grants {
+		rows[i] = RecordGrant(grant)
+	}
+
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return fmt.Errorf("add grants: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *ServiceAccountRepository) RemoveGrant(accountID, recordID uuid.UUID) (*model.RecordGrant, error) {
+	var grant RecordGrant
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&grant, "service_account_id = ? AND record_id = ?", accountID, recordID).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&RecordGrant{}, "service_account_id = ? AND record_id = ?", accountID, recordID).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("remove grant: %w", convertError(err))
+	}
+
+	return (*model.RecordGrant)(&grant), nil
+}
+
+// GetGrants returns the grants of service account accountID in order of creation
+func (r *ServiceAccountRepository) GetGrants(accountID uuid.UUID) ([]model.RecordGrant, error) {
+	var grants []RecordGrant
+	err := r.db.
+		Where("service_account_id = ?", accountID).
+		Order("created_on, record_id").
+		Find(&grants).Error
+	if err != nil {
+		return nil, fmt.Errorf("get grants: %w", convertError(err))
+	}
+
+	result := make([]model.RecordGrant, len(grants))
+	for i, grant := range grants {
+		result[i] = model.RecordGrant(grant)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type Session model.Session
+
+func (Session) TableName() string {
+	return "session"
+}
+
+type RefreshToken model.RefreshToken
+
+func (RefreshToken) TableName() string {
+	return "refresh_token"
+}
+
+func NewSessionRepository(db *gorm.DB) (*SessionRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &SessionRepository{db: db}, nil
+}
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+// Create stores a new session along with its first refresh token
+func (r *SessionRepository) Create(session *model.Session, token *model.RefreshToken) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		s := Session(*session)
+		if err := tx.Create(&s).Error; err != nil {
+			return fmt.Errorf("create session: %w", err)
+		}
+
+		t := RefreshToken(*token)
+		if err := tx.Create(&t).Error; err != nil {
+			return fmt.Errorf("create refresh token: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *SessionRepository) Get(id uuid.UUID) (*model.Session, error) {
+	var session Session
+	if err := r.db.First(&session, id).Error; err != nil {
+		return nil, fmt.Errorf("get session: %w", convertError(err))
+	}
+
+	return (*model.Session)(&session), nil
+}
+
+// GetActive returns the sessions of userID that are neither revoked nor expired at now, most recently used first
+func (r *SessionRepository) GetActive(userID uuid.UUID, now time.Time) ([]model.Session, error) {
+	var sessions []Session
+	err := r.db.
+		Where("user_id = ? AND revoked_on IS NULL AND expires_on > ?", userID, now).
+		Order("last_used_on DESC, id").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("get sessions: %w", convertError(err))
+	}
+
+	result := make([]model.Session, len(sessions))
+	for i, session := range sessions {
+		result[i] = model.Session(session)
+	}
+
+	return result, nil
+}
+
+func (r *SessionRepository) GetRefreshToken(hash string) (*model.RefreshToken, error) {
+	var token RefreshToken
+	if err := r.db.First(&token, "hash = ?", hash).Error; err != nil {
+		return nil, fmt.Errorf("get refresh token: %w", convertError(err))
+	}
+
+	return (*model.RefreshToken)(&token), nil
+}
+
+// Rotate marks the refresh token hash used and stores next in its place.
+// It reports pmerror.ErrNotFound when the token was used meanwhile.
+func (r *SessionRepository) Rotate(hash string, next *model.RefreshToken, now time.Time) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).
+			Where("hash = ? AND used_on IS NULL", hash).
+			Update("used_on", now)
+		if result.Error != nil {
+			return fmt.Errorf("use refresh token: %w", result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		t := RefreshToken(*next)
+		if err := tx.Create(&t).Error; err != nil {
+			return fmt.Errorf("create refresh token: %w", err)
+		}
+
+		if err := tx.Model(&Session{}).Where("id = ?", next.SessionID).Update("last_used_on", now).Error; err != nil {
+			return fmt.Errorf("update session: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rotate: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// Revoke revokes session id of userID, sessions already revoked are reported as pmerror.ErrNotFound
+func (r *SessionRepository) Revoke(userID, id uuid.UUID, now time.Time) (*model.Session, error) {
+	var session Session
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Session{}).
+			Where("id = ? AND user_id = ? AND revoked_on IS NULL", id, userID).
+			Update("revoked_on", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.First(&session, id).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("revoke: %w", convertError(err))
+	}
+
+	return (*model.Session)(&session), nil
+}
@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type TOTP model.TOTP
+
+func (TOTP) TableName() string {
+	return "user_totp"
+}
+
+type RecoveryCode model.RecoveryCode
+
+func (RecoveryCode) TableName() string {
+	return "recovery_code"
+}
+
+type LoginChallenge model.LoginChallenge
+
+func (LoginChallenge) TableName() string {
+	return "login_challenge"
+}
+
+func NewTwoFactorRepository(db *gorm.DB) (*TwoFactorRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &TwoFactorRepository{db: db}, nil
+}
+
+type TwoFactorRepository struct {
+	db *gorm.DB
+}
+
+func (r *TwoFactorRepository) GetTOTP(userID uuid.UUID) (*model.TOTP, error) {
+	var totp TOTP
+	if err := r.db.First(&totp, userID).Error; err != nil {
+		return nil, fmt.Errorf("get totp: %w", convertError(err))
+	}
+
+	return (*model.TOTP)(&totp), nil
+}
+
+// SaveTOTP stores a pending enrollment in place of the former pending one, confirmed enrollments are not replaced
+func (r *TwoFactorRepository) SaveTOTP(totp *model.TOTP) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("confirmed_on IS NULL").Delete(&TOTP{}, totp.ID).Error; err != nil {
+			return err
+		}
+
+		t := TOTP(*totp)
+		return tx.Create(&t).Error
+	})
+	if err != nil {
+		return fmt.Errorf("save totp: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// ConfirmTOTP enables the pending enrollment of userID with the step of its first code and replaces the recovery codes.
+// Enrollments that are not pending are reported as pmerror.ErrNotFound.
+func (r *TwoFactorRepository) ConfirmTOTP(userID uuid.UUID, step int64, now time.Time, codes []model.RecoveryCode) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TOTP{}).
+			Where("id = ? AND confirmed_on IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_on": now, "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+	if err != nil {
+		return fmt.Errorf("confirm totp: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// UseTOTPStep records that a code of step was accepted, steps not after the last used one are reported as pmerror.ErrNotFound
+func (r *TwoFactorRepository) UseTOTPStep(userID uuid.UUID, step int64) error {
+	result := r.db.Model(&TOTP{}).
+		Where("id = ? AND confirmed_on IS NOT NULL AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return fmt.Errorf("use totp step: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use totp step: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// DeleteTOTP disables two-factor authentication of userID along with its recovery codes
+func (r *TwoFactorRepository) DeleteTOTP(userID uuid.UUID) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&TOTP{}, userID).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("delete totp: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID uuid.UUID, codes []model.RecoveryCode) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+	if err != nil {
+		return fmt.Errorf("replace recovery codes: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, codes []model.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	rows := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		rows[i] = RecoveryCode(code)
+	}
+
+	return tx.Create(&rows).Error
+}
+
+// UseRecoveryCode marks recovery code hash of userID used, codes already used are reported as pmerror.ErrNotFound
+func (r *TwoFactorRepository) UseRecoveryCode(userID uuid.UUID, hash string, now time.Time) error {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("hash = ? AND user_id = ? AND used_on IS NULL", hash, userID).
+		Update("used_on", now)
+	if result.Error != nil {
+		return fmt.Errorf("use recovery code: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use recovery code: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+func (r *TwoFactorRepository) CreateChallenge(challenge *model.LoginChallenge) error {
+	c := LoginChallenge(*challenge)
+	if err := r.db.Create(&c).Error; err != nil {
+		return fmt.Errorf("create login challenge: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *TwoFactorRepository) GetChallenge(hash string) (*model.LoginChallenge, error) {
+	var challenge LoginChallenge
+	if err := r.db.First(&challenge, "hash = ?", hash).Error; err != nil {
+		return nil, fmt.Errorf("get login challenge: %w", convertError(err))
+	}
+
+	return (*model.LoginChallenge)(&challenge), nil
+}
+
+// FailChallenge counts a wrong code entered for challenge hash
+func (r *TwoFactorRepository) FailChallenge(hash string) error {
+	err := r.db.Model(&LoginChallenge{}).
+		Where("hash = ?", hash).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+	if err != nil {
+		return fmt.Errorf("fail login challenge: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// UseChallenge marks challenge hash used. Challenges used meanwhile or out of attempts are reported as pmerror.ErrNotFound.
+func (r *TwoFactorRepository) UseChallenge(hash string, now time.Time) error {
+	result := r.db.Model(&LoginChallenge{}).
+		Where("hash = ? AND used_on IS NULL AND attempts < ?", hash, model.MaxLoginChallengeAttempts).
+		Update("used_on", now)
+	if result.Error != nil {
+		return fmt.Errorf("use login challenge: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use login challenge: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
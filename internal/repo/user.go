@@ -3,6 +3,7 @@ package repo
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -57,6 +58,25 @@ func (r *UserRepository) GetByName(name string) (*model.User, error) {
 	return (*model.User)(&user), nil
 }
 
+func (r *UserRepository) GetByDirectoryDN(dn string) (*model.User, error) {
+	var user User
+	if err := r.db.First(&user, "directory_dn = ?", dn).Error; err != nil {
+		return nil, fmt.Errorf("first: %w", convertError(err))
+	}
+
+	return (*model.User)(&user), nil
+}
+
+// GetDirectoryUsers returns the users managed by the LDAP sync, disabled ones included
+func (r *UserRepository) GetDirectoryUsers() ([]model.User, error) {
+	var users []model.User
+	if err := r.db.Model(&User{}).Where("directory_dn IS NOT NULL").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("find: %w", convertError(err))
+	}
+
+	return users, nil
+}
+
 func (r *UserRepository) Create(user *model.User) (*model.User, error) {
 	core := User(*user)
 	if err := r.db.Create(&core).Error; err != nil {
@@ -92,3 +112,17 @@ func (r *UserRepository) Delete(id uuid.UUID) (*model.User, error) {
 
 	return (*model.User)(&user), nil
 }
+
+// SetDisabled disables a user or enables it again with nil, Updates of a user struct skips nil
+func (r *UserRepository) SetDisabled(id uuid.UUID, disabledOn *time.Time) error {
+	result := r.db.Model(&User{}).Where("id = ?", id).Update("disabled_on", disabledOn)
+	if result.Error != nil {
+		return fmt.Errorf("set disabled: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("set disabled: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
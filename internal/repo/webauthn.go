@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ChillyWR/PasswordManager/model"
+)
+
+type WebAuthnCredential struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	Name         string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    int64
+	Transports   string
+	CreatedOn    time.Time
+	LastUsedOn   *time.Time
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credential"
+}
+
+func newWebAuthnCredential(credential *model.WebAuthnCredential) *WebAuthnCredential {
+	return &WebAuthnCredential{
+		ID:           credential.ID,
+		UserID:       credential.UserID,
+		Name:         credential.Name,
+		CredentialID: credential.CredentialID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    int64(credential.SignCount),
+		Transports:   strings.Join(credential.Transports, " "),
+		CreatedOn:    credential.CreatedOn,
+		LastUsedOn:   credential.LastUsedOn,
+	}
+}
+
+func (c *WebAuthnCredential) model() *model.WebAuthnCredential {
+	return &model.WebAuthnCredential{
+		ID:           c.ID,
+		UserID:       c.UserID,
+		Name:         c.Name,
+		CredentialID: c.CredentialID,
+		PublicKey:    c.PublicKey,
+		SignCount:    uint32(c.SignCount),
+		Transports:   strings.Fields(c.Transports),
+		CreatedOn:    c.CreatedOn,
+		LastUsedOn:   c.LastUsedOn,
+	}
+}
+
+type WebAuthnCeremony model.WebAuthnCeremony
+
+func (WebAuthnCeremony) TableName() string {
+	return "webauthn_ceremony"
+}
+
+func NewWebAuthnRepository(db *gorm.DB) (*WebAuthnRepository, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &WebAuthnRepository{db: db}, nil
+}
+
+type WebAuthnRepository struct {
+	db *gorm.DB
+}
+
+func (r *WebAuthnRepository) CreateCredential(credential *model.WebAuthnCredential) error {
+	if err := r.db.Create(newWebAuthnCredential(credential)).Error; err != nil {
+		return fmt.Errorf("create webauthn credential: %w", convertError(err))
+	}
+
+	return nil
+}
+
+// GetCredentials returns the credentials of userID, oldest first
+func (r *WebAuthnRepository) GetCredentials(userID uuid.UUID) ([]model.WebAuthnCredential, error) {
+	var credentials []WebAuthnCredential
+	if err := r.db.Where("user_id = ?", userID).Order("created_on, id").Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("get webauthn credentials: %w", convertError(err))
+	}
+
+	result := make([]model.WebAuthnCredential, len(credentials))
+	for i, credential := range credentials {
+		result[i] = *credential.model()
+	}
+
+	return result, nil
+}
+
+// GetCredential returns the credential an authenticator identifies as credentialID
+func (r *WebAuthnRepository) GetCredential(credentialID []byte) (*model.WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	if err := r.db.First(&credential, "credential_id = ?", credentialID).Error; err != nil {
+		return nil, fmt.Errorf("get webauthn credential: %w", convertError(err))
+	}
+
+	return credential.model(), nil
+}
+
+// UseCredential stores the signature counter of an assertion of credential id.
+// Counters that do not increase, as when another assertion was accepted meanwhile, are reported as pmerror.ErrNotFound.
+// Authenticators without a counter always report 0.
+func (r *WebAuthnRepository) UseCredential(id uuid.UUID, signCount uint32, now time.Time) error {
+	result := r.db.Model(&WebAuthnCredential{}).
+		Where("id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))", id, int64(signCount), int64(signCount)).
+		Updates(map[string]interface{}{"sign_count": int64(signCount), "last_used_on": now})
+	if result.Error != nil {
+		return fmt.Errorf("use webauthn credential: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use webauthn credential: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
+
+// DeleteCredential deletes credential id of userID, credentials of other users are reported as pmerror.ErrNotFound
+func (r *WebAuthnRepository) DeleteCredential(userID, id uuid.UUID) (*model.WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&credential, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&WebAuthnCredential{}, id).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete webauthn credential: %w", convertError(err))
+	}
+
+	return credential.model(), nil
+}
+
+func (r *WebAuthnRepository) CreateCeremony(ceremony *model.WebAuthnCeremony) error {
+	c := WebAuthnCeremony(*ceremony)
+	if err := r.db.Create(&c).Error; err != nil {
+		return fmt.Errorf("create webauthn ceremony: %w", convertError(err))
+	}
+
+	return nil
+}
+
+func (r *WebAuthnRepository) GetCeremony(challenge string) (*model.WebAuthnCeremony, error) {
+	var ceremony WebAuthnCeremony
+	if err := r.db.First(&ceremony, "challenge = ?", challenge).Error; err != nil {
+		return nil, fmt.Errorf("get webauthn ceremony: %w", convertError(err))
+	}
+
+	return (*model.WebAuthnCeremony)(&ceremony), nil
+}
+
+// UseCeremony marks ceremony challenge used, ceremonies used meanwhile are reported as pmerror.ErrNotFound
+func (r *WebAuthnRepository) UseCeremony(challenge string, now time.Time) error {
+	result := r.db.Model(&WebAuthnCeremony{}).
+		Where("challenge = ? AND used_on IS NULL", challenge).
+		Update("used_on", now)
+	if result.Error != nil {
+		return fmt.Errorf("use webauthn ceremony: %w", convertError(result.Error))
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("use webauthn ceremony: %w", convertError(gorm.ErrRecordNotFound))
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// AccessTokenPrefix tells personal access tokens apart from session JWTs in the Authorization header
+const AccessTokenPrefix = "pmpat_"
+
+// MaxAccessTokenTTL limits how far ahead a personal access token can expire
+const MaxAccessTokenTTL = 366 * 24 * time.Hour
+
+// Scope limits what a personal access token can reach, sessions are not limited
+type Scope string
+
+const (
+	ScopeRecordsRead  Scope = "records:read"
+	ScopeRecordsWrite Scope = "records:write"
+	// ScopeUsersAdmin can only be granted by admins
+	ScopeUsersAdmin Scope = "users:admin"
+)
+
+var Scopes = []Scope{ScopeRecordsRead, ScopeRecordsWrite, ScopeUsersAdmin}
+
+func (s Scope) Valid() bool {
+	for _, scope := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccessToken is a personal access token automation signs in with, only its SHA-256 is stored.
+// Tokens of service accounts have ServiceAccountID set and no UserID.
+type AccessToken struct {
+	ID               uuid.UUID  `json:"id"`
+	UserID           uuid.UUID  `json:"user_id"`
+	ServiceAccountID *uuid.UUID `json:"service_account_id,omitempty"`
+	Name             string     `json:"name"`
+	Hash             string     `json:"-"`
+	Scopes           []Scope    `json:"scopes"`
+	CreatedOn        time.Time  `json:"created_on"`
+	ExpiresOn        time.Time  `json:"expires_on"`
+	LastUsedOn       *time.Time `json:"last_used_on,omitempty"`
+	LastUsedIP       string     `json:"last_used_ip,omitempty"`
+	RevokedOn        *time.Time `json:"revoked_on,omitempty"`
+}
+
+// Principal is the ID of the user or service account the token acts as
+func (t *AccessToken) Principal() uuid.UUID {
+	if t.ServiceAccountID != nil {
+		return *t.ServiceAccountID
+	}
+
+	return t.UserID
+}
+
+func (t *AccessToken) Active(now time.Time) bool {
+	return t.RevokedOn == nil && now.Before(t.ExpiresOn)
+}
+
+func (t *AccessToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type AccessTokenForm struct {
+	Name      *string    `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresOn *time.Time `json:"expires_on"`
+}
+
+func (f AccessTokenForm) Validate(now time.Time) error {
+	if f.Name == nil || *f.Name == "" {
+		return fmt.Errorf("%w: Name is empty", pmerror.ErrInvalidInput)
+	}
+
+	if err := validateScopes(f.Scopes); err != nil {
+		return err
+	}
+
+	if f.ExpiresOn == nil {
+		return fmt.Errorf("%w: ExpiresOn is empty", pmerror.ErrInvalidInput)
+	}
+
+	if !f.ExpiresOn.After(now) || f.ExpiresOn.After(now.Add(MaxAccessTokenTTL)) {
+		return fmt.Errorf("%w: ExpiresOn must be in the future and within %s", pmerror.ErrInvalidInput, MaxAccessTokenTTL)
+	}
+
+	return nil
+}
+
+func validateScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: Scopes are empty", pmerror.ErrInvalidInput)
+	}
+
+	seen := make(map[Scope]bool, len(scopes))
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return fmt.Errorf("%w: unknown scope %q", pmerror.ErrInvalidInput, scope)
+		}
+
+		if seen[scope] {
+			return fmt.Errorf("%w: duplicate scope %q", pmerror.ErrInvalidInput, scope)
+		}
+		seen[scope] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// CommonNamePrefix marks subjects matching the common name of a certificate, other subjects are SAN URIs
+const CommonNamePrefix = "CN="
+
+// CertificateMapping lets clients presenting a certificate of the trusted CAs act as a user or service account.
+// Subject is "CN=<common name>" or a URI SAN such as spiffe://example.org/billing. Like access tokens,
+// mappings of service accounts have ServiceAccountID set and no UserID.
+type CertificateMapping struct {
+	ID               uuid.UUID  `json:"id"`
+	Subject          string     `json:"subject"`
+	UserID           uuid.UUID  `json:"user_id"`
+	ServiceAccountID *uuid.UUID `json:"service_account_id,omitempty"`
+	Scopes           []Scope    `json:"scopes"`
+	CreatedBy        *uuid.UUID `json:"created_by"`
+	CreatedOn        time.Time  `json:"created_on"`
+	LastUsedOn       *time.Time `json:"last_used_on,omitempty"`
+}
+
+// Principal is the ID of the user or service account the certificate acts as
+func (m *CertificateMapping) Principal() uuid.UUID {
+	if m.ServiceAccountID != nil {
+		return *m.ServiceAccountID
+	}
+
+	return m.UserID
+}
+
+func (m *CertificateMapping) HasScope(scope Scope) bool {
+	for _, s := range m.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CertificateMappingForm maps Subject to either UserID or ServiceAccountID
+type CertificateMappingForm struct {
+	Subject          *string    `json:"subject"`
+	UserID           *uuid.UUID `json:"user_id"`
+	ServiceAccountID *uuid.UUID `json:"service_account_id"`
+	Scopes           []Scope    `json:"scopes"`
+}
+
+func (f CertificateMappingForm) Validate() error {
+	if f.Subject == nil || *f.Subject == "" {
+		return fmt.Errorf("%w: Subject is empty", pmerror.ErrInvalidInput)
+	}
+
+	if name, ok := strings.CutPrefix(*f.Subject, CommonNamePrefix); ok {
+		if name == "" {
+			return fmt.Errorf("%w: Subject has an empty common name", pmerror.ErrInvalidInput)
+		}
+	} else if u, err := url.Parse(*f.Subject); err != nil || !u.IsAbs() {
+		return fmt.Errorf("%w: Subject must be %s<common name> or an absolute URI", pmerror.ErrInvalidInput, CommonNamePrefix)
+	}
+
+	if (f.UserID == nil) == (f.ServiceAccountID == nil) {
+		return fmt.Errorf("%w: exactly one of UserID and ServiceAccountID must be set", pmerror.ErrInvalidInput)
+	}
+
+	return validateScopes(f.Scopes)
+}
@@ -0,0 +1,19 @@
+package model
+
+// IndexKind names a blind index over record fields
+type IndexKind string
+
+const (
+	// exact match indexes
+	UsernameIndex IndexKind = "username"
+	EmailIndex    IndexKind = "email"
+	// tokenized indexes, every label of a URL host and every word of a record name
+	URLHostIndex IndexKind = "url_host"
+	NameIndex    IndexKind = "name"
+)
+
+// IndexEntry is a keyed token of a term found in a record, it does not reveal the term
+type IndexEntry struct {
+	Kind  IndexKind
+	Token string
+}
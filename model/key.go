@@ -0,0 +1,11 @@
+package model
+
+// PerUserKeyID groups values encrypted with data keys of individual users
+const PerUserKeyID = "per-user"
+
+// KeyUsage is the number of encrypted fields that still use a key
+type KeyUsage struct {
+	KeyID  string `json:"key_id"`
+	Active bool   `json:"active"`
+	Fields int    `json:"fields"`
+}
@@ -0,0 +1,9 @@
+package model
+
+// LDAPSyncResult counts the users a directory sync changed, Failed are members that could not be synced
+type LDAPSyncResult struct {
+	Created  int `json:"created"`
+	Updated  int `json:"updated"`
+	Disabled int `json:"disabled"`
+	Failed   int `json:"failed"`
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// OIDCLoginTTL limits how long signing in at the identity provider can take
+const OIDCLoginTTL = 10 * time.Minute
+
+// OIDCReauthTTL limits how long the state of a reauthentication at the identity provider proves presence
+const OIDCReauthTTL = 5 * time.Minute
+
+// Identity links a user to an account at an OpenID Connect provider, by issuer and subject.
+// Groups are the ones the provider reported on the last sign in.
+type Identity struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Groups      []string   `json:"groups"`
+	CreatedOn   time.Time  `json:"created_on"`
+	LastLoginOn *time.Time `json:"last_login_on,omitempty"`
+}
+
+// OIDCLogin is a sign in at the identity provider in progress, only the SHA-256 of its state is stored.
+// LinkUserID is set when a signed in user links an identity to their account, or confirms it is them when
+// Reauth is set. Those logins are AuthenticatedOn once the provider signed the user in again.
+type OIDCLogin struct {
+	Hash            string
+	Nonce           string
+	Verifier        string
+	LinkUserID      *uuid.UUID
+	Reauth          bool
+	CreatedOn       time.Time
+	ExpiresOn       time.Time
+	UsedOn          *time.Time
+	AuthenticatedOn *time.Time
+}
+
+func (l *OIDCLogin) Active(now time.Time) bool {
+	return l.UsedOn == nil && now.Before(l.ExpiresOn)
+}
+
+// OIDCResult is how a callback completed, either UserID signs in or, for Reauth logins, the state
+// the provider sent back now proves UserID is present. Users with two-factor authentication get a
+// Challenge to complete the sign in with instead.
+type OIDCResult struct {
+	UserID    uuid.UUID
+	Reauth    bool
+	Challenge string
+}
+
+// SSOPolicy is whether single sign-on is the only way to sign in, UpdatedBy is the admin that last set it
+type SSOPolicy struct {
+	Required  bool       `json:"required"`
+	UpdatedBy *uuid.UUID `json:"updated_by,omitempty"`
+	UpdatedOn *time.Time `json:"updated_on,omitempty"`
+}
+
+type SSOPolicyForm struct {
+	Required *bool `json:"required"`
+}
+
+func (f SSOPolicyForm) Validate() error {
+	if f.Required == nil {
+		return fmt.Errorf("%w: Required is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// OIDCCallbackForm is what the identity provider sends back to the redirect URL
+type OIDCCallbackForm struct {
+	State *string `json:"state"`
+	Code  *string `json:"code"`
+}
+
+func (f OIDCCallbackForm) Validate() error {
+	if f.State == nil || *f.State == "" {
+		return fmt.Errorf("%w: State is empty", pmerror.ErrInvalidInput)
+	}
+
+	if f.Code == nil || *f.Code == "" {
+		return fmt.Errorf("%w: Code is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// LoginFailure counts failed sign ins with an account name since the last successful one
+type LoginFailure struct {
+	Account       string
+	Failures      int
+	LastFailureOn time.Time
+	// LockedUntil is set once the failures reached the lockout threshold
+	LockedUntil *time.Time
+}
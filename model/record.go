@@ -27,17 +27,53 @@ func NewCredentialRecord(name string, notes *string, userID uuid.UUID) *Credenti
 		UpdatedOn: pmtime.TruncateToMillisecond(time.Now().UTC()),
 		CreatedBy: userID,
 		UpdatedBy: userID,
+		Revision:  1,
 	}
 }
 
 type CredentialRecord struct {
 	ID        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
-	Notes     *string   `json:"notes"`
+	Name      string    `json:"name" pm:"encrypt,label,seal=1"`
+	Notes     *string   `json:"notes" pm:"encrypt"`
 	CreatedOn time.Time `json:"created_on"`
 	UpdatedOn time.Time `json:"updated_on"`
 	UpdatedBy uuid.UUID `json:"updated_by"`
 	CreatedBy uuid.UUID `json:"created_by"`
+	// Revision is bumped on every write, encrypted fields are bound to it. Rows written before
+	// fields were bound to their record have revision 0, they are rewritten by vault upgrades.
+	Revision int `json:"revision"`
+	// Seal is the seal the row was written with, see LatestSeal
+	Seal int `json:"-"`
+	// Mark is stored apart from the row, it is nil for records encrypted by clients
+	Mark *RevisionMark `json:"-" gorm:"-"`
+}
+
+// RevisionMark is the high-water mark of the revision of a record, authenticated with a key derived from the vault key.
+// A row replayed from before the latest write of the record is behind its mark.
+type RevisionMark struct {
+	Revision int
+	MAC      string
+}
+
+// LatestSeal counts the rounds in which fields stored in plaintext started being encrypted.
+// Fields tagged seal=N are encrypted on rows with a seal of at least N and still plaintext on
+// rows written before, those are sealed on their next write.
+const LatestSeal = 2
+
+// MarkedSeal is the first seal whose rows are always written with a revision mark, a row at it without one lost it
+const MarkedSeal = 2
+
+// EncryptionScope names the table the encrypted fields of a record type are stored in,
+// fields are bound to "<scope>.<json name>"
+func (CredentialRecord) EncryptionScope() string {
+	return "credential_record"
+}
+
+// Touch marks r as updated by userID and starts its next revision
+func (r *CredentialRecord) Touch(userID uuid.UUID) {
+	r.UpdatedOn = pmtime.TruncateToMillisecond(time.Now().UTC())
+	r.UpdatedBy = userID
+	r.Revision++
 }
 
 func (r *CredentialRecord) ApplyForm(f *CredentialRecordForm) {
@@ -52,9 +88,13 @@ func (r *CredentialRecord) ApplyForm(f *CredentialRecordForm) {
 
 type LoginRecord struct {
 	CredentialRecord
-	Username *string `json:"username"`
-	Password *string `json:"password"`
-	URL      *string `json:"url"`
+	Username *string `json:"username" pm:"encrypt"`
+	Password *string `json:"password" pm:"encrypt"`
+	URL      *string `json:"url" pm:"encrypt,label,seal=1"`
+}
+
+func (LoginRecord) EncryptionScope() string {
+	return "login"
 }
 
 func (r *LoginRecord) ApplyForm(f *LoginRecordForm) {
@@ -75,11 +115,15 @@ func (r *LoginRecord) ApplyForm(f *LoginRecordForm) {
 
 type CardRecord struct {
 	CredentialRecord
-	Brand           *string `json:"brand"`
-	Number          *string `json:"number"`
-	ExpirationMonth *string `json:"expiration_month"`
-	ExpirationYear  *string `json:"expiration_year"`
-	CVV             *string `json:"cvv"`
+	Brand           *string `json:"brand" pm:"encrypt,label,seal=2"`
+	Number          *string `json:"number" pm:"encrypt"`
+	ExpirationMonth *string `json:"expiration_month" pm:"encrypt"`
+	ExpirationYear  *string `json:"expiration_year" pm:"encrypt"`
+	CVV             *string `json:"cvv" pm:"encrypt"`
+}
+
+func (CardRecord) EncryptionScope() string {
+	return "card"
 }
 
 func (r *CardRecord) ApplyForm(f *CardRecordForm) {
@@ -108,17 +152,21 @@ func (r *CardRecord) ApplyForm(f *CardRecordForm) {
 
 type IdentityRecord struct {
 	CredentialRecord
-	FirstName      *string `json:"first_name"`
-	MiddleName     *string `json:"middle_name"`
-	LastName       *string `json:"last_name"`
-	Address        *string `json:"address"`
-	Email          *string `json:"email"`
-	PhoneNumber    *string `json:"phone_number"`
-	PassportNumber *string `json:"passport_number"`
-	Country        *string `json:"country"`
+	FirstName      *string `json:"first_name" pm:"encrypt"`
+	MiddleName     *string `json:"middle_name" pm:"encrypt"`
+	LastName       *string `json:"last_name" pm:"encrypt"`
+	Address        *string `json:"address" pm:"encrypt"`
+	Email          *string `json:"email" pm:"encrypt"`
+	PhoneNumber    *string `json:"phone_number" pm:"encrypt"`
+	PassportNumber *string `json:"passport_number" pm:"encrypt"`
+	Country        *string `json:"country" pm:"encrypt,label,seal=1"`
+}
+
+func (IdentityRecord) EncryptionScope() string {
+	return "identity"
 }
 
-func (r IdentityRecord) ApplyForm(f *IdentityRecordForm) {
+func (r *IdentityRecord) ApplyForm(f *IdentityRecordForm) {
 	r.CredentialRecord.ApplyForm(&f.CredentialRecordForm)
 
 	if f.FirstName != nil {
@@ -154,6 +202,38 @@ func (r IdentityRecord) ApplyForm(f *IdentityRecordForm) {
 	}
 }
 
+// Vault is the full set of records owned by a single user
+type Vault struct {
+	SecureNotes []CredentialRecord
+	Logins      []LoginRecord
+	Cards       []CardRecord
+	Identities  []IdentityRecord
+	// Index replaces the blind index entries of the records it has keys for
+	Index map[uuid.UUID][]IndexEntry
+}
+
+// Records returns pointers to all records of the vault, in the order of its fields
+func (v *Vault) Records() []interface{} {
+	records := make([]interface{}, 0, len(v.SecureNotes)+len(v.Logins)+len(v.Cards)+len(v.Identities))
+	for i := range v.SecureNotes {
+		records = append(records, &v.SecureNotes[i])
+	}
+
+	for i := range v.Logins {
+		records = append(records, &v.Logins[i])
+	}
+
+	for i := range v.Cards {
+		records = append(records, &v.Cards[i])
+	}
+
+	for i := range v.Identities {
+		records = append(records, &v.Identities[i])
+	}
+
+	return records
+}
+
 // Forms are meant to be filled by user
 
 type CredentialRecordForm struct {
@@ -254,3 +334,32 @@ func (f IdentityRecordForm) Empty() bool {
 		(f.PassportNumber == nil || *f.PassportNumber == "") &&
 		(f.Country == nil || *f.Country == "")
 }
+
+// Record returns a pointer to record id of the vault, nil when the vault has none
+func (v *Vault) Record(id uuid.UUID) interface{} {
+	for i := range v.SecureNotes {
+		if v.SecureNotes[i].ID == id {
+			return &v.SecureNotes[i]
+		}
+	}
+
+	for i := range v.Logins {
+		if v.Logins[i].ID == id {
+			return &v.Logins[i]
+		}
+	}
+
+	for i := range v.Cards {
+		if v.Cards[i].ID == id {
+			return &v.Cards[i]
+		}
+	}
+
+	for i := range v.Identities {
+		if v.Identities[i].ID == id {
+			return &v.Identities[i]
+		}
+	}
+
+	return nil
+}
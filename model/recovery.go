@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// RecoverySetup records who holds shares of a user's recovery key, the key itself is never stored
+type RecoverySetup struct {
+	UserID    uuid.UUID   `json:"user_id"`
+	Threshold int         `json:"threshold"`
+	Trustees  []uuid.UUID `json:"trustees"`
+	// KeyID is the fingerprint the rebuilt recovery key is checked against
+	KeyID string `json:"key_id"`
+	// WrappedKey is the vault key of the user encrypted with the recovery key.
+	// Setups made before vault keys were wrapped have none, completing them only resets the password.
+	WrappedKey string    `json:"-"`
+	CreatedOn  time.Time `json:"created_on"`
+}
+
+func (s *RecoverySetup) IsTrustee(userID uuid.UUID) bool {
+	for _, trustee := range s.Trustees {
+		if trustee == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecoveryShare is one share of the recovery key of UserID. It is held for its trustee, encrypted with the server keys,
+// until the trustee collects it, then the server forgets it.
+type RecoveryShare struct {
+	UserID    uuid.UUID `json:"user_id"`
+	TrusteeID uuid.UUID `json:"trustee_id"`
+	Share     string    `json:"share"`
+}
+
+// RecoveryKit is returned when recovery is set up. Neither the recovery key nor the shares are part of it,
+// each trustee collects its own share.
+type RecoveryKit struct {
+	KeyID     string      `json:"key_id"`
+	Threshold int         `json:"threshold"`
+	Trustees  []uuid.UUID `json:"trustees"`
+}
+
+type RecoveryCeremonyStatus string
+
+const (
+	RecoveryCeremonyOpen      RecoveryCeremonyStatus = "open"
+	RecoveryCeremonyCompleted RecoveryCeremonyStatus = "completed"
+	RecoveryCeremonyFailed    RecoveryCeremonyStatus = "failed"
+	RecoveryCeremonyExpired   RecoveryCeremonyStatus = "expired"
+	RecoveryCeremonyCancelled RecoveryCeremonyStatus = "cancelled"
+)
+
+// RecoveryCeremony collects shares from trustees until the recovery key of UserID can be rebuilt
+type RecoveryCeremony struct {
+	ID     uuid.UUID              `json:"id"`
+	UserID uuid.UUID              `json:"user_id"`
+	Status RecoveryCeremonyStatus `json:"status"`
+	// SecretHash is the SHA-256 of the secret given to whoever started the ceremony, it is required to complete it
+	SecretHash string          `json:"-"`
+	Submitted  int             `json:"submitted" gorm:"-"`
+	Threshold  int             `json:"threshold" gorm:"-"`
+	Events     []RecoveryEvent `json:"events,omitempty" gorm:"-"`
+	CreatedOn  time.Time       `json:"created_on"`
+	// ReadyOn is when the ceremony can be completed at the earliest, until then only its owner can act on it, by cancelling it
+	ReadyOn   time.Time `json:"ready_on"`
+	ExpiresOn time.Time `json:"expires_on"`
+}
+
+type RecoveryEventKind string
+
+const (
+	RecoveryEventStarted        RecoveryEventKind = "started"
+	RecoveryEventShareSubmitted RecoveryEventKind = "share_submitted"
+	RecoveryEventRejected       RecoveryEventKind = "rejected"
+	RecoveryEventCompleted      RecoveryEventKind = "completed"
+	RecoveryEventFailed         RecoveryEventKind = "failed"
+	RecoveryEventExpired        RecoveryEventKind = "expired"
+	RecoveryEventCancelled      RecoveryEventKind = "cancelled"
+)
+
+// RecoveryEvent records a step of a ceremony, ActorID is nil for steps taken without signing in
+type RecoveryEvent struct {
+	ID         uuid.UUID         `json:"id"`
+	CeremonyID uuid.UUID         `json:"ceremony_id"`
+	Kind       RecoveryEventKind `json:"kind"`
+	ActorID    *uuid.UUID        `json:"actor_id"`
+	Detail     string            `json:"detail,omitempty"`
+	CreatedOn  time.Time         `json:"created_on"`
+}
+
+type RecoverySetupForm struct {
+	Trustees  []uuid.UUID `json:"trustees"`
+	Threshold int         `json:"threshold"`
+	// VaultKey is the base64 vault key the client derives from the master password, only sent in client mode.
+	// The server wraps it with the recovery key and forgets it, a completed ceremony returns it.
+	VaultKey *string `json:"vault_key"`
+}
+
+func (f RecoverySetupForm) Validate(owner uuid.UUID) error {
+	if f.Threshold < 2 || f.Threshold > len(f.Trustees) {
+		return fmt.Errorf("%w: threshold must be between 2 and the number of trustees", pmerror.ErrInvalidInput)
+	}
+
+	seen := make(map[uuid.UUID]bool, len(f.Trustees))
+	for _, trustee := range f.Trustees {
+		if trustee == owner {
+			return fmt.Errorf("%w: owner can not be a trustee", pmerror.ErrInvalidInput)
+		}
+
+		if seen[trustee] {
+			return fmt.Errorf("%w: duplicate trustee %s", pmerror.ErrInvalidInput, trustee)
+		}
+		seen[trustee] = true
+	}
+
+	return nil
+}
+
+type RecoveryShareForm struct {
+	Share *string `json:"share"`
+}
+
+type RecoveryCompleteForm struct {
+	Secret *string `json:"secret"`
+	// Password replaces the master password of the recovered account
+	Password *string `json:"password"`
+}
+
+func (f RecoveryCompleteForm) Validate() error {
+	if f.Secret == nil || *f.Secret == "" {
+		return fmt.Errorf("%w: Secret is empty", pmerror.ErrInvalidInput)
+	}
+
+	if f.Password == nil || *f.Password == "" {
+		return fmt.Errorf("%w: Password is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+)
+
+// ServiceAccount is a non-human identity managed by admins. It owns no vault and only reads the records granted to it,
+// with access tokens limited to ScopeRecordsRead.
+type ServiceAccount struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// CreatedBy is the admin who created the account, it does not own it
+	CreatedBy *uuid.UUID    `json:"created_by"`
+	CreatedOn time.Time     `json:"created_on"`
+	Grants    []RecordGrant `json:"grants,omitempty" gorm:"-"`
+	Tokens    []AccessToken `json:"tokens,omitempty" gorm:"-"`
+}
+
+// RecordGrant lets a service account read a record with the key of its owner
+type RecordGrant struct {
+	ServiceAccountID uuid.UUID  `json:"service_account_id"`
+	RecordID         uuid.UUID  `json:"record_id"`
+	OwnerID          uuid.UUID  `json:"owner_id"`
+	GrantedBy        *uuid.UUID `json:"granted_by"`
+	CreatedOn        time.Time  `json:"created_on"`
+}
+
+type ServiceAccountForm struct {
+	Name *string `json:"name"`
+}
+
+func (f ServiceAccountForm) Validate() error {
+	if f.Name == nil || *f.Name == "" {
+		return fmt.Errorf("%w: Name is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+type RecordGrantForm struct {
+	RecordIDs []uuid.UUID `json:"record_ids"`
+}
+
+func (f RecordGrantForm) Validate() error {
+	if len(f.RecordIDs) == 0 {
+		return fmt.Errorf("%w: RecordIDs are empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a signed in device of a user. Access tokens carry its ID and stop working once it is revoked,
+// the refresh token issued with it is rotated on every use.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedOn  time.Time  `json:"created_on"`
+	LastUsedOn time.Time  `json:"last_used_on"`
+	ExpiresOn  time.Time  `json:"expires_on"`
+	RevokedOn  *time.Time `json:"revoked_on,omitempty"`
+	// Current marks the session of the access token the sessions were listed with
+	Current bool `json:"current" gorm:"-"`
+}
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedOn == nil && now.Before(s.ExpiresOn)
+}
+
+// RefreshToken is a single use token of a session, only its SHA-256 is stored.
+// A token presented again after it was used means it leaked, the whole session is revoked.
+type RefreshToken struct {
+	Hash      string     `json:"-"`
+	SessionID uuid.UUID  `json:"session_id"`
+	CreatedOn time.Time  `json:"created_on"`
+	UsedOn    *time.Time `json:"used_on,omitempty"`
+}
+
+type RefreshForm struct {
+	RefreshToken *string `json:"refresh_token"`
+}
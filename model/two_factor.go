@@ -0,0 +1,124 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
+)
+
+const (
+	// LoginChallengeTTL limits how long a second factor can be entered after the password
+	LoginChallengeTTL = 5 * time.Minute
+	// MaxLoginChallengeAttempts limits wrong codes per challenge, guessing one of a million codes needs a new password check
+	MaxLoginChallengeAttempts = 5
+	RecoveryCodeCount         = 10
+)
+
+// TOTP is the authenticator app enrollment of a user, it is pending until confirmed with a first code.
+// Secret is encrypted with the server keyring.
+type TOTP struct {
+	ID          uuid.UUID
+	Secret      string
+	CreatedOn   time.Time
+	ConfirmedOn *time.Time
+	// LastUsedStep is the last time step a code was accepted for, codes are not accepted twice
+	LastUsedStep int64
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedOn != nil
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code, only its SHA-256 is stored
+type RecoveryCode struct {
+	UserID    uuid.UUID
+	Hash      string
+	CreatedOn time.Time
+	UsedOn    *time.Time
+}
+
+// LoginChallenge is issued instead of a session once the password of a user with two-factor authentication matches,
+// only the SHA-256 of the challenge token is stored
+type LoginChallenge struct {
+	Hash      string
+	UserID    uuid.UUID
+	CreatedOn time.Time
+	ExpiresOn time.Time
+	Attempts  int
+	UsedOn    *time.Time
+}
+
+func (c *LoginChallenge) Active(now time.Time) bool {
+	return c.UsedOn == nil && now.Before(c.ExpiresOn) && c.Attempts < MaxLoginChallengeAttempts
+}
+
+// TOTPEnrollment is shown once to set up an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPConfirmForm struct {
+	Code *string `json:"code"`
+}
+
+func (f TOTPConfirmForm) Validate() error {
+	if f.Code == nil || *f.Code == "" {
+		return fmt.Errorf("%w: Code is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// SecondFactorForm completes a login with either a TOTP code, a recovery code
+// or an assertion of a security key answering options of the challenge
+type SecondFactorForm struct {
+	ChallengeToken *string                       `json:"challenge_token"`
+	Code           *string                       `json:"code"`
+	RecoveryCode   *string                       `json:"recovery_code"`
+	WebAuthn       *pmwebauthn.AssertionResponse `json:"webauthn"`
+}
+
+func (f SecondFactorForm) Validate() error {
+	if f.ChallengeToken == nil || *f.ChallengeToken == "" {
+		return fmt.Errorf("%w: ChallengeToken is empty", pmerror.ErrInvalidInput)
+	}
+
+	set := 0
+	for _, factor := range []bool{f.Code != nil && *f.Code != "", f.RecoveryCode != nil && *f.RecoveryCode != "", f.WebAuthn != nil} {
+		if factor {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("%w: exactly one of Code, RecoveryCode or WebAuthn must be set", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// ReauthForm confirms a signed in user is present before two-factor settings change, with the password
+// or the state of a reauthentication at the identity provider, and a TOTP or recovery code
+type ReauthForm struct {
+	Password     *string `json:"password"`
+	OIDCState    *string `json:"oidc_state"`
+	Code         *string `json:"code"`
+	RecoveryCode *string `json:"recovery_code"`
+}
+
+func (f ReauthForm) Validate() error {
+	if (f.Password == nil || *f.Password == "") == (f.OIDCState == nil || *f.OIDCState == "") {
+		return fmt.Errorf("%w: either Password or OIDCState must be set", pmerror.ErrInvalidInput)
+	}
+
+	if (f.Code == nil || *f.Code == "") == (f.RecoveryCode == nil || *f.RecoveryCode == "") {
+		return fmt.Errorf("%w: either Code or RecoveryCode must be set", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -6,15 +6,75 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
 	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
 )
 
+// User is an account. Password holds an Argon2id hash, or ciphertext for users that have not logged in since hashing was introduced.
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string    `json:"name"`
-	Password  string    `json:"password"`
+	Password  string    `json:"-"`
+	IsAdmin   bool      `json:"is_admin"`
 	CreatedOn time.Time `json:"created_on"`
 	UpdatedOn time.Time `json:"updated_on"`
+
+	// DataKey is the random vault key of the user wrapped with the server keyring
+	DataKey string `json:"-"`
+	// VaultVersion is the LatestVaultVersion the records of the user were last rewritten for
+	VaultVersion int `json:"-"`
+
+	// Parameters of the vault key clients derive in client mode.
+	// In server mode they are only read to migrate former password derived keys to DataKey.
+	KDFSalt    []byte `json:"-"`
+	KDFTime    uint32 `json:"-"`
+	KDFMemory  uint32 `json:"-"`
+	KDFThreads uint8  `json:"-"`
+
+	// DirectoryDN is the normalized LDAP DN of users signing in through the directory, they have no usable password
+	DirectoryDN *string `json:"directory_dn,omitempty"`
+	// DisabledOn is set for directory users that left the allowed groups, they can't sign in
+	DisabledOn *time.Time `json:"disabled_on,omitempty"`
+}
+
+// LatestVaultVersion counts the changes to how records are stored that need the whole vault rewritten.
+// Server mode vaults below it are rewritten on the next login of their owner or offline by cmd/rekey.
+//
+//	1: records are indexed
+//	2: fields of every record are bound to it and its revision is marked
+const LatestVaultVersion = 2
+
+func (u *User) KDFParams() pmcrypto.KDFParams {
+	return pmcrypto.KDFParams{
+		Time:    u.KDFTime,
+		Memory:  u.KDFMemory,
+		Threads: u.KDFThreads,
+	}
+}
+
+func (u *User) SetKDF(salt []byte, params pmcrypto.KDFParams) {
+	u.KDFSalt = salt
+	u.KDFTime = params.Time
+	u.KDFMemory = params.Memory
+	u.KDFThreads = params.Threads
+}
+
+// Prelogin tells a client how to derive its vault key, KDF fields are only set in client mode
+type Prelogin struct {
+	Mode    string `json:"mode"`
+	KDF     string `json:"kdf,omitempty"`
+	Salt    []byte `json:"salt,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+func (p *Prelogin) SetKDF(salt []byte, params pmcrypto.KDFParams) {
+	p.KDF = "argon2id"
+	p.Salt = salt
+	p.Time = params.Time
+	p.Memory = params.Memory
+	p.Threads = params.Threads
 }
 
 // Forms are meant to be filled by user
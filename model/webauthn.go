@@ -0,0 +1,116 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmerror"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
+)
+
+// WebAuthnCeremonyTTL limits how long a browser can take to answer WebAuthn options
+const WebAuthnCeremonyTTL = 5 * time.Minute
+
+// WebAuthnCeremonyKind tells what a WebAuthn challenge was issued for, a response to one is not accepted for another
+type WebAuthnCeremonyKind string
+
+const (
+	WebAuthnRegistration WebAuthnCeremonyKind = "registration"
+	// WebAuthnLogin signs in without a password, any passkey of the relying party may answer it
+	WebAuthnLogin WebAuthnCeremonyKind = "login"
+	// WebAuthnSecondFactor completes a login challenge issued after the password matched
+	WebAuthnSecondFactor WebAuthnCeremonyKind = "second_factor"
+)
+
+// WebAuthnCredential is a passkey or security key of a user, a user can register several
+type WebAuthnCredential struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Name         string     `json:"name"`
+	CredentialID []byte     `json:"credential_id"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"sign_count"`
+	Transports   []string   `json:"transports,omitempty"`
+	CreatedOn    time.Time  `json:"created_on"`
+	LastUsedOn   *time.Time `json:"last_used_on,omitempty"`
+}
+
+func (c *WebAuthnCredential) Credential() *pmwebauthn.Credential {
+	return &pmwebauthn.Credential{ID: c.CredentialID, PublicKey: c.PublicKey, SignCount: c.SignCount}
+}
+
+func (c *WebAuthnCredential) Descriptor() pmwebauthn.CredentialDescriptor {
+	return pmwebauthn.CredentialDescriptor{
+		Type:       pmwebauthn.CredentialType,
+		ID:         pmwebauthn.Encoding.EncodeToString(c.CredentialID),
+		Transports: c.Transports,
+	}
+}
+
+// WebAuthnCeremony is a challenge issued with WebAuthn options, it is answered once.
+// UserID is unset for passwordless logins, the user is only known once a passkey answers.
+type WebAuthnCeremony struct {
+	Challenge string
+	Kind      WebAuthnCeremonyKind
+	UserID    *uuid.UUID
+	CreatedOn time.Time
+	ExpiresOn time.Time
+	UsedOn    *time.Time
+}
+
+func (c *WebAuthnCeremony) Active(now time.Time) bool {
+	return c.UsedOn == nil && now.Before(c.ExpiresOn)
+}
+
+// WebAuthnRegistrationForm registers the credential a browser created for registration options. The password,
+// or the state of a reauthentication at the identity provider, is checked again, a passkey signs in on its own.
+type WebAuthnRegistrationForm struct {
+	Name       *string                         `json:"name"`
+	Password   *string                         `json:"password"`
+	OIDCState  *string                         `json:"oidc_state"`
+	Credential *pmwebauthn.AttestationResponse `json:"credential"`
+}
+
+func (f WebAuthnRegistrationForm) Validate() error {
+	if f.Name == nil || *f.Name == "" {
+		return fmt.Errorf("%w: Name is empty", pmerror.ErrInvalidInput)
+	}
+
+	if (f.Password == nil || *f.Password == "") == (f.OIDCState == nil || *f.OIDCState == "") {
+		return fmt.Errorf("%w: either Password or OIDCState must be set", pmerror.ErrInvalidInput)
+	}
+
+	if f.Credential == nil {
+		return fmt.Errorf("%w: Credential is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// WebAuthnLoginForm signs in with the assertion a browser created for login options
+type WebAuthnLoginForm struct {
+	Credential *pmwebauthn.AssertionResponse `json:"credential"`
+}
+
+func (f WebAuthnLoginForm) Validate() error {
+	if f.Credential == nil {
+		return fmt.Errorf("%w: Credential is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// WebAuthnOptionsForm asks for assertion options to answer a login challenge with a security key
+type WebAuthnOptionsForm struct {
+	ChallengeToken *string `json:"challenge_token"`
+}
+
+func (f WebAuthnOptionsForm) Validate() error {
+	if f.ChallengeToken == nil || *f.ChallengeToken == "" {
+		return fmt.Errorf("%w: ChallengeToken is empty", pmerror.ErrInvalidInput)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package pmcrypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// blindIndexTokenSize truncates tokens to 128 bits, collisions between terms are negligible
+const blindIndexTokenSize = 16
+
+// BlindIndex computes keyed tokens of search terms: equal terms give equal tokens,
+// tokens reveal nothing about terms to whoever does not hold the key
+type BlindIndex struct {
+	key []byte
+}
+
+// NewBlindIndex derives the index key from a data key, so the key encrypting fields is never used for MACs
+func NewBlindIndex(dataKey string) *BlindIndex {
+	mac := hmac.New(sha256.New, []byte(dataKey))
+	mac.Write([]byte("pm blind index v1"))
+
+	return &BlindIndex{key: mac.Sum(nil)}
+}
+
+// Token returns the token of term in the index kind, the same term gives different tokens in different kinds
+func (b *BlindIndex) Token(kind, term string) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(term))
+
+	return hex.EncodeToString(mac.Sum(nil)[:blindIndexTokenSize])
+}
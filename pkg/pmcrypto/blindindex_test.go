@@ -0,0 +1,17 @@
+package pmcrypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlindIndex(t *testing.T) {
+	index := NewBlindIndex(testKey)
+
+	require.Equal(t, index.Token("username", "alice"), NewBlindIndex(testKey).Token("username", "alice"))
+	require.NotEqual(t, index.Token("username", "alice"), index.Token("username", "bob"))
+	require.NotEqual(t, index.Token("username", "alice"), index.Token("email", "alice"))
+	require.NotEqual(t, index.Token("username", "alice"), NewBlindIndex("abcdefghijklmnopqrstuvwxyz123456").Token("username", "alice"))
+	require.Len(t, index.Token("name", ""), 2*blindIndexTokenSize)
+}
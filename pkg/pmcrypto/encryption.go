@@ -15,6 +15,8 @@ func encode(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
 }
 
+// Encrypt produces the legacy AES-CFB format with a fixed IV.
+// It is kept for values that are not migrated yet, new data should use EncryptAEAD.
 func Encrypt(target, secret string) (string, error) {
 	block, err := aes.NewCipher([]byte(secret))
 	if err != nil {
@@ -34,7 +36,22 @@ func decode(s string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(s)
 }
 
+// Decrypt reads both AEAD envelopes and the legacy CFB format
 func Decrypt(target, salt string) (string, error) {
+	if IsEnvelope(target) {
+		e, err := ParseEnvelope(target)
+		if err != nil {
+			return "", err
+		}
+
+		plainText, err := e.Open([]byte(salt))
+		if err != nil {
+			return "", err
+		}
+
+		return string(plainText), nil
+	}
+
 	block, err := aes.NewCipher([]byte(salt))
 	if err != nil {
 		return "", fmt.Errorf("new cipher: %v", err)
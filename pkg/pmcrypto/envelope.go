@@ -0,0 +1,248 @@
+package pmcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Envelope layout before encoding:
+//
+//	version (1 byte) | algorithm (1 byte) | key ID length (1 byte) | key ID | nonce | ciphertext with tag
+//
+// Encoded envelopes are base64 prefixed with EnvelopePrefix. The prefix is not part
+// of the base64 alphabet, so envelopes are never confused with legacy CFB values.
+//
+// Version 2 envelopes are laid out the same, their ciphertext is additionally authenticated
+// with associated data that is not stored in the envelope and must be supplied to open it.
+const (
+	EnvelopePrefix = "pm:"
+
+	EnvelopeVersion1 byte = 1
+	EnvelopeVersion2 byte = 2
+)
+
+type Algorithm byte
+
+const (
+	AlgorithmAES256GCM         Algorithm = 1
+	AlgorithmXChaCha20Poly1305 Algorithm = 2
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmAES256GCM:
+		return "AES-256-GCM"
+	case AlgorithmXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
+var (
+	ErrMalformedEnvelope = errors.New("malformed envelope")
+	// ErrIntegrity is returned when a ciphertext was tampered with or opened with a wrong key
+	ErrIntegrity = errors.New("message authentication failed")
+)
+
+type Envelope struct {
+	Version    byte
+	Algorithm  Algorithm
+	KeyID      string
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func newAEAD(alg Algorithm, key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%s requires %d byte key, got %d", alg, KeySize, len(key))
+	}
+
+	switch alg {
+	case AlgorithmAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("new cipher: %v", err)
+		}
+
+		return cipher.NewGCM(block)
+	case AlgorithmXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+}
+
+// Seal encrypts plaintext with a fresh random nonce
+func Seal(alg Algorithm, key []byte, keyID string, plaintext []byte) (*Envelope, error) {
+	return seal(EnvelopeVersion1, alg, key, keyID, plaintext, nil)
+}
+
+// SealWithAAD encrypts plaintext into a version 2 envelope bound to aad
+func SealWithAAD(alg Algorithm, key []byte, keyID string, plaintext, aad []byte) (*Envelope, error) {
+	return seal(EnvelopeVersion2, alg, key, keyID, plaintext, aad)
+}
+
+func seal(version byte, alg Algorithm, key []byte, keyID string, plaintext, aad []byte) (*Envelope, error) {
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("key ID is longer than 255 bytes")
+	}
+
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("read random: %v", err)
+	}
+
+	return &Envelope{
+		Version:    version,
+		Algorithm:  alg,
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, aad),
+	}, nil
+}
+
+// Open decrypts a version 1 envelope
+func (e *Envelope) Open(key []byte) ([]byte, error) {
+	if e.Version != EnvelopeVersion1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformedEnvelope, e.Version)
+	}
+
+	return e.open(key, nil)
+}
+
+// OpenWithAAD decrypts a version 2 envelope, it fails with ErrIntegrity unless aad is the one it was sealed with
+func (e *Envelope) OpenWithAAD(key, aad []byte) ([]byte, error) {
+	if e.Version != EnvelopeVersion2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformedEnvelope, e.Version)
+	}
+
+	return e.open(key, aad)
+}
+
+func (e *Envelope) open(key, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(e.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce size", ErrMalformedEnvelope)
+	}
+
+	plainText, err := aead.Open(nil, e.Nonce, e.Ciphertext, aad)
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+
+	return plainText, nil
+}
+
+func (e *Envelope) String() string {
+	b := make([]byte, 0, 3+len(e.KeyID)+len(e.Nonce)+len(e.Ciphertext))
+	b = append(b, e.Version, byte(e.Algorithm), byte(len(e.KeyID)))
+	b = append(b, e.KeyID...)
+	b = append(b, e.Nonce...)
+	b = append(b, e.Ciphertext...)
+
+	return EnvelopePrefix + encode(b)
+}
+
+func IsEnvelope(s string) bool {
+	return strings.HasPrefix(s, EnvelopePrefix)
+}
+
+func ParseEnvelope(s string) (*Envelope, error) {
+	if !IsEnvelope(s) {
+		return nil, fmt.Errorf("%w: missing prefix", ErrMalformedEnvelope)
+	}
+
+	b, err := decode(strings.TrimPrefix(s, EnvelopePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode: %v", ErrMalformedEnvelope, err)
+	}
+
+	if len(b) < 3 || len(b) < 3+int(b[2]) {
+		return nil, fmt.Errorf("%w: too short", ErrMalformedEnvelope)
+	}
+
+	e := &Envelope{
+		Version:   b[0],
+		Algorithm: Algorithm(b[1]),
+		KeyID:     string(b[3 : 3+int(b[2])]),
+	}
+	b = b[3+int(b[2]):]
+
+	var nonceSize int
+	switch e.Algorithm {
+	case AlgorithmAES256GCM:
+		nonceSize = 12
+	case AlgorithmXChaCha20Poly1305:
+		nonceSize = chacha20poly1305.NonceSizeX
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrMalformedEnvelope, e.Algorithm)
+	}
+
+	if len(b) < nonceSize {
+		return nil, fmt.Errorf("%w: too short", ErrMalformedEnvelope)
+	}
+
+	e.Nonce, e.Ciphertext = b[:nonceSize], b[nonceSize:]
+
+	return e, nil
+}
+
+// KeyID returns a short fingerprint identifying secret without revealing it
+func KeyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+// EncryptAEAD encrypts target with AES-256-GCM and returns an encoded envelope tagged with keyID
+func EncryptAEAD(target, secret, keyID string) (string, error) {
+	e, err := Seal(AlgorithmAES256GCM, []byte(secret), keyID, []byte(target))
+	if err != nil {
+		return "", err
+	}
+
+	return e.String(), nil
+}
+
+// EncryptWithAAD is EncryptAEAD producing a version 2 envelope bound to aad
+func EncryptWithAAD(target, secret, keyID string, aad []byte) (string, error) {
+	e, err := SealWithAAD(AlgorithmAES256GCM, []byte(secret), keyID, []byte(target), aad)
+	if err != nil {
+		return "", err
+	}
+
+	return e.String(), nil
+}
+
+// DecryptWithAAD opens a version 2 envelope produced by EncryptWithAAD.
+// Legacy values and version 1 envelopes are rejected, they carry no associated data.
+func DecryptWithAAD(target, secret string, aad []byte) (string, error) {
+	e, err := ParseEnvelope(target)
+	if err != nil {
+		return "", err
+	}
+
+	plainText, err := e.OpenWithAAD([]byte(secret), aad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
@@ -0,0 +1,117 @@
+package pmcrypto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "12345678901234567890123456789012"
+
+func TestSeal(t *testing.T) {
+	for _, alg := range []Algorithm{AlgorithmAES256GCM, AlgorithmXChaCha20Poly1305} {
+		t.Run("success_"+alg.String(), func(t *testing.T) {
+			e, err := Seal(alg, []byte(testKey), "key-1", []byte("test input"))
+			require.NoError(t, err)
+
+			parsed, err := ParseEnvelope(e.String())
+			require.NoError(t, err)
+			require.Equal(t, e, parsed)
+			require.Equal(t, "key-1", parsed.KeyID)
+			require.Equal(t, alg, parsed.Algorithm)
+
+			plainText, err := parsed.Open([]byte(testKey))
+			require.NoError(t, err)
+			require.Equal(t, "test input", string(plainText))
+		})
+	}
+
+	t.Run("error_invalid_key_length", func(t *testing.T) {
+		_, err := Seal(AlgorithmAES256GCM, []byte("1234567890123456"), "", []byte("test input"))
+		require.Error(t, err)
+	})
+}
+
+func TestEncryptAEAD(t *testing.T) {
+	t.Run("success_random_nonce", func(t *testing.T) {
+		encryptedText1, err := EncryptAEAD("test input", testKey, KeyID(testKey))
+		require.NoError(t, err)
+
+		encryptedText2, err := EncryptAEAD("test input", testKey, KeyID(testKey))
+		require.NoError(t, err)
+
+		require.NotEqual(t, encryptedText1, encryptedText2, "identical plaintexts should produce different ciphertexts")
+		require.True(t, IsEnvelope(encryptedText1))
+
+		decryptedText, err := Decrypt(encryptedText1, testKey)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decryptedText)
+	})
+
+	t.Run("success_decrypt_legacy", func(t *testing.T) {
+		encryptedText, err := Encrypt("test input", testKey)
+		require.NoError(t, err)
+		require.False(t, IsEnvelope(encryptedText))
+
+		decryptedText, err := Decrypt(encryptedText, testKey)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decryptedText)
+	})
+
+	t.Run("error_tampered", func(t *testing.T) {
+		encryptedText, err := EncryptAEAD("test input", testKey, "")
+		require.NoError(t, err)
+
+		e, err := ParseEnvelope(encryptedText)
+		require.NoError(t, err)
+		e.Ciphertext[0] ^= 1
+
+		_, err = Decrypt(e.String(), testKey)
+		require.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("error_wrong_key", func(t *testing.T) {
+		encryptedText, err := EncryptAEAD("test input", testKey, "")
+		require.NoError(t, err)
+
+		_, err = Decrypt(encryptedText, "abcdefghijklmnopqrstuvwxyz123456")
+		require.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("error_malformed", func(t *testing.T) {
+		_, err := Decrypt(EnvelopePrefix+"AQ==", testKey)
+		require.True(t, errors.Is(err, ErrMalformedEnvelope))
+	})
+}
+
+func TestEncryptWithAAD(t *testing.T) {
+	aad := []byte("record|owner|notes|1")
+
+	encryptedText, err := EncryptWithAAD("test input", testKey, KeyID(testKey), aad)
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		decryptedText, err := DecryptWithAAD(encryptedText, testKey, aad)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decryptedText)
+	})
+
+	t.Run("error_other_aad", func(t *testing.T) {
+		_, err := DecryptWithAAD(encryptedText, testKey, []byte("record|owner|notes|2"))
+		require.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("error_without_aad", func(t *testing.T) {
+		_, err := Decrypt(encryptedText, testKey)
+		require.True(t, errors.Is(err, ErrMalformedEnvelope))
+	})
+
+	t.Run("error_version_1", func(t *testing.T) {
+		v1, err := EncryptAEAD("test input", testKey, KeyID(testKey))
+		require.NoError(t, err)
+
+		_, err = DecryptWithAAD(v1, testKey, aad)
+		require.True(t, errors.Is(err, ErrMalformedEnvelope))
+	})
+}
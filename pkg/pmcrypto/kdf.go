@@ -0,0 +1,58 @@
+package pmcrypto
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// KeySize is the size of keys produced by DeriveKey, suitable for AES-256
+	KeySize  = 32
+	SaltSize = 16
+)
+
+// KDFParams are Argon2id cost parameters. They are stored along with the salt
+// so that keys stay derivable after the defaults are tuned.
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+var DefaultKDFParams = KDFParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+func (p KDFParams) Validate() error {
+	if p.Time == 0 {
+		return fmt.Errorf("time must be positive")
+	}
+
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("memory must be at least 8 KiB per thread")
+	}
+
+	if p.Threads == 0 {
+		return fmt.Errorf("threads must be positive")
+	}
+
+	return nil
+}
+
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("read random: %v", err)
+	}
+
+	return salt, nil
+}
+
+// DeriveKey derives a KeySize byte key from password using Argon2id
+func DeriveKey(password string, salt []byte, params KDFParams) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, KeySize)
+}
@@ -0,0 +1,52 @@
+package pmcrypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testKDFParams = KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+func TestDeriveKey(t *testing.T) {
+	t.Run("success_deterministic", func(t *testing.T) {
+		salt, err := NewSalt()
+		require.NoError(t, err)
+
+		key1 := DeriveKey("master password", salt, testKDFParams)
+		key2 := DeriveKey("master password", salt, testKDFParams)
+		require.Len(t, key1, KeySize)
+		require.Equal(t, key1, key2)
+	})
+
+	t.Run("success_salt_changes_key", func(t *testing.T) {
+		salt1, err := NewSalt()
+		require.NoError(t, err)
+
+		salt2, err := NewSalt()
+		require.NoError(t, err)
+
+		require.NotEqual(t, DeriveKey("master password", salt1, testKDFParams), DeriveKey("master password", salt2, testKDFParams))
+	})
+
+	t.Run("success_usable_as_cipher_key", func(t *testing.T) {
+		salt, err := NewSalt()
+		require.NoError(t, err)
+
+		key := string(DeriveKey("master password", salt, testKDFParams))
+
+		encryptedText, err := Encrypt("test input", key)
+		require.NoError(t, err)
+
+		decryptedText, err := Decrypt(encryptedText, key)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decryptedText)
+	})
+}
+
+func TestKDFParams_Validate(t *testing.T) {
+	require.NoError(t, DefaultKDFParams.Validate())
+	require.Error(t, KDFParams{Time: 0, Memory: 1024, Threads: 1}.Validate())
+	require.Error(t, KDFParams{Time: 1, Memory: 1024, Threads: 0}.Validate())
+	require.Error(t, KDFParams{Time: 1, Memory: 4, Threads: 1}.Validate())
+}
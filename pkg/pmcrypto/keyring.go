@@ -0,0 +1,167 @@
+package pmcrypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Keyring holds keys identified by ID. The active key encrypts new values,
+// the others are kept so that values written before a rotation stay readable.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// ReadKeyring builds a keyring from a file holding ParseKeyring keys, so that key material stays out of the image
+func ReadKeyring(path, active string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	return ParseKeyring(strings.TrimSpace(string(raw)), active)
+}
+
+// ParseKeyring builds a keyring from comma separated "id:base64key" pairs
+func ParseKeyring(keys, active string) (*Keyring, error) {
+	k := NewKeyring()
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			// do not echo the entry, it may contain key material
+			return nil, errors.New("invalid key entry: expected id:base64key")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode key %q: %v", id, err)
+		}
+
+		if err := k.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.SetActive(active); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || id == LegacyKeyID || len(id) > 255 {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+
+	if len(key) != KeySize {
+		return fmt.Errorf("key %q must be %d bytes, got %d", id, KeySize, len(key))
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+
+	k.keys[id] = key
+
+	return nil
+}
+
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	k.active = id
+
+	return nil
+}
+
+func (k *Keyring) ActiveID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active
+}
+
+// IDs returns sorted IDs of all keys
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (k *Keyring) Has(id string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	_, ok := k.keys[id]
+	return ok
+}
+
+func (k *Keyring) Encrypt(target string) (string, error) {
+	k.mu.RLock()
+	id, key := k.active, k.keys[k.active]
+	k.mu.RUnlock()
+
+	if key == nil {
+		return "", fmt.Errorf("%w: no active key", ErrUnknownKey)
+	}
+
+	e, err := Seal(AlgorithmAES256GCM, key, id, []byte(target))
+	if err != nil {
+		return "", err
+	}
+
+	return e.String(), nil
+}
+
+func (k *Keyring) Decrypt(target string) (string, error) {
+	e, err := ParseEnvelope(target)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[e.KeyID]
+	k.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, e.KeyID)
+	}
+
+	plainText, err := e.Open(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
@@ -0,0 +1,108 @@
+package pmcrypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	oldKey := []byte("12345678901234567890123456789012")
+	newKey := []byte("abcdefghijklmnopqrstuvwxyz123456")
+
+	t.Run("success_rotation", func(t *testing.T) {
+		k := NewKeyring()
+		require.NoError(t, k.Add("k1", oldKey))
+		require.NoError(t, k.SetActive("k1"))
+
+		encryptedOld, err := k.Encrypt("test input")
+		require.NoError(t, err)
+
+		require.NoError(t, k.Add("k2", newKey))
+		require.NoError(t, k.SetActive("k2"))
+		require.True(t, NeedsRotation(k, encryptedOld))
+
+		encryptedNew, err := k.Encrypt("test input")
+		require.NoError(t, err)
+		require.False(t, NeedsRotation(k, encryptedNew))
+
+		id, err := KeyIDOf(encryptedNew)
+		require.NoError(t, err)
+		require.Equal(t, "k2", id)
+
+		for _, encrypted := range []string{encryptedOld, encryptedNew} {
+			decrypted, err := k.Decrypt(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, "test input", decrypted)
+		}
+	})
+
+	t.Run("success_legacy", func(t *testing.T) {
+		k := NewKeyring()
+		require.NoError(t, k.Add("k1", oldKey))
+		require.NoError(t, k.SetActive("k1"))
+		p := WithLegacy(k, "1234567890123456")
+
+		encrypted, err := Encrypt("test input", "1234567890123456")
+		require.NoError(t, err)
+
+		id, err := KeyIDOf(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, LegacyKeyID, id)
+
+		require.True(t, NeedsRotation(p, encrypted))
+
+		decrypted, err := p.Decrypt(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decrypted)
+
+		_, err = k.Decrypt(encrypted)
+		require.True(t, errors.Is(err, ErrMalformedEnvelope))
+	})
+
+	t.Run("error_unknown_key", func(t *testing.T) {
+		k := NewKeyring()
+		require.NoError(t, k.Add("k1", oldKey))
+		require.NoError(t, k.SetActive("k1"))
+
+		encrypted, err := EncryptAEAD("test input", string(newKey), "k2")
+		require.NoError(t, err)
+
+		_, err = k.Decrypt(encrypted)
+		require.True(t, errors.Is(err, ErrUnknownKey))
+	})
+
+	t.Run("error_invalid_keys", func(t *testing.T) {
+		k := NewKeyring()
+		require.Error(t, k.Add("k1", []byte("short")))
+		require.Error(t, k.Add(LegacyKeyID, oldKey))
+		require.NoError(t, k.Add("k1", oldKey))
+		require.Error(t, k.Add("k1", newKey))
+		require.True(t, errors.Is(k.SetActive("k2"), ErrUnknownKey))
+	})
+}
+
+func TestParseKeyring(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+	key2 := base64.StdEncoding.EncodeToString([]byte("abcdefghijklmnopqrstuvwxyz123456"))
+
+	t.Run("success", func(t *testing.T) {
+		k, err := ParseKeyring(fmt.Sprintf("k1:%s, k2:%s", key1, key2), "k2")
+		require.NoError(t, err)
+		require.Equal(t, []string{"k1", "k2"}, k.IDs())
+		require.Equal(t, "k2", k.ActiveID())
+	})
+
+	t.Run("error_missing_active", func(t *testing.T) {
+		_, err := ParseKeyring("k1:"+key1, "k2")
+		require.Error(t, err)
+	})
+
+	t.Run("error_malformed", func(t *testing.T) {
+		_, err := ParseKeyring(key1, "k1")
+		require.Error(t, err)
+	})
+}
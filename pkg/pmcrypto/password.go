@@ -0,0 +1,91 @@
+package pmcrypto
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// passwordHashPrefix starts Argon2id hashes in the PHC string format:
+//
+//	$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<base64 salt>$<base64 hash>
+const passwordHashPrefix = "$argon2id$"
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// HashPassword returns an Argon2id hash of password with a random salt
+func HashPassword(password string, params KDFParams) (string, error) {
+	if err := params.Validate(); err != nil {
+		return "", err
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return "", err
+	}
+
+	hash := DeriveKey(password, salt, params)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", passwordHashPrefix, argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyPassword reports whether password matches hash, hashes are compared in constant time
+func VerifyPassword(password, hash string) (bool, error) {
+	params, salt, expected, err := parsePasswordHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(expected)))
+
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+// PasswordNeedsRehash reports whether hash was made with parameters other than params
+func PasswordNeedsRehash(hash string, params KDFParams) bool {
+	actual, _, _, err := parsePasswordHash(hash)
+	return err != nil || actual != params
+}
+
+func IsPasswordHash(s string) bool {
+	return strings.HasPrefix(s, passwordHashPrefix)
+}
+
+func parsePasswordHash(hash string) (KDFParams, []byte, []byte, error) {
+	var params KDFParams
+
+	parts := strings.Split(strings.TrimPrefix(hash, passwordHashPrefix), "$")
+	if !IsPasswordHash(hash) || len(parts) != 4 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported version", ErrMalformedHash)
+	}
+
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: parameters", ErrMalformedHash)
+	}
+
+	if err := params.Validate(); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: salt", ErrMalformedHash)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: hash", ErrMalformedHash)
+	}
+
+	return params, salt, key, nil
+}
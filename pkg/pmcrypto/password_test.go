@@ -0,0 +1,50 @@
+package pmcrypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 1024, Threads: 1}
+
+	hash, err := HashPassword("test password", params)
+	require.NoError(t, err)
+	require.True(t, IsPasswordHash(hash))
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	other, err := HashPassword("test password", params)
+	require.NoError(t, err)
+	require.NotEqual(t, hash, other)
+
+	t.Run("success_verify", func(t *testing.T) {
+		ok, err := VerifyPassword("test password", hash)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = VerifyPassword("wrong password", hash)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("success_needs_rehash", func(t *testing.T) {
+		require.False(t, PasswordNeedsRehash(hash, params))
+		require.True(t, PasswordNeedsRehash(hash, KDFParams{Time: 2, Memory: 1024, Threads: 1}))
+	})
+
+	t.Run("error_malformed", func(t *testing.T) {
+		for _, hash := range []string{
+			"",
+			"legacy ciphertext",
+			"$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQ",
+			"$argon2id$v=16$m=1024,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+			"$argon2id$v=19$m=1024,t=0,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+		} {
+			_, err := VerifyPassword("password", hash)
+			require.True(t, errors.Is(err, ErrMalformedHash), hash)
+		}
+	})
+}
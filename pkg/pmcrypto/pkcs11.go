@@ -0,0 +1,220 @@
+//go:build pkcs11
+
+package pmcrypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+const gcmNonceSize = 12
+
+// PKCS11 is a KeyProvider backed by AES-256 keys on a PKCS#11 token, such as an HSM or SoftHSM.
+// Key material never leaves the token, values are envelopes tagged with the key label.
+type PKCS11 struct {
+	ctx *pkcs11.Ctx
+
+	// mu serializes operations, a PKCS#11 session runs one at a time
+	mu      sync.Mutex
+	session pkcs11.SessionHandle
+	keys    map[string]pkcs11.ObjectHandle
+	active  string
+}
+
+func NewPKCS11(config PKCS11Config) (*PKCS11, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("load module %s", config.Module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+
+	p := &PKCS11{ctx: ctx, keys: make(map[string]pkcs11.ObjectHandle), active: config.Active}
+	if err := p.open(config); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *PKCS11) open(config PKCS11Config) error {
+	slots, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("get slots: %w", err)
+	}
+
+	slot, found := uint(0), false
+	for _, s := range slots {
+		info, err := p.ctx.GetTokenInfo(s)
+		if err != nil {
+			return fmt.Errorf("get token info: %w", err)
+		}
+
+		if strings.TrimSpace(info.Label) == config.TokenLabel {
+			slot, found = s, true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("token %q not found", config.TokenLabel)
+	}
+
+	p.session, err = p.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("open session: %w", err)
+	}
+
+	if err := p.ctx.Login(p.session, pkcs11.CKU_USER, config.PIN); err != nil {
+		p.ctx.CloseSession(p.session)
+		return fmt.Errorf("login: %w", err)
+	}
+
+	for _, label := range config.Keys {
+		handle, err := p.findKey(label)
+		if err != nil {
+			p.ctx.Logout(p.session)
+			p.ctx.CloseSession(p.session)
+			return err
+		}
+
+		p.keys[label] = handle
+	}
+
+	return nil
+}
+
+func (p *PKCS11) findKey(label string) (pkcs11.ObjectHandle, error) {
+	err := p.ctx.FindObjectsInit(p.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("find key %q: %w", label, err)
+	}
+
+	handles, _, err := p.ctx.FindObjects(p.session, 2)
+	if finalErr := p.ctx.FindObjectsFinal(p.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("find key %q: %w", label, err)
+	}
+
+	if len(handles) != 1 {
+		return 0, fmt.Errorf("%w: expected one key labeled %q, found %d", ErrUnknownKey, label, len(handles))
+	}
+
+	return handles[0], nil
+}
+
+func (p *PKCS11) Encrypt(target string) (string, error) {
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("read random: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	params := pkcs11.NewGCMParams(nonce, nil, 128)
+	defer params.Free()
+
+	err := p.ctx.EncryptInit(p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, p.keys[p.active])
+	if err != nil {
+		return "", fmt.Errorf("encrypt init: %w", err)
+	}
+
+	ciphertext, err := p.ctx.Encrypt(p.session, []byte(target))
+	if err != nil {
+		return "", fmt.Errorf("encrypt: %w", err)
+	}
+
+	e := &Envelope{
+		Version:    EnvelopeVersion1,
+		Algorithm:  AlgorithmAES256GCM,
+		KeyID:      p.active,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}
+
+	return e.String(), nil
+}
+
+func (p *PKCS11) Decrypt(target string) (string, error) {
+	e, err := ParseEnvelope(target)
+	if err != nil {
+		return "", err
+	}
+
+	if e.Version != EnvelopeVersion1 || e.Algorithm != AlgorithmAES256GCM || len(e.Nonce) != gcmNonceSize {
+		return "", fmt.Errorf("%w: unsupported by PKCS#11 provider", ErrMalformedEnvelope)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	handle, ok := p.keys[e.KeyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, e.KeyID)
+	}
+
+	params := pkcs11.NewGCMParams(e.Nonce, nil, 128)
+	defer params.Free()
+
+	err = p.ctx.DecryptInit(p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}, handle)
+	if err != nil {
+		return "", fmt.Errorf("decrypt init: %w", err)
+	}
+
+	plainText, err := p.ctx.Decrypt(p.session, e.Ciphertext)
+	if err != nil {
+		var pkcs11Err pkcs11.Error
+		if errors.As(err, &pkcs11Err) && (pkcs11Err == pkcs11.CKR_ENCRYPTED_DATA_INVALID || pkcs11Err == pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE) {
+			return "", ErrIntegrity
+		}
+
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+
+	return string(plainText), nil
+}
+
+func (p *PKCS11) ActiveID() string {
+	return p.active
+}
+
+func (p *PKCS11) IDs() []string {
+	ids := make([]string, 0, len(p.keys))
+	for id := range p.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (p *PKCS11) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := errors.Join(p.ctx.Logout(p.session), p.ctx.CloseSession(p.session), p.ctx.Finalize())
+	p.ctx.Destroy()
+
+	return err
+}
@@ -0,0 +1,41 @@
+package pmcrypto
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// PKCS11Config selects AES-256 keys on a PKCS#11 token.
+// The provider is only available in binaries built with the "pkcs11" tag, which requires cgo.
+type PKCS11Config struct {
+	// Module is the path of the PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so
+	Module     string
+	TokenLabel string
+	PIN        string
+	// Keys are labels of the keys that can decrypt, Active is the label of the one encrypting new values
+	Keys   []string
+	Active string
+}
+
+func (c PKCS11Config) validate() error {
+	if c.Module == "" {
+		return errors.New("pkcs11 module is empty")
+	}
+
+	if c.TokenLabel == "" {
+		return errors.New("pkcs11 token label is empty")
+	}
+
+	for _, label := range c.Keys {
+		if label == "" || label == LegacyKeyID || len(label) > 255 {
+			return fmt.Errorf("invalid key label %q", label)
+		}
+	}
+
+	if !slices.Contains(c.Keys, c.Active) {
+		return fmt.Errorf("%w: active key %q is not listed", ErrUnknownKey, c.Active)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !pkcs11
+
+package pmcrypto
+
+import "errors"
+
+// PKCS11 is a stub, build with the "pkcs11" tag for PKCS#11 support
+type PKCS11 struct {
+	KeyProvider
+}
+
+func NewPKCS11(config PKCS11Config) (*PKCS11, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("built without PKCS#11 support, rebuild with -tags pkcs11")
+}
+
+func (p *PKCS11) Close() error {
+	return nil
+}
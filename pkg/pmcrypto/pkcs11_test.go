@@ -0,0 +1,128 @@
+//go:build pkcs11
+
+package pmcrypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPKCS11 runs against SoftHSM, e.g.
+//
+//	softhsm2-util --init-token --free --label pm-test --pin 1234 --so-pin 1234
+//	PM_TEST_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PM_TEST_PKCS11_TOKEN=pm-test PM_TEST_PKCS11_PIN=1234 go test -tags pkcs11 ./pkg/pmcrypto/
+func TestPKCS11(t *testing.T) {
+	module := os.Getenv("PM_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("PM_TEST_PKCS11_MODULE is not set")
+	}
+
+	config := PKCS11Config{
+		Module:     module,
+		TokenLabel: os.Getenv("PM_TEST_PKCS11_TOKEN"),
+		PIN:        os.Getenv("PM_TEST_PKCS11_PIN"),
+	}
+
+	oldKey := fmt.Sprintf("pm-test-old-%d", os.Getpid())
+	newKey := fmt.Sprintf("pm-test-new-%d", os.Getpid())
+	generateTestKeys(t, config, oldKey, newKey)
+
+	config.Keys, config.Active = []string{oldKey}, oldKey
+	p, err := NewPKCS11(config)
+	require.NoError(t, err)
+
+	encryptedOld, err := p.Encrypt("test input")
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	config.Keys, config.Active = []string{oldKey, newKey}, newKey
+	p, err = NewPKCS11(config)
+	require.NoError(t, err)
+	defer p.Close()
+
+	t.Run("success_rotation", func(t *testing.T) {
+		require.True(t, NeedsRotation(p, encryptedOld))
+
+		encryptedNew, err := p.Encrypt("test input")
+		require.NoError(t, err)
+		require.False(t, NeedsRotation(p, encryptedNew))
+
+		for _, encrypted := range []string{encryptedOld, encryptedNew} {
+			decrypted, err := p.Decrypt(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, "test input", decrypted)
+		}
+	})
+
+	t.Run("error_tampered", func(t *testing.T) {
+		e, err := ParseEnvelope(encryptedOld)
+		require.NoError(t, err)
+		e.Ciphertext[0] ^= 1
+
+		_, err = p.Decrypt(e.String())
+		require.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("error_unknown_key", func(t *testing.T) {
+		config.Keys, config.Active = []string{"missing"}, "missing"
+		_, err := NewPKCS11(config)
+		require.True(t, errors.Is(err, ErrUnknownKey))
+	})
+}
+
+// generateTestKeys creates AES-256 keys on the token that are destroyed when the test ends
+func generateTestKeys(t *testing.T, config PKCS11Config, labels ...string) {
+	t.Helper()
+
+	ctx := pkcs11.New(config.Module)
+	require.NotNil(t, ctx)
+	require.NoError(t, ctx.Initialize())
+
+	slots, err := ctx.GetSlotList(true)
+	require.NoError(t, err)
+
+	var session pkcs11.SessionHandle
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		require.NoError(t, err)
+
+		if info.Label == config.TokenLabel {
+			session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+			require.NoError(t, err)
+			break
+		}
+	}
+	require.NotZero(t, session, "token %q not found", config.TokenLabel)
+	require.NoError(t, ctx.Login(session, pkcs11.CKU_USER, config.PIN))
+
+	var handles []pkcs11.ObjectHandle
+	for _, label := range labels {
+		handle, err := ctx.GenerateKey(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, KeySize),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		})
+		require.NoError(t, err)
+		handles = append(handles, handle)
+	}
+
+	t.Cleanup(func() {
+		for _, handle := range handles {
+			ctx.DestroyObject(session, handle)
+		}
+		ctx.Logout(session)
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		ctx.Destroy()
+	})
+}
@@ -0,0 +1,66 @@
+package pmcrypto
+
+import "errors"
+
+// LegacyKeyID identifies values in the legacy CFB format, which carry no key ID
+const LegacyKeyID = "legacy"
+
+var ErrUnknownKey = errors.New("unknown key")
+
+// KeyProvider encrypts server side secrets, such as user data keys, with master keys it holds.
+// Key IDs are the ones returned by KeyIDOf for values the provider encrypted.
+type KeyProvider interface {
+	Encrypt(target string) (string, error)
+	Decrypt(target string) (string, error)
+	// ActiveID is the ID of the master key encrypting new values
+	ActiveID() string
+	// IDs are sorted IDs of master keys that can still decrypt
+	IDs() []string
+}
+
+// KeyIDOf returns the ID of the key target was encrypted with
+func KeyIDOf(target string) (string, error) {
+	if IsEnvelope(target) {
+		e, err := ParseEnvelope(target)
+		if err != nil {
+			return "", err
+		}
+
+		return e.KeyID, nil
+	}
+
+	if IsVaultCiphertext(target) {
+		version, err := vaultVersion(target)
+		if err != nil {
+			return "", err
+		}
+
+		return vaultKeyID(version), nil
+	}
+
+	return LegacyKeyID, nil
+}
+
+// NeedsRotation reports whether target was encrypted with a key other than the active one of p
+func NeedsRotation(p KeyProvider, target string) bool {
+	id, err := KeyIDOf(target)
+	return err != nil || id != p.ActiveID()
+}
+
+// WithLegacy makes p read values in the legacy CFB format with secret
+func WithLegacy(p KeyProvider, secret string) KeyProvider {
+	return &legacyProvider{KeyProvider: p, secret: secret}
+}
+
+type legacyProvider struct {
+	KeyProvider
+	secret string
+}
+
+func (p *legacyProvider) Decrypt(target string) (string, error) {
+	if IsEnvelope(target) || IsVaultCiphertext(target) {
+		return p.KeyProvider.Decrypt(target)
+	}
+
+	return Decrypt(target, p.secret)
+}
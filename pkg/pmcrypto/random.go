@@ -0,0 +1,21 @@
+package pmcrypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// RandomSize is how many random bytes NewRandom encodes
+const RandomSize = 32
+
+// NewRandom generates a URL safe random string, for OIDC states, nonces and PKCE code verifiers
+// as well as the passwords of users nobody knows the password of
+func NewRandom() (string, error) {
+	b := make([]byte, RandomSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
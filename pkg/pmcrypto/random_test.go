@@ -0,0 +1,21 @@
+package pmcrypto
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRandom(t *testing.T) {
+	a, err := NewRandom()
+	require.NoError(t, err)
+
+	b, err := NewRandom()
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+
+	raw, err := base64.RawURLEncoding.DecodeString(a)
+	require.NoError(t, err)
+	require.Len(t, raw, RandomSize)
+}
@@ -0,0 +1,37 @@
+package pmcrypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// RevisionMAC authenticates revision high-water marks: the latest revision a record was written at,
+// kept apart from the record so that a row replayed from before its latest write stands out
+type RevisionMAC struct {
+	key []byte
+}
+
+// NewRevisionMAC derives the mark key from a data key, apart from the blind index key
+func NewRevisionMAC(dataKey string) *RevisionMAC {
+	mac := hmac.New(sha256.New, []byte(dataKey))
+	mac.Write([]byte("pm revision mark v1"))
+
+	return &RevisionMAC{key: mac.Sum(nil)}
+}
+
+// Sum returns the MAC of revision of record, record names the record along with its owner
+func (r *RevisionMAC) Sum(record string, revision int) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(record))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.Itoa(revision)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells whether sum is the MAC of revision of record
+func (r *RevisionMAC) Verify(record string, revision int, sum string) bool {
+	return hmac.Equal([]byte(r.Sum(record, revision)), []byte(sum))
+}
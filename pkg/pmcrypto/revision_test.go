@@ -0,0 +1,18 @@
+package pmcrypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisionMAC(t *testing.T) {
+	mac := NewRevisionMAC(testKey)
+	sum := mac.Sum("record", 2)
+
+	require.True(t, NewRevisionMAC(testKey).Verify("record", 2, sum))
+	require.False(t, mac.Verify("record", 1, sum))
+	require.False(t, mac.Verify("other", 2, sum))
+	require.False(t, NewRevisionMAC("abcdefghijklmnopqrstuvwxyz123456").Verify("record", 2, sum))
+	require.NotEqual(t, NewBlindIndex(testKey).Token("record", "2"), sum[:2*blindIndexTokenSize])
+}
@@ -0,0 +1,253 @@
+package pmcrypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultPrefix starts ciphertexts of the HashiCorp Vault transit engine, "vault:v<version>:<base64>"
+const VaultPrefix = "vault:"
+
+const (
+	defaultVaultMount   = "transit"
+	defaultVaultTimeout = 10 * time.Second
+)
+
+type VaultTransitConfig struct {
+	Address string
+	Token   string
+	// Mount is the path the transit engine is mounted at, "transit" by default
+	Mount string
+	// Key is the name of the transit key, rotated in Vault with "vault write -f transit/keys/<key>/rotate"
+	Key     string
+	Timeout time.Duration
+}
+
+// VaultTransit is a KeyProvider that sends secrets to the Vault transit engine, master keys never leave Vault.
+// Key IDs are "vault:v<version>" of the transit key.
+type VaultTransit struct {
+	config VaultTransitConfig
+	client *http.Client
+
+	mu sync.RWMutex
+	// latest and min are the key versions used for encryption and the oldest one allowed to decrypt
+	latest int
+	min    int
+}
+
+func NewVaultTransit(config VaultTransitConfig) (*VaultTransit, error) {
+	if config.Address == "" {
+		return nil, errors.New("vault address is empty")
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("vault token is empty")
+	}
+
+	if config.Key == "" {
+		return nil, errors.New("vault transit key is empty")
+	}
+
+	if config.Mount == "" {
+		config.Mount = defaultVaultMount
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultVaultTimeout
+	}
+
+	config.Address = strings.TrimSuffix(config.Address, "/")
+
+	v := &VaultTransit{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+
+	if err := v.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Refresh reads the key versions, a rotation in Vault is otherwise picked up by the next Encrypt
+func (v *VaultTransit) Refresh() error {
+	var info struct {
+		LatestVersion        int `json:"latest_version"`
+		MinDecryptionVersion int `json:"min_decryption_version"`
+	}
+
+	if err := v.do(http.MethodGet, "keys", nil, &info); err != nil {
+		return fmt.Errorf("read key: %w", err)
+	}
+
+	if info.LatestVersion < 1 {
+		return fmt.Errorf("key %q has no versions", v.config.Key)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.latest, v.min = info.LatestVersion, info.MinDecryptionVersion
+	if v.min < 1 {
+		v.min = 1
+	}
+
+	return nil
+}
+
+func (v *VaultTransit) Encrypt(target string) (string, error) {
+	var result struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+
+	err := v.do(http.MethodPost, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString([]byte(target)),
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("encrypt: %w", err)
+	}
+
+	version, err := vaultVersion(result.Ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	if version > v.latest {
+		v.latest = version
+	}
+	v.mu.Unlock()
+
+	return result.Ciphertext, nil
+}
+
+func (v *VaultTransit) Decrypt(target string) (string, error) {
+	if _, err := vaultVersion(target); err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+
+	if err := v.do(http.MethodPost, "decrypt", map[string]string{"ciphertext": target}, &result); err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+
+	plainText, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return "", fmt.Errorf("decode plaintext: %v", err)
+	}
+
+	return string(plainText), nil
+}
+
+func (v *VaultTransit) ActiveID() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return vaultKeyID(v.latest)
+}
+
+func (v *VaultTransit) IDs() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	ids := make([]string, 0, v.latest-v.min+1)
+	for version := v.min; version <= v.latest; version++ {
+		ids = append(ids, vaultKeyID(version))
+	}
+
+	return ids
+}
+
+// do calls the transit endpoint /v1/<mount>/<action>/<key> and decodes the "data" of the response into result
+func (v *VaultTransit) do(method, action string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal: %v", err)
+		}
+
+		reader = bytes.NewReader(raw)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", v.config.Address, v.config.Mount, action, v.config.Key)
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+
+	req.Header.Set("X-Vault-Token", v.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&payload); err != nil {
+		return fmt.Errorf("vault responded %d: decode: %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.Join(payload.Errors, "; ")
+		if strings.Contains(msg, "message authentication failed") {
+			return ErrIntegrity
+		}
+
+		return fmt.Errorf("vault responded %d: %s", resp.StatusCode, msg)
+	}
+
+	if err := json.Unmarshal(payload.Data, result); err != nil {
+		return fmt.Errorf("unmarshal data: %v", err)
+	}
+
+	return nil
+}
+
+func IsVaultCiphertext(s string) bool {
+	return strings.HasPrefix(s, VaultPrefix)
+}
+
+func vaultVersion(ciphertext string) (int, error) {
+	rest, ok := strings.CutPrefix(ciphertext, VaultPrefix+"v")
+	if !ok {
+		return 0, fmt.Errorf("%w: missing vault prefix", ErrMalformedEnvelope)
+	}
+
+	version, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, fmt.Errorf("%w: missing vault key version", ErrMalformedEnvelope)
+	}
+
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%w: invalid vault key version", ErrMalformedEnvelope)
+	}
+
+	return n, nil
+}
+
+func vaultKeyID(version int) string {
+	return VaultPrefix + "v" + strconv.Itoa(version)
+}
@@ -0,0 +1,209 @@
+package pmcrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransit stands in for "vault server -dev" with the transit engine mounted at /v1/transit
+type fakeTransit struct {
+	token string
+
+	mu   sync.Mutex
+	keys map[string][]cipher.AEAD
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	respond := func(status int, data any, errs ...string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{"data": data, "errors": errs})
+	}
+
+	if r.Header.Get("X-Vault-Token") != f.token {
+		respond(http.StatusForbidden, nil, "permission denied")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/")
+	if len(parts) < 2 {
+		respond(http.StatusNotFound, nil)
+		return
+	}
+	action, name := parts[0], parts[1]
+
+	var body map[string]string
+	if r.Method == http.MethodPost && r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respond(http.StatusBadRequest, nil, err.Error())
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case action == "keys" && r.Method == http.MethodPost:
+		// create and rotate both add a version
+		f.keys[name] = append(f.keys[name], newTestAEAD())
+		respond(http.StatusOK, nil)
+	case action == "keys":
+		if len(f.keys[name]) == 0 {
+			respond(http.StatusNotFound, nil)
+			return
+		}
+
+		respond(http.StatusOK, map[string]int{"latest_version": len(f.keys[name]), "min_decryption_version": 1})
+	case action == "encrypt":
+		plainText, err := base64.StdEncoding.DecodeString(body["plaintext"])
+		if err != nil || len(f.keys[name]) == 0 {
+			respond(http.StatusBadRequest, nil, "invalid request")
+			return
+		}
+
+		aead := f.keys[name][len(f.keys[name])-1]
+		nonce := make([]byte, aead.NonceSize())
+		rand.Read(nonce)
+
+		ciphertext := fmt.Sprintf("vault:v%d:%s", len(f.keys[name]),
+			base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plainText, nil)))
+		respond(http.StatusOK, map[string]any{"ciphertext": ciphertext, "key_version": len(f.keys[name])})
+	case action == "decrypt":
+		var version int
+		var encoded string
+		if _, err := fmt.Sscanf(strings.Replace(body["ciphertext"], ":", " ", 2), "vault v%d %s", &version, &encoded); err != nil ||
+			version < 1 || version > len(f.keys[name]) {
+			respond(http.StatusBadRequest, nil, "invalid ciphertext")
+			return
+		}
+
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		aead := f.keys[name][version-1]
+		if len(raw) < aead.NonceSize() {
+			respond(http.StatusBadRequest, nil, "invalid ciphertext")
+			return
+		}
+
+		plainText, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+		if err != nil {
+			respond(http.StatusBadRequest, nil, "cipher: message authentication failed")
+			return
+		}
+
+		respond(http.StatusOK, map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plainText)})
+	default:
+		respond(http.StatusNotFound, nil)
+	}
+}
+
+func newTestAEAD() cipher.AEAD {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	return aead
+}
+
+// vaultTestServer returns the address and token of a Vault with the transit engine enabled.
+// PM_TEST_VAULT_ADDR and PM_TEST_VAULT_TOKEN select a real "vault server -dev", a fake is used otherwise.
+func vaultTestServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	if addr := os.Getenv("PM_TEST_VAULT_ADDR"); addr != "" {
+		return addr, os.Getenv("PM_TEST_VAULT_TOKEN")
+	}
+
+	server := httptest.NewServer(&fakeTransit{token: "test-token", keys: make(map[string][]cipher.AEAD)})
+	t.Cleanup(server.Close)
+
+	return server.URL, "test-token"
+}
+
+func vaultTestCall(t *testing.T, addr, token, path string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, addr+path, bytes.NewReader(nil))
+	require.NoError(t, err)
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Less(t, resp.StatusCode, 300)
+}
+
+func TestVaultTransit(t *testing.T) {
+	addr, token := vaultTestServer(t)
+
+	key := fmt.Sprintf("pm-test-%d", os.Getpid())
+	vaultTestCall(t, addr, token, "/v1/transit/keys/"+key)
+
+	v, err := NewVaultTransit(VaultTransitConfig{Address: addr, Token: token, Key: key})
+	require.NoError(t, err)
+	require.Equal(t, "vault:v1", v.ActiveID())
+
+	encryptedOld, err := v.Encrypt("test input")
+	require.NoError(t, err)
+	require.True(t, IsVaultCiphertext(encryptedOld))
+	require.False(t, NeedsRotation(v, encryptedOld))
+
+	t.Run("success_rotation", func(t *testing.T) {
+		vaultTestCall(t, addr, token, "/v1/transit/keys/"+key+"/rotate")
+		require.NoError(t, v.Refresh())
+		require.Equal(t, "vault:v2", v.ActiveID())
+		require.Equal(t, []string{"vault:v1", "vault:v2"}, v.IDs())
+		require.True(t, NeedsRotation(v, encryptedOld))
+
+		encryptedNew, err := v.Encrypt("test input")
+		require.NoError(t, err)
+
+		id, err := KeyIDOf(encryptedNew)
+		require.NoError(t, err)
+		require.Equal(t, "vault:v2", id)
+
+		for _, encrypted := range []string{encryptedOld, encryptedNew} {
+			decrypted, err := v.Decrypt(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, "test input", decrypted)
+		}
+	})
+
+	t.Run("success_legacy", func(t *testing.T) {
+		legacy, err := Encrypt("test input", "1234567890123456")
+		require.NoError(t, err)
+
+		decrypted, err := WithLegacy(v, "1234567890123456").Decrypt(legacy)
+		require.NoError(t, err)
+		require.Equal(t, "test input", decrypted)
+	})
+
+	t.Run("error_tampered", func(t *testing.T) {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encryptedOld, "vault:v1:"))
+		require.NoError(t, err)
+		raw[len(raw)-1] ^= 1
+
+		_, err = v.Decrypt("vault:v1:" + base64.StdEncoding.EncodeToString(raw))
+		require.True(t, errors.Is(err, ErrIntegrity))
+	})
+
+	t.Run("error_wrong_token", func(t *testing.T) {
+		_, err := NewVaultTransit(VaultTransitConfig{Address: addr, Token: "wrong", Key: key})
+		require.Error(t, err)
+	})
+}
@@ -1,6 +1,10 @@
 package pmerror
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type PMError error
 
@@ -8,5 +12,23 @@ var (
 	ErrInvalidInput PMError = errors.New("invalid input")
 	ErrNotFound     PMError = errors.New("not found")
 	ErrForbidden    PMError = errors.New("forbidden")
+	ErrUnauthorized PMError = errors.New("unauthorized")
 	ErrInternal     PMError = errors.New("internal server error")
+	// ErrIntegrity means stored ciphertext does not belong where it was found: swapped, replayed or edited
+	ErrIntegrity PMError = errors.New("integrity check failed")
+	// ErrTooManyRequests is reported through RetryError, which tells when to try again
+	ErrTooManyRequests PMError = errors.New("too many requests")
 )
+
+// RetryError is ErrTooManyRequests for a client that may try again After
+type RetryError struct {
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyRequests, e.After)
+}
+
+func (e *RetryError) Is(target error) bool {
+	return target == ErrTooManyRequests
+}
@@ -0,0 +1,68 @@
+package pmjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func FuzzJWK(f *testing.F) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, MinRSABits)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, private := range []any{edKey, ecKey, rsaKey} {
+		key, err := NewKey("seed", private, nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		jwk := key.JWK()
+		f.Add(jwk.KeyType, jwk.Algorithm, jwk.Curve, jwk.X, jwk.Y, jwk.N, jwk.E)
+	}
+	f.Add("RSA", "RS256", "", "", "", "AQAB", "AA")
+	f.Add("EC", "ES256", "P-256", "AA", "AA", "", "")
+
+	f.Fuzz(func(t *testing.T, kty, alg, crv, x, y, n, e string) {
+		jwk := JWK{KeyType: kty, ID: "fuzz", Algorithm: alg, Curve: crv, X: x, Y: y, N: n, E: e}
+		key, err := jwk.Key()
+		if err != nil {
+			return
+		}
+
+		if key.CanSign() {
+			t.Fatal("a JWK has no private part")
+		}
+
+		if ec, ok := key.public.(*ecdsa.PublicKey); ok && !ec.Curve.IsOnCurve(ec.X, ec.Y) {
+			t.Fatal("accepted a point off the curve")
+		}
+
+		if public, ok := key.public.(*rsa.PublicKey); ok && (public.E < 3 || public.E%2 == 0) {
+			t.Fatalf("accepted exponent %d", public.E)
+		}
+
+		// what was accepted publishes as the same key
+		again, err := key.JWK().Key()
+		if err != nil {
+			t.Fatalf("published JWK does not parse: %s", err)
+		}
+
+		if again.Method != key.Method || !again.public.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.public) {
+			t.Fatal("published JWK is another key")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package pmjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public part of a key as described by RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set other services verify tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public part of every key of the set
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, id := range s.IDs() {
+		jwks.Keys = append(jwks.Keys, s.keys[id].JWK())
+	}
+
+	return jwks
+}
+
+func (k *Key) JWK() JWK {
+	jwk := JWK{ID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = encode(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType, jwk.Curve = "EC", public.Curve.Params().Name
+		jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+	}
+
+	return jwk
+}
+
+// Key parses the public key of a JWK published by another service, it only verifies tokens
+func (j JWK) Key() (*Key, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	var public crypto.PublicKey
+	switch j.KeyType {
+	case "OKP":
+		x, err := decode(j.X)
+		if err != nil || j.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %q: invalid Ed25519 key", j.ID)
+		}
+		public = ed25519.PublicKey(x)
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: decode modulus: %w", j.ID, err)
+		}
+		e, err := decode(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q: invalid exponent", j.ID)
+		}
+		// crypto/rsa only verifies with odd exponents that fit an int32, reject the rest up front
+		exponent := new(big.Int).SetBytes(e).Int64()
+		if exponent < 3 || exponent > 1<<31-1 || exponent%2 == 0 {
+			return nil, fmt.Errorf("key %q: invalid exponent", j.ID)
+		}
+		public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent)}
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("key %q: unsupported curve %q", j.ID, j.Curve)
+		}
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("key %q: invalid point", j.ID)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("key %q: point is not on %s", j.ID, j.Curve)
+		}
+		public = key
+	default:
+		return nil, fmt.Errorf("key %q: unsupported key type %q", j.ID, j.KeyType)
+	}
+
+	key, err := NewKey(j.ID, nil, public)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.Algorithm != "" && j.Algorithm != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q: algorithm %s is not supported for it", j.ID, j.Algorithm)
+	}
+
+	return key, nil
+}
+
+// KeySet builds a key set verifying tokens with the signing keys of the set.
+// Keys for encryption, without an ID or of unsupported types are skipped, a set may publish them for other clients.
+func (s JWKS) KeySet() (*KeySet, error) {
+	set := NewKeySet()
+	for _, jwk := range s.Keys {
+		if jwk.ID == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		key, err := jwk.Key()
+		if err != nil {
+			continue
+		}
+
+		if err := set.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+
+	return set, nil
+}
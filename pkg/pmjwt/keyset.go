@@ -0,0 +1,249 @@
+// Package pmjwt signs and verifies JSON Web Tokens with a rotatable set of asymmetric keys.
+// Tokens are parsed and their signatures checked by github.com/golang-jwt/jwt, this package only turns keys
+// into standard library public keys: PEM through crypto/x509 and JWKs of other services field by field,
+// which FuzzJWK checks on arbitrary input.
+package pmjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// MinRSABits is the smallest RSA modulus accepted for signing or verifying tokens
+const MinRSABits = 2048
+
+// Key signs and verifies tokens with the algorithm its type calls for:
+// EdDSA for Ed25519, RS256 for RSA and ES256, ES384 or ES512 for ECDSA on P-256, P-384 or P-521.
+// Keys without a private part only verify tokens.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// NewKey builds a key of id from a public key and, when it signs, its private key
+func NewKey(id string, private crypto.PrivateKey, public crypto.PublicKey) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("key ID is empty")
+	}
+
+	if private != nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %q: unsupported private key %T", id, private)
+		}
+		public = signer.Public()
+	}
+
+	method, err := methodOf(public)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+
+	return &Key{ID: id, Method: method, private: private, public: public}, nil
+}
+
+// ParseKey builds a key of id from a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key or a PKIX public key
+func ParseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM block", id)
+	}
+
+	var private crypto.PrivateKey
+	var public crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %q: unsupported PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+
+	return NewKey(id, private, public)
+}
+
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+func methodOf(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public := public.(type) {
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	case *rsa.PublicKey:
+		if public.N.BitLen() < MinRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits is shorter than %d", public.N.BitLen(), MinRSABits)
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", public.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", public)
+	}
+}
+
+// KeySet holds the keys tokens are signed with. The active key signs new tokens, every key verifies them,
+// so that a new key can be published before it becomes active and an old one kept until its tokens expire.
+// A key set is built once at startup, it is not safe to add keys while it is in use.
+type KeySet struct {
+	keys   map[string]*Key
+	active string
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: make(map[string]*Key)}
+}
+
+// ReadKeySet builds a key set from comma separated "id:path" pairs of PEM files, so that key material stays out of the environment
+func ReadKeySet(keys, active string) (*KeySet, error) {
+	s := NewKeySet()
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, path, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key entry %q: expected id:path", pair)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read key %q: %w", id, err)
+		}
+
+		key, err := ParseKey(id, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.SetActive(active); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *KeySet) Add(key *Key) error {
+	if _, ok := s.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate key %q", key.ID)
+	}
+
+	s.keys[key.ID] = key
+
+	return nil
+}
+
+// SetActive selects the key signing new tokens, it has to have a private part
+func (s *KeySet) SetActive(id string) error {
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("unknown active key %q", id)
+	}
+
+	if !key.CanSign() {
+		return fmt.Errorf("active key %q has no private key", id)
+	}
+
+	s.active = id
+
+	return nil
+}
+
+// IDs returns the key IDs in a stable order
+func (s *KeySet) IDs() []string {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Sign signs claims with the active key and names it in the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, ok := s.keys[s.active]
+	if !ok {
+		return "", errors.New("no active key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token with the key its kid header names, the algorithm has to be the one of that key
+func (s *KeySet) Parse(token string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.Parse(token, s.keyfunc, append([]jwt.ParserOption{jwt.WithValidMethods(s.methods())}, options...)...)
+}
+
+func (s *KeySet) keyfunc(t *jwt.Token) (any, error) {
+	id, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("no kid header")
+	}
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", id, t.Method.Alg())
+	}
+
+	return key.public, nil
+}
+
+func (s *KeySet) methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, key := range s.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+
+	return methods
+}
@@ -0,0 +1,186 @@
+package pmjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T, id string, private any) *Key {
+	t.Helper()
+
+	key, err := NewKey(id, private, nil)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestKeySet(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, MinRSABits)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{"user_id": "test"}
+
+	t.Run("success_algorithms", func(t *testing.T) {
+		for _, tc := range []struct {
+			private any
+			alg     string
+			kty     string
+		}{
+			{edKey, "EdDSA", "OKP"},
+			{rsaKey, "RS256", "RSA"},
+			{ecKey, "ES256", "EC"},
+		} {
+			s := NewKeySet()
+			require.NoError(t, s.Add(newTestKey(t, "k1", tc.private)))
+			require.NoError(t, s.SetActive("k1"))
+
+			signed, err := s.Sign(claims)
+			require.NoError(t, err)
+
+			token, err := s.Parse(signed)
+			require.NoError(t, err)
+			require.Equal(t, tc.alg, token.Method.Alg())
+			require.Equal(t, "k1", token.Header["kid"])
+			require.Equal(t, "test", token.Claims.(jwt.MapClaims)["user_id"])
+
+			jwks := s.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			require.Equal(t, tc.kty, jwks.Keys[0].KeyType)
+			require.Equal(t, tc.alg, jwks.Keys[0].Algorithm)
+
+			// other services verify with the published set alone
+			published, err := jwks.KeySet()
+			require.NoError(t, err)
+			_, err = published.Parse(signed)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("success_rotation", func(t *testing.T) {
+		s := NewKeySet()
+		require.NoError(t, s.Add(newTestKey(t, "k1", rsaKey)))
+		require.NoError(t, s.SetActive("k1"))
+
+		signedOld, err := s.Sign(claims)
+		require.NoError(t, err)
+
+		require.NoError(t, s.Add(newTestKey(t, "k2", edKey)))
+		require.NoError(t, s.SetActive("k2"))
+
+		signedNew, err := s.Sign(claims)
+		require.NoError(t, err)
+
+		for _, signed := range []string{signedOld, signedNew} {
+			_, err := s.Parse(signed)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, []string{"k1", "k2"}, s.IDs())
+	})
+
+	t.Run("success_verification_only", func(t *testing.T) {
+		signing := NewKeySet()
+		require.NoError(t, signing.Add(newTestKey(t, "k1", ecKey)))
+		require.NoError(t, signing.SetActive("k1"))
+
+		signed, err := signing.Sign(claims)
+		require.NoError(t, err)
+
+		public, err := NewKey("k1", nil, ecKey.Public())
+		require.NoError(t, err)
+
+		s := NewKeySet()
+		require.NoError(t, s.Add(public))
+		require.Error(t, s.SetActive("k1"))
+
+		_, err = s.Parse(signed)
+		require.NoError(t, err)
+	})
+
+	t.Run("error_unknown_kid", func(t *testing.T) {
+		other := NewKeySet()
+		require.NoError(t, other.Add(newTestKey(t, "k2", edKey)))
+		require.NoError(t, other.SetActive("k2"))
+
+		signed, err := other.Sign(claims)
+		require.NoError(t, err)
+
+		s := NewKeySet()
+		require.NoError(t, s.Add(newTestKey(t, "k1", edKey)))
+		require.NoError(t, s.SetActive("k1"))
+
+		_, err = s.Parse(signed)
+		require.Error(t, err)
+	})
+
+	t.Run("error_hmac", func(t *testing.T) {
+		s := NewKeySet()
+		require.NoError(t, s.Add(newTestKey(t, "k1", edKey)))
+		require.NoError(t, s.SetActive("k1"))
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString([]byte("secret"))
+		require.NoError(t, err)
+
+		_, err = s.Parse(signed)
+		require.Error(t, err)
+	})
+
+	t.Run("error_short_rsa_key", func(t *testing.T) {
+		short, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+
+		_, err = NewKey("k1", short, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestReadKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+		return path
+	}
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	require.NoError(t, err)
+
+	edPath := write("ed.pem", "PRIVATE KEY", edDER)
+	ecPath := write("ec.pem", "PUBLIC KEY", ecDER)
+
+	s, err := ReadKeySet(fmt.Sprintf("new:%s, old:%s", edPath, ecPath), "new")
+	require.NoError(t, err)
+	require.Equal(t, []string{"new", "old"}, s.IDs())
+
+	_, err = ReadKeySet(fmt.Sprintf("new:%s,old:%s", edPath, ecPath), "old")
+	require.Error(t, err)
+
+	_, err = ReadKeySet(edPath, "new")
+	require.Error(t, err)
+}
@@ -0,0 +1,300 @@
+package pmldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/internal/ber"
+)
+
+// maxMessageSize bounds a single response, directory entries are small
+const maxMessageSize = 4 << 20
+
+// Search scopes, RFC 4511 4.5.1.2
+const (
+	ScopeBase    = 0
+	ScopeOne     = 1
+	ScopeSubtree = 2
+	derefNever   = 0
+	noAttributes = "1.1"
+)
+
+// ResultError is a result code other than success the server responded with
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap result %d: %s", e.Code, e.Message)
+}
+
+type entry struct {
+	DN string
+	// Attributes are keyed by lowercase name, names are case insensitive
+	Attributes map[string][]string
+}
+
+func (e *entry) first(name string) string {
+	if values := e.Attributes[strings.ToLower(name)]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// conn runs one operation at a time, the directory connects for each call
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	id      int64
+	timeout time.Duration
+}
+
+func dial(config *Config) (*conn, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.TLS != nil {
+		tlsConfig = config.TLS.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+
+	var nc net.Conn
+	if u.Scheme == "ldaps" {
+		nc, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc), timeout: config.Timeout}
+	if config.StartTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(tlsConfig); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *conn) startTLS(config *tls.Config) error {
+	op := ber.Seq(ber.TagExtendedRequest, ber.String(ber.TagExtendedName, ber.OIDStartTLS))
+	resp, err := c.roundTrip(op, ber.TagExtendedResponse)
+	if err != nil {
+		return fmt.Errorf("start TLS: %w", err)
+	}
+
+	if err := parseResult(resp); err != nil {
+		return fmt.Errorf("start TLS: %w", err)
+	}
+
+	tlsConn := tls.Client(c.nc, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("start TLS: handshake: %w", err)
+	}
+
+	c.nc, c.r = tlsConn, bufio.NewReader(tlsConn)
+
+	return nil
+}
+
+// bind authenticates with a simple bind, an empty dn and password bind anonymously
+func (c *conn) bind(dn, password string) error {
+	op := ber.Seq(ber.TagBindRequest,
+		ber.Int(ber.TagInteger, 3),
+		ber.String(ber.TagOctetString, dn),
+		ber.String(ber.TagSimpleAuth, password))
+
+	resp, err := c.roundTrip(op, ber.TagBindResponse)
+	if err != nil {
+		return fmt.Errorf("bind: %w", err)
+	}
+
+	return parseResult(resp)
+}
+
+func (c *conn) search(baseDN string, scope int64, filter string, attributes []string) ([]entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := ber.Seq(ber.TagSequence)
+	for _, attr := range attributes {
+		attrs.Children = append(attrs.Children, ber.String(ber.TagOctetString, attr))
+	}
+
+	op := ber.Seq(ber.TagSearchRequest,
+		ber.String(ber.TagOctetString, baseDN),
+		ber.Int(ber.TagEnumerated, scope),
+		ber.Int(ber.TagEnumerated, derefNever),
+		ber.Int(ber.TagInteger, 0),
+		ber.Int(ber.TagInteger, int64(c.timeout/time.Second)),
+		ber.Bool(false),
+		compiled,
+		attrs)
+
+	id, err := c.send(op)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	var entries []entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, fmt.Errorf("search: %w", err)
+		}
+
+		switch resp.Tag {
+		case ber.TagSearchEntry:
+			e, err := parseEntry(resp)
+			if err != nil {
+				return nil, fmt.Errorf("search: %w", err)
+			}
+
+			entries = append(entries, e)
+		case ber.TagSearchReference:
+			// referrals to other servers are not followed
+		case ber.TagSearchDone:
+			if err := parseResult(resp); err != nil {
+				return nil, err
+			}
+
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("search: unexpected response 0x%02x", resp.Tag)
+		}
+	}
+}
+
+func (c *conn) close() {
+	_ = c.nc.SetDeadline(time.Now().Add(c.timeout))
+	_, _ = c.send(ber.Element{Tag: ber.TagUnbindRequest})
+	c.nc.Close()
+}
+
+func (c *conn) roundTrip(op ber.Element, expected byte) (ber.Element, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return ber.Element{}, err
+	}
+
+	resp, err := c.receive(id)
+	if err != nil {
+		return ber.Element{}, err
+	}
+
+	if resp.Tag != expected {
+		return ber.Element{}, fmt.Errorf("unexpected response 0x%02x", resp.Tag)
+	}
+
+	return resp, nil
+}
+
+func (c *conn) send(op ber.Element) (int64, error) {
+	c.id++
+	if err := c.nc.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, fmt.Errorf("set deadline: %w", err)
+	}
+
+	if _, err := c.nc.Write(ber.Message(c.id, op).Encode()); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+
+	return c.id, nil
+}
+
+// receive returns the protocol operation of the next response to message id
+func (c *conn) receive(id int64) (ber.Element, error) {
+	for {
+		msg, err := ber.Read(c.r, maxMessageSize)
+		if err != nil {
+			return ber.Element{}, fmt.Errorf("read: %w", err)
+		}
+
+		if msg.Tag != ber.TagSequence || len(msg.Children) < 2 {
+			return ber.Element{}, errors.New("malformed message")
+		}
+
+		got, err := msg.Children[0].Int()
+		if err != nil {
+			return ber.Element{}, fmt.Errorf("message ID: %w", err)
+		}
+
+		// message ID 0 is an unsolicited notification, the server is about to close the connection
+		if got == 0 {
+			if err := parseResult(msg.Children[1]); err != nil {
+				return ber.Element{}, fmt.Errorf("disconnected: %w", err)
+			}
+			return ber.Element{}, errors.New("disconnected")
+		}
+
+		if got == id {
+			return msg.Children[1], nil
+		}
+	}
+}
+
+func parseResult(op ber.Element) error {
+	if len(op.Children) < 3 {
+		return errors.New("malformed result")
+	}
+
+	code, err := op.Children[0].Int()
+	if err != nil {
+		return fmt.Errorf("result code: %w", err)
+	}
+
+	if code != ber.ResultSuccess {
+		return &ResultError{Code: code, Message: op.Children[2].Text()}
+	}
+
+	return nil
+}
+
+func parseEntry(op ber.Element) (entry, error) {
+	if len(op.Children) != 2 || op.Children[1].Tag != ber.TagSequence {
+		return entry{}, errors.New("malformed entry")
+	}
+
+	e := entry{DN: op.Children[0].Text(), Attributes: make(map[string][]string)}
+	for _, attr := range op.Children[1].Children {
+		if len(attr.Children) != 2 || attr.Children[1].Tag != ber.TagSet {
+			return entry{}, errors.New("malformed attribute")
+		}
+
+		name := strings.ToLower(attr.Children[0].Text())
+		for _, value := range attr.Children[1].Children {
+			e.Attributes[name] = append(e.Attributes[name], value.Text())
+		}
+	}
+
+	return e, nil
+}
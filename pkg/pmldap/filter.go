@@ -0,0 +1,204 @@
+package pmldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/internal/ber"
+)
+
+// EscapeFilter escapes a value for use in a search filter, RFC 4515 3
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// compileFilter encodes the string representation of a search filter, RFC 4515
+func compileFilter(filter string) (ber.Element, error) {
+	e, rest, err := parseFilter(strings.TrimSpace(filter), 0)
+	if err != nil {
+		return ber.Element{}, fmt.Errorf("filter %q: %w", filter, err)
+	}
+
+	if rest != "" {
+		return ber.Element{}, fmt.Errorf("filter %q: trailing %q", filter, rest)
+	}
+
+	return e, nil
+}
+
+func parseFilter(s string, depth int) (ber.Element, string, error) {
+	if depth > 16 {
+		return ber.Element{}, "", errors.New("nested too deeply")
+	}
+
+	if !strings.HasPrefix(s, "(") {
+		return ber.Element{}, "", errors.New("expected (")
+	}
+	s = s[1:]
+
+	if s == "" {
+		return ber.Element{}, "", errors.New("unexpected end")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := ber.TagFilterAnd
+		if s[0] == '|' {
+			tag = ber.TagFilterOr
+		}
+
+		s = s[1:]
+		set := ber.Seq(tag)
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s, depth+1)
+			if err != nil {
+				return ber.Element{}, "", err
+			}
+
+			set.Children = append(set.Children, child)
+			s = rest
+		}
+
+		if len(set.Children) == 0 {
+			return ber.Element{}, "", errors.New("empty filter set")
+		}
+
+		return closeFilter(set, s)
+	case '!':
+		child, rest, err := parseFilter(s[1:], depth+1)
+		if err != nil {
+			return ber.Element{}, "", err
+		}
+
+		return closeFilter(ber.Seq(ber.TagFilterNot, child), rest)
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return ber.Element{}, "", errors.New("expected )")
+	}
+
+	item, err := parseItem(s[:end])
+	if err != nil {
+		return ber.Element{}, "", err
+	}
+
+	return item, s[end+1:], nil
+}
+
+func closeFilter(e ber.Element, s string) (ber.Element, string, error) {
+	if !strings.HasPrefix(s, ")") {
+		return ber.Element{}, "", errors.New("expected )")
+	}
+
+	return e, s[1:], nil
+}
+
+func parseItem(item string) (ber.Element, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return ber.Element{}, errors.New("expected attribute=value")
+	}
+
+	attr, raw := item[:eq], item[eq+1:]
+
+	tag := ber.TagFilterEquality
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = ber.TagFilterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = ber.TagFilterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = ber.TagFilterApprox, attr[:len(attr)-1]
+	}
+
+	if attr == "" || strings.ContainsAny(attr, "()*\\ ") {
+		return ber.Element{}, fmt.Errorf("invalid attribute %q", attr)
+	}
+
+	if tag == ber.TagFilterEquality && raw == "*" {
+		return ber.String(ber.TagFilterPresent, attr), nil
+	}
+
+	// unescaped asterisks separate substrings, escaped ones are part of a value
+	parts := strings.Split(raw, "*")
+	values := make([]string, len(parts))
+	for i, part := range parts {
+		value, err := unescapeFilter(part)
+		if err != nil {
+			return ber.Element{}, err
+		}
+
+		values[i] = value
+	}
+
+	if len(values) == 1 {
+		return ber.Seq(tag, ber.String(ber.TagOctetString, attr), ber.String(ber.TagOctetString, values[0])), nil
+	}
+
+	if tag != ber.TagFilterEquality {
+		return ber.Element{}, errors.New("substrings only match with =")
+	}
+
+	substrings := ber.Seq(ber.TagSequence)
+	for i, value := range values {
+		if value == "" {
+			if i == 0 || i == len(values)-1 {
+				continue
+			}
+
+			return ber.Element{}, errors.New("empty substring")
+		}
+
+		kind := ber.TagSubstringAny
+		if i == 0 {
+			kind = ber.TagSubstringInitial
+		} else if i == len(values)-1 {
+			kind = ber.TagSubstringFinal
+		}
+
+		substrings.Children = append(substrings.Children, ber.String(kind, value))
+	}
+
+	return ber.Seq(ber.TagFilterSubstrings, ber.String(ber.TagOctetString, attr), substrings), nil
+}
+
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+3 > len(s) {
+			return "", errors.New("truncated escape")
+		}
+
+		decoded, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape %q", s[i:i+3])
+		}
+
+		b.Write(decoded)
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,274 @@
+// Package ber encodes and decodes the subset of ASN.1 BER that LDAPv3 uses: definite lengths and
+// low tag numbers. It is shared by the client and the test server.
+//
+// encoding/asn1 only speaks DER and the maintained LDAP clients would add a dependency for the three operations
+// pmldap sends, so the decoder is kept here. It bounds nesting and never allocates more than maxSize or its input,
+// FuzzDecode and FuzzRead check it on arbitrary input.
+package ber
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Class and form bits of an identifier octet
+const (
+	ClassUniversal   byte = 0x00
+	ClassApplication byte = 0x40
+	ClassContext     byte = 0x80
+	Constructed      byte = 0x20
+)
+
+// Universal tags
+const (
+	TagBoolean     byte = 0x01
+	TagInteger     byte = 0x02
+	TagOctetString byte = 0x04
+	TagNull        byte = 0x05
+	TagEnumerated  byte = 0x0a
+	TagSequence    byte = 0x30
+	TagSet         byte = 0x31
+)
+
+// maxDepth bounds nesting, LDAP messages with filters rarely nest more than a few levels
+const maxDepth = 32
+
+var ErrTruncated = errors.New("ber: truncated input")
+
+// Element is a decoded TLV. Primitive elements hold Value, constructed ones Children.
+type Element struct {
+	Tag      byte
+	Value    []byte
+	Children []Element
+}
+
+func (e Element) Constructed() bool {
+	return e.Tag&Constructed != 0
+}
+
+func String(tag byte, s string) Element {
+	return Element{Tag: tag, Value: []byte(s)}
+}
+
+func Int(tag byte, n int64) Element {
+	// minimal two's complement, big endian
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return Element{Tag: tag, Value: b}
+}
+
+func Bool(b bool) Element {
+	if b {
+		return Element{Tag: TagBoolean, Value: []byte{0xff}}
+	}
+
+	return Element{Tag: TagBoolean, Value: []byte{0x00}}
+}
+
+// Seq returns a constructed element, tag has to carry the Constructed bit
+func Seq(tag byte, children ...Element) Element {
+	return Element{Tag: tag, Children: children}
+}
+
+// Text returns the value of a primitive element as a string
+func (e Element) Text() string {
+	return string(e.Value)
+}
+
+func (e Element) Int() (int64, error) {
+	if e.Constructed() || len(e.Value) == 0 || len(e.Value) > 8 {
+		return 0, fmt.Errorf("ber: invalid integer of %d bytes", len(e.Value))
+	}
+
+	n := int64(int8(e.Value[0]))
+	for _, b := range e.Value[1:] {
+		n = n<<8 | int64(b)
+	}
+
+	return n, nil
+}
+
+func (e Element) Bool() (bool, error) {
+	if e.Constructed() || len(e.Value) != 1 {
+		return false, errors.New("ber: invalid boolean")
+	}
+
+	return e.Value[0] != 0, nil
+}
+
+func (e Element) Encode() []byte {
+	content := e.Value
+	if e.Constructed() {
+		content = nil
+		for _, child := range e.Children {
+			content = append(content, child.Encode()...)
+		}
+	}
+
+	out := []byte{e.Tag}
+	out = append(out, encodeLength(len(content))...)
+
+	return append(out, content...)
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// Read reads one element from r, elements larger than maxSize are rejected before they are buffered
+func Read(r *bufio.Reader, maxSize int) (Element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return Element{}, err
+	}
+
+	length, err := readLength(r)
+	if err != nil {
+		return Element{}, err
+	}
+
+	// four length bytes overflow an int on 32-bit platforms
+	if length < 0 || length > maxSize {
+		return Element{}, fmt.Errorf("ber: element of %d bytes exceeds %d", length, maxSize)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Element{}, ErrTruncated
+		}
+		return Element{}, err
+	}
+
+	return decodeContent(tag, content, 0)
+}
+
+// Decode decodes data holding exactly one element
+func Decode(data []byte) (Element, error) {
+	e, n, err := decode(data, 0)
+	if err != nil {
+		return Element{}, err
+	}
+
+	if n != len(data) {
+		return Element{}, errors.New("ber: trailing data")
+	}
+
+	return e, nil
+}
+
+func decode(data []byte, depth int) (Element, int, error) {
+	if len(data) < 2 {
+		return Element{}, 0, ErrTruncated
+	}
+
+	tag := data[0]
+	if tag&0x1f == 0x1f {
+		return Element{}, 0, errors.New("ber: high tag numbers are not supported")
+	}
+
+	length, n := int(data[1]), 2
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 {
+			return Element{}, 0, errors.New("ber: indefinite lengths are not allowed")
+		}
+
+		if size > 4 || len(data) < 2+size {
+			return Element{}, 0, ErrTruncated
+		}
+
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		n += size
+	}
+
+	if length < 0 || len(data)-n < length {
+		return Element{}, 0, ErrTruncated
+	}
+
+	e, err := decodeContent(tag, data[n:n+length], depth)
+	if err != nil {
+		return Element{}, 0, err
+	}
+
+	return e, n + length, nil
+}
+
+func decodeContent(tag byte, content []byte, depth int) (Element, error) {
+	if tag&0x1f == 0x1f {
+		return Element{}, errors.New("ber: high tag numbers are not supported")
+	}
+
+	e := Element{Tag: tag}
+	if !e.Constructed() {
+		e.Value = content
+		return e, nil
+	}
+
+	if depth >= maxDepth {
+		return Element{}, errors.New("ber: nested too deeply")
+	}
+
+	e.Children = []Element{}
+	for len(content) > 0 {
+		child, n, err := decode(content, depth+1)
+		if err != nil {
+			return Element{}, err
+		}
+
+		e.Children = append(e.Children, child)
+		content = content[n:]
+	}
+
+	return e, nil
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, ErrTruncated
+	}
+
+	if first&0x80 == 0 {
+		return int(first), nil
+	}
+
+	size := int(first & 0x7f)
+	if size == 0 {
+		return 0, errors.New("ber: indefinite lengths are not allowed")
+	}
+
+	if size > 4 {
+		return 0, fmt.Errorf("ber: length of %d bytes is not supported", size)
+	}
+
+	length := 0
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, ErrTruncated
+		}
+
+		length = length<<8 | int(b)
+	}
+
+	return length, nil
+}
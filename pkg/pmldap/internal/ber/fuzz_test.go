@@ -0,0 +1,71 @@
+package ber
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func fuzzSeeds(f *testing.F) {
+	bind := Message(1, Seq(TagBindRequest, Int(TagInteger, 3), String(TagOctetString, "cn=admin,dc=example,dc=org"),
+		String(ClassContext|0, "secret")))
+	f.Add(bind.Encode())
+	f.Add(Message(2, Result(TagBindResponse, ResultInvalidCredentials, "", "invalid credentials")).Encode())
+	f.Add(Message(3, Seq(TagSearchEntry, String(TagOctetString, "uid=alice,dc=example,dc=org"),
+		Seq(TagSequence, Seq(TagSequence, String(TagOctetString, "uid"), Seq(TagSet, String(TagOctetString, "alice")))))).Encode())
+	f.Add([]byte{TagSequence, 0x84, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{TagSequence, 0x80})
+	f.Add([]byte{TagOctetString, 0x81, 0x01, 'a'})
+	f.Add(bytes.Repeat([]byte{TagSequence, 0x02}, maxDepth+1))
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := Decode(data)
+		if err != nil {
+			return
+		}
+
+		// lengths may be encoded longer than needed, the element has to survive a minimal encoding
+		again, err := Decode(e.Encode())
+		if err != nil {
+			t.Fatalf("encoded element does not decode: %s", err)
+		}
+
+		if !reflect.DeepEqual(e, again) {
+			t.Fatal("encoded element decodes differently")
+		}
+
+		walk(e)
+	})
+}
+
+func FuzzRead(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := Read(bufio.NewReader(bytes.NewReader(data)), 64)
+		if err != nil {
+			return
+		}
+
+		if len(e.Value) > 64 {
+			t.Fatalf("read an element of %d bytes past the limit", len(e.Value))
+		}
+
+		walk(e)
+	})
+}
+
+// walk reads every element the way the LDAP client does, none of which may panic
+func walk(e Element) {
+	_, _ = e.Int()
+	_, _ = e.Bool()
+	_ = e.Text()
+	for _, child := range e.Children {
+		walk(child)
+	}
+}
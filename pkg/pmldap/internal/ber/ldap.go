@@ -0,0 +1,59 @@
+package ber
+
+// Identifier octets of the LDAPv3 protocol operations, RFC 4511
+const (
+	TagBindRequest      = ClassApplication | Constructed | 0
+	TagBindResponse     = ClassApplication | Constructed | 1
+	TagUnbindRequest    = ClassApplication | 2
+	TagSearchRequest    = ClassApplication | Constructed | 3
+	TagSearchEntry      = ClassApplication | Constructed | 4
+	TagSearchDone       = ClassApplication | Constructed | 5
+	TagSearchReference  = ClassApplication | Constructed | 19
+	TagExtendedRequest  = ClassApplication | Constructed | 23
+	TagExtendedResponse = ClassApplication | Constructed | 24
+
+	// TagSimpleAuth holds the password of a simple bind
+	TagSimpleAuth = ClassContext | 0
+	// TagExtendedName holds the OID of an extended request
+	TagExtendedName = ClassContext | 0
+)
+
+// Filter choices of a search request
+const (
+	TagFilterAnd            = ClassContext | Constructed | 0
+	TagFilterOr             = ClassContext | Constructed | 1
+	TagFilterNot            = ClassContext | Constructed | 2
+	TagFilterEquality       = ClassContext | Constructed | 3
+	TagFilterSubstrings     = ClassContext | Constructed | 4
+	TagFilterGreaterOrEqual = ClassContext | Constructed | 5
+	TagFilterLessOrEqual    = ClassContext | Constructed | 6
+	TagFilterPresent        = ClassContext | 7
+	TagFilterApprox         = ClassContext | Constructed | 8
+
+	TagSubstringInitial = ClassContext | 0
+	TagSubstringAny     = ClassContext | 1
+	TagSubstringFinal   = ClassContext | 2
+)
+
+// Result codes the client and the test server tell apart
+const (
+	ResultSuccess            = 0
+	ResultOperationsError    = 1
+	ResultProtocolError      = 2
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+	ResultUnwillingToPerform = 53
+)
+
+// OIDStartTLS names the StartTLS extended operation, RFC 4511 4.14
+const OIDStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// Message wraps a protocol operation with its message ID
+func Message(id int64, op Element) Element {
+	return Seq(TagSequence, Int(TagInteger, id), op)
+}
+
+// Result encodes an LDAPResult as the protocol operation tag
+func Result(tag byte, code int64, matchedDN, message string) Element {
+	return Seq(tag, Int(TagEnumerated, code), String(TagOctetString, matchedDN), String(TagOctetString, message))
+}
@@ -0,0 +1,324 @@
+// Package pmldap authenticates users by binding to an LDAP directory and lists the members of the groups
+// allowed to sign in. It implements the subset of LDAPv3 it needs: simple binds, searches and StartTLS.
+package pmldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/internal/ber"
+)
+
+const defaultTimeout = 10 * time.Second
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrNotMember is returned for users that authenticated but are in none of the groups
+	ErrNotMember = errors.New("not a member of the allowed groups")
+)
+
+type Config struct {
+	// URL is ldap://host[:port] or ldaps://host[:port]
+	URL      string
+	StartTLS bool
+	TLS      *tls.Config
+
+	// BindDN and BindPassword are the account searching the directory, empty searches anonymously
+	BindDN       string
+	BindPassword string
+
+	UserBaseDN string
+	// UserFilter finds a user by name, %s is replaced with the escaped name, "(uid=%s)" by default
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	NameAttribute     string
+
+	GroupBaseDN string
+	// GroupFilter selects the groups whose members may sign in, such as "(cn=vault-users)"
+	GroupFilter string
+	// MemberAttribute of groups lists their members, as DNs unless it is memberUid
+	MemberAttribute string
+
+	Timeout time.Duration
+}
+
+// User is a directory entry of a member of the allowed groups
+type User struct {
+	DN       string
+	Username string
+	Email    string
+	Name     string
+}
+
+// Directory connects for every call, so that a restarted server or expired connection needs no handling
+type Directory struct {
+	config Config
+}
+
+func NewDirectory(config Config) (*Directory, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	if config.UserBaseDN == "" {
+		return nil, errors.New("user base DN is empty")
+	}
+
+	if config.GroupFilter == "" {
+		return nil, errors.New("group filter is empty")
+	}
+
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		return nil, errors.New("user filter must contain %s once")
+	}
+
+	if _, err := compileFilter(strings.Replace(config.UserFilter, "%s", "x", 1)); err != nil {
+		return nil, fmt.Errorf("user filter: %w", err)
+	}
+
+	if _, err := compileFilter(config.GroupFilter); err != nil {
+		return nil, fmt.Errorf("group filter: %w", err)
+	}
+
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.UserBaseDN
+	}
+
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = "uid"
+	}
+
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+
+	if config.NameAttribute == "" {
+		config.NameAttribute = "cn"
+	}
+
+	if config.MemberAttribute == "" {
+		config.MemberAttribute = "member"
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return &Directory{config: config}, nil
+}
+
+// Authenticate binds as the user named username with password and checks that it is a member of the groups
+func (d *Directory) Authenticate(username, password string) (*User, error) {
+	// a simple bind with an empty password is an unauthenticated bind, servers accept it for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	user, err := d.findUser(c, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var result *ResultError
+	if err := c.bind(user.DN, password); errors.As(err, &result) && result.Code == ber.ResultInvalidCredentials {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("bind as user: %w", err)
+	}
+
+	// groups are read as the search account again, users may not be allowed to read them
+	if err := c.bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+
+	value := user.DN
+	if d.memberUID() {
+		value = user.Username
+	}
+
+	filter := fmt.Sprintf("(&%s(%s=%s))", d.config.GroupFilter, d.config.MemberAttribute, EscapeFilter(value))
+	groups, err := c.search(d.config.GroupBaseDN, ScopeSubtree, filter, []string{noAttributes})
+	if err != nil {
+		return nil, fmt.Errorf("search groups: %w", err)
+	}
+
+	if len(groups) == 0 {
+		return nil, ErrNotMember
+	}
+
+	return user, nil
+}
+
+// Members returns the users that are members of the groups, members outside the user base DN are left out
+func (d *Directory) Members() ([]User, error) {
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	groups, err := c.search(d.config.GroupBaseDN, ScopeSubtree, d.config.GroupFilter, []string{d.config.MemberAttribute})
+	if err != nil {
+		return nil, fmt.Errorf("search groups: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var users []User
+	for _, group := range groups {
+		for _, member := range group.Attributes[strings.ToLower(d.config.MemberAttribute)] {
+			var user *User
+			if d.memberUID() {
+				user, err = d.findUser(c, member)
+			} else {
+				user, err = d.readUser(c, member)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if user == nil || seen[NormalizeDN(user.DN)] {
+				continue
+			}
+
+			seen[NormalizeDN(user.DN)] = true
+			users = append(users, *user)
+		}
+	}
+
+	return users, nil
+}
+
+func (d *Directory) connect() (*conn, error) {
+	c, err := dial(&d.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.config.BindDN != "" {
+		if err := c.bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			c.close()
+			return nil, fmt.Errorf("bind: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// findUser returns nil when no user or several users are named username
+func (d *Directory) findUser(c *conn, username string) (*User, error) {
+	filter := strings.Replace(d.config.UserFilter, "%s", EscapeFilter(username), 1)
+	entries, err := c.search(d.config.UserBaseDN, ScopeSubtree, filter, d.attributes())
+	if err != nil {
+		return nil, fmt.Errorf("search user: %w", err)
+	}
+
+	if len(entries) != 1 {
+		return nil, nil
+	}
+
+	return d.user(&entries[0]), nil
+}
+
+// readUser returns nil for DNs that do not exist or are not users
+func (d *Directory) readUser(c *conn, dn string) (*User, error) {
+	if !strings.HasSuffix(NormalizeDN(dn), ","+NormalizeDN(d.config.UserBaseDN)) {
+		return nil, nil
+	}
+
+	entries, err := c.search(dn, ScopeBase, "(objectClass=*)", d.attributes())
+	var result *ResultError
+	if errors.As(err, &result) && result.Code == ber.ResultNoSuchObject {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read user: %w", err)
+	}
+
+	if len(entries) != 1 {
+		return nil, nil
+	}
+
+	return d.user(&entries[0]), nil
+}
+
+// user returns nil for entries without a user name, such as nested groups
+func (d *Directory) user(e *entry) *User {
+	username := e.first(d.config.UsernameAttribute)
+	if username == "" {
+		return nil
+	}
+
+	return &User{
+		DN:       e.DN,
+		Username: username,
+		Email:    e.first(d.config.EmailAttribute),
+		Name:     e.first(d.config.NameAttribute),
+	}
+}
+
+func (d *Directory) attributes() []string {
+	return []string{d.config.UsernameAttribute, d.config.EmailAttribute, d.config.NameAttribute}
+}
+
+func (d *Directory) memberUID() bool {
+	return strings.EqualFold(d.config.MemberAttribute, "memberUid")
+}
+
+// NormalizeDN lowercases dn and drops spaces around separators, so that DNs the directory spells
+// differently compare equal
+func NormalizeDN(dn string) string {
+	out := make([]byte, 0, len(dn))
+	// escaped characters are never trimmed
+	kept := 0
+	for i := 0; i < len(dn); i++ {
+		switch c := dn[i]; c {
+		case '\\':
+			out = append(out, c)
+			if i+1 < len(dn) {
+				out = append(out, dn[i+1])
+				i++
+			}
+			kept = len(out)
+		case ',', '=', '+':
+			for len(out) > kept && out[len(out)-1] == ' ' {
+				out = out[:len(out)-1]
+			}
+			out = append(out, c)
+			for i+1 < len(dn) && dn[i+1] == ' ' {
+				i++
+			}
+			kept = len(out)
+		default:
+			out = append(out, c)
+		}
+	}
+
+	for len(out) > kept && out[len(out)-1] == ' ' {
+		out = out[:len(out)-1]
+	}
+
+	return strings.ToLower(strings.TrimLeft(string(out), " "))
+}
@@ -0,0 +1,136 @@
+package pmldap_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/ldaptest"
+)
+
+const (
+	testPeopleDN = "ou=people,dc=example,dc=org"
+	testGroupsDN = "ou=groups,dc=example,dc=org"
+	testBindDN   = "cn=search,dc=example,dc=org"
+)
+
+// newTestServer serves alice and bob, only alice is in the vault group
+func newTestServer(t *testing.T) *ldaptest.Server {
+	t.Helper()
+
+	server, err := ldaptest.New()
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	server.Add(testBindDN, map[string][]string{"cn": {"search"}, "userPassword": {"search secret"}})
+	server.Add("uid=alice,"+testPeopleDN, map[string][]string{
+		"objectClass":  {"inetOrgPerson"},
+		"uid":          {"alice"},
+		"cn":           {"Alice Liddell"},
+		"mail":         {"alice@example.org"},
+		"userPassword": {"alice secret"},
+	})
+	server.Add("uid=bob,"+testPeopleDN, map[string][]string{
+		"objectClass":  {"inetOrgPerson"},
+		"uid":          {"bob"},
+		"cn":           {"Bob (Builder)*"},
+		"userPassword": {"bob secret"},
+	})
+	server.Add("cn=vault-users,"+testGroupsDN, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {"vault-users"},
+		// members are spelled the way directories often store them, and may be other groups
+		"member": {"UID=alice, OU=people, DC=example, DC=org", "cn=nested," + testGroupsDN},
+	})
+	server.Add("cn=posix-vault,"+testGroupsDN, map[string][]string{
+		"objectClass": {"posixGroup"},
+		"cn":          {"posix-vault"},
+		"memberUid":   {"bob", "ghost"},
+	})
+
+	return server
+}
+
+func TestDirectory(t *testing.T) {
+	server := newTestServer(t)
+
+	newDirectory := func(t *testing.T, config pmldap.Config) *pmldap.Directory {
+		config.URL = server.URL()
+		config.BindDN, config.BindPassword = testBindDN, "search secret"
+		config.UserBaseDN, config.GroupBaseDN = testPeopleDN, testGroupsDN
+
+		d, err := pmldap.NewDirectory(config)
+		require.NoError(t, err)
+
+		return d
+	}
+
+	t.Run("success_authenticate", func(t *testing.T) {
+		d := newDirectory(t, pmldap.Config{GroupFilter: "(&(objectClass=groupOfNames)(cn=vault-users))"})
+
+		user, err := d.Authenticate("alice", "alice secret")
+		require.NoError(t, err)
+		require.Equal(t, &pmldap.User{
+			DN:       "uid=alice," + testPeopleDN,
+			Username: "alice",
+			Email:    "alice@example.org",
+			Name:     "Alice Liddell",
+		}, user)
+	})
+
+	t.Run("error_authenticate", func(t *testing.T) {
+		d := newDirectory(t, pmldap.Config{GroupFilter: "(cn=vault-users)"})
+
+		_, err := d.Authenticate("alice", "wrong")
+		require.ErrorIs(t, err, pmldap.ErrInvalidCredentials)
+
+		// an empty password would be an unauthenticated bind the server accepts
+		_, err = d.Authenticate("alice", "")
+		require.ErrorIs(t, err, pmldap.ErrInvalidCredentials)
+
+		_, err = d.Authenticate("*", "alice secret")
+		require.ErrorIs(t, err, pmldap.ErrInvalidCredentials)
+
+		_, err = d.Authenticate("bob", "bob secret")
+		require.ErrorIs(t, err, pmldap.ErrNotMember)
+	})
+
+	t.Run("success_members", func(t *testing.T) {
+		d := newDirectory(t, pmldap.Config{GroupFilter: "(cn=vault-users)"})
+
+		users, err := d.Members()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "alice", users[0].Username)
+	})
+
+	t.Run("success_member_uid", func(t *testing.T) {
+		d := newDirectory(t, pmldap.Config{GroupFilter: "(cn=posix-*)", MemberAttribute: "memberUid"})
+
+		users, err := d.Members()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "Bob (Builder)*", users[0].Name)
+
+		_, err = d.Authenticate("bob", "bob secret")
+		require.NoError(t, err)
+	})
+
+	t.Run("error_config", func(t *testing.T) {
+		_, err := pmldap.NewDirectory(pmldap.Config{URL: "http://localhost", UserBaseDN: testPeopleDN, GroupFilter: "(cn=x)"})
+		require.Error(t, err)
+
+		_, err = pmldap.NewDirectory(pmldap.Config{URL: server.URL(), UserBaseDN: testPeopleDN, GroupFilter: "(cn=x"})
+		require.Error(t, err)
+
+		_, err = pmldap.NewDirectory(pmldap.Config{URL: server.URL(), UserBaseDN: testPeopleDN, GroupFilter: "(cn=x)", UserFilter: "(uid=alice)"})
+		require.Error(t, err)
+	})
+}
+
+func TestNormalizeDN(t *testing.T) {
+	require.Equal(t, "uid=alice,ou=people,dc=example,dc=org", pmldap.NormalizeDN(" UID = alice , OU=People,dc=example, DC=org "))
+	require.Equal(t, `cn=smith\, john,dc=org`, pmldap.NormalizeDN(`CN=Smith\, John, DC=org`))
+	require.Equal(t, pmldap.EscapeFilter("a*(b)\\"), `a\2a\28b\29\5c`)
+}
@@ -0,0 +1,355 @@
+// Package ldaptest runs an in-process LDAP server holding entries a test adds. It answers simple binds
+// against userPassword and searches, which is all pmldap needs.
+package ldaptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap"
+	"github.com/ChillyWR/PasswordManager/pkg/pmldap/internal/ber"
+)
+
+// dnAttributes hold DNs, their values compare as DNs rather than strings
+var dnAttributes = map[string]bool{"member": true, "uniquemember": true, "owner": true}
+
+type entry struct {
+	dn string
+	// attributes are keyed by lowercase name, names keeps their spelling
+	attributes map[string][]string
+	names      map[string]string
+}
+
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+func New() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	s := &Server{
+		listener: listener,
+		entries:  make(map[string]*entry),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Add stores an entry in place of one with the same DN, userPassword is what binds as it check
+func (s *Server) Add(dn string, attributes map[string][]string) {
+	e := &entry{dn: dn, attributes: make(map[string][]string), names: make(map[string]string)}
+	for name, values := range attributes {
+		e.attributes[strings.ToLower(name)] = append([]string(nil), values...)
+		e.names[strings.ToLower(name)] = name
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[pmldap.NormalizeDN(dn)] = e
+}
+
+func (s *Server) Delete(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, pmldap.NormalizeDN(dn))
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		msg, err := ber.Read(r, 1<<20)
+		if err != nil || msg.Tag != ber.TagSequence || len(msg.Children) < 2 {
+			return
+		}
+
+		id, err := msg.Children[0].Int()
+		if err != nil {
+			return
+		}
+
+		var responses []ber.Element
+		switch op := msg.Children[1]; op.Tag {
+		case ber.TagBindRequest:
+			responses = []ber.Element{s.bind(op)}
+		case ber.TagSearchRequest:
+			responses = s.search(op)
+		case ber.TagExtendedRequest:
+			responses = []ber.Element{ber.Result(ber.TagExtendedResponse, ber.ResultUnwillingToPerform, "", "extended operations are not supported")}
+		default:
+			// unbind, or an operation the server does not know
+			return
+		}
+
+		for _, resp := range responses {
+			if _, err := c.Write(ber.Message(id, resp).Encode()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) bind(op ber.Element) ber.Element {
+	if len(op.Children) != 3 || op.Children[2].Tag != ber.TagSimpleAuth {
+		return ber.Result(ber.TagBindResponse, ber.ResultProtocolError, "", "only simple binds are supported")
+	}
+
+	dn, password := op.Children[1].Text(), op.Children[2].Text()
+
+	// an unauthenticated bind succeeds for any DN, as it does on real servers
+	if password == "" {
+		return ber.Result(ber.TagBindResponse, ber.ResultSuccess, "", "")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[pmldap.NormalizeDN(dn)]; ok {
+		for _, stored := range e.attributes["userpassword"] {
+			if stored == password {
+				return ber.Result(ber.TagBindResponse, ber.ResultSuccess, "", "")
+			}
+		}
+	}
+
+	return ber.Result(ber.TagBindResponse, ber.ResultInvalidCredentials, "", "invalid credentials")
+}
+
+func (s *Server) search(op ber.Element) []ber.Element {
+	if len(op.Children) != 8 {
+		return []ber.Element{ber.Result(ber.TagSearchDone, ber.ResultProtocolError, "", "malformed search")}
+	}
+
+	base := pmldap.NormalizeDN(op.Children[0].Text())
+	scope, err := op.Children[1].Int()
+	if err != nil {
+		return []ber.Element{ber.Result(ber.TagSearchDone, ber.ResultProtocolError, "", err.Error())}
+	}
+
+	filter := op.Children[6]
+	selected := make(map[string]bool)
+	for _, attr := range op.Children[7].Children {
+		selected[strings.ToLower(attr.Text())] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[base]; !ok && scope == pmldap.ScopeBase {
+		return []ber.Element{ber.Result(ber.TagSearchDone, ber.ResultNoSuchObject, "", "no such object")}
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var responses []ber.Element
+	for _, key := range keys {
+		if !inScope(key, base, scope) {
+			continue
+		}
+
+		e := s.entries[key]
+		ok, err := match(filter, e)
+		if err != nil {
+			return []ber.Element{ber.Result(ber.TagSearchDone, ber.ResultProtocolError, "", err.Error())}
+		}
+
+		if ok {
+			responses = append(responses, e.encode(selected))
+		}
+	}
+
+	return append(responses, ber.Result(ber.TagSearchDone, ber.ResultSuccess, "", ""))
+}
+
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case pmldap.ScopeBase:
+		return dn == base
+	case pmldap.ScopeOne:
+		i := strings.IndexByte(dn, ',')
+		return i >= 0 && dn[i+1:] == base
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+// encode returns the selected attributes of e, passwords are never returned
+func (e *entry) encode(selected map[string]bool) ber.Element {
+	all := len(selected) == 0 || selected["*"]
+
+	names := make([]string, 0, len(e.attributes))
+	for name := range e.attributes {
+		if name != "userpassword" && (all || selected[name]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	attributes := ber.Seq(ber.TagSequence)
+	for _, name := range names {
+		values := ber.Seq(ber.TagSet)
+		for _, value := range e.attributes[name] {
+			values.Children = append(values.Children, ber.String(ber.TagOctetString, value))
+		}
+
+		attributes.Children = append(attributes.Children, ber.Seq(ber.TagSequence, ber.String(ber.TagOctetString, e.names[name]), values))
+	}
+
+	return ber.Seq(ber.TagSearchEntry, ber.String(ber.TagOctetString, e.dn), attributes)
+}
+
+// match evaluates a filter, values compare case insensitively as most directory attributes do
+func match(filter ber.Element, e *entry) (bool, error) {
+	switch filter.Tag {
+	case ber.TagFilterAnd, ber.TagFilterOr:
+		for _, child := range filter.Children {
+			ok, err := match(child, e)
+			if err != nil {
+				return false, err
+			}
+
+			if ok == (filter.Tag == ber.TagFilterOr) {
+				return ok, nil
+			}
+		}
+
+		return filter.Tag == ber.TagFilterAnd, nil
+	case ber.TagFilterNot:
+		if len(filter.Children) != 1 {
+			return false, errors.New("malformed not")
+		}
+
+		ok, err := match(filter.Children[0], e)
+		return !ok, err
+	case ber.TagFilterPresent:
+		name := strings.ToLower(filter.Text())
+		return name == "objectclass" || len(e.attributes[name]) > 0, nil
+	case ber.TagFilterEquality, ber.TagFilterApprox, ber.TagFilterGreaterOrEqual, ber.TagFilterLessOrEqual:
+		if len(filter.Children) != 2 {
+			return false, errors.New("malformed assertion")
+		}
+
+		name := strings.ToLower(filter.Children[0].Text())
+		normalize := strings.ToLower
+		if dnAttributes[name] {
+			normalize = pmldap.NormalizeDN
+		}
+
+		asserted := normalize(filter.Children[1].Text())
+		for _, value := range e.attributes[name] {
+			value = normalize(value)
+			switch {
+			case filter.Tag == ber.TagFilterGreaterOrEqual && value >= asserted,
+				filter.Tag == ber.TagFilterLessOrEqual && value <= asserted,
+				(filter.Tag == ber.TagFilterEquality || filter.Tag == ber.TagFilterApprox) && value == asserted:
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case ber.TagFilterSubstrings:
+		if len(filter.Children) != 2 {
+			return false, errors.New("malformed substrings")
+		}
+
+		for _, value := range e.attributes[strings.ToLower(filter.Children[0].Text())] {
+			if matchSubstrings(strings.ToLower(value), filter.Children[1].Children) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported filter 0x%02x", filter.Tag)
+	}
+}
+
+func matchSubstrings(value string, parts []ber.Element) bool {
+	for _, part := range parts {
+		s := strings.ToLower(part.Text())
+		switch part.Tag {
+		case ber.TagSubstringInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ber.TagSubstringAny:
+			i := strings.Index(value, s)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(s):]
+		case ber.TagSubstringFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+			value = ""
+		}
+	}
+
+	return true
+}
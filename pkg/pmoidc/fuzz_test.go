@@ -0,0 +1,108 @@
+package pmoidc_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc/oidctest"
+)
+
+const fuzzNonce = "fuzz-nonce"
+
+func newFuzzProvider(f *testing.F) (*oidctest.Provider, *pmoidc.Provider) {
+	idp, err := oidctest.New("client", "secret")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(idp.Close)
+
+	provider, err := pmoidc.NewProvider(idp.Config("http://localhost:5000/login/oidc/callback"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	return idp, provider
+}
+
+// FuzzVerify changes signed tokens, none of which may verify once the signature no longer matches
+func FuzzVerify(f *testing.F) {
+	idp, provider := newFuzzProvider(f)
+
+	now := time.Now()
+	token, err := idp.Sign(map[string]interface{}{
+		"iss": idp.Issuer(), "aud": "client", "sub": "alice-id", "nonce": fuzzNonce,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	hmac := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"test"}`))
+	f.Add(parts[0], parts[1], "")
+	f.Add(none, parts[1], "")
+	f.Add(hmac, parts[1], parts[2])
+	f.Add(parts[0], parts[1]+"A", parts[2])
+
+	f.Fuzz(func(t *testing.T, header, payload, signature string) {
+		forged := header + "." + payload + "." + signature
+		if forged == token {
+			return
+		}
+
+		if claims, err := provider.Verify(forged, fuzzNonce); err == nil {
+			t.Fatalf("verified a token the provider did not sign for %q", claims.Subject)
+		}
+	})
+}
+
+// FuzzVerifyClaims signs arbitrary claims, only tokens for this client, issuer and nonce that are current may verify
+func FuzzVerifyClaims(f *testing.F) {
+	idp, provider := newFuzzProvider(f)
+
+	now := time.Now().Unix()
+	f.Add(idp.Issuer(), "client", "", fuzzNonce, "alice-id", now, now+3600, "staff")
+	f.Add(idp.Issuer(), "other", "client", fuzzNonce, "alice-id", now, now+3600, "")
+	f.Add(idp.Issuer()+"/", "client", "", fuzzNonce, "alice-id", now, now+3600, "")
+	f.Add(idp.Issuer(), "client", "other", fuzzNonce, "alice-id", now, now+3600, "")
+	f.Add(idp.Issuer(), "client", "", "", "alice-id", now, now+3600, "")
+	f.Add(idp.Issuer(), "client", "", fuzzNonce, "", now, now-3600, "")
+	f.Add(idp.Issuer(), "client", "", fuzzNonce, "alice-id", now+3600, now+7200, "")
+
+	f.Fuzz(func(t *testing.T, iss, aud, azp, nonce, sub string, iat, exp int64, group string) {
+		claims := map[string]interface{}{"iss": iss, "aud": aud, "nonce": nonce, "sub": sub, "iat": iat, "exp": exp}
+		if azp != "" {
+			claims["azp"] = azp
+		}
+		if group != "" {
+			claims["groups"] = group
+		}
+
+		token, err := idp.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := provider.Verify(token, fuzzNonce)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case iss != idp.Issuer() || aud != "client" || (azp != "" && azp != "client"):
+			t.Fatalf("verified a token of issuer %q for %q authorized to %q", iss, aud, azp)
+		case nonce != fuzzNonce:
+			t.Fatalf("verified a token with nonce %q", nonce)
+		case sub == "" || result.Subject != sub:
+			t.Fatalf("verified subject %q as %q", sub, result.Subject)
+		case time.Unix(exp, 0).Before(time.Now().Add(-2*time.Minute)) || time.Unix(iat, 0).After(time.Now().Add(2*time.Minute)):
+			t.Fatalf("verified a token issued at %d expiring at %d", iat, exp)
+		case group != "" && (len(result.Groups) != 1 || result.Groups[0] != group):
+			t.Fatalf("verified groups %q as %q", group, result.Groups)
+		}
+	})
+}
@@ -0,0 +1,394 @@
+// Package pmoidc is an OpenID Connect relying party using the authorization code flow with PKCE.
+// ID tokens are parsed and verified by github.com/golang-jwt/jwt with the keys pmjwt reads from the provider's
+// key set, only the claim checks live here. FuzzVerify and FuzzVerifyClaims check them on arbitrary tokens.
+package pmoidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultGroupsClaim = "groups"
+	// leeway tolerates clocks of the provider and the server drifting apart
+	leeway = time.Minute
+	// minKeysRefresh limits fetching the key set again for tokens signed by unknown keys
+	minKeysRefresh = time.Minute
+)
+
+var DefaultScopes = []string{"openid", "profile", "email"}
+
+type Config struct {
+	// Issuer is the issuer identifier of the provider, ID tokens have to name it exactly
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL receives the code after signing in, it has to be registered at the provider
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim is the ID token claim listing the groups of a user, "groups" by default
+	GroupsClaim string
+
+	// DiscoveryURL defaults to "<issuer>/.well-known/openid-configuration".
+	// Setting all three endpoints skips discovery.
+	DiscoveryURL          string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	Timeout time.Duration
+}
+
+// Claims are what the ID token tells about a user
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+	// AuthTime is when the user last authenticated at the provider, zero when the ID token does not say
+	AuthTime time.Time
+}
+
+// Provider discovers the endpoints of the provider on first use, so that the server starts while the provider is down
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu            sync.Mutex
+	discovered    bool
+	keys          *pmjwt.KeySet
+	keysFetchedOn time.Time
+}
+
+func NewProvider(config Config) (*Provider, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("issuer is empty")
+	}
+
+	if config.ClientID == "" {
+		return nil, errors.New("client ID is empty")
+	}
+
+	if config.RedirectURL == "" {
+		return nil, errors.New("redirect URL is empty")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+
+	if config.DiscoveryURL == "" {
+		config.DiscoveryURL = strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	return &Provider{
+		config:     config,
+		client:     &http.Client{Timeout: config.Timeout},
+		discovered: config.AuthorizationEndpoint != "" && config.TokenEndpoint != "" && config.JWKSURI != "",
+	}, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// CodeChallenge derives the S256 PKCE challenge of verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where a user signs in at the provider, it is sent back to the redirect URL with state and a code.
+// Fresh asks the provider to authenticate the user again even when it has a session, the ID token then carries auth_time.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string, fresh bool) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	if fresh {
+		query.Set("prompt", "login")
+		query.Set("max_age", "0")
+	}
+
+	separator := "?"
+	if strings.Contains(p.config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.config.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems a code at the token endpoint and verifies the ID token it returns against nonce
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	// public clients only send their ID, confidential ones authenticate with client_secret_basic
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	status, err := p.do(req, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded %d: %s %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, errors.New("token endpoint returned no ID token")
+	}
+
+	return p.Verify(tokens.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of an ID token
+func (p *Provider) Verify(idToken, nonce string) (*Claims, error) {
+	keys, err := p.keySet(false)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := keys.Parse(idToken, jwt.WithoutClaimsValidation())
+	if err != nil {
+		// the provider may have rotated its keys since they were fetched
+		if keys, err = p.keySet(true); err != nil {
+			return nil, err
+		}
+
+		if token, err = keys.Parse(idToken, jwt.WithoutClaimsValidation()); err != nil {
+			return nil, fmt.Errorf("parse ID token: %w", err)
+		}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("unexpected ID token claims")
+	}
+
+	now := time.Now()
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return nil, errors.New("ID token is from another issuer")
+	}
+
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.New("ID token is for another client")
+	}
+
+	// tokens for several audiences have to name this client as the authorized party
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, errors.New("ID token is authorized for another client")
+	}
+
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), true) {
+		return nil, errors.New("ID token expired")
+	}
+
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), true) {
+		return nil, errors.New("ID token is issued in the future")
+	}
+
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	result := &Claims{Issuer: p.config.Issuer}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified, _ = claims["email_verified"].(bool)
+	result.Name, _ = claims["name"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		result.AuthTime = time.Unix(int64(authTime), 0).UTC()
+	}
+
+	if result.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				result.Groups = append(result.Groups, name)
+			}
+		}
+	case string:
+		// some providers send a single group as a string
+		result.Groups = []string{groups}
+	}
+
+	return result, nil
+}
+
+// discover reads the endpoints from the discovery document unless they are configured
+func (p *Provider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.config.DiscoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	status, err := p.do(req, &document)
+	if err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("discovery responded %d", status)
+	}
+
+	if document.Issuer != p.config.Issuer {
+		return fmt.Errorf("discovery is for issuer %q, expected %q", document.Issuer, p.config.Issuer)
+	}
+
+	if p.config.AuthorizationEndpoint == "" {
+		p.config.AuthorizationEndpoint = document.AuthorizationEndpoint
+	}
+
+	if p.config.TokenEndpoint == "" {
+		p.config.TokenEndpoint = document.TokenEndpoint
+	}
+
+	if p.config.JWKSURI == "" {
+		p.config.JWKSURI = document.JWKSURI
+	}
+
+	if p.config.AuthorizationEndpoint == "" || p.config.TokenEndpoint == "" || p.config.JWKSURI == "" {
+		return errors.New("discovery lacks endpoints")
+	}
+
+	p.discovered = true
+
+	return nil
+}
+
+// keySet returns the keys of the provider, refresh fetches them again unless that was done recently
+func (p *Provider) keySet(refresh bool) (*pmjwt.KeySet, error) {
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.keysFetchedOn) < minKeysRefresh) {
+		return p.keys, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.config.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+
+	var jwks pmjwt.JWKS
+	status, err := p.do(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("jwks responded %d", status)
+	}
+
+	keys, err := jwks.KeySet()
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	p.keys, p.keysFetchedOn = keys, time.Now()
+
+	return keys, nil
+}
+
+// do sends req and decodes the JSON response into result, error responses of the token endpoint are JSON too
+func (p *Provider) do(req *http.Request, result any) (int, error) {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result); err != nil {
+		return resp.StatusCode, fmt.Errorf("responded %d: decode: %v", resp.StatusCode, err)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,116 @@
+package pmoidc_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc/oidctest"
+)
+
+func TestProvider(t *testing.T) {
+	idp, err := oidctest.New("client", "secret")
+	require.NoError(t, err)
+	defer idp.Close()
+
+	provider, err := pmoidc.NewProvider(idp.Config("http://localhost:5000/login/oidc/callback"))
+	require.NoError(t, err)
+
+	begin := func(t *testing.T) (string, string, string) {
+		state, err := pmcrypto.NewRandom()
+		require.NoError(t, err)
+		nonce, err := pmcrypto.NewRandom()
+		require.NoError(t, err)
+		verifier, err := pmcrypto.NewRandom()
+		require.NoError(t, err)
+
+		authURL, err := provider.AuthCodeURL(state, nonce, verifier, false)
+		require.NoError(t, err)
+
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		require.Equal(t, pmoidc.CodeChallenge(verifier), u.Query().Get("code_challenge"))
+		require.Equal(t, "openid profile email", u.Query().Get("scope"))
+		require.Empty(t, u.Query().Get("prompt"))
+
+		code, returned, err := idp.Authorize(authURL, map[string]interface{}{
+			"sub":                "alice-id",
+			"preferred_username": "alice",
+			"groups":             []string{"staff", "vault-admins"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, state, returned)
+
+		return code, nonce, verifier
+	}
+
+	t.Run("success_exchange", func(t *testing.T) {
+		code, nonce, verifier := begin(t)
+
+		claims, err := provider.Exchange(code, verifier, nonce)
+		require.NoError(t, err)
+		require.Equal(t, &pmoidc.Claims{
+			Issuer:            idp.Issuer(),
+			Subject:           "alice-id",
+			PreferredUsername: "alice",
+			Groups:            []string{"staff", "vault-admins"},
+		}, claims)
+
+		_, err = provider.Exchange(code, verifier, nonce)
+		require.Error(t, err)
+	})
+
+	t.Run("success_fresh", func(t *testing.T) {
+		nonce, err := pmcrypto.NewRandom()
+		require.NoError(t, err)
+		verifier, err := pmcrypto.NewRandom()
+		require.NoError(t, err)
+
+		authURL, err := provider.AuthCodeURL("state", nonce, verifier, true)
+		require.NoError(t, err)
+
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		require.Equal(t, "login", u.Query().Get("prompt"))
+		require.Equal(t, "0", u.Query().Get("max_age"))
+
+		authTime := time.Now().Truncate(time.Second).UTC()
+		code, _, err := idp.Authorize(authURL, map[string]interface{}{"sub": "alice-id", "auth_time": authTime.Unix()})
+		require.NoError(t, err)
+
+		claims, err := provider.Exchange(code, verifier, nonce)
+		require.NoError(t, err)
+		require.Equal(t, authTime, claims.AuthTime)
+	})
+
+	t.Run("error_wrong_verifier", func(t *testing.T) {
+		code, nonce, _ := begin(t)
+
+		_, err := provider.Exchange(code, "wrong", nonce)
+		require.Error(t, err)
+	})
+
+	t.Run("error_wrong_nonce", func(t *testing.T) {
+		code, _, verifier := begin(t)
+
+		_, err := provider.Exchange(code, verifier, "wrong")
+		require.Error(t, err)
+	})
+
+	t.Run("error_other_client", func(t *testing.T) {
+		other, err := pmoidc.NewProvider(pmoidc.Config{
+			Issuer:      idp.Issuer(),
+			ClientID:    "other",
+			RedirectURL: "http://localhost:5000/login/oidc/callback",
+		})
+		require.NoError(t, err)
+
+		code, nonce, verifier := begin(t)
+		_, err = other.Exchange(code, verifier, nonce)
+		require.Error(t, err)
+	})
+}
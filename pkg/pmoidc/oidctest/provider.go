@@ -0,0 +1,211 @@
+// Package oidctest runs a local OpenID Connect provider that signs in whoever a test asks for
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmcrypto"
+	"github.com/ChillyWR/PasswordManager/pkg/pmjwt"
+	"github.com/ChillyWR/PasswordManager/pkg/pmoidc"
+)
+
+type grant struct {
+	claims      jwt.MapClaims
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+}
+
+// Provider serves discovery, the token endpoint and the key set. Authorize stands in for the browser
+// signing in at the authorization endpoint.
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+
+	server *httptest.Server
+	keys   *pmjwt.KeySet
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+func New(clientID, clientSecret string) (*Provider, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	key, err := pmjwt.NewKey("test", private, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := pmjwt.NewKeySet()
+	if err := keys.Add(key); err != nil {
+		return nil, err
+	}
+
+	if err := keys.SetActive(key.ID); err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		keys:         keys,
+		grants:       make(map[string]*grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, p.keys.JWKS())
+	})
+	p.server = httptest.NewServer(mux)
+
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// Config returns a relying party configuration of the provider that discovers its endpoints
+func (p *Provider) Config(redirectURL string) pmoidc.Config {
+	return pmoidc.Config{
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Authorize signs in a user with claims at authURL, "sub" is required. It returns the code and state
+// the browser would bring to the redirect URL.
+func (p *Provider) Authorize(authURL string, claims map[string]interface{}) (string, string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	query := u.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		return "", "", errors.New("only the authorization code flow with S256 PKCE is supported")
+	}
+
+	if query.Get("client_id") != p.ClientID {
+		return "", "", errors.New("unknown client")
+	}
+
+	code, err := pmcrypto.NewRandom()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.grants[code] = &grant{
+		claims:      jwt.MapClaims(claims),
+		clientID:    query.Get("client_id"),
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+	}
+
+	return code, query.Get("state"), nil
+}
+
+// Sign returns an ID token of exactly claims signed by the provider, for tests of tokens it would not issue
+func (p *Provider) Sign(claims map[string]interface{}) (string, error) {
+	return p.keys.Sign(jwt.MapClaims(claims))
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/jwks",
+	})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	// client_secret_basic form encodes the credentials before joining them
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.Form.Get("client_id")
+	}
+
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.grants[r.Form.Get("code")]
+	delete(p.grants, r.Form.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || g.clientID != clientID || g.redirectURI != r.Form.Get("redirect_uri") ||
+		pmoidc.CodeChallenge(r.Form.Get("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer(),
+		"aud":   clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	for name, value := range g.claims {
+		claims[name] = value
+	}
+
+	idToken, err := p.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
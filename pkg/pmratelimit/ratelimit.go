@@ -0,0 +1,63 @@
+// Package pmratelimit implements token buckets and the backoff of repeated failures. It only does the arithmetic,
+// where buckets are stored is up to callers so that instances can share them.
+package pmratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit allows bursts of Requests requests, with tokens refilled evenly over Interval.
+// The zero Limit is disabled.
+type Limit struct {
+	Requests int
+	Interval time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Interval > 0
+}
+
+// Bucket is the state of a token bucket
+type Bucket struct {
+	Tokens    float64
+	UpdatedOn time.Time
+}
+
+// New returns a full bucket
+func (l Limit) New(now time.Time) Bucket {
+	return Bucket{Tokens: float64(l.Requests), UpdatedOn: now}
+}
+
+// Take refills b for the time passed until now and takes a token. When none is left b is only refilled
+// and Take returns how long it takes until the next token.
+func (l Limit) Take(b *Bucket, now time.Time) time.Duration {
+	rate := float64(l.Requests) / float64(l.Interval)
+
+	// clocks of instances sharing a bucket may disagree, a bucket is never refilled backwards
+	if elapsed := now.Sub(b.UpdatedOn); elapsed > 0 {
+		b.Tokens = math.Min(float64(l.Requests), b.Tokens+float64(elapsed)*rate)
+		b.UpdatedOn = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - b.Tokens) / rate))
+}
+
+// Backoff is the delay after failures: none for the first free ones, then doubling from base up to max
+func Backoff(failures, free int, base, max time.Duration) time.Duration {
+	if failures <= free || base <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := free + 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, max)
+}
@@ -0,0 +1,48 @@
+package pmratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{Requests: 3, Interval: 3 * time.Second}
+	now := time.Unix(1700000000, 0)
+	bucket := limit.New(now)
+
+	for i := 0; i < 3; i++ {
+		require.Zero(t, limit.Take(&bucket, now))
+	}
+
+	require.Equal(t, time.Second, limit.Take(&bucket, now))
+	require.Equal(t, 500*time.Millisecond, limit.Take(&bucket, now.Add(500*time.Millisecond)))
+	require.Zero(t, limit.Take(&bucket, now.Add(time.Second)))
+
+	// a clock behind the last update neither refills nor rewinds the bucket
+	require.Equal(t, time.Second, limit.Take(&bucket, now))
+	require.Equal(t, now.Add(time.Second), bucket.UpdatedOn)
+
+	// an idle bucket refills up to its size only
+	for i := 0; i < 3; i++ {
+		require.Zero(t, limit.Take(&bucket, now.Add(time.Hour)))
+	}
+	require.NotZero(t, limit.Take(&bucket, now.Add(time.Hour)))
+}
+
+func TestBackoff(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{
+		0:   0,
+		3:   0,
+		4:   time.Second,
+		5:   2 * time.Second,
+		7:   8 * time.Second,
+		8:   10 * time.Second,
+		100: 10 * time.Second,
+	} {
+		require.Equal(t, expected, Backoff(failures, 3, time.Second, 10*time.Second), "failures %d", failures)
+	}
+
+	require.Zero(t, Backoff(10, 3, 0, time.Minute))
+}
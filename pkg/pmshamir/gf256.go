@@ -0,0 +1,44 @@
+package pmshamir
+
+// Arithmetic in GF(2^8) modulo the AES polynomial x^8 + x^4 + x^3 + x + 1, with log tables over generator 3
+
+var (
+	expTable [255]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := range expTable {
+		expTable[i] = x
+		logTable[x] = byte(i)
+
+		// x *= 3, that is x*2 + x
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+// div panics on division by zero, Combine never divides by zero x coordinates
+func div(a, b byte) byte {
+	if b == 0 {
+		panic("pmshamir: division by zero")
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
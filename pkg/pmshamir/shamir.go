@@ -0,0 +1,108 @@
+// Package pmshamir implements Shamir's secret sharing over GF(2^8).
+//
+// Every byte of the secret is the constant term of its own random polynomial of degree threshold-1.
+// A share holds the value of each polynomial at one non-zero x, stored as the last byte of the share.
+package pmshamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// MaxShares is the number of non-zero x coordinates in GF(2^8)
+const MaxShares = 255
+
+var (
+	ErrInvalidParams = errors.New("invalid parameters")
+	ErrInvalidShares = errors.New("invalid shares")
+)
+
+// Split divides secret into n shares, any threshold of them rebuild it and fewer reveal nothing
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: secret is empty", ErrInvalidParams)
+	}
+
+	if threshold < 2 || threshold > n || n > MaxShares {
+		return nil, fmt.Errorf("%w: need 2 <= threshold <= shares <= %d, got threshold %d of %d", ErrInvalidParams, MaxShares, threshold, n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("read random: %v", err)
+		}
+		coefficients[0] = s
+
+		for _, share := range shares {
+			share[b] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine rebuilds the secret from shares produced by Split.
+// Fewer shares than the threshold produce a wrong secret rather than an error, callers have to verify it.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 shares", ErrInvalidShares)
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("%w: share is too short", ErrInvalidShares)
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, fmt.Errorf("%w: duplicate or zero x coordinate", ErrInvalidShares)
+		}
+
+		xs[i], seen[x] = x, true
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		// Lagrange interpolation at x = 0, subtraction is xor in GF(2^8)
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j := range shares {
+				if i != j {
+					basis = mul(basis, div(xs[j], xs[j]^xs[i]))
+				}
+			}
+
+			value ^= mul(share[b], basis)
+		}
+
+		secret[b] = value
+	}
+
+	return secret, nil
+}
+
+// evaluate computes the polynomial at x with Horner's method, coefficients[0] is the constant term
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+
+	return y
+}
@@ -0,0 +1,73 @@
+package pmshamir
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	secret := []byte("12345678901234567890123456789012")
+
+	t.Run("success_any_threshold_subset", func(t *testing.T) {
+		shares, err := Split(secret, 5, 3)
+		require.NoError(t, err)
+		require.Len(t, shares, 5)
+
+		for _, subset := range [][]int{{0, 1, 2}, {0, 2, 4}, {4, 3, 1}, {0, 1, 2, 3, 4}} {
+			var picked [][]byte
+			for _, i := range subset {
+				picked = append(picked, shares[i])
+			}
+
+			combined, err := Combine(picked)
+			require.NoError(t, err)
+			require.Equal(t, secret, combined)
+		}
+	})
+
+	t.Run("success_below_threshold_does_not_rebuild", func(t *testing.T) {
+		shares, err := Split(secret, 5, 3)
+		require.NoError(t, err)
+
+		combined, err := Combine(shares[:2])
+		require.NoError(t, err)
+		require.NotEqual(t, secret, combined)
+	})
+
+	t.Run("error_invalid_params", func(t *testing.T) {
+		for _, params := range [][2]int{{3, 1}, {3, 4}, {256, 2}} {
+			_, err := Split(secret, params[0], params[1])
+			require.True(t, errors.Is(err, ErrInvalidParams))
+		}
+
+		_, err := Split(nil, 3, 2)
+		require.True(t, errors.Is(err, ErrInvalidParams))
+	})
+
+	t.Run("error_invalid_shares", func(t *testing.T) {
+		shares, err := Split(secret, 3, 2)
+		require.NoError(t, err)
+
+		_, err = Combine([][]byte{shares[0], shares[0]})
+		require.True(t, errors.Is(err, ErrInvalidShares))
+
+		_, err = Combine([][]byte{shares[0], shares[1][1:]})
+		require.True(t, errors.Is(err, ErrInvalidShares))
+
+		_, err = Combine(shares[:1])
+		require.True(t, errors.Is(err, ErrInvalidShares))
+	})
+}
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			require.Equal(t, byte(a), div(mul(byte(a), byte(b)), byte(b)))
+		}
+	}
+
+	// x^7 * x = x^8 = x^4 + x^3 + x + 1
+	require.Equal(t, byte(0x1b), mul(0x80, 0x02))
+}
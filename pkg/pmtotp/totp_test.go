@@ -0,0 +1,78 @@
+package pmtotp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	// SHA1 test vectors of RFC 6238 appendix B
+	key := []byte("12345678901234567890")
+	for unix, expected := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		require.Equal(t, expected, code(key, Step(time.Unix(unix, 0)), 8))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+
+	t.Run("success_within_skew", func(t *testing.T) {
+		for _, offset := range []int64{-Skew, 0, Skew} {
+			c, err := Code(secret, Step(now)+offset)
+			require.NoError(t, err)
+
+			step, ok, err := Validate(secret, c, now)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, Step(now)+offset, step)
+		}
+	})
+
+	t.Run("error_outside_skew", func(t *testing.T) {
+		c, err := Code(secret, Step(now)+Skew+1)
+		require.NoError(t, err)
+
+		_, ok, err := Validate(secret, c, now)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, ok, err = Validate(secret, "12345", now)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("error_invalid_secret", func(t *testing.T) {
+		_, _, err := Validate("not base32!", "123456", now)
+		require.Error(t, err)
+	})
+}
+
+func TestURI(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	uri, err := URI("PasswordManager", "alice", secret)
+	require.NoError(t, err)
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/PasswordManager:alice", u.Path)
+	require.Equal(t, secret, u.Query().Get("secret"))
+	require.Equal(t, "PasswordManager", u.Query().Get("issuer"))
+	require.Equal(t, "6", u.Query().Get("digits"))
+}
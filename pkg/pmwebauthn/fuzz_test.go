@@ -0,0 +1,120 @@
+package pmwebauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// testCOSEKey returns the COSE_Key of a fixed P-256 point as authenticators encode it
+func testCOSEKey() []byte {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult([]byte{1})
+
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, x.FillBytes(make([]byte, 32))...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, y.FillBytes(make([]byte, 32))...)
+}
+
+// testAuthenticatorData returns authenticator data with flags, carrying a credential when attested is set
+func testAuthenticatorData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 1)
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint16(data, 4)
+	data = append(data, 1, 2, 3, 4)
+	return append(data, testCOSEKey()...)
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	f.Add(testCOSEKey())
+	f.Add([]byte{0x83, 0x01, 0x62, 'h', 'i', 0xf5})
+	f.Add([]byte{0x1b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xa2, 0x01, 0x01, 0x01, 0x02})
+	f.Add([]byte{0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, n, err := decodeCBOR(data)
+		if err != nil {
+			return
+		}
+
+		if n <= 0 || n > len(data) {
+			t.Fatalf("decoded %d bytes of %d", n, len(data))
+		}
+
+		// the item has to decode the same on its own, nothing past n may be read
+		again, m, err := decodeCBOR(data[:n])
+		if err != nil {
+			t.Fatalf("item of %d bytes does not decode on its own: %s", n, err)
+		}
+
+		if m != n || !reflect.DeepEqual(value, again) {
+			t.Fatalf("item of %d bytes decodes differently on its own", n)
+		}
+	})
+}
+
+func FuzzParsePublicKey(f *testing.F) {
+	f.Add(testCOSEKey(), []byte("data"), []byte("signature"))
+	f.Add([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}, []byte{}, []byte{})
+	f.Add([]byte{0xa4, 0x01, 0x03, 0x03, 0x39, 0x01, 0x00, 0x20, 0x41, 0x01, 0x21, 0x43, 0x01, 0x00, 0x01}, []byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, cose, data, signature []byte) {
+		key, err := parsePublicKey(cose)
+		if err != nil {
+			return
+		}
+
+		switch key.alg {
+		case AlgES256, AlgEdDSA, AlgRS256:
+		default:
+			t.Fatalf("accepted algorithm %d", key.alg)
+		}
+
+		if ec, ok := key.key.(*ecdsa.PublicKey); ok && !ec.Curve.IsOnCurve(ec.X, ec.Y) {
+			t.Fatal("accepted a point off the curve")
+		}
+
+		// arbitrary signatures are rejected with an error, never a panic
+		_ = key.verify(data, signature)
+	})
+}
+
+func FuzzParseAuthenticatorData(f *testing.F) {
+	f.Add(testAuthenticatorData(FlagUserPresent|FlagUserVerified, false))
+	f.Add(testAuthenticatorData(FlagUserPresent|FlagAttestedCredData, true))
+	f.Add(append(testAuthenticatorData(FlagUserPresent|FlagAttestedCredData|FlagExtensionData, true), 0xa0))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		data, err := parseAuthenticatorData(raw)
+		if err != nil {
+			return
+		}
+
+		if data.flags&FlagAttestedCredData == 0 {
+			if data.credentialID != nil || data.publicKey != nil {
+				t.Fatal("credential parsed without the attested flag")
+			}
+			return
+		}
+
+		if len(data.credentialID)+len(data.publicKey) > len(raw)-55 {
+			t.Fatalf("credential of %d bytes from %d bytes of data", len(data.credentialID)+len(data.publicKey), len(raw))
+		}
+
+		if _, n, err := decodeCBOR(data.publicKey); err != nil || n != len(data.publicKey) {
+			t.Fatal("credential public key is not a single CBOR item")
+		}
+	})
+}
@@ -0,0 +1,441 @@
+// Package pmwebauthn verifies WebAuthn registration and authentication ceremonies of a relying party.
+// Attestation statements are not verified: relying parties request "none" conveyance and trust no authenticator model.
+//
+// CBOR and COSE keys are decoded in this package rather than by a general purpose library: WebAuthn only needs
+// definite-length integers, strings, arrays and maps, and the decoder rejects everything else, bounds nesting and
+// never allocates more than its input. FuzzDecodeCBOR, FuzzParsePublicKey and FuzzParseAuthenticatorData check it
+// on arbitrary input, run them with go test -fuzz after changing the decoder.
+package pmwebauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChallengeSize is 256 bits, WebAuthn requires at least 128
+const ChallengeSize = 32
+
+const (
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+
+	CredentialType = "public-key"
+
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+// Flags of authenticator data
+const (
+	FlagUserPresent      = 0x01
+	FlagUserVerified     = 0x04
+	FlagAttestedCredData = 0x40
+	FlagExtensionData    = 0x80
+)
+
+// ErrSignCount means an authenticator reported a signature counter that did not increase, it may have been cloned
+var ErrSignCount = errors.New("signature counter did not increase")
+
+// Encoding is how binary fields travel in JSON, as unpadded base64url
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty is the service credentials are scoped to. ID is its domain, Origins are the exact origins
+// of the pages that run ceremonies, such as "https://vault.example.com".
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+func (rp *RelyingParty) Validate() error {
+	if rp.ID == "" || rp.Name == "" {
+		return errors.New("relying party ID and name must not be empty")
+	}
+
+	if len(rp.Origins) == 0 {
+		return errors.New("no relying party origins")
+	}
+
+	return nil
+}
+
+// NewChallenge generates a random challenge encoded for JSON
+func NewChallenge() (string, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", fmt.Errorf("read random: %w", err)
+	}
+
+	return Encoding.EncodeToString(challenge), nil
+}
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies an account to authenticators, ID is opaque and returned as the user handle of passkeys
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create once binary fields are decoded
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get once binary fields are decoded.
+// Without AllowCredentials authenticators offer the passkeys they hold for the relying party.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a passkey of user, authenticators already holding one of exclude are not registered twice
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude []CredentialDescriptor, timeout time.Duration) *CreationOptions {
+	return &CreationOptions{
+		Challenge: challenge,
+		RP:        RPEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: CredentialType, Alg: AlgES256},
+			{Type: CredentialType, Alg: AlgEdDSA},
+			{Type: CredentialType, Alg: AlgRS256},
+		},
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: UserVerificationPreferred,
+		},
+		Attestation: "none",
+	}
+}
+
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string, timeout time.Duration) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// AttestationResponse is the JSON of the PublicKeyCredential navigator.credentials.create returns
+type AttestationResponse struct {
+	ID       string                           `json:"id"`
+	RawID    string                           `json:"rawId"`
+	Type     string                           `json:"type"`
+	Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// AssertionResponse is the JSON of the PublicKeyCredential navigator.credentials.get returns
+type AssertionResponse struct {
+	ID       string                         `json:"id"`
+	RawID    string                         `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+func (r *AssertionResponse) CredentialID() ([]byte, error) {
+	return decode(r.RawID)
+}
+
+// UserHandle is the user entity ID a passkey was registered with, authenticators may omit it for other credentials
+func (r *AssertionResponse) UserHandle() ([]byte, error) {
+	return decode(r.Response.UserHandle)
+}
+
+// Credential is what a relying party stores of a registered authenticator
+type Credential struct {
+	ID []byte
+	// PublicKey is a COSE_Key
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Challenge returns the challenge clientDataJSON was signed for, to look up the ceremony it answers
+func Challenge(clientDataJSON string) (string, error) {
+	raw, err := decode(clientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("decode client data: %w", err)
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return "", fmt.Errorf("unmarshal client data: %w", err)
+	}
+
+	return data.Challenge, nil
+}
+
+// VerifyRegistration checks a response to CreationOptions with challenge and returns the credential to store
+func (rp *RelyingParty) VerifyRegistration(response *AttestationResponse, challenge string, requireUV bool) (*Credential, error) {
+	if response.Type != CredentialType {
+		return nil, fmt.Errorf("unsupported credential type %q", response.Type)
+	}
+
+	if _, err := rp.verifyClientData(response.Response.ClientDataJSON, CeremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	rawObject, err := decode(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("decode attestation object: %w", err)
+	}
+
+	decoded, _, err := decodeCBOR(rawObject)
+	if err != nil {
+		return nil, fmt.Errorf("decode attestation object: %w", err)
+	}
+
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+
+	rawAuthData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object lacks authenticator data")
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUV)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.flags&FlagAttestedCredData == 0 {
+		return nil, errors.New("authenticator data lacks a credential")
+	}
+
+	if rawID, err := decode(response.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, errors.New("credential ID does not match authenticator data")
+	}
+
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks a response to RequestOptions with challenge signed by credential and returns its new
+// signature counter. Counters that do not increase are reported as ErrSignCount, authenticators without one report 0.
+func (rp *RelyingParty) VerifyAssertion(response *AssertionResponse, challenge string, credential *Credential, requireUV bool) (uint32, error) {
+	if response.Type != CredentialType {
+		return 0, fmt.Errorf("unsupported credential type %q", response.Type)
+	}
+
+	if rawID, err := response.CredentialID(); err != nil || !bytes.Equal(rawID, credential.ID) {
+		return 0, errors.New("credential ID does not match")
+	}
+
+	rawClientData, err := rp.verifyClientData(response.Response.ClientDataJSON, CeremonyGet, challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := decode(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("decode authenticator data: %w", err)
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, requireUV)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := decode(response.Response.Signature)
+	if err != nil {
+		return 0, fmt.Errorf("decode signature: %w", err)
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("public key: %w", err)
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err := key.verify(append(rawAuthData, clientDataHash[:]...), signature); err != nil {
+		return 0, err
+	}
+
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, fmt.Errorf("%w: got %d after %d", ErrSignCount, authData.signCount, credential.SignCount)
+	}
+
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := decode(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode client data: %w", err)
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal client data: %w", err)
+	}
+
+	if data.Type != ceremony {
+		return nil, fmt.Errorf("client data is of %q, expected %q", data.Type, ceremony)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return nil, errors.New("challenge does not match")
+	}
+
+	if data.CrossOrigin {
+		return nil, errors.New("cross-origin ceremonies are not allowed")
+	}
+
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return raw, nil
+		}
+	}
+
+	return nil, fmt.Errorf("origin %q is not allowed", data.Origin)
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(raw []byte, requireUV bool) (*authenticatorData, error) {
+	data, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, rpIDHash[:]) != 1 {
+		return nil, errors.New("authenticator data is for another relying party")
+	}
+
+	if data.flags&FlagUserPresent == 0 {
+		return nil, errors.New("user was not present")
+	}
+
+	if requireUV && data.flags&FlagUserVerified == 0 {
+		return nil, errors.New("user was not verified")
+	}
+
+	return data, nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	data := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	if data.flags&FlagAttestedCredData == 0 {
+		return data, nil
+	}
+
+	// AAGUID, then the credential ID prefixed with its length, then its COSE key
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data is too short")
+	}
+
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("credential ID is truncated")
+	}
+
+	data.credentialID = append([]byte(nil), rest[:idLength]...)
+	rest = rest[idLength:]
+
+	_, n, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("decode credential public key: %w", err)
+	}
+
+	data.publicKey = append([]byte(nil), rest[:n]...)
+
+	if data.flags&FlagExtensionData == 0 && n != len(rest) {
+		return nil, errors.New("trailing bytes after attested credential data")
+	}
+
+	return data, nil
+}
+
+// decode accepts base64url with or without padding, browsers and libraries differ
+func decode(s string) ([]byte, error) {
+	return Encoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,113 @@
+package pmwebauthn_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn/webauthntest"
+)
+
+func TestRelyingParty(t *testing.T) {
+	rp := &pmwebauthn.RelyingParty{ID: "localhost", Name: "Test", Origins: []string{"http://localhost:5000"}}
+	require.NoError(t, rp.Validate())
+
+	user := pmwebauthn.UserEntity{ID: pmwebauthn.Encoding.EncodeToString([]byte("user")), Name: "user", DisplayName: "user"}
+
+	register := func(t *testing.T, authenticator *webauthntest.Authenticator) *pmwebauthn.Credential {
+		challenge, err := pmwebauthn.NewChallenge()
+		require.NoError(t, err)
+
+		response, err := authenticator.Register(rp.CreationOptions(challenge, user, nil, time.Minute))
+		require.NoError(t, err)
+
+		credential, err := rp.VerifyRegistration(response, challenge, true)
+		require.NoError(t, err)
+		return credential
+	}
+
+	assert := func(t *testing.T, authenticator *webauthntest.Authenticator, credential *pmwebauthn.Credential) (uint32, error) {
+		challenge, err := pmwebauthn.NewChallenge()
+		require.NoError(t, err)
+
+		response, err := authenticator.Assert(rp.RequestOptions(challenge, nil, pmwebauthn.UserVerificationRequired, time.Minute))
+		require.NoError(t, err)
+
+		got, err := pmwebauthn.Challenge(response.Response.ClientDataJSON)
+		require.NoError(t, err)
+		require.Equal(t, challenge, got)
+
+		return rp.VerifyAssertion(response, challenge, credential, true)
+	}
+
+	t.Run("success_register_and_assert", func(t *testing.T) {
+		authenticator := webauthntest.New(rp.Origins[0])
+		credential := register(t, authenticator)
+
+		for i := uint32(1); i <= 2; i++ {
+			signCount, err := assert(t, authenticator, credential)
+			require.NoError(t, err)
+			require.Equal(t, i, signCount)
+			credential.SignCount = signCount
+		}
+	})
+
+	t.Run("error_wrong_challenge", func(t *testing.T) {
+		authenticator := webauthntest.New(rp.Origins[0])
+		challenge, err := pmwebauthn.NewChallenge()
+		require.NoError(t, err)
+
+		response, err := authenticator.Register(rp.CreationOptions(challenge, user, nil, time.Minute))
+		require.NoError(t, err)
+
+		other, err := pmwebauthn.NewChallenge()
+		require.NoError(t, err)
+
+		_, err = rp.VerifyRegistration(response, other, true)
+		require.Error(t, err)
+	})
+
+	t.Run("error_wrong_origin", func(t *testing.T) {
+		authenticator := webauthntest.New("https://evil.example.com")
+		challenge, err := pmwebauthn.NewChallenge()
+		require.NoError(t, err)
+
+		response, err := authenticator.Register(rp.CreationOptions(challenge, user, nil, time.Minute))
+		require.NoError(t, err)
+
+		_, err = rp.VerifyRegistration(response, challenge, true)
+		require.Error(t, err)
+	})
+
+	t.Run("error_user_not_verified", func(t *testing.T) {
+		authenticator := webauthntest.New(rp.Origins[0])
+		credential := register(t, authenticator)
+
+		authenticator.UserVerified = false
+		_, err := assert(t, authenticator, credential)
+		require.Error(t, err)
+	})
+
+	t.Run("error_sign_count_regression", func(t *testing.T) {
+		authenticator := webauthntest.New(rp.Origins[0])
+		credential := register(t, authenticator)
+		credential.SignCount = 5
+
+		_, err := assert(t, authenticator, credential)
+		require.True(t, errors.Is(err, pmwebauthn.ErrSignCount))
+	})
+
+	t.Run("error_tampered_signature", func(t *testing.T) {
+		authenticator := webauthntest.New(rp.Origins[0])
+		credential := register(t, authenticator)
+
+		other := register(t, webauthntest.New(rp.Origins[0]))
+		credential.PublicKey = other.PublicKey
+
+		_, err := assert(t, authenticator, credential)
+		require.Error(t, err)
+	})
+}
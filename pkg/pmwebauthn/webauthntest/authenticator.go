@@ -0,0 +1,233 @@
+// Package webauthntest provides a software authenticator that drives WebAuthn ceremonies in tests
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ChillyWR/PasswordManager/pkg/pmwebauthn"
+)
+
+// Authenticator holds ES256 passkeys in memory. It always reports user presence and verification
+// unless UserVerified is false, and increments a signature counter per credential.
+type Authenticator struct {
+	Origin       string
+	UserVerified bool
+	credentials  map[string]*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{
+		Origin:       origin,
+		UserVerified: true,
+		credentials:  make(map[string]*credential),
+	}
+}
+
+// Register creates a passkey for options the way navigator.credentials.create does
+func (a *Authenticator) Register(options *pmwebauthn.CreationOptions) (*pmwebauthn.AttestationResponse, error) {
+	for _, excluded := range options.ExcludeCredentials {
+		if _, ok := a.credentials[excluded.ID]; ok {
+			return nil, errors.New("authenticator already holds an excluded credential")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("read random: %w", err)
+	}
+
+	userHandle, err := pmwebauthn.Encoding.DecodeString(options.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("decode user ID: %w", err)
+	}
+
+	cred := &credential{id: id, rpID: options.RP.ID, userHandle: userHandle, key: key}
+	encodedID := pmwebauthn.Encoding.EncodeToString(id)
+	a.credentials[encodedID] = cred
+
+	attested := make([]byte, 16, 18+len(id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+
+	authData := a.authenticatorData(cred, pmwebauthn.FlagAttestedCredData, attested)
+
+	clientDataJSON, err := a.clientData(pmwebauthn.CeremonyCreate, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject := encodeMap(
+		[]interface{}{"fmt", "attStmt", "authData"},
+		[]interface{}{"none", map[string]interface{}{}, authData},
+	)
+
+	return &pmwebauthn.AttestationResponse{
+		ID:    encodedID,
+		RawID: encodedID,
+		Type:  pmwebauthn.CredentialType,
+		Response: pmwebauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON:    pmwebauthn.Encoding.EncodeToString(clientDataJSON),
+			AttestationObject: pmwebauthn.Encoding.EncodeToString(attestationObject),
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Assert signs options with the first allowed credential, or with any passkey of the relying party
+// when options allow every credential, the way navigator.credentials.get does
+func (a *Authenticator) Assert(options *pmwebauthn.RequestOptions) (*pmwebauthn.AssertionResponse, error) {
+	cred := a.find(options)
+	if cred == nil {
+		return nil, errors.New("authenticator holds no allowed credential")
+	}
+
+	cred.signCount++
+	authData := a.authenticatorData(cred, 0, nil)
+
+	clientDataJSON, err := a.clientData(pmwebauthn.CeremonyGet, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	encodedID := pmwebauthn.Encoding.EncodeToString(cred.id)
+	return &pmwebauthn.AssertionResponse{
+		ID:    encodedID,
+		RawID: encodedID,
+		Type:  pmwebauthn.CredentialType,
+		Response: pmwebauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    pmwebauthn.Encoding.EncodeToString(clientDataJSON),
+			AuthenticatorData: pmwebauthn.Encoding.EncodeToString(authData),
+			Signature:         pmwebauthn.Encoding.EncodeToString(signature),
+			UserHandle:        pmwebauthn.Encoding.EncodeToString(cred.userHandle),
+		},
+	}, nil
+}
+
+// SetSignCount rewinds or advances the counter of a credential, as a cloned authenticator would
+func (a *Authenticator) SetSignCount(credentialID string, count uint32) {
+	if cred, ok := a.credentials[credentialID]; ok {
+		cred.signCount = count
+	}
+}
+
+func (a *Authenticator) find(options *pmwebauthn.RequestOptions) *credential {
+	if len(options.AllowCredentials) == 0 {
+		for _, cred := range a.credentials {
+			if cred.rpID == options.RPID {
+				return cred
+			}
+		}
+		return nil
+	}
+
+	for _, allowed := range options.AllowCredentials {
+		if cred, ok := a.credentials[allowed.ID]; ok && cred.rpID == options.RPID {
+			return cred
+		}
+	}
+
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+
+	flags |= pmwebauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= pmwebauthn.FlagUserVerified
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, cred.signCount)
+	return append(data, attested...)
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return encodeMap(
+		[]interface{}{1, 3, -1, -2, -3},
+		[]interface{}{2, pmwebauthn.AlgES256, 1, x, y},
+	)
+}
+
+// encodeMap encodes a CBOR map keeping the order of keys, values may be ints, strings, byte strings or empty maps
+func encodeMap(keys, values []interface{}) []byte {
+	data := header(5, uint64(len(keys)))
+	for i := range keys {
+		data = append(data, encode(keys[i])...)
+		data = append(data, encode(values[i])...)
+	}
+	return data
+}
+
+func encode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case map[string]interface{}:
+		return header(5, 0)
+	default:
+		panic(fmt.Sprintf("webauthntest: cannot encode %T", v))
+	}
+}
+
+func header(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+}